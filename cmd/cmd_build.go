@@ -1,10 +1,13 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/nanovms/ops/lepton"
 	"github.com/nanovms/ops/log"
+	"github.com/nanovms/ops/types"
 	"github.com/spf13/cobra"
 )
 
@@ -25,6 +28,9 @@ func BuildCommand() *cobra.Command {
 	PersistNightlyCommandFlags(persistentFlags)
 	PersistNanosVersionCommandFlags(persistentFlags)
 
+	persistentFlags.Bool("trace-deps", false, "run the program on the host under strace and add the libraries and data files it opens, like dlopen'd libraries, to the image")
+	persistentFlags.Duration("trace-timeout", 10*time.Second, "how long the program runs when tracing its dependencies")
+
 	return cmdBuild
 }
 
@@ -48,6 +54,11 @@ func buildCommandHandler(cmd *cobra.Command, args []string) {
 		exitWithError(err.Error())
 	}
 
+	if traceDeps, _ := flags.GetBool("trace-deps"); traceDeps {
+		timeout, _ := flags.GetDuration("trace-timeout")
+		addTracedDeps(c, timeout)
+	}
+
 	providerFlags := NewProviderCommandFlags(flags)
 
 	p, ctx, err := getProviderAndContext(c, providerFlags.TargetCloud)
@@ -62,3 +73,38 @@ func buildCommandHandler(cmd *cobra.Command, args []string) {
 	}
 	fmt.Printf("Bootable image file:%s\n", imagePath)
 }
+
+// addTracedDeps adds the files the program opens when run on the host
+// to the image and proposes them for the config.
+func addTracedDeps(c *types.Config, timeout time.Duration) {
+	fmt.Printf("Tracing %s for %s\n", c.Program, timeout)
+
+	deps, err := lepton.TraceDeps(c, timeout)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
+	if len(deps.Files) == 0 && len(deps.Proposed) == 0 && len(deps.Dirs) == 0 {
+		fmt.Println("No dependencies found besides the shared libraries")
+		return
+	}
+
+	for _, f := range deps.Files {
+		fmt.Printf("Adding traced file: %s\n", f)
+	}
+	c.Files = append(c.Files, deps.Files...)
+
+	// files outside of library and data directories may be secrets
+	for _, f := range deps.Proposed {
+		fmt.Printf("Traced file, not added: %s\n", f)
+	}
+	for _, d := range deps.Dirs {
+		fmt.Printf("Traced directory, not added: %s\n", d)
+	}
+
+	proposal, _ := json.MarshalIndent(struct {
+		Files []string `json:",omitempty"`
+		Dirs  []string `json:",omitempty"`
+	}{append(deps.Files, deps.Proposed...), deps.Dirs}, "", "  ")
+	fmt.Printf("Add the ones needed to your config to build without tracing:\n%s\n", proposal)
+}
@@ -0,0 +1,307 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	api "github.com/nanovms/ops/lepton"
+	"github.com/nanovms/ops/types"
+
+	"github.com/dustin/go-humanize"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// CacheCommands handles the packages, kernels and nightly builds ops
+// keeps on disk
+func CacheCommands() *cobra.Command {
+	cmdCache := &cobra.Command{
+		Use:   "cache",
+		Short: "show and prune the disk usage of downloaded packages, kernels and cached builds",
+		Args:  cobra.NoArgs,
+		Run:   cacheCommandHandler,
+	}
+
+	cmdCache.PersistentFlags().StringSlice("lock", []string{api.PackageLockFile}, "lockfiles whose packages are kept")
+	PersistConfigCommandFlags(cmdCache.PersistentFlags())
+
+	cmdCache.AddCommand(cachePruneCommand())
+	cmdCache.AddCommand(cacheStatsCommand())
+	return cmdCache
+}
+
+func cacheStatsCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "stats",
+		Short: "show the disk usage by kind and the hit rate of the build cache",
+		Args:  cobra.NoArgs,
+		Run:   cacheStatsCommandHandler,
+	}
+}
+
+// cacheKindStats is the disk usage of a kind of cache entries.
+type cacheKindStats struct {
+	Kind    string `json:"kind"`
+	Entries int    `json:"entries"`
+	Size    int64  `json:"size"`
+}
+
+func cacheStatsCommandHandler(cmd *cobra.Command, args []string) {
+	entries := cacheEntries(cmd)
+
+	buildStats, err := api.ReadBuildCacheStats()
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
+	kinds := []cacheKindStats{}
+	for _, kind := range []string{api.CacheKindPackage, api.CacheKindArchive, api.CacheKindKernel, api.CacheKindNightly, api.CacheKindBuild} {
+		ks := cacheKindStats{Kind: kind}
+		for _, e := range entries {
+			if e.Kind == kind {
+				ks.Entries++
+				ks.Size += e.Size
+			}
+		}
+		kinds = append(kinds, ks)
+	}
+
+	if jsonOutput, _ := cmd.Flags().GetBool("json"); jsonOutput {
+		printJSON(struct {
+			Kinds []cacheKindStats    `json:"kinds"`
+			Build api.BuildCacheStats `json:"build_cache"`
+		}{kinds, buildStats})
+		return
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Kind", "Entries", "Size"})
+	table.SetHeaderColor(
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor})
+
+	var total int64
+	for _, ks := range kinds {
+		table.Append([]string{ks.Kind, strconv.Itoa(ks.Entries), humanize.Bytes(uint64(ks.Size))})
+		total += ks.Size
+	}
+	table.SetFooter([]string{"", "", humanize.Bytes(uint64(total))})
+	table.Render()
+
+	lookups := buildStats.Hits + buildStats.Misses
+	if lookups == 0 {
+		fmt.Println("build cache: no builds yet")
+		return
+	}
+	fmt.Printf("build cache: %d hits, %d misses, %.0f%% hit rate\n", buildStats.Hits, buildStats.Misses, float64(buildStats.Hits)*100/float64(lookups))
+}
+
+// pkgPruneCommand prunes downloaded packages only.
+func pkgPruneCommand() *cobra.Command {
+	cmdPrune := &cobra.Command{
+		Use:   "prune",
+		Short: "delete unused downloaded packages",
+		Long: `Delete downloaded packages and package archives last used longer than
+--older-than ago and, with --max-size, the least recently used ones
+until they fit in the given size.
+
+Packages of local images and packages of lockfiles (--lock) are never
+deleted. See 'ops cache' for the disk usage of packages.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			pruneCache(cmd, []string{api.CacheKindPackage, api.CacheKindArchive})
+		},
+	}
+
+	persistPruneFlags(cmdPrune.PersistentFlags())
+	cmdPrune.PersistentFlags().StringSlice("lock", []string{api.PackageLockFile}, "lockfiles whose packages are kept")
+	return cmdPrune
+}
+
+func cachePruneCommand() *cobra.Command {
+	cmdPrune := &cobra.Command{
+		Use:   "prune",
+		Short: "delete unused packages, kernels, nightly builds and cached images",
+		Long: `Delete packages, package archives, nanos releases, nightly builds and
+images of the build cache last used longer than --older-than ago and,
+with --max-size, the least recently used ones until the cache fits in
+the given size.
+
+Packages of local images, packages of lockfiles (--lock) and their
+archives, the current nanos release and the one pinned by the config
+(-c) are never deleted.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			pruneCache(cmd, nil)
+		},
+	}
+
+	persistPruneFlags(cmdPrune.PersistentFlags())
+	return cmdPrune
+}
+
+func persistPruneFlags(flags *pflag.FlagSet) {
+	flags.String("older-than", "", "delete what wasn't used for this long (eg: 72h, 30d)")
+	flags.String("max-size", "", "delete the least recently used entries until the cache fits in this size (eg: 5GB)")
+	flags.Bool("dry-run", false, "only show what would be deleted")
+}
+
+// parseAge parses a duration which may be given in days (eg: 30d).
+func parseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	return time.ParseDuration(s)
+}
+
+func cacheEntries(cmd *cobra.Command) []api.CacheEntry {
+	entries, err := api.CacheEntries()
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
+	lockFiles, _ := cmd.Flags().GetStringSlice("lock")
+	err = api.MarkLockedPackages(entries, lockFiles)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
+	if configFile, _ := cmd.Flags().GetString("config"); strings.TrimSpace(configFile) != "" {
+		c := &types.Config{}
+		configFlags := NewConfigCommandFlags(cmd.Flags())
+		err = configFlags.MergeToConfig(c)
+		if err != nil {
+			exitWithError(err.Error())
+		}
+		api.MarkConfigKernel(entries, c, configFlags.Config)
+	}
+
+	return entries
+}
+
+func cacheCommandHandler(cmd *cobra.Command, args []string) {
+	entries := cacheEntries(cmd)
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Kind != entries[j].Kind {
+			return entries[i].Kind < entries[j].Kind
+		}
+		if entries[i].Name != entries[j].Name {
+			return entries[i].Name < entries[j].Name
+		}
+		return entries[i].Version < entries[j].Version
+	})
+
+	if jsonOutput, _ := cmd.Flags().GetBool("json"); jsonOutput {
+		printJSON(entries)
+		return
+	}
+
+	printCacheEntries(entries)
+}
+
+func printCacheEntries(entries []api.CacheEntry) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Kind", "Name", "Version", "Arch", "Size", "Last Used", "In Use"})
+	table.SetHeaderColor(
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor})
+	table.SetRowLine(true)
+
+	var total int64
+	for _, e := range entries {
+		total += e.Size
+		table.Append([]string{e.Kind, e.Name, e.Version, e.Arch, humanize.Bytes(uint64(e.Size)), api.Time2Human(e.LastUsed), e.InUse})
+	}
+
+	table.SetFooter([]string{"", "", "", "", humanize.Bytes(uint64(total)), "", ""})
+	table.Render()
+}
+
+// pruneCache deletes the entries of the given kinds (all if nil)
+// selected by the prune flags of cmd.
+func pruneCache(cmd *cobra.Command, kinds []string) {
+	flags := cmd.Flags()
+
+	olderThanStr, _ := flags.GetString("older-than")
+	maxSizeStr, _ := flags.GetString("max-size")
+	dryRun, _ := flags.GetBool("dry-run")
+
+	if olderThanStr == "" && maxSizeStr == "" {
+		exitWithError("set --older-than and/or --max-size")
+	}
+
+	var olderThan time.Duration
+	if olderThanStr != "" {
+		var err error
+		olderThan, err = parseAge(olderThanStr)
+		if err != nil {
+			exitWithError(err.Error())
+		}
+	}
+
+	var maxSize int64
+	if maxSizeStr != "" {
+		size, err := humanize.ParseBytes(maxSizeStr)
+		if err != nil {
+			exitWithError(err.Error())
+		}
+		maxSize = int64(size)
+	}
+
+	entries := []api.CacheEntry{}
+	for _, e := range cacheEntries(cmd) {
+		if kinds == nil || slices.Contains(kinds, e.Kind) {
+			entries = append(entries, e)
+		}
+	}
+
+	var freed uint64
+	for _, e := range api.SelectPrunable(entries, olderThan, maxSize, time.Now()) {
+		desc := e.Kind + " " + e.Name
+		if e.Version != "" {
+			desc += ":" + e.Version
+		}
+		if e.Arch != "" {
+			desc += " (" + e.Arch + ")"
+		}
+
+		if dryRun {
+			fmt.Printf("would delete %s, %s\n", desc, humanize.Bytes(uint64(e.Size)))
+			freed += uint64(e.Size)
+			continue
+		}
+
+		err := api.RemoveCacheEntry(e)
+		if err != nil {
+			fmt.Printf("failed deleting %s: %v\n", desc, err)
+			continue
+		}
+
+		fmt.Printf("deleted %s, %s\n", desc, humanize.Bytes(uint64(e.Size)))
+		freed += uint64(e.Size)
+	}
+
+	if dryRun {
+		fmt.Printf("%s would be freed\n", humanize.Bytes(freed))
+		return
+	}
+	fmt.Printf("%s freed\n", humanize.Bytes(freed))
+}
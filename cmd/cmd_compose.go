@@ -1,15 +1,14 @@
 package cmd
 
 import (
-	"crypto/sha1"
-	"encoding/hex"
 	"fmt"
 	"os"
-	"path"
+	"path/filepath"
 	"runtime"
 
 	"github.com/nanovms/ops/qemu"
 	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
 
 	api "github.com/nanovms/ops/lepton"
 )
@@ -20,12 +19,13 @@ func ComposeCommands() *cobra.Command {
 	var cmdCompose = &cobra.Command{
 		Use:       "compose",
 		Short:     "orchestrate multiple unikernels",
-		ValidArgs: []string{"up", "down"},
+		ValidArgs: []string{"up", "down", "convert"},
 		Args:      cobra.OnlyValidArgs,
 	}
 
 	cmdCompose.AddCommand(composeUpCommand())
 	cmdCompose.AddCommand(composeDownCommand())
+	cmdCompose.AddCommand(composeConvertCommand())
 
 	return cmdCompose
 }
@@ -37,6 +37,11 @@ func composeDownCommand() *cobra.Command {
 		Run:   composeDownCommandHandler,
 	}
 
+	persistentFlags := cmdDownCompose.PersistentFlags()
+
+	PersistConfigCommandFlags(persistentFlags)
+
+	persistentFlags.StringP("compose-file", "f", "", "compose file (default: cwd)")
 	return cmdDownCompose
 }
 
@@ -47,92 +52,147 @@ func composeUpCommand() *cobra.Command {
 		Run:   composeUpCommandHandler,
 	}
 
-	cmdUpCompose.PersistentFlags().StringP("compose-file", "f", "", "compose file (default: cwd)")
+	persistentFlags := cmdUpCompose.PersistentFlags()
+
+	PersistConfigCommandFlags(persistentFlags)
+	PersistProviderCommandFlags(persistentFlags)
+
+	persistentFlags.StringP("compose-file", "f", "", "compose file (default: cwd)")
+	persistentFlags.StringP("arch", "", "", "architecture for packages that don't set one")
+	persistentFlags.IntP("parallel", "", composeDefaultParallel, "max number of services started at once")
+	persistentFlags.BoolP("copy", "", false, "copy whole file system of docker images (docker-compose.yml only)")
 	return cmdUpCompose
 }
 
-func composeDownCommandHandler(cmd *cobra.Command, args []string) {
-	if runtime.GOOS == "darwin" && qemu.OPSD == "" {
-		fmt.Println("this command is only enabled if you have OPSD compiled in.")
-		os.Exit(1)
+func composeConvertCommand() *cobra.Command {
+	var cmdConvertCompose = &cobra.Command{
+		Use:   "convert [docker-compose.yml]",
+		Short: "convert a docker-compose.yml to an ops compose.yaml",
+		Args:  cobra.ExactArgs(1),
+		Run:   composeConvertCommandHandler,
 	}
 
-	c := api.NewConfig()
+	persistentFlags := cmdConvertCompose.PersistentFlags()
 
-	p, ctx, err := getProviderAndContext(c, "onprem")
-	if err != nil {
-		exitForCmd(cmd, err.Error())
-	}
+	persistentFlags.StringP("output", "o", "", "file to write the compose.yaml to (default: stdout)")
+	persistentFlags.StringP("arch", "", "", "architecture for services that don't set a platform")
+	persistentFlags.BoolP("copy", "", false, "copy whole file system of docker images")
+	persistentFlags.BoolP("verbose", "v", false, "verbose")
+	return cmdConvertCompose
+}
 
-	// this looks like it's killing everything when it should only be
-	// killing the set desired.
-	instances, err := p.GetInstances(ctx)
-	if err != nil {
-		fmt.Println(err)
-	}
+func composeDownCommandHandler(cmd *cobra.Command, args []string) {
+	flags := cmd.Flags()
+	configFlags := NewConfigCommandFlags(flags)
+	globalFlags := NewGlobalCommandFlags(flags)
 
-	// get bridge of one of these instances..
+	composeFile, _ := cmd.Flags().GetString("compose-file")
 
-	for i := 0; i < len(instances); i++ {
-		err = p.DeleteInstance(ctx, instances[i].Name)
-		if err != nil {
-			exitWithError(err.Error())
-		}
+	c := api.NewConfig()
+	mergeContainer := NewMergeConfigContainer(configFlags, globalFlags)
+	err := mergeContainer.Merge(c)
+	if err != nil {
+		exitWithError(err.Error())
 	}
 
-	if runtime.GOOS == "linux" {
-		body := getComposeContents("")
-
-		h := sha1.New()
-		h.Write([]byte(body))
-		sha := hex.EncodeToString(h.Sum(nil))
-
-		opshome := api.GetOpsHome()
-		composes := path.Join(opshome, "composes")
-
-		body, err := os.ReadFile(composes + "/" + sha)
-		if err != nil {
-			fmt.Println(err)
-		}
-
-		brName := string(body)
+	com := Compose{
+		config: c,
+	}
 
-		// need to tune down the taps here too...
-		removeBridge(brName)
+	err = com.Down(composeFile)
+	if err != nil {
+		exitForCmd(cmd, err.Error())
 	}
 }
 
 func composeUpCommandHandler(cmd *cobra.Command, args []string) {
-	if runtime.GOOS == "darwin" && qemu.OPSD == "" {
-		fmt.Println("this command is only enabled if you have OPSD compiled in.")
-		os.Exit(1)
-	}
-
 	flags := cmd.Flags()
+	configFlags := NewConfigCommandFlags(flags)
 	globalFlags := NewGlobalCommandFlags(flags)
+	providerFlags := NewProviderCommandFlags(flags)
 
 	composeFile, _ := cmd.Flags().GetString("compose-file")
+	parallel, _ := cmd.Flags().GetInt("parallel")
+	copyWholeFS, _ := cmd.Flags().GetBool("copy")
 
 	c := api.NewConfig()
-	mergeContainer := NewMergeConfigContainer(globalFlags)
+	mergeContainer := NewMergeConfigContainer(configFlags, globalFlags, providerFlags)
 	err := mergeContainer.Merge(c)
 	if err != nil {
 		exitWithError(err.Error())
 	}
 
+	arch, _ := cmd.Flags().GetString("arch")
+	if arch != "" {
+		if arch != "arm64" && arch != "amd64" {
+			exitWithError("unknown architecture")
+		}
+		c.Arch = arch
+	}
+
+	if c.CloudConfig.Platform == "onprem" && runtime.GOOS == "darwin" && qemu.OPSD == "" {
+		fmt.Println("this command is only enabled if you have OPSD compiled in.")
+		os.Exit(1)
+	}
+
 	if c.Kernel == "" {
-		version, err := getCurrentVersion()
+		version, err := getCurrentVersion(c.Arch)
 		if err != nil {
 			fmt.Println(err)
 		}
-		version = setKernelVersion(version)
+		version = setKernelVersion(version, c.Arch)
 
 		c.Kernel = getKernelVersion(version)
 	}
 
 	com := Compose{
-		config: c,
+		config:      c,
+		parallel:    parallel,
+		copyWholeFS: copyWholeFS,
 	}
 	com.UP(composeFile)
 
 }
+
+func composeConvertCommandHandler(cmd *cobra.Command, args []string) {
+	flags := cmd.Flags()
+
+	output, _ := flags.GetString("output")
+	arch, _ := flags.GetString("arch")
+	copyWholeFS, _ := flags.GetBool("copy")
+	verbose, _ := flags.GetBool("verbose")
+
+	if arch != "" && arch != "arm64" && arch != "amd64" {
+		exitWithError("unknown architecture")
+	}
+
+	body, err := os.ReadFile(args[0])
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
+	if !isDockerCompose(body) {
+		exitWithError(args[0] + " is not a docker compose file")
+	}
+
+	y, err := convertDockerCompose(body, filepath.Dir(args[0]), arch, copyWholeFS, verbose)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
+	out, err := yaml.Marshal(y)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
+	if output == "" {
+		fmt.Print(string(out))
+		return
+	}
+
+	err = os.WriteFile(output, out, 0644)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+	fmt.Fprintf(os.Stderr, "wrote %s\n", output)
+}
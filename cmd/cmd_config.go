@@ -0,0 +1,244 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/nanovms/ops/log"
+	"github.com/nanovms/ops/types"
+	"github.com/spf13/cobra"
+)
+
+// ConfigCommands handles ops config files
+func ConfigCommands() *cobra.Command {
+	cmdConfig := &cobra.Command{
+		Use:       "config",
+		Short:     "config file related commands",
+		Args:      cobra.OnlyValidArgs,
+		ValidArgs: []string{"show", "validate", "schema", "convert"},
+		// config commands report the problems of config files themselves
+		// instead of failing to load them like other commands
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := checkInvalidFlags(cmd, args); err != nil {
+				return err
+			}
+
+			config := &types.Config{}
+			globalFlags := NewGlobalCommandFlags(cmd.Flags())
+			if err := globalFlags.MergeToConfig(config); err != nil {
+				return err
+			}
+
+			log.InitDefault(os.Stdout, config)
+			return nil
+		},
+	}
+
+	cmdConfig.AddCommand(configShowCommand())
+	cmdConfig.AddCommand(configValidateCommand())
+	cmdConfig.AddCommand(configSchemaCommand())
+	cmdConfig.AddCommand(configConvertCommand())
+	return cmdConfig
+}
+
+func configShowCommand() *cobra.Command {
+	cmdShow := &cobra.Command{
+		Use:   "show",
+		Short: "show the effective config",
+		Long: `Show the effective config of a config file: the files it extends
+merged beneath it, the profile selected with --profile merged over it and,
+with ops_render_config=true, ${ENV_VAR} and ${file:path} interpolated.`,
+		Example: "  ops config show -c config.yaml --profile prod --format yaml",
+		Args:    cobra.NoArgs,
+		Run:     configShowCommandHandler,
+	}
+
+	persistentFlags := cmdShow.PersistentFlags()
+	PersistConfigCommandFlags(persistentFlags)
+	persistentFlags.String("format", configFormatJSON, "output format [json, yaml, toml]")
+	return cmdShow
+}
+
+func configShowCommandHandler(cmd *cobra.Command, args []string) {
+	configFlags := NewConfigCommandFlags(cmd.Flags())
+	if configFlags.Config == "" {
+		exitWithError("a config file is required, use -c")
+	}
+
+	c := &types.Config{}
+	err := unWarpConfigProfile(configFlags.Config, configFlags.Profile, c)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
+	format, _ := cmd.Flags().GetString("format")
+	body, err := encodeConfig(c, format)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+	fmt.Print(string(body))
+}
+
+// encodeConfig writes c in format with the field names and omissions
+// of its JSON encoding.
+func encodeConfig(c *types.Config, format string) ([]byte, error) {
+	body, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	n, err := parseJSONConfig("config", body)
+	if err != nil {
+		return nil, err
+	}
+	return encodeConfigNode(n, format)
+}
+
+func configValidateCommand() *cobra.Command {
+	cmdValidate := &cobra.Command{
+		Use:   "validate",
+		Short: "validate a config file",
+		Long: `Validate a config file and the files it extends against the config
+schema: unknown fields, including fields with the wrong case, values of
+the wrong type and invalid sizes for Memory and BaseVolumeSz are reported
+with their line and column, as well as the CloudConfig fields required by
+the platform of the effective config.`,
+		Example: "  ops config validate -c config.json --profile prod",
+		Args:    cobra.NoArgs,
+		Run:     configValidateCommandHandler,
+	}
+
+	PersistConfigCommandFlags(cmdValidate.PersistentFlags())
+	return cmdValidate
+}
+
+func configValidateCommandHandler(cmd *cobra.Command, args []string) {
+	configFlags := NewConfigCommandFlags(cmd.Flags())
+	if configFlags.Config == "" {
+		exitWithError("a config file is required, use -c")
+	}
+	jsonOutput, _ := cmd.Flags().GetBool("json")
+
+	errs := validateConfigFile(configFlags.Config, configFlags.Profile)
+
+	if jsonOutput {
+		if errs == nil {
+			errs = ConfigErrors{}
+		}
+		printJSON(errs)
+	} else if len(errs) == 0 {
+		fmt.Printf("%s is valid\n", configFlags.Config)
+	} else {
+		for _, err := range errs {
+			fmt.Println(err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		os.Exit(1)
+	}
+}
+
+// validateConfigFile returns the problems of the config file with the
+// selected profile.
+func validateConfigFile(file string, profile string) ConfigErrors {
+	data, err := resolveConfigFile(file, profile)
+	if err != nil {
+		var errs ConfigErrors
+		if errors.As(err, &errs) {
+			return errs
+		}
+		return ConfigErrors{{File: file, Message: err.Error()}}
+	}
+
+	c := &types.Config{}
+	err = ConvertJSONToConfig(data, c)
+	if err != nil {
+		return ConfigErrors{{File: file, Message: err.Error()}}
+	}
+
+	err = checkProviderConfig(file, profile, c)
+	if err != nil {
+		var errs ConfigErrors
+		if errors.As(err, &errs) {
+			return errs
+		}
+		return ConfigErrors{{File: file, Message: err.Error()}}
+	}
+	return nil
+}
+
+func configSchemaCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "schema",
+		Short: "print the JSON Schema of config files",
+		Long: `Print the JSON Schema of config files, generated from the config
+fields, e.g. for editors to complete and check config files.`,
+		Example: "  ops config schema > ops-config.schema.json",
+		Args:    cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			body, err := json.MarshalIndent(configFileSchema(), "", "  ")
+			if err != nil {
+				exitWithError(err.Error())
+			}
+			fmt.Println(string(body))
+		},
+	}
+}
+
+func configConvertCommand() *cobra.Command {
+	cmdConvert := &cobra.Command{
+		Use:   "convert <file> [output]",
+		Short: "convert a config file between json, yaml and toml",
+		Long: `Convert a config file between the JSON, YAML and TOML formats, told
+by the file extensions. Extends, Profiles and interpolations are kept as
+they are. Without output the converted config is printed in the format
+given with --to.`,
+		Example: `  ops config convert config.json config.yaml
+  ops config convert config.toml --to json`,
+		Args: cobra.RangeArgs(1, 2),
+		Run:  configConvertCommandHandler,
+	}
+
+	cmdConvert.PersistentFlags().String("to", "", "output format [json, yaml, toml], by default the one of the output file")
+	return cmdConvert
+}
+
+func configConvertCommandHandler(cmd *cobra.Command, args []string) {
+	format, _ := cmd.Flags().GetString("to")
+	if format == "" {
+		if len(args) < 2 {
+			exitWithError("an output file or --to is required")
+		}
+		format = configFormat(args[1])
+	}
+
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
+	n, err := parseConfigNode(args[0], data)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+	if _, err := validateConfigNode(args[0], n); err != nil {
+		exitWithError(err.Error())
+	}
+
+	body, err := encodeConfigNode(n, format)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
+	if len(args) < 2 {
+		fmt.Print(string(body))
+		return
+	}
+
+	err = os.WriteFile(args[1], body, 0644)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+}
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"crypto/ed25519"
 	"fmt"
 	"path/filepath"
 	"strconv"
@@ -30,6 +31,9 @@ func DeployCommand() *cobra.Command {
 	PersistNightlyCommandFlags(persistentFlags)
 	PersistNanosVersionCommandFlags(persistentFlags)
 
+	persistentFlags.String("sign-key", "", "ed25519 private key file the image built is signed with, required by a TrustPolicy")
+	persistentFlags.String("sign-key-id", "", "id of the sign key in the trust store, the name of the key file by default")
+
 	return cmdDeploy
 }
 
@@ -73,19 +77,18 @@ func deployCommandHandler(cmd *cobra.Command, args []string) {
 		exitWithError(err.Error())
 	}
 
-	// Delete image with the same name
-	images, err := p.GetImages(ctx, "")
-	if err != nil {
-		exitWithError(err.Error())
+	// a TrustPolicy only accepts the image built once signed, with
+	// --sign-key or by a PostBuild hook
+	var signKeyID string
+	var signKey ed25519.PrivateKey
+	if keyFile, _ := flags.GetString("sign-key"); keyFile != "" {
+		keyID, _ := flags.GetString("sign-key-id")
+		signKeyID, signKey = readSigningKey(keyFile, keyID)
 	}
 
-	for _, i := range images {
-		if i.Name == ctx.Config().CloudConfig.ImageName {
-			err = p.DeleteImage(ctx, ctx.Config().CloudConfig.ImageName)
-			if err != nil {
-				exitWithError(err.Error())
-			}
-		}
+	err = lepton.RunHook(ctx.Config(), lepton.HookPreDeploy)
+	if err != nil {
+		exitWithError(err.Error())
 	}
 
 	// Build image
@@ -102,7 +105,38 @@ func deployCommandHandler(cmd *cobra.Command, args []string) {
 		}
 	}
 
-	err = p.CreateImage(ctx, keypath)
+	// the image uploaded is the local one, checked before replacing the
+	// image of the provider
+	if signKey != nil {
+		err = lepton.SignImage(ctx.Config().RunConfig.ImageName, signKeyID, signKey)
+		if err != nil {
+			exitWithError(err.Error())
+		}
+	}
+	err = lepton.VerifyLocalImage(ctx.Config(), ctx.Config().RunConfig.ImageName)
+	if err != nil {
+		if signKey == nil {
+			exitWithError(err.Error() + ", or deploy with --sign-key")
+		}
+		exitWithError(err.Error())
+	}
+
+	// Delete image with the same name
+	images, err := p.GetImages(ctx, "")
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
+	for _, i := range images {
+		if i.Name == ctx.Config().CloudConfig.ImageName {
+			err = p.DeleteImage(ctx, ctx.Config().CloudConfig.ImageName)
+			if err != nil {
+				exitWithError(err.Error())
+			}
+		}
+	}
+
+	err = createImage(p, ctx, keypath)
 	if err != nil {
 		exitWithError(err.Error())
 	}
@@ -120,6 +154,11 @@ func deployCommandHandler(cmd *cobra.Command, args []string) {
 		exitWithError(err.Error())
 	}
 
+	err = lepton.InjectSecrets(ctx.Config())
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
 	err = p.CreateInstance(ctx)
 	if err != nil {
 		exitWithError("failed creating instance: " + err.Error())
@@ -134,4 +173,9 @@ func deployCommandHandler(cmd *cobra.Command, args []string) {
 			}
 		}
 	}
+
+	err = lepton.RunHook(ctx.Config(), lepton.HookPostDeploy)
+	if err != nil {
+		exitWithError(err.Error())
+	}
 }
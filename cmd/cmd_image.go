@@ -1,10 +1,12 @@
 package cmd
 
 import (
+	"crypto/ed25519"
 	"fmt"
 	"io"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -16,6 +18,7 @@ import (
 	"github.com/nanovms/ops/provider"
 	"github.com/nanovms/ops/provider/onprem"
 	"github.com/nanovms/ops/types"
+	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 )
@@ -25,7 +28,7 @@ func ImageCommands() *cobra.Command {
 	var cmdImage = &cobra.Command{
 		Use:       "image",
 		Short:     "manage nanos images",
-		ValidArgs: []string{"create", "list", "delete", "resize", "sync", "cat", "cp", "ls", "search", "tree", "env", "mirror"},
+		ValidArgs: []string{"create", "list", "delete", "resize", "sync", "cat", "cp", "ls", "search", "tree", "env", "mirror", "sbom", "inspect", "sign", "verify"},
 		Args:      cobra.OnlyValidArgs,
 	}
 
@@ -43,6 +46,10 @@ func ImageCommands() *cobra.Command {
 	cmdImage.AddCommand(imageTreeCommand())
 	cmdImage.AddCommand(imageEnvCommand())
 	cmdImage.AddCommand(imageMirrorCommand())
+	cmdImage.AddCommand(imageSBOMCommand())
+	cmdImage.AddCommand(imageInspectCommand())
+	cmdImage.AddCommand(imageSignCommand())
+	cmdImage.AddCommand(imageVerifyCommand())
 	cmdImage.AddCommand(imageSearchCommand())
 
 	return cmdImage
@@ -118,7 +125,7 @@ func imageCreateCommandHandler(cmd *cobra.Command, args []string) {
 		}
 	}
 
-	err = p.CreateImage(ctx, keypath)
+	err = createImage(p, ctx, keypath)
 	if err != nil {
 		exitWithError(err.Error())
 	}
@@ -131,6 +138,30 @@ func imageCreateCommandHandler(cmd *cobra.Command, args []string) {
 	fmt.Printf("%s image '%s' created...\n", c.CloudConfig.Platform, imageName)
 }
 
+// createImage creates the image built at keypath on the provider and
+// records how long the upload took.
+func createImage(p api.Provider, ctx *api.Context, keypath string) error {
+	start := time.Now()
+
+	// labels the image with its provenance; images built by older ops
+	// versions have none
+	if reader, err := fs.NewReader(ctx.Config().RunConfig.ImageName); err == nil {
+		if provenance, err := api.ImageProvenance(reader); err == nil {
+			ctx.Config().CloudConfig.Tags = append(ctx.Config().CloudConfig.Tags, provenance.Labels()...)
+		}
+		reader.Close()
+	}
+	ctx.Config().CloudConfig.Tags = append(ctx.Config().CloudConfig.Tags, api.ImageSignatureLabels(ctx.Config().RunConfig.ImageName)...)
+
+	err := p.CreateImage(ctx, keypath)
+	if err != nil {
+		return err
+	}
+
+	api.RecordDuration(api.OpUpload, ctx.Config().CloudConfig.Platform, time.Since(start))
+	return nil
+}
+
 func imageListCommand() *cobra.Command {
 	var cmdImageList = &cobra.Command{
 		Use:   "list",
@@ -822,6 +853,153 @@ func imageEnvCommandHandler(cmd *cobra.Command, args []string) {
 	}
 }
 
+func imageInspectCommand() *cobra.Command {
+	var cmdInspect = &cobra.Command{
+		Use:   "inspect <image_name>",
+		Short: "show how a local image was built",
+		Run:   imageInspectCommandHandler,
+		Args:  cobra.ExactArgs(1),
+	}
+	return cmdInspect
+}
+
+func imageInspectCommandHandler(cmd *cobra.Command, args []string) {
+	reader := getLocalImageReader(cmd.Flags(), args)
+	provenance, err := api.ImageProvenance(reader)
+	reader.Close()
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
+	if jsonOutput, _ := cmd.Flags().GetBool("json"); jsonOutput {
+		printJSON(provenance)
+		return
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Field", "Value"})
+	table.SetHeaderColor(
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor})
+	table.SetAutoWrapText(false)
+
+	table.Append([]string{"Ops version", provenance.OpsVersion})
+	table.Append([]string{"Nanos version", provenance.NanosVersion})
+	table.Append([]string{"Arch", provenance.Arch})
+	table.Append([]string{"Program", provenance.Program})
+	table.Append([]string{"Build time", provenance.BuildTime.Format(time.RFC3339)})
+	if provenance.GitCommit != "" {
+		commit := provenance.GitCommit
+		if provenance.GitDirty {
+			commit += " (modified)"
+		}
+		table.Append([]string{"Git commit", commit})
+	}
+	table.Append([]string{"Config sha256", provenance.ConfigSHA256})
+	if provenance.Kernel != nil {
+		table.Append([]string{"Kernel " + provenance.Kernel.Name, provenance.Kernel.SHA256})
+	}
+	for _, klib := range provenance.Klibs {
+		table.Append([]string{"Klib " + klib.Name, klib.SHA256})
+	}
+	for _, pkg := range provenance.Packages {
+		table.Append([]string{"Package " + pkg.Name, pkg.SHA256})
+	}
+	table.Render()
+}
+
+func imageSignCommand() *cobra.Command {
+	var cmdSign = &cobra.Command{
+		Use:   "sign <image_name>",
+		Short: "sign a local image",
+		Long: `Sign a local image, or an image file, with an ed25519 private key. The
+signature is kept in ~/.ops/signatures and added to the labels of the
+image when it's uploaded to a provider. Instances are only created from
+signed images when the config has a TrustPolicy.`,
+		Run:  imageSignCommandHandler,
+		Args: cobra.ExactArgs(1),
+	}
+	cmdSign.Flags().String("key", "", "ed25519 private key file, PEM (PKCS #8) or base64")
+	cmdSign.Flags().String("key-id", "", "id of the key in the trust store, the name of the key file by default")
+	cmdSign.MarkFlagRequired("key")
+	return cmdSign
+}
+
+func imageSignCommandHandler(cmd *cobra.Command, args []string) {
+	keyFile, _ := cmd.Flags().GetString("key")
+	keyID, _ := cmd.Flags().GetString("key-id")
+	keyID, key := readSigningKey(keyFile, keyID)
+
+	imagePath := localImagePath(args[0])
+	err := api.SignImage(imagePath, keyID, key)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+	fmt.Printf("signed %s with key %s\n", filepath.Base(imagePath), keyID)
+}
+
+func imageVerifyCommand() *cobra.Command {
+	var cmdVerify = &cobra.Command{
+		Use:   "verify <image_name>",
+		Short: "verify the signature of a local image",
+		Long: `Verify a local image, or an image file, is signed by a key of the trust
+store, or of the TrustPolicy of the config if any.`,
+		Run:  imageVerifyCommandHandler,
+		Args: cobra.ExactArgs(1),
+	}
+	return cmdVerify
+}
+
+func imageVerifyCommandHandler(cmd *cobra.Command, args []string) {
+	c := api.NewConfig()
+	mergeContainer := NewMergeConfigContainer(NewConfigCommandFlags(cmd.Flags()), NewGlobalCommandFlags(cmd.Flags()))
+	err := mergeContainer.Merge(c)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+	c.TrustPolicy.RequireSigned = true
+
+	imagePath := localImagePath(args[0])
+	err = api.VerifyLocalImage(c, imagePath)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+	fmt.Printf("%s is signed by a trusted key\n", filepath.Base(imagePath))
+}
+
+// readSigningKey reads the ed25519 signing key of keyFile, with the id
+// keyID or the name of the key file.
+func readSigningKey(keyFile string, keyID string) (string, ed25519.PrivateKey) {
+	if keyID == "" {
+		keyID = strings.TrimSuffix(filepath.Base(keyFile), filepath.Ext(keyFile))
+	}
+
+	body, err := os.ReadFile(keyFile)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+	key, err := api.ParseEd25519PrivateKey(body)
+	if err != nil {
+		exitWithError(fmt.Sprintf("invalid key %s: %v", keyFile, err))
+	}
+	return keyID, key
+}
+
+// localImagePath returns the path of the local image named image, or
+// image if it's the path of an image file.
+func localImagePath(image string) string {
+	imagePath := path.Join(api.LocalImageDir, image)
+	if _, err := os.Stat(imagePath); err == nil {
+		return imagePath
+	}
+
+	if info, err := os.Stat(image); err == nil && !info.IsDir() {
+		return image
+	}
+	exitWithError(fmt.Sprintf("Local image %s not found", image))
+	return ""
+}
+
 func getLocalImageReader(flags *pflag.FlagSet, args []string) *fs.Reader {
 	c := api.NewConfig()
 	configFlags := NewConfigCommandFlags(flags)
@@ -133,17 +133,27 @@ func instanceCreateCommandHandler(cmd *cobra.Command, args []string) {
 	}
 
 	if c.Kernel == "" {
-		version, err := getCurrentVersion()
+		version, err := getCurrentVersion(c.Arch)
 		if err != nil {
 			fmt.Println(err)
 		}
-		version = setKernelVersion(version)
+		version = setKernelVersion(version, c.Arch)
 
 		c.Kernel = getKernelVersion(version)
 	}
 
 	c.RunConfig.Kernel = c.Kernel
 
+	err = verifyInstanceImage(p, ctx)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
+	err = lepton.InjectSecrets(c)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
 	err = p.CreateInstance(ctx)
 	if err != nil {
 		exitWithError(err.Error())
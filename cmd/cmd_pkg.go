@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"bufio"
+	"crypto/ed25519"
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
@@ -47,22 +48,30 @@ func PackageCommands() *cobra.Command {
 		Use:       "pkg",
 		Short:     "Package related commands",
 		Args:      cobra.OnlyValidArgs,
-		ValidArgs: []string{"list", "get", "describe", "delete", "contents", "add", "load", "from-docker", "login", "from-pkg"},
+		ValidArgs: []string{"list", "get", "describe", "delete", "contents", "add", "load", "from-docker", "from-oci", "login", "from-pkg", "serve", "lock", "prune", "sbom", "diff", "outdated"},
 	}
 
 	cmdPkgSearch.PersistentFlags().StringP("arch", "", "", "set different architecture")
+	PersistConfigCommandFlags(cmdPkgSearch.PersistentFlags())
 
 	cmdPkg.AddCommand(addCommand())
 	cmdPkg.AddCommand(getCommand())
 	cmdPkg.AddCommand(contentsCommand())
 	cmdPkg.AddCommand(describeCommand())
 	cmdPkg.AddCommand(fromDockerCommand())
+	cmdPkg.AddCommand(fromOCICommand())
 	cmdPkg.AddCommand(fromRunCommand())
 	cmdPkg.AddCommand(fromPackageCommand())
 	cmdPkg.AddCommand(listCommand())
 	cmdPkg.AddCommand(LoadCommand())
 	cmdPkg.AddCommand(DeleteCommand())
 	cmdPkg.AddCommand(pushCommand())
+	cmdPkg.AddCommand(serveCommand())
+	cmdPkg.AddCommand(lockCommand())
+	cmdPkg.AddCommand(pkgPruneCommand())
+	cmdPkg.AddCommand(pkgSBOMCommand())
+	cmdPkg.AddCommand(pkgDiffCommand())
+	cmdPkg.AddCommand(pkgOutdatedCommand())
 
 	cmdPkg.AddCommand(cmdPkgSearch)
 	cmdPkg.AddCommand(cmdPkgLogin)
@@ -79,11 +88,11 @@ func getPkgArch() string {
 }
 
 // translate amd64 -> x86_64
-func getPreferredArch() string {
+func getPreferredArch(arch string) string {
 
 	parch := "amd64"
-	if api.AltGOARCH != "" {
-		if api.AltGOARCH == "arm64" {
+	if arch != "" {
+		if arch == "arm64" {
 			parch = "arm64"
 		}
 	} else {
@@ -124,7 +133,7 @@ func cmdListPackages(cmd *cobra.Command, args []string) {
 			return
 		}
 	} else {
-		pkgList, err := api.GetPackageList(api.NewConfig())
+		pkgList, err := api.GetPackageList(c)
 		if err != nil {
 			log.Errorf("failed getting packages: %s", err)
 			return
@@ -160,7 +169,7 @@ func cmdListPackages(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	rt := getPreferredArch()
+	rt := getPreferredArch(c.Arch)
 
 	var rows [][]string
 	for _, pkg := range packages {
@@ -197,6 +206,18 @@ func cmdListPackages(cmd *cobra.Command, args []string) {
 func cmdSearchPackages(cmd *cobra.Command, args []string) {
 	q := args[0]
 
+	flags := cmd.Flags()
+	configFlags := NewConfigCommandFlags(flags)
+	globalFlags := NewGlobalCommandFlags(flags)
+
+	c := api.NewConfig()
+
+	mergeContainer := NewMergeConfigContainer(configFlags, globalFlags)
+	err := mergeContainer.Merge(c)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
 	arch, err := cmd.Flags().GetString("arch")
 	if err != nil {
 		exitWithError(err.Error())
@@ -213,13 +234,13 @@ func cmdSearchPackages(cmd *cobra.Command, args []string) {
 	rt := getPkgArch()
 
 	if arch != "" {
-		pkgs, err = api.SearchPackagesWithArch(q, arch)
+		pkgs, err = api.SearchPackageSources(c, q, arch)
 		if err != nil {
 			log.Errorf("Error while searching packages: %s", err.Error())
 			return
 		}
 	} else {
-		pkgs, err = api.SearchPackagesWithArch(q, rt)
+		pkgs, err = api.SearchPackageSources(c, q, rt)
 		if err != nil {
 			log.Errorf("Error while searching packages: %s", err.Error())
 			return
@@ -285,6 +306,11 @@ func getCommandHandler(cmd *cobra.Command, args []string) {
 		log.Fatal(errors.New("invalid package name. expected format <namespace>/<pkg>:<version>"))
 	}
 
+	requireSigned, _ := flags.GetBool("require-signed")
+	if requireSigned {
+		c.RequireSignedPackages = true
+	}
+
 	downloadPackage(args[0], c)
 }
 
@@ -326,6 +352,14 @@ func describeCommandHandler(cmd *cobra.Command, args []string) {
 		}
 	}
 
+	if !jsonOutput && !pkgFlags.LocalPackage {
+		idf := api.ParseIdentifier(args[0])
+		arches, err := api.PackageArches(c, idf.Namespace, idf.Name, idf.Version)
+		if err == nil && len(arches) > 0 {
+			fmt.Println("Available arches: " + strings.Join(arches, ", "))
+		}
+	}
+
 	description := path.Join(expackage, "README.md")
 	if _, err := os.Stat(description); err != nil {
 		log.Errorf("Error: Package information not provided.")
@@ -510,6 +544,38 @@ func fromDockerCommandHandler(cmd *cobra.Command, args []string) {
 	fmt.Println(packageName)
 }
 
+func fromOCICommandHandler(cmd *cobra.Command, args []string) {
+	flags := cmd.Flags()
+
+	c := api.NewConfig()
+
+	configFlags := NewConfigCommandFlags(flags)
+	globalFlags := NewGlobalCommandFlags(flags)
+	nightlyFlags := NewNightlyCommandFlags(flags)
+	pkgFlags := NewPkgCommandFlags(flags)
+
+	mergeContainer := NewMergeConfigContainer(configFlags, globalFlags, nightlyFlags, pkgFlags)
+	err := mergeContainer.Merge(c)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
+	imageName := args[0]
+	verbose, _ := flags.GetBool("verbose")
+	packageName, _ := flags.GetString("name")
+	targetExecutable, _ := flags.GetString("file")
+	copyWholeFS, _ := flags.GetBool("copy")
+	nodiscover, _ := flags.GetBool("nodiscover")
+
+	cmdArgs, err := flags.GetStringArray("args")
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
+	packageName, _ = ExtractFromOCIImage(imageName, packageName, pkgFlags.Parch(), targetExecutable, verbose, copyWholeFS, nodiscover, cmdArgs)
+	fmt.Println(packageName)
+}
+
 func fromPackageCommandHandler(cmd *cobra.Command, args []string) {
 	flags := cmd.Flags()
 
@@ -546,7 +612,7 @@ func fromPackageCommandHandler(cmd *cobra.Command, args []string) {
 	o := path.Join(api.GetOpsHome(), "packages", pkgFlags.Parch(), oldpkg)
 	ppath := o + "/package.manifest"
 	oldConfig := &types.Config{}
-	unWarpConfig(ppath, oldConfig)
+	unWarpPackageManifest(ppath, oldConfig)
 
 	api.ClonePackage(oldpkg, newpkg, version, pkgFlags.Parch(), oldConfig, c)
 }
@@ -641,16 +707,58 @@ func pushCommandHandler(cmd *cobra.Command, args []string) {
 		log.Fatalf("no local package with the name %s found", packageFolder)
 	}
 
-	// build the archive here
-	archiveName := filepath.Join(localPackages, pkgFlags.Parch(), packageFolder) + ".tar.gz"
+	arches, _ := flags.GetStringSlice("arches")
+	if len(arches) == 0 {
+		arches = []string{pkgFlags.Parch()}
+	}
 
-	err = api.CreateTarGz(filepath.Join(localPackages, pkgFlags.Parch(), packageFolder), archiveName)
-	if err != nil {
-		log.Fatal(err)
+	var keyID string
+	var key ed25519.PrivateKey
+	if keyFile, _ := flags.GetString("sign-key"); keyFile != "" {
+		signKeyID, _ := flags.GetString("sign-key-id")
+		keyID, key = readSigningKey(keyFile, signKeyID)
+	}
+
+	// build the archives here
+	archives := map[string]string{}
+	signatures := map[string]string{}
+	for _, arch := range arches {
+		if arch != "amd64" && arch != "arm64" {
+			log.Fatalf("unsupported arch %s, use amd64 or arm64", arch)
+		}
+
+		pkgDir := filepath.Join(localPackages, arch, packageFolder)
+		if _, err := os.Stat(pkgDir); os.IsNotExist(err) {
+			log.Fatalf("no local package %s found for %s", packageFolder, arch)
+		}
+
+		archiveName := pkgDir + ".tar.gz"
+		err = api.CreateTarGz(pkgDir, archiveName)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer os.RemoveAll(archiveName)
+
+		archives[arch] = archiveName
+
+		if key == nil {
+			continue
+		}
+		sig, err := api.SignPackageArchive(archiveName, keyID, key)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sigName := archiveName + api.PackageSignatureSuffix
+		err = os.WriteFile(sigName, sig, 0644)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer os.RemoveAll(sigName)
+
+		signatures[arch] = sigName
 	}
-	defer os.RemoveAll(archiveName)
 
-	req, err := api.BuildRequestForArchiveUpload(ns, name, foundPkg, archiveName, private, pkgFlags.Parch())
+	req, err := api.BuildRequestForArchiveUpload(ns, name, foundPkg, archives, signatures, private)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -768,6 +876,7 @@ func getCommand() *cobra.Command {
 
 	PersistConfigCommandFlags(persistentFlags)
 	PersistNightlyCommandFlags(persistentFlags)
+	persistentFlags.Bool("require-signed", false, "refuse packages not signed by a key of the trust store")
 
 	return cmdGetPackage
 }
@@ -806,6 +915,36 @@ func describeCommand() *cobra.Command {
 	return cmdDescribePackage
 }
 
+func fromOCICommand() *cobra.Command {
+	var cmdFromOCI = &cobra.Command{
+		Use:   "from-oci [image|layout|tarball]",
+		Short: "create a package from an executable of an OCI image without docker",
+		Long: `Create a package from an executable of an OCI image without a docker
+daemon. The image is pulled from its registry (using the credentials of
+docker login if any) or read from an OCI layout directory or a tarball
+made by docker save. Its layers are flattened and the executable, the
+entrypoint of the image unless --file is given, is packaged along with
+its shared libraries.`,
+		Args: cobra.ExactArgs(1),
+		Run:  fromOCICommandHandler,
+	}
+
+	persistentFlags := cmdFromOCI.PersistentFlags()
+
+	PersistConfigCommandFlags(persistentFlags)
+	PersistNightlyCommandFlags(persistentFlags)
+
+	persistentFlags.Bool("verbose", false, "verbose mode")
+	persistentFlags.StringP("file", "", "", "target executable")
+	persistentFlags.BoolP("copy", "", false, "copy whole file system")
+	persistentFlags.StringP("name", "", "", "name of the package")
+	persistentFlags.BoolP("local", "l", false, "load local package")
+	persistentFlags.BoolP("nodiscover", "", false, "don't try to discover linked libs")
+	persistentFlags.StringArrayP("args", "a", nil, "command line arguments")
+
+	return cmdFromOCI
+}
+
 func fromDockerCommand() *cobra.Command {
 
 	var cmdFromDocker = &cobra.Command{
@@ -908,6 +1047,9 @@ func pushCommand() *cobra.Command {
 	PersistNightlyCommandFlags(persistentFlags)
 	persistentFlags.BoolP("local", "l", false, "load local package")
 	persistentFlags.BoolP("private", "p", false, "set the package as private")
+	persistentFlags.StringSlice("arches", nil, "publish the local packages of these arches as one release (eg: amd64,arm64)")
+	persistentFlags.String("sign-key", "", "ed25519 private key file the archives are signed with")
+	persistentFlags.String("sign-key-id", "", "id of the sign key in the trust store, the name of the key file by default")
 
 	return cmdPushPackage
 }
@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	api "github.com/nanovms/ops/lepton"
+
+	"github.com/dustin/go-humanize"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+func pkgDiffCommand() *cobra.Command {
+	cmdDiff := &cobra.Command{
+		Use:   "diff <packagename> <packagename>",
+		Short: "show what changes between two packages",
+		Long: `Show what changes between two packages, e.g. two versions of the same
+package: the Program, Args, Env, Klibs and Dirs of the package manifests
+and the files of the sysroots with their sizes and hashes. The packages
+are downloaded if needed.`,
+		Example: "  ops pkg diff eyberg/node:18.12.1 eyberg/node:20.5.0",
+		Args:    cobra.ExactArgs(2),
+		Run:     pkgDiffCommandHandler,
+	}
+
+	persistentFlags := cmdDiff.PersistentFlags()
+	PersistConfigCommandFlags(persistentFlags)
+	persistentFlags.BoolP("local", "l", false, "load local packages")
+
+	return cmdDiff
+}
+
+func pkgDiffCommandHandler(cmd *cobra.Command, args []string) {
+	flags := cmd.Flags()
+	configFlags := NewConfigCommandFlags(flags)
+	globalFlags := NewGlobalCommandFlags(flags)
+	pkgFlags := NewPkgCommandFlags(flags)
+
+	c := api.NewConfig()
+
+	mergeContainer := NewMergeConfigContainer(configFlags, globalFlags, pkgFlags)
+	err := mergeContainer.Merge(c)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
+	local, _ := flags.GetBool("local")
+
+	dirs := make([]string, len(args))
+	for i, pkg := range args {
+		if !local && len(strings.Split(pkg, "/")) < 2 {
+			exitWithError("invalid package name. expected format <namespace>/<pkg>:<version>")
+		}
+
+		pkgFlags.Package = pkg
+		dirs[i] = pkgFlags.PackagePath()
+		if _, err := os.Stat(dirs[i]); os.IsNotExist(err) {
+			dirs[i], err = downloadPackage(pkg, c)
+			if err != nil {
+				exitWithError(err.Error())
+			}
+		}
+	}
+
+	diff, err := api.DiffPackages(dirs[0], dirs[1])
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
+	if jsonOutput, _ := flags.GetBool("json"); jsonOutput {
+		printJSON(diff)
+		return
+	}
+
+	if diff.Empty() {
+		fmt.Printf("%s and %s are identical\n", args[0], args[1])
+		return
+	}
+
+	if len(diff.Manifest) > 0 {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Field", args[0], args[1]})
+		table.SetHeaderColor(
+			tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
+			tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
+			tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor})
+		table.SetRowLine(true)
+
+		for _, f := range diff.Manifest {
+			table.Append([]string{f.Field, f.Old, f.New})
+		}
+		table.Render()
+	}
+
+	if len(diff.Files) > 0 {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Change", "Path", "Old Size", "New Size"})
+		table.SetHeaderColor(
+			tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
+			tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
+			tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
+			tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor})
+
+		size := func(s int64, sha string) string {
+			if sha == "" {
+				return ""
+			}
+			return humanize.Bytes(uint64(s))
+		}
+
+		for _, f := range diff.Files {
+			table.Append([]string{f.Change, f.Path, size(f.OldSize, f.OldSHA256), size(f.NewSize, f.NewSHA256)})
+		}
+		table.Render()
+	}
+}
+
+func pkgOutdatedCommand() *cobra.Command {
+	cmdOutdated := &cobra.Command{
+		Use:   "outdated",
+		Short: "list downloaded packages with newer versions available",
+		Args:  cobra.NoArgs,
+		Run:   pkgOutdatedCommandHandler,
+	}
+
+	PersistConfigCommandFlags(cmdOutdated.PersistentFlags())
+
+	return cmdOutdated
+}
+
+func pkgOutdatedCommandHandler(cmd *cobra.Command, args []string) {
+	flags := cmd.Flags()
+	configFlags := NewConfigCommandFlags(flags)
+	globalFlags := NewGlobalCommandFlags(flags)
+
+	c := api.NewConfig()
+
+	mergeContainer := NewMergeConfigContainer(configFlags, globalFlags)
+	err := mergeContainer.Merge(c)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
+	outdated, err := api.OutdatedPackages(c)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
+	if jsonOutput, _ := flags.GetBool("json"); jsonOutput {
+		printJSON(outdated)
+		return
+	}
+
+	if len(outdated) == 0 {
+		fmt.Println("all packages are up to date")
+		return
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Package", "Arch", "Version", "Latest"})
+	table.SetHeaderColor(
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor})
+
+	for _, p := range outdated {
+		table.Append([]string{p.Name, p.Arch, p.Version, p.Latest})
+	}
+	table.Render()
+}
@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	api "github.com/nanovms/ops/lepton"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+func lockCommand() *cobra.Command {
+	var cmdLock = &cobra.Command{
+		Use:   "lock [packages...]",
+		Short: "lock package versions in ops.lock",
+		Long: `Resolve packages against the package sources and record their version,
+archive sha256 and source in ops.lock of the working directory. The
+dependencies of packages, and of the config with -c, are locked too.
+
+'ops pkg load', 'ops build --package', 'ops pkg get' and 'ops compose up'
+use the locked versions of packages without a version (or latest) and
+fail if a package doesn't match the lock.
+
+Locked packages are updated by locking them again.`,
+		Run: lockCommandHandler,
+	}
+
+	persistentFlags := cmdLock.PersistentFlags()
+
+	PersistConfigCommandFlags(persistentFlags)
+	persistentFlags.StringP("compose", "f", "", "also lock the packages of a compose file")
+	persistentFlags.StringP("arch", "", "", "architecture to lock the packages for")
+
+	return cmdLock
+}
+
+// lockTarget is a package to lock for an arch.
+type lockTarget struct {
+	pkg  string
+	arch string
+}
+
+// composeLockTargets returns the packages of the compose file that
+// should be locked.
+func composeLockTargets(body []byte, arch string) ([]lockTarget, error) {
+	if isDockerCompose(body) {
+		return nil, fmt.Errorf("docker compose files only use local packages, there is nothing to lock")
+	}
+
+	y := ComposeFile{}
+	err := yaml.Unmarshal(body, &y)
+	if err != nil {
+		return nil, err
+	}
+
+	pkgs := []lockTarget{{composeDNSPackage, arch}}
+	for _, comp := range y.Packages {
+		if comp.Local {
+			continue
+		}
+
+		a := arch
+		if comp.Arch != "" {
+			a = comp.Arch
+		}
+		pkgs = append(pkgs, lockTarget{comp.Name, a})
+	}
+
+	return pkgs, nil
+}
+
+func lockCommandHandler(cmd *cobra.Command, args []string) {
+	flags := cmd.Flags()
+	configFlags := NewConfigCommandFlags(flags)
+	globalFlags := NewGlobalCommandFlags(flags)
+
+	c := api.NewConfig()
+
+	mergeContainer := NewMergeConfigContainer(configFlags, globalFlags)
+	err := mergeContainer.Merge(c)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
+	arch, _ := flags.GetString("arch")
+	if arch == "" {
+		arch = api.TargetArch(c)
+	}
+
+	pkgs := []lockTarget{}
+	for _, pkg := range args {
+		pkgs = append(pkgs, lockTarget{pkg, arch})
+	}
+	for _, dep := range c.Dependencies {
+		pkgs = append(pkgs, lockTarget{dep, arch})
+	}
+
+	composeFile, _ := flags.GetString("compose")
+	if composeFile != "" {
+		body, err := os.ReadFile(composeFile)
+		if err != nil {
+			exitWithError(err.Error())
+		}
+
+		cpkgs, err := composeLockTargets(body, arch)
+		if err != nil {
+			exitWithError(err.Error())
+		}
+		pkgs = append(pkgs, cpkgs...)
+	}
+
+	if len(pkgs) == 0 {
+		exitWithError("no packages to lock, pass packages, a config with Dependencies or a compose file")
+	}
+
+	lock, err := api.ReadPackageLock(api.PackageLockFile)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+	if lock == nil {
+		lock = &api.PackageLock{}
+	}
+
+	for _, p := range pkgs {
+		locks, err := api.LockPackageWithDependencies(c, p.pkg, p.arch)
+		if err != nil {
+			exitWithError(fmt.Sprintf("failed locking %s: %v", p.pkg, err))
+		}
+
+		for _, locked := range locks {
+			lock.Add(locked)
+			fmt.Printf("locked %s (%s) sha256:%s\n", locked.Identifier(), locked.Arch, locked.SHA256)
+		}
+	}
+
+	err = lock.Write(api.PackageLockFile)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+
+	api "github.com/nanovms/ops/lepton"
+	"github.com/nanovms/ops/log"
+	"github.com/nanovms/ops/registry"
+
+	"github.com/spf13/cobra"
+)
+
+func serveCommand() *cobra.Command {
+	var cmdServe = &cobra.Command{
+		Use:   "serve",
+		Short: "serve a private package registry",
+		Long: `Serve a package registry compatible with pkghub backed by a local directory.
+
+Point ops at it with:
+
+	export OPS_PKGHUB_BASE_URL=http://<host>:<port>
+	export OPS_PACKAGE_BASE_URL=http://<host>:<port>/v2/packages
+	export OPS_PACKAGE_MANIFEST_URL=http://<host>:<port>/v2/manifest.json
+
+Pushing packages and reading private ones needs an api key from the auth
+file (default <dir>/auth.json):
+
+	{"keys": [{"key": "secret", "username": "alice", "namespaces": ["alice"]}]}
+
+Without it the registry is read only.`,
+		Args: cobra.NoArgs,
+		Run:  serveCommandHandler,
+	}
+
+	persistentFlags := cmdServe.PersistentFlags()
+
+	persistentFlags.StringP("dir", "d", filepath.Join(api.GetOpsHome(), "registry"), "directory packages are stored in")
+	persistentFlags.StringP("auth", "", "", "api keys file (default <dir>/auth.json)")
+	persistentFlags.StringP("addr", "", ":8000", "address to listen on")
+
+	return cmdServe
+}
+
+func serveCommandHandler(cmd *cobra.Command, args []string) {
+	flags := cmd.Flags()
+
+	dir, _ := flags.GetString("dir")
+	authPath, _ := flags.GetString("auth")
+	addr, _ := flags.GetString("addr")
+
+	if authPath == "" {
+		authPath = filepath.Join(dir, "auth.json")
+	}
+
+	store, err := registry.NewStore(dir)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
+	auth, err := registry.LoadAuth(authPath)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
+	if auth == nil {
+		fmt.Printf("no api keys found in %s, the registry is read only\n", authPath)
+	}
+
+	server := &registry.Server{
+		Store: store,
+		Auth:  auth,
+	}
+
+	fmt.Printf("serving packages from %s on %s\n", dir, addr)
+	log.Fatal(http.ListenAndServe(addr, server.Handler()))
+}
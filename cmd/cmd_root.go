@@ -27,7 +27,8 @@ func GetRootCommand() *cobra.Command {
 			configFlag = strings.TrimSpace(configFlag)
 
 			if configFlag != "" {
-				if err := unWarpConfig(configFlag, config); err != nil {
+				profileFlag, _ := cmd.Flags().GetString("profile")
+				if err := unWarpConfigProfile(configFlag, profileFlag, config); err != nil {
 					return err
 				}
 			}
@@ -53,7 +54,10 @@ func GetRootCommand() *cobra.Command {
 	rootCmd.AddCommand(NetworkCommands())
 	rootCmd.AddCommand(ProfileCommand())
 	rootCmd.AddCommand(PackageCommands())
+	rootCmd.AddCommand(CacheCommands())
+	rootCmd.AddCommand(ConfigCommands())
 	rootCmd.AddCommand(RunCommand())
+	rootCmd.AddCommand(SecretCommands())
 	rootCmd.AddCommand(ComposeCommands())
 
 	rootCmd.AddCommand(UpdateCommand())
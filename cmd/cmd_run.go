@@ -7,6 +7,7 @@ import (
 
 	"github.com/nanovms/ops/lepton"
 	api "github.com/nanovms/ops/lepton"
+	"github.com/nanovms/ops/log"
 	"github.com/nanovms/ops/provider/onprem"
 	"github.com/spf13/cobra"
 )
@@ -63,6 +64,10 @@ func runCommandHandler(cmd *cobra.Command, args []string) {
 		exitWithError(err.Error())
 	}
 
+	if len(c.Secrets.Values) > 0 {
+		log.Warn("secrets are passed in the user data of cloud instances, local instances don't get them")
+	}
+
 	qmp, _ := cmd.Flags().GetBool("qmp")
 	if qmp {
 		c.RunConfig.QMP = true
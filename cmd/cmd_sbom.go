@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	api "github.com/nanovms/ops/lepton"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+func persistSBOMFlags(flags *pflag.FlagSet) {
+	flags.String("format", api.SBOMFormatCycloneDX, "sbom format: cyclonedx or spdx")
+	flags.StringP("output", "o", "", "write the sbom to this file instead of stdout")
+}
+
+func pkgSBOMCommand() *cobra.Command {
+	cmdSBOM := &cobra.Command{
+		Use:   "sbom [packagename]",
+		Short: "generate the software bill of materials of a package",
+		Long: `Generate the software bill of materials of a package as CycloneDX or
+SPDX JSON. It lists the ELF binaries and shared libraries of the package
+with the libraries they are linked against, the debian and alpine
+packages recorded in dpkg/apk databases and the package dependencies.`,
+		Args: cobra.ExactArgs(1),
+		Run:  pkgSBOMCommandHandler,
+	}
+
+	persistentFlags := cmdSBOM.PersistentFlags()
+	PersistConfigCommandFlags(persistentFlags)
+	persistentFlags.BoolP("local", "l", false, "load local package")
+	persistSBOMFlags(persistentFlags)
+
+	return cmdSBOM
+}
+
+func pkgSBOMCommandHandler(cmd *cobra.Command, args []string) {
+	flags := cmd.Flags()
+	configFlags := NewConfigCommandFlags(flags)
+	globalFlags := NewGlobalCommandFlags(flags)
+	pkgFlags := NewPkgCommandFlags(flags)
+
+	c := api.NewConfig()
+
+	mergeContainer := NewMergeConfigContainer(configFlags, globalFlags, pkgFlags)
+	err := mergeContainer.Merge(c)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
+	local, _ := flags.GetBool("local")
+	if !local && len(strings.Split(args[0], "/")) < 2 {
+		exitWithError("invalid package name. expected format <namespace>/<pkg>:<version>")
+	}
+
+	pkgFlags.Package = args[0]
+
+	expackage := pkgFlags.PackagePath()
+	if _, err := os.Stat(expackage); os.IsNotExist(err) {
+		expackage, err = downloadPackage(args[0], c)
+		if err != nil {
+			exitWithError(err.Error())
+		}
+	}
+
+	sbom, err := api.PackageSBOM(expackage, args[0])
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
+	writeSBOM(cmd, sbom)
+}
+
+func imageSBOMCommand() *cobra.Command {
+	cmdSBOM := &cobra.Command{
+		Use:   "sbom <image_name>",
+		Short: "generate the software bill of materials of an image",
+		Long: `Generate the software bill of materials of a local image as CycloneDX
+or SPDX JSON. It lists the ELF binaries and shared libraries of the image
+with the libraries they are linked against and the debian and alpine
+packages recorded in dpkg/apk databases.`,
+		Args: cobra.ExactArgs(1),
+		Run:  imageSBOMCommandHandler,
+	}
+
+	persistSBOMFlags(cmdSBOM.PersistentFlags())
+	return cmdSBOM
+}
+
+func imageSBOMCommandHandler(cmd *cobra.Command, args []string) {
+	reader := getLocalImageReader(cmd.Flags(), args)
+	defer reader.Close()
+
+	sbom, err := api.ImageSBOM(reader, args[0])
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
+	writeSBOM(cmd, sbom)
+}
+
+func writeSBOM(cmd *cobra.Command, sbom *api.SBOM) {
+	format, _ := cmd.Flags().GetString("format")
+	output, _ := cmd.Flags().GetString("output")
+
+	body, err := sbom.Encode(format)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
+	if output == "" {
+		fmt.Println(string(body))
+		return
+	}
+
+	err = os.WriteFile(output, body, 0644)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+	fmt.Printf("sbom of %s written to %s, %d components\n", sbom.Name, output, len(sbom.Components))
+}
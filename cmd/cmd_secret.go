@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	api "github.com/nanovms/ops/lepton"
+
+	"github.com/spf13/cobra"
+)
+
+// SecretCommands handles the local secret store config secrets can be
+// read from
+func SecretCommands() *cobra.Command {
+	cmdSecret := &cobra.Command{
+		Use:       "secret",
+		Short:     "manage the local secret store",
+		Long:      "Manage the secrets of the local store, read by config secrets with \"Store\".",
+		ValidArgs: []string{"set", "get", "list", "delete"},
+		Args:      cobra.OnlyValidArgs,
+	}
+
+	cmdSecret.AddCommand(secretSetCommand())
+	cmdSecret.AddCommand(secretGetCommand())
+	cmdSecret.AddCommand(secretListCommand())
+	cmdSecret.AddCommand(secretDeleteCommand())
+	return cmdSecret
+}
+
+func secretSetCommand() *cobra.Command {
+	cmdSet := &cobra.Command{
+		Use:   "set <name>",
+		Short: "set a secret, read from stdin or --from-file",
+		Args:  cobra.ExactArgs(1),
+		Run:   secretSetCommandHandler,
+	}
+	cmdSet.Flags().String("from-file", "", "file to read the secret from")
+	return cmdSet
+}
+
+func secretSetCommandHandler(cmd *cobra.Command, args []string) {
+	file, _ := cmd.Flags().GetString("from-file")
+
+	var body []byte
+	var err error
+	if file != "" {
+		body, err = os.ReadFile(file)
+	} else {
+		body, err = io.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
+	err = api.SetSecret(args[0], strings.TrimRight(string(body), "\r\n"))
+	if err != nil {
+		exitWithError(err.Error())
+	}
+}
+
+func secretGetCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "get <name>",
+		Short: "print a secret",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			value, err := api.GetSecret(args[0])
+			if err != nil {
+				exitWithError(err.Error())
+			}
+			fmt.Println(value)
+		},
+	}
+}
+
+func secretListCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "list the names of the secrets",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			names, err := api.ListSecrets()
+			if err != nil {
+				exitWithError(err.Error())
+			}
+
+			if jsonOutput, _ := cmd.Flags().GetBool("json"); jsonOutput {
+				if names == nil {
+					names = []string{}
+				}
+				printJSON(names)
+				return
+			}
+
+			for _, name := range names {
+				fmt.Println(name)
+			}
+		},
+	}
+}
+
+func secretDeleteCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "delete <name>",
+		Short: "delete a secret",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			err := api.DeleteSecret(args[0])
+			if err != nil {
+				exitWithError(err.Error())
+			}
+		},
+	}
+}
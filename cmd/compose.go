@@ -1,8 +1,6 @@
 package cmd
 
 import (
-	"crypto/sha1"
-	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -16,7 +14,9 @@ import (
 	"time"
 
 	api "github.com/nanovms/ops/lepton"
+	"github.com/nanovms/ops/network"
 	"github.com/nanovms/ops/provider/onprem"
+	"github.com/nanovms/ops/qemu"
 	"github.com/nanovms/ops/types"
 
 	"gopkg.in/yaml.v2"
@@ -43,11 +43,27 @@ import (
 //     name: mynewserver:0.0.1
 //   - pkg: myclient
 //     name: mynewclient:0.0.1
+//     depends_on: [myserver]
+//
+// services are started in parallel (see --parallel) once the packages
+// they depend on are up; each can set its own arch.
+//
+// a docker-compose.yml can be used instead; each service is turned into
+// a local package from its image (see 'ops compose convert').
+//
+// it can also target a cloud with 'ops compose up -t gcp'; then each
+// service is registered in the dns of the provider as name.domain
+// (domain defaults to service.internal) instead.
 //
 // much of this probably belongs in a diff. pkg but not sure what to do
 // there yet
 type Compose struct {
-	config *types.Config // don't think this belongs here
+	config   *types.Config // don't think this belongs here
+	parallel int           // max services started at once
+
+	// copy the whole fs of docker images when importing a
+	// docker-compose.yml
+	copyWholeFS bool
 }
 
 func getComposeContents(composeFile string) []byte {
@@ -71,6 +87,27 @@ func getComposeContents(composeFile string) []byte {
 	return body
 }
 
+// loadCompose parses the contents of a compose file. A
+// docker-compose.yml is translated on the fly, creating the packages of
+// its services from their images.
+func (com Compose) loadCompose(body []byte, composeFile string) (ComposeFile, error) {
+	y := ComposeFile{}
+
+	if isDockerCompose(body) {
+		dir := filepath.Dir(composeFile)
+		if composeFile == "" {
+			dir, _ = os.Getwd()
+		}
+		return convertDockerCompose(body, dir, com.config.Arch, com.copyWholeFS, com.config.RunConfig.Verbose)
+	}
+
+	err := yaml.Unmarshal(body, &y)
+	return y, err
+}
+
+// composeDNSPackage is the package used for service discovery.
+const composeDNSPackage = "eyberg/ops-dns:0.0.1"
+
 func genBridgeName() string {
 	return "ops0"
 }
@@ -82,23 +119,32 @@ func (com Compose) validatePackagesExist(y ComposeFile) {
 
 		pkgName := comp.Name
 		local := comp.Local
-		arch := comp.Arch
-
-		api.AltGOARCH = arch // this isn't really mt-safe..
+		arch := com.archFor(comp)
 
 		pkgFlags := PkgCommandFlags{
 			Package:      pkgName,
 			LocalPackage: local,
+			Arch:         arch,
 		}
 
 		if !local {
+			pinned, err := api.PinPackage(pkgName, arch)
+			if err != nil {
+				exitWithError(err.Error())
+			}
+
+			// packages are looked up by name later on
+			y.Packages[i].Name = pinned
+			pkgName = pinned
+			pkgFlags.Package = pinned
+
 			ppath := filepath.Join(pkgFlags.PackagePath()) + "/package.manifest"
 
-			_, err := os.Stat(ppath)
+			_, err = os.Stat(ppath)
 			if err != nil {
 				if os.IsNotExist(err) {
 					fmt.Printf("%s not found - downloading\n", pkgName)
-					downloadPackage(pkgName, &types.Config{})
+					downloadPackage(pkgName, &types.Config{Arch: arch})
 				}
 			}
 		}
@@ -111,54 +157,234 @@ func (com Compose) validatePackagesExist(y ComposeFile) {
 func (com Compose) UP(composeFile string) {
 
 	body := getComposeContents(composeFile)
+	sha := composeSHA(body)
 
-	h := sha1.New()
-	h.Write([]byte(body))
-	sha := hex.EncodeToString(h.Sum(nil))
-
-	y := ComposeFile{}
-
-	err := yaml.Unmarshal(body, &y)
+	y, err := com.loadCompose(body, composeFile)
 	if err != nil {
-		fmt.Println(err)
+		exitWithError(err.Error())
 	}
 
 	com.validatePackagesExist(y)
 
+	if com.config.CloudConfig.Platform != "onprem" {
+		state, err := com.upCloud(y, sha)
+		if err != nil {
+			exitWithError(err.Error())
+		}
+
+		com.printSummary(state)
+		return
+	}
+
 	brName := genBridgeName()
+	non := genNon(32)
 
-	opshome := api.GetOpsHome()
-	composes := path.Join(opshome, "composes")
+	// record everything we create as we go so 'compose down' can clean
+	// up even if we fail half-way through.
+	state := &ComposeState{
+		Target: "onprem",
+		Bridge: brName,
+		Nonce:  non,
+	}
+	com.saveState(state, sha)
 
-	err = os.WriteFile(composes+"/"+sha, []byte(brName), 0644)
-	if err != nil {
-		fmt.Println(err)
+	state.DNS = &ComposeInstance{
+		Name:  "dns",
+		Pkg:   composeDNSPackage,
+		Image: "dns",
+		Tap:   composeTapName("dns"),
 	}
+	com.saveState(state, sha)
 
-	non := genNon(32)
 	pid := com.spawnDNS(non, brName)
+	state.DNS.PID = pid
+	com.saveState(state, sha)
 
 	dnsIP, err := com.waitForIP(pid)
 	if err != nil {
 		fmt.Println(err)
 	}
+	state.DNS.IP = dnsIP
+	com.saveState(state, sha)
 
 	// FIXME
 	version := api.LocalReleaseVersion
 	com.config.Boot = path.Join(api.GetOpsHome(), version, "boot.img")
 
 	// spawn other pkgs
-	for i := 0; i < len(y.Packages); i++ {
-		pid := com.spawnProgram(y.Packages[i], dnsIP, com.config, brName)
+	err = com.startServices(y, state, sha, func(comp ComposePackage, c *types.Config, ci ComposeInstance, record func(ComposeInstance)) error {
+		pid, err := com.spawnProgram(comp, dnsIP, c, brName)
+		if err != nil {
+			return err
+		}
+		ci.PID = pid
+		record(ci)
+
 		ip, err := com.waitForIP(pid)
 		if err != nil {
-			fmt.Println(err)
+			return fmt.Errorf("%s: %v", comp.Pkg, err)
+		}
+		ci.IP = ip
+		record(ci)
+
+		com.addDNS(dnsIP, comp.Pkg, ip, non)
+		return nil
+	})
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
+	com.printSummary(state)
+}
+
+// Down tears down everything recorded in the state of a compose
+// started with UP. It keeps going on errors and only forgets about
+// what it was able to remove so it can be run again after a partial
+// failure.
+func (com Compose) Down(composeFile string) error {
+	body := getComposeContents(composeFile)
+	sha := composeSHA(body)
+
+	state, err := readComposeState(sha)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			fmt.Println("no running compose found for this compose file")
+			return nil
+		}
+		return err
+	}
+
+	if state.DNS == nil && len(state.Services) == 0 && state.Bridge != "" {
+		fmt.Println("compose state predates instance tracking - only the bridge will be removed")
+	}
+
+	if state.isOnPrem() && runtime.GOOS == "darwin" && qemu.OPSD == "" {
+		return errors.New("this command is only enabled if you have OPSD compiled in")
+	}
+
+	// tear down on whatever the compose was started on
+	target := "onprem"
+	if !state.isOnPrem() {
+		target = state.Target
+		com.config.CloudConfig.Platform = state.Target
+		if state.ProjectID != "" {
+			com.config.CloudConfig.ProjectID = state.ProjectID
+		}
+		if state.Zone != "" {
+			com.config.CloudConfig.Zone = state.Zone
+		}
+	}
+
+	p, ctx, err := getProviderAndContext(com.config, target)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+
+	remaining := []ComposeInstance{}
+	for _, svc := range state.Services {
+		if state.DNS != nil && state.DNS.IP != "" && svc.IP != "" {
+			com.removeDNS(state.DNS.IP, svc.Name, state.Nonce)
+		}
+
+		if !state.isOnPrem() && svc.IP != "" {
+			if err := com.removeCloudDNS(p, state.endpoint(svc)); err != nil {
+				errs = append(errs, err)
+				remaining = append(remaining, svc)
+				continue
+			}
+		}
+
+		if err := com.teardownInstance(p, ctx, svc); err != nil {
+			errs = append(errs, err)
+			remaining = append(remaining, svc)
+		}
+	}
+	state.Services = remaining
+
+	if state.DNS != nil && len(state.Services) == 0 {
+		if err := com.teardownInstance(p, ctx, *state.DNS); err != nil {
+			errs = append(errs, err)
+		} else {
+			state.DNS = nil
+		}
+	}
+
+	if state.Bridge != "" && state.DNS == nil && len(state.Services) == 0 {
+		if runtime.GOOS == "linux" {
+			removeBridge(state.Bridge)
+		}
+		state.Bridge = ""
+	}
+
+	if state.isEmpty() {
+		return state.remove(sha)
+	}
+
+	com.saveState(state, sha)
+
+	return fmt.Errorf("compose was not fully torn down, run 'ops compose down' again: %v", errors.Join(errs...))
+}
+
+// teardownInstance stops the instance and removes its tap device. It
+// is not an error if either is already gone.
+func (com Compose) teardownInstance(p api.Provider, ctx *api.Context, ci ComposeInstance) error {
+	if ci.PID != "" {
+		instance, err := p.GetInstanceByName(ctx, ci.Name)
+		if err != nil && !api.IsInstanceNotFoundError(err) {
+			return err
+		}
+
+		// only kill it if it is still the one we started
+		if err == nil && strings.TrimSpace(instance.ID) == strings.TrimSpace(ci.PID) {
+			if com.config.RunConfig.ShowDebug {
+				fmt.Printf("deleting instance %s with pid %s\n", ci.Name, ci.PID)
+			}
+
+			err = p.DeleteInstance(ctx, ci.Name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if ci.Tap != "" && runtime.GOOS == "linux" {
+		networkService := network.NewIprouteNetworkService()
+
+		exists, err := networkService.CheckNetworkInterfaceExists(ci.Tap)
+		if err != nil {
+			return err
+		}
+
+		if exists {
+			_, err = networkService.DeleteNIC(ci.Tap)
+			if err != nil {
+				return err
+			}
 		}
+	}
+
+	return nil
+}
 
-		com.addDNS(dnsIP, y.Packages[i].Pkg, ip, non)
+func (com Compose) saveState(state *ComposeState, sha string) {
+	err := state.save(sha)
+	if err != nil {
+		fmt.Println(err)
 	}
 }
 
+// composeTapName returns the tap device used for an instance in a
+// compose; taps are only used on linux.
+func composeTapName(name string) string {
+	if runtime.GOOS != "linux" {
+		return ""
+	}
+
+	return name
+}
+
 func (com Compose) waitForIP(pid string) (string, error) {
 	ip := ""
 	for i := 0; i < 20; i++ {
@@ -206,19 +432,81 @@ func (com Compose) addDNS(dnsIP string, host string, ip string, non string) {
 	}
 }
 
-func (com Compose) spawnProgram(comp ComposePackage, dnsIP string, c *types.Config, brName string) string {
+func (com Compose) removeDNS(dnsIP string, host string, non string) {
+	client := &http.Client{
+		Timeout: 2 * time.Second,
+	}
+	if com.config.RunConfig.ShowDebug {
+		fmt.Printf("removing record %s\n", host)
+	}
+	req, err := http.NewRequest("GET", "http://"+dnsIP+":8080/delete?svc="+host+".service", nil)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	// the dns instance might already be gone; nothing to do then
+	res, err := client.Do(req)
+	if err != nil {
+		if com.config.RunConfig.ShowDebug {
+			fmt.Println(err)
+		}
+		return
+	}
+	res.Body.Close()
+}
+
+func (com Compose) spawnProgram(comp ComposePackage, dnsIP string, c *types.Config, brName string) (string, error) {
+	pname := comp.Pkg
+
+	p, ctx, err := com.buildProgramImage(comp, c, []string{dnsIP})
+	if err != nil {
+		return "", err
+	}
+
+	if runtime.GOOS == "linux" {
+		// linux specific config for compose - need a ifdef here
+		c.RunConfig.Bridged = true  // prob. need to set the actual bridge name?
+		c.RunConfig.TapName = pname // should prob. generate these - max 16 chars && no '/'
+
+		// TODO: pass this in as uniq bridge group if under linux
+		c.RunConfig.BridgeName = brName
+	}
+
+	c.RunConfig.QMP = true
+
+	err = verifyInstanceImage(p, ctx)
+	if err != nil {
+		return "", err
+	}
+
+	z := p.(*onprem.OnPrem)
+	pid, err := z.CreateInstancePID(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if c.RunConfig.ShowDebug {
+		fmt.Printf("%s instance with pid %s '%s' created...\n", c.CloudConfig.Platform, pid, c.RunConfig.InstanceName)
+	}
+
+	return pid, nil
+}
+
+// buildProgramImage loads the package of a compose service into c and
+// builds its image on the target platform. The instance and image are
+// both named after the service.
+func (com Compose) buildProgramImage(comp ComposePackage, c *types.Config, nameServers []string) (api.Provider, *api.Context, error) {
 
 	pkgName := comp.Name
 	pname := comp.Pkg
 	local := comp.Local
-	arch := comp.Arch
 	baseVolumeSz := comp.BaseVolumeSz
 
-	api.AltGOARCH = arch // this isn't really mt-safe..
-
 	pkgFlags := PkgCommandFlags{
 		Package:      pkgName,
 		LocalPackage: local,
+		Arch:         c.Arch,
 	}
 
 	ppath := filepath.Join(pkgFlags.PackagePath()) + "/package.manifest"
@@ -226,21 +514,36 @@ func (com Compose) spawnProgram(comp ComposePackage, dnsIP string, c *types.Conf
 		ppath = strings.ReplaceAll(ppath, ":", "_")
 	}
 
-	unWarpConfig(ppath, c)
+	unWarpPackageManifest(ppath, c)
 
 	if baseVolumeSz != "" {
 		c.BaseVolumeSz = baseVolumeSz
 	}
 
-	// we need to reset this for each instance in the compose.
-	// FIXME: this is not mt-safe at all; eventually api.AltGOARCH needs
-	// to be instantiated per instance - it's just that every other
-	// operation in ops assumes a single event.
-	version, err := getCurrentVersion()
+	for k, v := range comp.Env {
+		if c.Env == nil {
+			c.Env = map[string]string{}
+		}
+		c.Env[k] = v
+	}
+
+	for k, v := range comp.MapDirs {
+		if c.MapDirs == nil {
+			c.MapDirs = map[string]string{}
+		}
+		c.MapDirs[k] = v
+	}
+
+	c.RunConfig.Ports = append(c.RunConfig.Ports, comp.Ports...)
+	c.RunConfig.UDPPorts = append(c.RunConfig.UDPPorts, comp.UDPPorts...)
+
+	// each service can have its own arch so the kernel is picked per
+	// service
+	version, err := getCurrentVersion(c.Arch)
 	if err != nil {
 		fmt.Println(err)
 	}
-	version = setKernelVersion(version)
+	version = setKernelVersion(version, c.Arch)
 	c.Kernel = getKernelVersion(version)
 	c.RunConfig.Kernel = c.Kernel
 
@@ -248,8 +551,9 @@ func (com Compose) spawnProgram(comp ComposePackage, dnsIP string, c *types.Conf
 
 	c.RunConfig.ImageName = path.Join(api.GetOpsHome(), "images", pname)
 	c.RunConfig.InstanceName = pname
+	c.CloudConfig.ImageName = pname
 
-	c.NameServers = []string{dnsIP}
+	c.NameServers = nameServers
 
 	packageFolder := filepath.Base(pkgFlags.PackagePath())
 	if strings.Contains(executableName, packageFolder) {
@@ -258,9 +562,9 @@ func (com Compose) spawnProgram(comp ComposePackage, dnsIP string, c *types.Conf
 		executableName = filepath.Join(api.PackageSysRootFolderName, executableName)
 	}
 
-	p, ctx, err := getProviderAndContext(c, "onprem")
+	p, ctx, err := getProviderAndContext(c, c.CloudConfig.Platform)
 	if err != nil {
-		fmt.Println(err)
+		return nil, nil, err
 	}
 
 	// really shouldn't be hitting this..
@@ -268,48 +572,43 @@ func (com Compose) spawnProgram(comp ComposePackage, dnsIP string, c *types.Conf
 		ctx.Config().RunConfig.Kernel = c.Kernel
 	}
 
+	// cloud images are named after the service so replace whatever a
+	// previous compose left behind
+	if c.CloudConfig.Platform != "onprem" {
+		images, err := p.GetImages(ctx, "")
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, i := range images {
+			if i.Name == pname {
+				err = p.DeleteImage(ctx, pname)
+				if err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+	}
+
 	var keypath string
 	if pkgFlags.Package != "" {
 		keypath, err = p.BuildImageWithPackage(ctx, pkgFlags.PackagePath())
 		if err != nil {
-			exitWithError(err.Error())
+			return nil, nil, fmt.Errorf("failed building image for %s: %v", pname, err)
 		}
 	} else {
 		keypath, err = p.BuildImage(ctx)
 		if err != nil {
-			exitWithError(err.Error())
+			return nil, nil, fmt.Errorf("failed building image for %s: %v", pname, err)
 		}
 	}
 
-	err = p.CreateImage(ctx, keypath)
-	if err != nil {
-		exitWithError(err.Error())
-	}
-
-	if runtime.GOOS == "linux" {
-		// linux specific config for compose - need a ifdef here
-		c.RunConfig.Bridged = true  // prob. need to set the actual bridge name?
-		c.RunConfig.TapName = pname // should prob. generate these - max 16 chars && no '/'
-
-		// TODO: pass this in as uniq bridge group if under linux
-		c.RunConfig.BridgeName = brName
-	}
-
-	c.RunConfig.InstanceName = pname
-	c.CloudConfig.ImageName = pname
-	c.RunConfig.QMP = true
-
-	z := p.(*onprem.OnPrem)
-	pid, err := z.CreateInstancePID(ctx)
+	err = createImage(p, ctx, keypath)
 	if err != nil {
-		exitWithError(err.Error())
+		return nil, nil, err
 	}
 
-	if c.RunConfig.ShowDebug {
-		fmt.Printf("%s instance with pid %s '%s' created...\n", c.CloudConfig.Platform, pid, c.RunConfig.InstanceName)
-	}
-
-	return pid
+	return p, ctx, nil
 }
 
 // spawnDNS will grab whatever native pkg exists for the platform.
@@ -324,11 +623,11 @@ func (com Compose) spawnDNS(non string, brName string) string {
 
 	// ideally all of this should happen in one place
 	if c.Kernel == "" {
-		version, err := getCurrentVersion()
+		version, err := getCurrentVersion("")
 		if err != nil {
 			fmt.Println(err)
 		}
-		version = setKernelVersion(version)
+		version = setKernelVersion(version, "")
 
 		c.Kernel = getKernelVersion(version)
 
@@ -336,7 +635,7 @@ func (com Compose) spawnDNS(non string, brName string) string {
 	}
 
 	pkgFlags := PkgCommandFlags{
-		Package: "eyberg/ops-dns:0.0.1",
+		Package: composeDNSPackage,
 	}
 
 	ppath := filepath.Join(pkgFlags.PackagePath()) + "/package.manifest"
@@ -348,7 +647,7 @@ func (com Compose) spawnDNS(non string, brName string) string {
 		os.Exit(1)
 	}
 
-	unWarpConfig(ppath, c)
+	unWarpPackageManifest(ppath, c)
 
 	packageFolder := filepath.Base(pkgFlags.PackagePath())
 	executableName := c.Program
@@ -373,7 +672,7 @@ func (com Compose) spawnDNS(non string, brName string) string {
 	}
 
 	fmt.Println("creating image")
-	err = p.CreateImage(ctx, keypath)
+	err = createImage(p, ctx, keypath)
 	if err != nil {
 		exitWithError(err.Error())
 	}
@@ -415,13 +714,23 @@ type ComposePackage struct {
 	Pkg          string
 	Name         string
 	Local        bool
-	Arch         string
-	BaseVolumeSz string `yaml:"base_volume_sz"`
+	Arch         string   `yaml:",omitempty"`
+	BaseVolumeSz string   `yaml:"base_volume_sz,omitempty"`
+	DependsOn    []string `yaml:"depends_on,omitempty"` // pkgs that need to be up first
+
+	// merged into the config of the package
+	Env      map[string]string `yaml:",omitempty"`
+	Ports    []string          `yaml:",omitempty"`
+	UDPPorts []string          `yaml:"udp_ports,omitempty"`
+	MapDirs  map[string]string `yaml:"map_dirs,omitempty"`
 }
 
 // ComposeFile represents a configuration for ops compose.
 type ComposeFile struct {
 	Packages []ComposePackage
+
+	// Domain services are registered under on cloud targets.
+	Domain string `yaml:",omitempty"`
 }
 
 func genNon(length int) string {
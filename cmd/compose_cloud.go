@@ -0,0 +1,203 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	api "github.com/nanovms/ops/lepton"
+	"github.com/nanovms/ops/types"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// composeDefaultDomain is the domain services are registered under on
+// cloud targets when the compose file doesn't set one.
+//
+// eg: a 'myserver' pkg is reachable at myserver.service.internal
+const composeDefaultDomain = "service.internal"
+
+// upCloud builds an image for each package of the compose and starts
+// it on the configured cloud. Instead of spawning our own dns server
+// services are registered in the dns of the provider.
+func (com Compose) upCloud(y ComposeFile, sha string) (*ComposeState, error) {
+	c := com.config
+
+	domain := y.Domain
+	if domain == "" {
+		domain = composeDefaultDomain
+	}
+
+	state := &ComposeState{
+		Target:    c.CloudConfig.Platform,
+		ProjectID: c.CloudConfig.ProjectID,
+		Zone:      c.CloudConfig.Zone,
+		Domain:    domain,
+	}
+	com.saveState(state, sha)
+
+	err := com.startServices(y, state, sha, func(comp ComposePackage, c *types.Config, ci ComposeInstance, record func(ComposeInstance)) error {
+		// instances resolve each other through the dns of the
+		// provider so there are no name servers to set
+		p, ctx, err := com.buildProgramImage(comp, c, nil)
+		if err != nil {
+			return err
+		}
+
+		err = verifyInstanceImage(p, ctx)
+		if err != nil {
+			return err
+		}
+
+		err = api.InjectSecrets(ctx.Config())
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("creating instance %s on %s\n", comp.Pkg, c.CloudConfig.Platform)
+		err = p.CreateInstance(ctx)
+		if err != nil {
+			return fmt.Errorf("failed creating instance %s: %v", comp.Pkg, err)
+		}
+
+		instance, err := com.waitForCloudInstance(p, ctx, comp.Pkg)
+		if err != nil {
+			return err
+		}
+
+		ci.PID = instance.ID
+		ci.IP = instance.PrivateIps[0]
+		if len(instance.PublicIps) > 0 {
+			ci.PublicIP = instance.PublicIps[0]
+		}
+		record(ci)
+
+		err = com.addCloudDNS(p, state.endpoint(ci), ci.IP)
+		if err != nil {
+			fmt.Printf("could not register %s: %v\n", state.endpoint(ci), err)
+		}
+
+		return nil
+	})
+
+	return state, err
+}
+
+// waitForCloudInstance waits for an instance to show up with a private
+// ip.
+func (com Compose) waitForCloudInstance(p api.Provider, ctx *api.Context, name string) (*api.CloudInstance, error) {
+	for i := 0; i < 60; i++ {
+		instance, err := p.GetInstanceByName(ctx, name)
+		if err != nil && !api.IsInstanceNotFoundError(err) {
+			return nil, err
+		}
+
+		if err == nil && len(instance.PrivateIps) > 0 && instance.PrivateIps[0] != "" {
+			if com.config.RunConfig.ShowDebug {
+				fmt.Printf("found ip of %s\n", instance.PrivateIps[0])
+			}
+			return instance, nil
+		}
+
+		time.Sleep(5 * time.Second)
+	}
+
+	return nil, errors.New("timed out waiting for instance " + name)
+}
+
+// composeDNSMu serializes dns changes so services started in parallel
+// don't race to create the zone.
+var composeDNSMu sync.Mutex
+
+// addCloudDNS points fqdn at ip in the dns of the provider.
+func (com Compose) addCloudDNS(p api.Provider, fqdn string, ip string) error {
+	dnsService, ok := p.(api.DNSService)
+	if !ok {
+		return fmt.Errorf("%s has no dns support, services can only be reached by ip", com.config.CloudConfig.Platform)
+	}
+
+	if com.config.RunConfig.ShowDebug {
+		fmt.Printf("adding record %s for %s\n", fqdn, ip)
+	}
+
+	c := *com.config
+	c.CloudConfig.DomainName = fqdn
+
+	composeDNSMu.Lock()
+	defer composeDNSMu.Unlock()
+
+	return api.CreateDNSRecord(&c, ip, dnsService)
+}
+
+// removeCloudDNS removes the record for fqdn from the dns of the
+// provider. It is not an error if it is already gone.
+func (com Compose) removeCloudDNS(p api.Provider, fqdn string) error {
+	dnsService, ok := p.(api.DNSService)
+	if !ok {
+		return nil
+	}
+
+	// same zone api.CreateDNSRecord puts the record in
+	parts := strings.Split(fqdn, ".")
+	if len(parts) < 2 {
+		return nil
+	}
+	dnsName := strings.Join(parts[len(parts)-2:], ".")
+
+	zoneID, err := dnsService.FindOrCreateZoneIDByName(com.config, dnsName)
+	if err != nil {
+		return err
+	}
+
+	return dnsService.DeleteZoneRecordIfExists(com.config, zoneID, fqdn+".")
+}
+
+// composeEndpoint is how a service of a compose can be reached.
+type composeEndpoint struct {
+	Service  string `json:"service"`
+	Package  string `json:"package"`
+	Instance string `json:"instance"`
+	IP       string `json:"ip"`
+	PublicIP string `json:"public_ip"`
+	Endpoint string `json:"endpoint"`
+}
+
+// printSummary prints where each service of the compose can be reached.
+func (com Compose) printSummary(state *ComposeState) {
+	endpoints := []composeEndpoint{}
+	for _, svc := range state.Services {
+		endpoints = append(endpoints, composeEndpoint{
+			Service:  svc.Name,
+			Package:  svc.Pkg,
+			Instance: svc.PID,
+			IP:       svc.IP,
+			PublicIP: svc.PublicIP,
+			Endpoint: state.endpoint(svc),
+		})
+	}
+
+	if com.config.RunConfig.JSON {
+		printJSON(endpoints)
+		return
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Service", "Package", "Instance", "Private IP", "Public IP", "Endpoint"})
+	table.SetHeaderColor(
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor})
+	table.SetRowLine(true)
+
+	for _, e := range endpoints {
+		table.Append([]string{e.Service, e.Package, e.Instance, e.IP, e.PublicIP, e.Endpoint})
+	}
+
+	table.Render()
+}
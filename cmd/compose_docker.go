@@ -0,0 +1,494 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/nanovms/ops/log"
+	"gopkg.in/yaml.v2"
+)
+
+// DockerComposeFile is the subset of a docker-compose.yml ops compose
+// knows how to translate.
+type DockerComposeFile struct {
+	Services map[string]DockerComposeService
+	Networks map[string]interface{}
+	Volumes  map[string]interface{}
+}
+
+// DockerComposeService is a service of a docker-compose.yml.
+type DockerComposeService struct {
+	Image       string
+	Build       interface{}
+	Platform    string
+	Command     dockerStringList
+	Entrypoint  dockerStringList
+	Environment dockerEnv
+	EnvFile     interface{} `yaml:"env_file"`
+	Ports       []interface{}
+	Volumes     []interface{}
+	Networks    dockerNames
+	DependsOn   dockerNames `yaml:"depends_on"`
+	Privileged  bool
+	Healthcheck interface{}
+}
+
+// dockerStringList is a command or entrypoint which can either be a
+// string or a list.
+type dockerStringList []string
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (l *dockerStringList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err == nil {
+		words, err := splitShellWords(s)
+		if err != nil {
+			return err
+		}
+		*l = words
+		return nil
+	}
+
+	var list []string
+	if err := unmarshal(&list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// splitShellWords splits s in words as a shell would, like docker
+// compose does for commands given as strings: quotes and backslashes
+// escape spaces, there is no expansion.
+func splitShellWords(s string) ([]string, error) {
+	words := []string{}
+	var word strings.Builder
+	inWord := false
+
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case ch == '\\':
+			inWord = true
+			if i+1 < len(s) {
+				i++
+				word.WriteByte(s[i])
+			}
+		case ch == '\'':
+			inWord = true
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote in %q", s)
+			}
+			word.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+		case ch == '"':
+			inWord = true
+			closed := false
+			for i++; i < len(s); i++ {
+				if s[i] == '"' {
+					closed = true
+					break
+				}
+				// in double quotes a backslash only escapes these
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte("\"\\$`", s[i+1]) >= 0 {
+					i++
+				}
+				word.WriteByte(s[i])
+			}
+			if !closed {
+				return nil, fmt.Errorf("unterminated quote in %q", s)
+			}
+		default:
+			inWord = true
+			word.WriteByte(ch)
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// dockerEnv is an environment which can either be a map or a list of
+// KEY=VALUE.
+type dockerEnv map[string]string
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (e *dockerEnv) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	env := dockerEnv{}
+
+	var list []string
+	if err := unmarshal(&list); err == nil {
+		for _, kv := range list {
+			k, v, found := strings.Cut(kv, "=")
+			if !found {
+				// bare keys take their value from the host
+				v = os.Getenv(k)
+			}
+			env[k] = v
+		}
+		*e = env
+		return nil
+	}
+
+	var m map[string]interface{}
+	if err := unmarshal(&m); err != nil {
+		return err
+	}
+	for k, v := range m {
+		if v == nil {
+			env[k] = os.Getenv(k)
+			continue
+		}
+		env[k] = fmt.Sprint(v)
+	}
+	*e = env
+	return nil
+}
+
+// dockerNames is a list of names which can also be given as a map keyed
+// by name (eg: networks or depends_on with conditions).
+type dockerNames []string
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (n *dockerNames) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var list []string
+	if err := unmarshal(&list); err == nil {
+		*n = list
+		return nil
+	}
+
+	var m map[string]interface{}
+	if err := unmarshal(&m); err != nil {
+		return err
+	}
+	names := []string{}
+	for k := range m {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	*n = names
+	return nil
+}
+
+// isDockerCompose tells if body looks like a docker-compose.yml rather
+// than an ops compose.yaml.
+func isDockerCompose(body []byte) bool {
+	m := map[string]interface{}{}
+	if err := yaml.Unmarshal(body, &m); err != nil {
+		return false
+	}
+
+	_, hasServices := m["services"]
+	_, hasPackages := m["packages"]
+	return hasServices && !hasPackages
+}
+
+// parseDockerCompose parses the contents of a docker-compose.yml.
+func parseDockerCompose(body []byte) (*DockerComposeFile, error) {
+	dc := &DockerComposeFile{}
+	err := yaml.Unmarshal(body, dc)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(dc.Services) == 0 {
+		return nil, errors.New("no services found in docker compose file")
+	}
+
+	return dc, nil
+}
+
+// dockerComposeImage is a docker image that has to be turned into a
+// package for a service.
+type dockerComposeImage struct {
+	Service    string
+	Image      string
+	Parch      string
+	Executable string
+	Args       []string
+}
+
+// dockerComposeConversion is the result of translating a
+// docker-compose.yml.
+type dockerComposeConversion struct {
+	Compose  ComposeFile
+	Images   []dockerComposeImage
+	Warnings []string
+}
+
+// toOps translates a docker compose into an ops compose. Each service
+// becomes a local package named after the service; the images listed
+// in the result still have to be extracted for those packages to exist.
+//
+// dir is the directory of the docker-compose.yml, relative bind mounts
+// are resolved against it.
+func (dc *DockerComposeFile) toOps(dir string, arch string) dockerComposeConversion {
+	conv := dockerComposeConversion{}
+
+	warn := func(format string, a ...interface{}) {
+		conv.Warnings = append(conv.Warnings, fmt.Sprintf(format, a...))
+	}
+
+	names := []string{}
+	for name := range dc.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	networks := map[string]bool{}
+	skipped := map[string]bool{}
+
+	for _, name := range names {
+		svc := dc.Services[name]
+
+		if svc.Build != nil {
+			if svc.Image == "" {
+				warn("%s: build is not supported and there is no image to fall back on, skipping", name)
+				skipped[name] = true
+				continue
+			}
+			warn("%s: build is not supported, using image %s as is", name, svc.Image)
+		}
+
+		if svc.Image == "" {
+			warn("%s: no image, skipping", name)
+			skipped[name] = true
+			continue
+		}
+
+		if svc.Privileged {
+			warn("%s: privileged has no meaning for a unikernel and is ignored", name)
+		}
+
+		if svc.Healthcheck != nil {
+			warn("%s: healthcheck is not supported and is ignored", name)
+		}
+
+		if svc.EnvFile != nil {
+			warn("%s: env_file is not supported, use environment instead", name)
+		}
+
+		pkgArch := arch
+		if svc.Platform != "" {
+			platformArch := dockerPlatformArch(svc.Platform)
+			if platformArch == "" {
+				warn("%s: unknown platform %s", name, svc.Platform)
+			} else {
+				pkgArch = platformArch
+			}
+		}
+
+		comp := ComposePackage{
+			Pkg:   name,
+			Name:  name,
+			Local: true,
+			Arch:  pkgArch,
+			Env:   svc.Environment,
+
+			DependsOn: svc.DependsOn,
+		}
+
+		for _, p := range svc.Ports {
+			port, udp, err := dockerPort(p)
+			if err != nil {
+				warn("%s: %v", name, err)
+				continue
+			}
+			if port.published != "" && port.published != port.target {
+				warn("%s: port %s is exposed as %s, ops uses the same port on both ends", name, port.published, port.target)
+			}
+			if udp {
+				comp.UDPPorts = append(comp.UDPPorts, port.target)
+			} else {
+				comp.Ports = append(comp.Ports, port.target)
+			}
+		}
+
+		for _, v := range svc.Volumes {
+			src, dst, ok := dockerBindMount(v, dir)
+			if !ok {
+				warn("%s: volume %v is not a bind mount and is ignored", name, v)
+				continue
+			}
+			if comp.MapDirs == nil {
+				comp.MapDirs = map[string]string{}
+			}
+			comp.MapDirs[src] = dst
+		}
+		if len(comp.MapDirs) > 0 {
+			warn("%s: bind mounts are copied into the image at build time", name)
+		}
+
+		for _, n := range svc.Networks {
+			networks[n] = true
+		}
+
+		// entrypoint + command is what docker would run; without an
+		// entrypoint the command is the program itself.
+		img := dockerComposeImage{
+			Service: name,
+			Image:   svc.Image,
+			Parch:   (&PkgCommandFlags{Arch: pkgArch}).Parch(),
+		}
+		if len(svc.Entrypoint) > 0 {
+			img.Executable = svc.Entrypoint[0]
+			img.Args = append(append([]string{}, svc.Entrypoint[1:]...), svc.Command...)
+		} else if len(svc.Command) > 0 {
+			img.Executable = svc.Command[0]
+			img.Args = append([]string{}, svc.Command[1:]...)
+		}
+
+		conv.Compose.Packages = append(conv.Compose.Packages, comp)
+		conv.Images = append(conv.Images, img)
+	}
+
+	// services depending on skipped ones are started without them
+	for i, comp := range conv.Compose.Packages {
+		var deps []string
+		for _, d := range comp.DependsOn {
+			if skipped[d] {
+				warn("%s: depends on %s which is skipped, ignoring the dependency", comp.Name, d)
+				continue
+			}
+			deps = append(deps, d)
+		}
+		conv.Compose.Packages[i].DependsOn = deps
+	}
+
+	if len(networks) > 1 {
+		warn("all services share a single network, networks are not isolated")
+	}
+
+	if len(dc.Volumes) > 0 {
+		warn("named volumes are not supported and are ignored")
+	}
+
+	return conv
+}
+
+// dockerPlatformArch returns the ops arch of a docker platform such as
+// linux/arm64.
+func dockerPlatformArch(platform string) string {
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || parts[0] != "linux" {
+		return ""
+	}
+
+	switch parts[1] {
+	case "amd64", "x86_64":
+		return "amd64"
+	case "arm64", "aarch64":
+		return "arm64"
+	}
+	return ""
+}
+
+type dockerPortMapping struct {
+	published string
+	target    string
+}
+
+// dockerPort parses the short (eg: 127.0.0.1:8080:80/udp) or long
+// syntax of a port.
+func dockerPort(p interface{}) (dockerPortMapping, bool, error) {
+	switch v := p.(type) {
+	case int:
+		return dockerPortMapping{target: fmt.Sprint(v)}, false, nil
+	case string:
+		spec, proto, _ := strings.Cut(v, "/")
+		parts := strings.Split(spec, ":")
+		pm := dockerPortMapping{target: parts[len(parts)-1]}
+		if len(parts) > 1 {
+			pm.published = parts[len(parts)-2]
+		}
+		if pm.target == "" {
+			return pm, false, fmt.Errorf("invalid port %s", v)
+		}
+		return pm, proto == "udp", nil
+	case map[interface{}]interface{}:
+		pm := dockerPortMapping{}
+		if t, ok := v["target"]; ok {
+			pm.target = fmt.Sprint(t)
+		}
+		if pub, ok := v["published"]; ok {
+			pm.published = fmt.Sprint(pub)
+		}
+		if pm.target == "" {
+			return pm, false, fmt.Errorf("port %v has no target", v)
+		}
+		return pm, fmt.Sprint(v["protocol"]) == "udp", nil
+	}
+
+	return dockerPortMapping{}, false, fmt.Errorf("invalid port %v", p)
+}
+
+// dockerBindMount returns the host and image paths of a bind mount
+// volume. Named volumes are not bind mounts.
+func dockerBindMount(v interface{}, dir string) (string, string, bool) {
+	var src, dst string
+
+	switch vol := v.(type) {
+	case string:
+		parts := strings.Split(vol, ":")
+		if len(parts) < 2 {
+			return "", "", false
+		}
+		src, dst = parts[0], parts[1]
+	case map[interface{}]interface{}:
+		if fmt.Sprint(vol["type"]) != "bind" {
+			return "", "", false
+		}
+		src, dst = fmt.Sprint(vol["source"]), fmt.Sprint(vol["target"])
+	default:
+		return "", "", false
+	}
+
+	switch {
+	case strings.HasPrefix(src, "~"):
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", "", false
+		}
+		src = filepath.Join(home, src[1:])
+	case strings.HasPrefix(src, "."):
+		src = filepath.Join(dir, src)
+	case !filepath.IsAbs(src):
+		// named volume
+		return "", "", false
+	}
+
+	return src, dst, true
+}
+
+// convertDockerCompose translates the docker-compose.yml in body and
+// creates the packages for its services from their docker images.
+func convertDockerCompose(body []byte, dir string, arch string, copyWholeFS bool, verbose bool) (ComposeFile, error) {
+	dc, err := parseDockerCompose(body)
+	if err != nil {
+		return ComposeFile{}, err
+	}
+
+	conv := dc.toOps(dir, arch)
+	for _, w := range conv.Warnings {
+		log.Warn(w)
+	}
+
+	for _, img := range conv.Images {
+		fmt.Fprintf(os.Stderr, "creating package %s from %s\n", img.Service, img.Image)
+		ExtractFromDockerImage(img.Image, img.Service, img.Parch, img.Executable, true, verbose, copyWholeFS, false, img.Args)
+	}
+
+	return conv.Compose, nil
+}
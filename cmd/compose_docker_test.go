@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+const testDockerCompose = `
+services:
+  web:
+    image: nginx:1.25
+    platform: linux/arm64
+    ports:
+      - "8080:80"
+      - "53/udp"
+    environment:
+      - MODE=prod
+    volumes:
+      - ./html:/usr/share/nginx/html:ro
+      - data:/var/lib/data
+    depends_on:
+      db:
+        condition: service_healthy
+      app:
+        condition: service_started
+    privileged: true
+  db:
+    image: postgres:16
+    entrypoint: docker-entrypoint.sh
+    command: ["postgres", "-c", "fsync=off"]
+    environment:
+      POSTGRES_PASSWORD: secret
+      PGPORT: 5432
+    healthcheck:
+      test: ["CMD", "pg_isready"]
+  app:
+    build: .
+volumes:
+  data: {}
+`
+
+func TestComposeIsDockerCompose(t *testing.T) {
+	assert.True(t, isDockerCompose([]byte(testDockerCompose)))
+	assert.False(t, isDockerCompose([]byte("packages:\n  - pkg: myserver\n    name: mynewserver:0.0.1\n")))
+	assert.False(t, isDockerCompose([]byte("not: [valid")))
+}
+
+func TestComposeDockerToOps(t *testing.T) {
+	dc, err := parseDockerCompose([]byte(testDockerCompose))
+	assert.Nil(t, err)
+
+	conv := dc.toOps("/src", "amd64")
+
+	assert.Equal(t, []ComposePackage{
+		{
+			Pkg:   "db",
+			Name:  "db",
+			Local: true,
+			Arch:  "amd64",
+			Env:   map[string]string{"POSTGRES_PASSWORD": "secret", "PGPORT": "5432"},
+		},
+		{
+			Pkg:       "web",
+			Name:      "web",
+			Local:     true,
+			Arch:      "arm64",
+			Env:       map[string]string{"MODE": "prod"},
+			DependsOn: []string{"db"},
+			Ports:     []string{"80"},
+			UDPPorts:  []string{"53"},
+			MapDirs:   map[string]string{"/src/html": "/usr/share/nginx/html"},
+		},
+	}, conv.Compose.Packages)
+
+	assert.Equal(t, []dockerComposeImage{
+		{
+			Service:    "db",
+			Image:      "postgres:16",
+			Parch:      "amd64",
+			Executable: "docker-entrypoint.sh",
+			Args:       []string{"postgres", "-c", "fsync=off"},
+		},
+		{
+			Service: "web",
+			Image:   "nginx:1.25",
+			Parch:   "arm64",
+		},
+	}, conv.Images)
+
+	assert.Contains(t, conv.Warnings, "app: build is not supported and there is no image to fall back on, skipping")
+	assert.Contains(t, conv.Warnings, "web: depends on app which is skipped, ignoring the dependency")
+	assert.Contains(t, conv.Warnings, "db: healthcheck is not supported and is ignored")
+	assert.Contains(t, conv.Warnings, "web: privileged has no meaning for a unikernel and is ignored")
+	assert.Contains(t, conv.Warnings, "web: port 8080 is exposed as 80, ops uses the same port on both ends")
+	assert.Contains(t, conv.Warnings, "web: volume data:/var/lib/data is not a bind mount and is ignored")
+}
+
+func TestComposeDockerToOpsRoundTrip(t *testing.T) {
+	dc, err := parseDockerCompose([]byte(testDockerCompose))
+	assert.Nil(t, err)
+
+	conv := dc.toOps("/src", "")
+
+	out, err := yaml.Marshal(conv.Compose)
+	assert.Nil(t, err)
+
+	y := ComposeFile{}
+	err = yaml.Unmarshal(out, &y)
+	assert.Nil(t, err)
+	assert.Equal(t, conv.Compose, y)
+
+	levels, err := composeLevels(y.Packages)
+	assert.Nil(t, err)
+	assert.Len(t, levels, 2)
+}
+
+func TestComposeDockerPort(t *testing.T) {
+	p, udp, err := dockerPort("127.0.0.1:8080:80/tcp")
+	assert.Nil(t, err)
+	assert.False(t, udp)
+	assert.Equal(t, dockerPortMapping{published: "8080", target: "80"}, p)
+
+	p, _, err = dockerPort(9000)
+	assert.Nil(t, err)
+	assert.Equal(t, "9000", p.target)
+
+	p, udp, err = dockerPort(map[interface{}]interface{}{"target": 53, "published": 53, "protocol": "udp"})
+	assert.Nil(t, err)
+	assert.True(t, udp)
+	assert.Equal(t, "53", p.target)
+}
+
+func TestComposeDockerStringCommand(t *testing.T) {
+	var svc DockerComposeService
+	err := yaml.Unmarshal([]byte(`command: sh -c "echo a  b" 'c d' e\ f "g\"h"`), &svc)
+	assert.Nil(t, err)
+	assert.Equal(t, dockerStringList{"sh", "-c", "echo a  b", "c d", "e f", `g"h`}, svc.Command)
+
+	err = yaml.Unmarshal([]byte(`entrypoint: sh -c "unterminated`), &svc)
+	assert.ErrorContains(t, err, "unterminated quote")
+}
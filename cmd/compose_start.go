@@ -0,0 +1,190 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/nanovms/ops/types"
+)
+
+// composeDefaultParallel is how many services compose starts at once
+// unless told otherwise.
+const composeDefaultParallel = 4
+
+// composeStartFunc starts a single service of a compose with its own
+// config. ci is the service as recorded in the compose state; record
+// stores any progress made so 'compose down' can find it.
+type composeStartFunc func(comp ComposePackage, c *types.Config, ci ComposeInstance, record func(ComposeInstance)) error
+
+// startServices starts the packages of y, at most com.parallel at a
+// time. A package is only started once everything it depends on is up;
+// if any package fails to start nothing depending on it is started.
+func (com Compose) startServices(y ComposeFile, state *ComposeState, sha string, start composeStartFunc) error {
+	levels, err := composeLevels(y.Packages)
+	if err != nil {
+		return err
+	}
+
+	parallel := com.parallel
+	if parallel < 1 {
+		parallel = composeDefaultParallel
+	}
+
+	// every service gets a slot up front so workers only ever touch
+	// their own entry
+	offset := len(state.Services)
+	for _, comp := range y.Packages {
+		state.Services = append(state.Services, ComposeInstance{
+			Name:  comp.Pkg,
+			Pkg:   comp.Name,
+			Image: comp.Pkg,
+			Tap:   composeTapName(comp.Pkg),
+		})
+	}
+	com.saveState(state, sha)
+
+	var mu sync.Mutex
+	var errs []error
+
+	for _, level := range levels {
+		wg := sync.WaitGroup{}
+		sem := make(chan struct{}, parallel)
+
+		for _, i := range level {
+			wg.Add(1)
+			sem <- struct{}{}
+
+			go func(i int) {
+				defer wg.Done()
+				defer func() { <-sem }()
+
+				comp := y.Packages[i]
+
+				c, err := com.serviceConfig(comp)
+				if err == nil {
+					mu.Lock()
+					ci := state.Services[offset+i]
+					mu.Unlock()
+
+					err = start(comp, c, ci, func(ci ComposeInstance) {
+						mu.Lock()
+						defer mu.Unlock()
+						state.Services[offset+i] = ci
+						com.saveState(state, sha)
+					})
+				}
+
+				if err != nil {
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+				}
+			}(i)
+		}
+
+		wg.Wait()
+
+		if len(errs) > 0 {
+			return errors.Join(errs...)
+		}
+	}
+
+	return nil
+}
+
+// serviceConfig returns a config of its own for a compose package so
+// services can be built in parallel.
+func (com Compose) serviceConfig(comp ComposePackage) (*types.Config, error) {
+	body, err := json.Marshal(com.config)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &types.Config{}
+	err = json.Unmarshal(body, c)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Arch = com.archFor(comp)
+	c.RunConfig.Arch = c.Arch
+
+	return c, nil
+}
+
+// archFor returns the arch a compose package is built for; packages
+// without one use whatever was set for the whole compose.
+func (com Compose) archFor(comp ComposePackage) string {
+	if comp.Arch != "" {
+		return comp.Arch
+	}
+
+	return com.config.Arch
+}
+
+// composeLevels groups the packages of a compose (by index) so that
+// every package comes after all the packages it depends on. Packages
+// in the same group don't depend on each other.
+func composeLevels(pkgs []ComposePackage) ([][]int, error) {
+	index := map[string]int{}
+	for i, comp := range pkgs {
+		index[comp.Pkg] = i
+	}
+
+	level := make([]int, len(pkgs))
+	for i := range level {
+		level[i] = -1
+	}
+
+	var visit func(i int, seen map[int]bool) (int, error)
+	visit = func(i int, seen map[int]bool) (int, error) {
+		if level[i] >= 0 {
+			return level[i], nil
+		}
+
+		if seen[i] {
+			return 0, fmt.Errorf("dependency cycle involving %s", pkgs[i].Pkg)
+		}
+		seen[i] = true
+
+		l := 0
+		for _, dep := range pkgs[i].DependsOn {
+			j, ok := index[dep]
+			if !ok {
+				return 0, fmt.Errorf("%s depends on unknown package %s", pkgs[i].Pkg, dep)
+			}
+
+			dl, err := visit(j, seen)
+			if err != nil {
+				return 0, err
+			}
+
+			if dl+1 > l {
+				l = dl + 1
+			}
+		}
+
+		level[i] = l
+		return l, nil
+	}
+
+	levels := [][]int{}
+	for i := range pkgs {
+		l, err := visit(i, map[int]bool{})
+		if err != nil {
+			return nil, err
+		}
+
+		for len(levels) <= l {
+			levels = append(levels, []int{})
+		}
+	}
+
+	for i, l := range level {
+		levels[l] = append(levels[l], i)
+	}
+
+	return levels, nil
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/nanovms/ops/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComposeLevels(t *testing.T) {
+	pkgs := []ComposePackage{
+		{Pkg: "web", DependsOn: []string{"api"}},
+		{Pkg: "db"},
+		{Pkg: "api", DependsOn: []string{"db", "cache"}},
+		{Pkg: "cache"},
+	}
+
+	levels, err := composeLevels(pkgs)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]int{{1, 3}, {2}, {0}}, levels)
+}
+
+func TestComposeLevelsIndependent(t *testing.T) {
+	pkgs := []ComposePackage{{Pkg: "a"}, {Pkg: "b"}, {Pkg: "c"}}
+
+	levels, err := composeLevels(pkgs)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]int{{0, 1, 2}}, levels)
+}
+
+func TestComposeLevelsErrors(t *testing.T) {
+	_, err := composeLevels([]ComposePackage{
+		{Pkg: "a", DependsOn: []string{"b"}},
+		{Pkg: "b", DependsOn: []string{"a"}},
+	})
+	assert.ErrorContains(t, err, "dependency cycle")
+
+	_, err = composeLevels([]ComposePackage{
+		{Pkg: "a", DependsOn: []string{"missing"}},
+	})
+	assert.ErrorContains(t, err, "unknown package missing")
+}
+
+func TestComposeServiceConfigArch(t *testing.T) {
+	com := Compose{config: &types.Config{Arch: "amd64", Program: "p"}}
+
+	c, err := com.serviceConfig(ComposePackage{Pkg: "a", Arch: "arm64"})
+	assert.Nil(t, err)
+	assert.Equal(t, "arm64", c.Arch)
+	assert.Equal(t, "arm64", c.RunConfig.Arch)
+	assert.Equal(t, "p", c.Program)
+
+	c, err = com.serviceConfig(ComposePackage{Pkg: "b"})
+	assert.Nil(t, err)
+	assert.Equal(t, "amd64", c.Arch)
+
+	// configs are not shared between services
+	c.Program = "changed"
+	assert.Equal(t, "p", com.config.Program)
+}
@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path"
+
+	api "github.com/nanovms/ops/lepton"
+)
+
+// ComposeState records everything a 'compose up' created so that
+// 'compose down' can find and tear it down again.
+//
+// It is stored as json in ~/.ops/composes/<sha1 of compose file> and is
+// re-written after each step of 'compose up' so that a partially
+// started compose can still be torn down.
+type ComposeState struct {
+	Target    string            `json:"target,omitempty"` // empty means onprem
+	ProjectID string            `json:"projectid,omitempty"`
+	Zone      string            `json:"zone,omitempty"`
+	Domain    string            `json:"domain,omitempty"` // cloud dns domain
+	Bridge    string            `json:"bridge"`
+	Nonce     string            `json:"nonce"`
+	DNS       *ComposeInstance  `json:"dns,omitempty"`
+	Services  []ComposeInstance `json:"services"`
+}
+
+// ComposeInstance is a unikernel spawned as part of a compose.
+type ComposeInstance struct {
+	Name     string `json:"name"` // instance name
+	Pkg      string `json:"pkg"`
+	Image    string `json:"image"`
+	PID      string `json:"pid"` // instance id on cloud targets
+	IP       string `json:"ip"`
+	PublicIP string `json:"public_ip,omitempty"`
+	Tap      string `json:"tap"`
+}
+
+func composeSHA(body []byte) string {
+	h := sha1.New()
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func composeStatePath(sha string) string {
+	return path.Join(api.GetOpsHome(), "composes", sha)
+}
+
+// readComposeState returns the state of the compose identified by sha.
+//
+// older versions of ops only stored the bridge name so if the file is
+// not json we assume that is what we have.
+func readComposeState(sha string) (*ComposeState, error) {
+	body, err := os.ReadFile(composeStatePath(sha))
+	if err != nil {
+		return nil, err
+	}
+
+	state := &ComposeState{}
+	if err := json.Unmarshal(body, state); err != nil {
+		state.Bridge = string(body)
+	}
+
+	return state, nil
+}
+
+func (s *ComposeState) save(sha string) error {
+	body, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(composeStatePath(sha), body, 0644)
+}
+
+// remove deletes the state file; it is not an error if it is already
+// gone.
+func (s *ComposeState) remove(sha string) error {
+	err := os.Remove(composeStatePath(sha))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// isOnPrem returns true if the compose was started locally.
+func (s *ComposeState) isOnPrem() bool {
+	return s.Target == "" || s.Target == "onprem"
+}
+
+// endpoint returns the name a service can be reached at by the other
+// services of the compose.
+func (s *ComposeState) endpoint(ci ComposeInstance) string {
+	if s.isOnPrem() {
+		return ci.Name + ".service"
+	}
+
+	return ci.Name + "." + s.Domain
+}
+
+// isEmpty returns true if there is nothing left to tear down.
+func (s *ComposeState) isEmpty() bool {
+	return s.Bridge == "" && s.DNS == nil && len(s.Services) == 0
+}
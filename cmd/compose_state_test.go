@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComposeStateSaveAndRead(t *testing.T) {
+	t.Setenv("OPS_HOME", t.TempDir())
+
+	sha := composeSHA([]byte("packages: []"))
+
+	state := &ComposeState{
+		Bridge: "ops0",
+		Nonce:  "abc",
+		DNS:    &ComposeInstance{Name: "dns", PID: "1", IP: "10.0.0.2", Tap: "dns"},
+		Services: []ComposeInstance{
+			{Name: "myserver", Pkg: "mynewserver:0.0.1", PID: "2", IP: "10.0.0.3", Tap: "myserver"},
+		},
+	}
+
+	err := state.save(sha)
+	assert.Nil(t, err)
+
+	got, err := readComposeState(sha)
+	assert.Nil(t, err)
+	assert.Equal(t, state, got)
+
+	err = got.remove(sha)
+	assert.Nil(t, err)
+
+	// removing twice is fine
+	err = got.remove(sha)
+	assert.Nil(t, err)
+
+	_, err = readComposeState(sha)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestComposeStateReadLegacy(t *testing.T) {
+	t.Setenv("OPS_HOME", t.TempDir())
+
+	sha := composeSHA([]byte("legacy"))
+
+	err := os.WriteFile(composeStatePath(sha), []byte("ops0"), 0644)
+	assert.Nil(t, err)
+
+	state, err := readComposeState(sha)
+	assert.Nil(t, err)
+	assert.Equal(t, "ops0", state.Bridge)
+	assert.Nil(t, state.DNS)
+	assert.Empty(t, state.Services)
+	assert.False(t, state.isEmpty())
+}
+
+func TestComposeStateEndpoint(t *testing.T) {
+	svc := ComposeInstance{Name: "myserver"}
+
+	legacy := &ComposeState{}
+	assert.True(t, legacy.isOnPrem())
+	assert.Equal(t, "myserver.service", legacy.endpoint(svc))
+
+	cloud := &ComposeState{Target: "gcp", Domain: composeDefaultDomain}
+	assert.False(t, cloud.isOnPrem())
+	assert.Equal(t, "myserver.service.internal", cloud.endpoint(svc))
+}
@@ -0,0 +1,235 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// configInterpolationRegex matches ${ENV_VAR}, $ENV_VAR and ${file:path};
+// $${...} is kept as ${...}.
+var configInterpolationRegex = regexp.MustCompile(`\$?\$(?:\{([^}]*)\}|([A-Za-z_][A-Za-z0-9_]*))`)
+
+// interpolateConfigString replaces the environment variables and files
+// referenced in s, file paths being relative to dir.
+func interpolateConfigString(s string, dir string) (string, error) {
+	var err error
+	s = configInterpolationRegex.ReplaceAllStringFunc(s, func(match string) string {
+		if strings.HasPrefix(match, "$$") {
+			return match[1:]
+		}
+
+		sub := configInterpolationRegex.FindStringSubmatch(match)
+		ref := sub[1] + sub[2]
+		if p, ok := strings.CutPrefix(ref, "file:"); ok {
+			if !filepath.IsAbs(p) {
+				p = filepath.Join(dir, p)
+			}
+			body, ferr := os.ReadFile(p)
+			if ferr != nil {
+				err = fmt.Errorf("failed interpolating %s: %v", match, ferr)
+				return match
+			}
+			return strings.TrimRight(string(body), "\r\n")
+		}
+
+		v, ok := os.LookupEnv(ref)
+		if !ok {
+			err = fmt.Errorf("failed interpolating %s: environment variable %s is not set", match, ref)
+			return match
+		}
+		return v
+	})
+	return s, err
+}
+
+func interpolateConfigValue(v any, dir string) (any, error) {
+	switch t := v.(type) {
+	case string:
+		return interpolateConfigString(t, dir)
+	case []any:
+		for i := range t {
+			iv, err := interpolateConfigValue(t[i], dir)
+			if err != nil {
+				return nil, err
+			}
+			t[i] = iv
+		}
+	case map[string]any:
+		for k := range t {
+			iv, err := interpolateConfigValue(t[k], dir)
+			if err != nil {
+				return nil, err
+			}
+			t[k] = iv
+		}
+	}
+	return v, nil
+}
+
+// mergeConfigMaps merges overlay into base: objects are merged
+// recursively, anything else in overlay replaces what is in base.
+func mergeConfigMaps(base, overlay map[string]any) map[string]any {
+	for k, v := range overlay {
+		bm, bok := base[k].(map[string]any)
+		om, ook := v.(map[string]any)
+		if bok && ook {
+			base[k] = mergeConfigMaps(bm, om)
+			continue
+		}
+		base[k] = v
+	}
+	return base
+}
+
+// configKey returns the key of m matching name case-insensitively, like
+// the json decoding of the config does.
+func configKey(m map[string]any, name string) (string, bool) {
+	for k := range m {
+		if strings.EqualFold(k, name) {
+			return k, true
+		}
+	}
+	return "", false
+}
+
+// decodeConfigFile decodes the config file data in the format of file,
+// checking it against the config schema.
+func decodeConfigFile(file string, data []byte) (map[string]any, error) {
+	n, err := parseConfigNode(file, data)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := validateConfigNode(file, n); err != nil {
+		return nil, err
+	}
+
+	v, err := configNodeValue(n)
+	if err != nil {
+		return nil, ErrInvalidFileConfig(fmt.Errorf("%s: %v", file, err))
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil, ErrInvalidFileConfig(fmt.Errorf("%s: a config must be an object", file))
+	}
+	return m, nil
+}
+
+// readConfigFile reads file and the files it extends merged beneath it,
+// keeping only profile of its profiles and recording the names of all
+// of them in profiles. chain has the files extending it.
+func readConfigFile(file string, profile string, profiles map[string]bool, chain []string) (map[string]any, error) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range chain {
+		if f == abs {
+			return nil, fmt.Errorf("config %s extends itself: %s", file, strings.Join(append(chain, abs), " -> "))
+		}
+	}
+	chain = append(chain, abs)
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config: %w", err)
+	}
+
+	m, err := decodeConfigFile(file, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if k, ok := configKey(m, "Profiles"); ok {
+		fileProfiles, ok := m[k].(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("Profiles of %s must be an object of configs by name", file)
+		}
+		for name := range fileProfiles {
+			profiles[name] = true
+			if name != profile {
+				delete(fileProfiles, name)
+			}
+		}
+	}
+
+	dir := filepath.Dir(file)
+	if os.Getenv("ops_render_config") == "true" {
+		if _, err := interpolateConfigValue(m, dir); err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+	}
+
+	k, ok := configKey(m, "Extends")
+	if !ok {
+		return m, nil
+	}
+
+	var extends []string
+	switch t := m[k].(type) {
+	case string:
+		extends = []string{t}
+	case []any:
+		for _, e := range t {
+			s, ok := e.(string)
+			if !ok {
+				return nil, fmt.Errorf("Extends of %s must be a list of config files", file)
+			}
+			extends = append(extends, s)
+		}
+	default:
+		return nil, fmt.Errorf("Extends of %s must be a list of config files", file)
+	}
+	delete(m, k)
+
+	merged := map[string]any{}
+	for _, e := range extends {
+		if !filepath.IsAbs(e) {
+			e = filepath.Join(dir, e)
+		}
+		base, err := readConfigFile(e, profile, profiles, chain)
+		if err != nil {
+			return nil, err
+		}
+		merged = mergeConfigMaps(merged, base)
+	}
+
+	return mergeConfigMaps(merged, m), nil
+}
+
+// resolveConfigFile returns the effective config of file as JSON: the
+// files it extends merged beneath it, the selected profile merged over
+// it and, with ops_render_config=true, ${ENV_VAR} and ${file:path}
+// interpolated.
+func resolveConfigFile(file string, profile string) ([]byte, error) {
+	names := map[string]bool{}
+	m, err := readConfigFile(file, profile, names, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var selected map[string]any
+	if k, ok := configKey(m, "Profiles"); ok {
+		profiles, _ := m[k].(map[string]any)
+		selected, _ = profiles[profile].(map[string]any)
+		delete(m, k)
+	}
+
+	if profile != "" {
+		if selected == nil {
+			available := []string{}
+			for name := range names {
+				available = append(available, name)
+			}
+			sort.Strings(available)
+			return nil, fmt.Errorf("profile %q not found in %s, available profiles: %s", profile, file, strings.Join(available, ", "))
+		}
+		m = mergeConfigMaps(m, selected)
+	}
+
+	return json.Marshal(m)
+}
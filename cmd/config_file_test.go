@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/nanovms/ops/testutils"
+	"github.com/nanovms/ops/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfigFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	testutils.WriteFiles(t, dir, files)
+	return dir
+}
+
+func TestUnWarpConfigProfile(t *testing.T) {
+	t.Setenv("ops_render_config", "true")
+	t.Setenv("OPS_TEST_REGION", "us-west1")
+
+	dir := writeConfigFiles(t, map[string]string{
+		"base.json": `{
+			"Args": ["--base"],
+			"Env": {"A": "base", "B": "base"},
+			"RunConfig": {"Memory": "1G", "Ports": ["80"]},
+			"Profiles": {"prod": {"RunConfig": {"Memory": "4G"}}}
+		}`,
+		"secrets/token": "s3cr3t\n",
+		"config.json": `{
+			"Extends": ["base.json"],
+			"Env": {"B": "app", "TOKEN": "${file:secrets/token}", "LITERAL": "$${HOME}", "USER": "user-$OPS_TEST_REGION"},
+			"CloudConfig": {"Zone": "${OPS_TEST_REGION}-a"},
+			"Profiles": {
+				"dev": {"Env": {"DEBUG": "1"}},
+				"prod": {"RunConfig": {"Ports": ["443"]}}
+			}
+		}`,
+	})
+	file := filepath.Join(dir, "config.json")
+
+	c := &types.Config{}
+	assert.Nil(t, unWarpConfigProfile(file, "", c))
+	assert.Equal(t, []string{"--base"}, c.Args)
+	assert.Equal(t, map[string]string{"A": "base", "B": "app", "TOKEN": "s3cr3t", "LITERAL": "${HOME}", "USER": "user-us-west1"}, c.Env)
+	assert.Equal(t, "us-west1-a", c.CloudConfig.Zone)
+	assert.Equal(t, "1G", c.RunConfig.Memory)
+
+	c = &types.Config{}
+	assert.Nil(t, unWarpConfigProfile(file, "prod", c))
+	assert.Equal(t, "4G", c.RunConfig.Memory)
+	assert.Equal(t, []string{"443"}, c.RunConfig.Ports)
+	assert.Equal(t, "", c.Env["DEBUG"])
+
+	err := unWarpConfigProfile(file, "qa", &types.Config{})
+	assert.ErrorContains(t, err, "available profiles: dev, prod")
+}
+
+func TestUnWarpConfigErrors(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"a.json":      `{"Extends": "b.json"}`,
+		"b.json":      `{"Extends": ["a.json"]}`,
+		"env.json":    `{"Env": {"X": "${OPS_TEST_UNSET_VARIABLE}"}}`,
+		"broken.json": "{\n  \"Args\": [\n}",
+	})
+
+	err := unWarpConfig(filepath.Join(dir, "a.json"), &types.Config{})
+	assert.ErrorContains(t, err, "extends itself")
+
+	// interpolation is opt-in, references are kept as they are without it
+	c := &types.Config{}
+	assert.Nil(t, unWarpConfig(filepath.Join(dir, "env.json"), c))
+	assert.Equal(t, "${OPS_TEST_UNSET_VARIABLE}", c.Env["X"])
+
+	t.Setenv("ops_render_config", "true")
+	err = unWarpConfig(filepath.Join(dir, "env.json"), &types.Config{})
+	assert.ErrorContains(t, err, "OPS_TEST_UNSET_VARIABLE is not set")
+
+	err = unWarpConfig(filepath.Join(dir, "broken.json"), &types.Config{})
+	assert.ErrorContains(t, err, "line: 3")
+}
+
+func TestUnWarpPackageManifest(t *testing.T) {
+	t.Setenv("OPS_TEST_SECRET", "secret")
+	dir := writeConfigFiles(t, map[string]string{
+		"base.json":        `{"Args": ["base"]}`,
+		"package.manifest": `{"Program": "app", "Env": {"X": "${OPS_TEST_SECRET}", "Y": "${file:base.json}"}}`,
+		"extends.manifest": `{"Program": "app", "Extends": "base.json"}`,
+	})
+
+	// manifests of packages are not interpolated
+	c := &types.Config{}
+	assert.Nil(t, unWarpPackageManifest(filepath.Join(dir, "package.manifest"), c))
+	assert.Equal(t, map[string]string{"X": "${OPS_TEST_SECRET}", "Y": "${file:base.json}"}, c.Env)
+
+	err := unWarpPackageManifest(filepath.Join(dir, "extends.manifest"), &types.Config{})
+	assert.ErrorContains(t, err, "unknown field \"Extends\"")
+}
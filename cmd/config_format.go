@@ -0,0 +1,530 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// config file formats
+const (
+	configFormatJSON = "json"
+	configFormatYAML = "yaml"
+	configFormatTOML = "toml"
+)
+
+// configFormats are the formats config files can be written in.
+var configFormats = []string{configFormatJSON, configFormatYAML, configFormatTOML}
+
+// configFormat tells the format of a config file from its extension,
+// JSON by default.
+func configFormat(file string) string {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		return configFormatYAML
+	case ".toml":
+		return configFormatTOML
+	}
+	return configFormatJSON
+}
+
+// parseConfigNode parses the config file data in the format of file to
+// a tree of yaml nodes, which keep the order and positions of values
+// whatever the format. Field names and types are the same in every
+// format, those of the JSON config.
+func parseConfigNode(file string, data []byte) (*yaml.Node, error) {
+	switch configFormat(file) {
+	case configFormatYAML:
+		return parseYAMLConfig(file, data)
+	case configFormatTOML:
+		return parseTOMLConfig(file, data)
+	}
+	return parseJSONConfig(file, data)
+}
+
+func parseJSONConfig(file string, data []byte) (*yaml.Node, error) {
+	p := &jsonNodeParser{data: data, dec: json.NewDecoder(bytes.NewReader(data))}
+	p.dec.UseNumber()
+
+	n, err := p.node()
+	if err != nil {
+		if jsonErr, ok := err.(*json.SyntaxError); ok {
+			offset := min(int(jsonErr.Offset), len(data))
+			line := 1 + bytes.Count(data[:offset], []byte("\n"))
+			problemPart := data[max(offset-1, 0):min(offset+10, len(data))]
+			err = fmt.Errorf("%w ~ error near '%s' in %s (offset %d) line: %v", err, problemPart, file, offset, line)
+		} else if err == io.ErrUnexpectedEOF || err == io.EOF {
+			err = fmt.Errorf("%s: unexpected end of JSON input", file)
+		}
+		return nil, ErrInvalidFileConfig(err)
+	}
+	return n, nil
+}
+
+// jsonNodeParser reads JSON token by token to tell the positions of the
+// values.
+type jsonNodeParser struct {
+	data []byte
+	dec  *json.Decoder
+}
+
+func (p *jsonNodeParser) node() (*yaml.Node, error) {
+	pos := offsetPosition(p.data, p.dec.InputOffset())
+	tok, err := p.dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	n := &yaml.Node{Line: pos.Line, Column: pos.Column}
+	switch t := tok.(type) {
+	case json.Delim:
+		n.Kind, n.Tag = yaml.MappingNode, "!!map"
+		if t == '[' {
+			n.Kind, n.Tag = yaml.SequenceNode, "!!seq"
+		}
+		for p.dec.More() {
+			c, err := p.node()
+			if err != nil {
+				return nil, err
+			}
+			n.Content = append(n.Content, c)
+		}
+		// closing delimiter
+		if _, err := p.dec.Token(); err != nil {
+			return nil, err
+		}
+	case string:
+		n.Kind, n.Tag, n.Value = yaml.ScalarNode, "!!str", t
+	case json.Number:
+		n.Kind, n.Tag, n.Value = yaml.ScalarNode, "!!int", t.String()
+		if strings.ContainsAny(n.Value, ".eE") {
+			n.Tag = "!!float"
+		}
+	case bool:
+		n.Kind, n.Tag, n.Value = yaml.ScalarNode, "!!bool", strconv.FormatBool(t)
+	default:
+		n.Kind, n.Tag, n.Value = yaml.ScalarNode, "!!null", "null"
+	}
+	return n, nil
+}
+
+// offsetPosition returns the line and column of the first token after
+// offset in data.
+func offsetPosition(data []byte, offset int64) configPosition {
+	pos := int(offset)
+	for pos < len(data) && strings.IndexByte(" \t\r\n,:", data[pos]) >= 0 {
+		pos++
+	}
+	pos = min(pos, len(data))
+
+	lineStart := bytes.LastIndexByte(data[:pos], '\n') + 1
+	return configPosition{
+		Line:   1 + bytes.Count(data[:pos], []byte("\n")),
+		Column: 1 + len([]rune(string(data[lineStart:pos]))),
+	}
+}
+
+func parseYAMLConfig(file string, data []byte) (*yaml.Node, error) {
+	var doc yaml.Node
+	err := yaml.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, ErrInvalidFileConfig(fmt.Errorf("%s: %v", file, err))
+	}
+	if len(doc.Content) == 0 {
+		return &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: 1, Column: 1}, nil
+	}
+	return flattenYAMLNode(doc.Content[0]), nil
+}
+
+// flattenYAMLNode resolves the aliases and merge keys of n and the tags
+// of its scalars, values of other types than JSON ones being strings.
+func flattenYAMLNode(n *yaml.Node) *yaml.Node {
+	if n.Kind == yaml.AliasNode {
+		return flattenYAMLNode(n.Alias)
+	}
+
+	flat := &yaml.Node{Kind: n.Kind, Tag: n.ShortTag(), Value: n.Value, Line: n.Line, Column: n.Column}
+	switch n.Kind {
+	case yaml.ScalarNode:
+		switch flat.Tag {
+		case "!!str", "!!int", "!!float", "!!bool", "!!null":
+		default:
+			flat.Tag = "!!str"
+		}
+	case yaml.SequenceNode:
+		for _, c := range n.Content {
+			flat.Content = append(flat.Content, flattenYAMLNode(c))
+		}
+	case yaml.MappingNode:
+		var merged []*yaml.Node
+		keys := map[string]bool{}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, v := n.Content[i], flattenYAMLNode(n.Content[i+1])
+			if k.ShortTag() == "!!merge" {
+				merged = append(merged, v)
+				continue
+			}
+			keys[k.Value] = true
+			flat.Content = append(flat.Content, flattenYAMLNode(k), v)
+		}
+
+		// keys of the mappings merged first win over those merged later,
+		// explicit keys over all of them
+		var sources []*yaml.Node
+		for _, m := range merged {
+			if m.Kind == yaml.SequenceNode {
+				sources = append(sources, m.Content...)
+			} else {
+				sources = append(sources, m)
+			}
+		}
+		for _, m := range sources {
+			for i := 0; i+1 < len(m.Content); i += 2 {
+				if !keys[m.Content[i].Value] {
+					keys[m.Content[i].Value] = true
+					flat.Content = append(flat.Content, m.Content[i], m.Content[i+1])
+				}
+			}
+		}
+	}
+	return flat
+}
+
+func parseTOMLConfig(file string, data []byte) (*yaml.Node, error) {
+	m := map[string]any{}
+	_, err := toml.Decode(string(data), &m)
+	if err != nil {
+		var parseErr toml.ParseError
+		if errors.As(err, &parseErr) {
+			return nil, ErrInvalidFileConfig(fmt.Errorf("%s:%d:%d: %s", file, parseErr.Position.Line, parseErr.Position.Col, parseErr.Message))
+		}
+		return nil, ErrInvalidFileConfig(fmt.Errorf("%s: %v", file, err))
+	}
+
+	return tomlNode(m, "", tomlKeyPositions(data)), nil
+}
+
+// tomlNode converts a value decoded from TOML to nodes positioned on
+// the lines of their keys.
+func tomlNode(v any, path string, positions map[string]configPosition) *yaml.Node {
+	pos := configPosition{Line: 1, Column: 1}
+	for p := path; p != ""; p = parentConfigPath(p) {
+		if found, ok := positions[p]; ok {
+			pos = found
+			break
+		}
+	}
+
+	n := &yaml.Node{Kind: yaml.ScalarNode, Line: pos.Line, Column: pos.Column}
+	switch t := v.(type) {
+	case map[string]any:
+		n.Kind, n.Tag = yaml.MappingNode, "!!map"
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			p := joinConfigPath(path, k)
+			kn := tomlNode(k, p, positions)
+			kn.Tag = "!!str"
+			n.Content = append(n.Content, kn, tomlNode(t[k], p, positions))
+		}
+	case []map[string]any:
+		n.Kind, n.Tag = yaml.SequenceNode, "!!seq"
+		for i, e := range t {
+			n.Content = append(n.Content, tomlNode(e, fmt.Sprintf("%s[%d]", path, i), positions))
+		}
+	case []any:
+		n.Kind, n.Tag = yaml.SequenceNode, "!!seq"
+		for i, e := range t {
+			n.Content = append(n.Content, tomlNode(e, fmt.Sprintf("%s[%d]", path, i), positions))
+		}
+	case string:
+		n.Tag, n.Value = "!!str", t
+	case bool:
+		n.Tag, n.Value = "!!bool", strconv.FormatBool(t)
+	case int64:
+		n.Tag, n.Value = "!!int", strconv.FormatInt(t, 10)
+	case float64:
+		n.Tag, n.Value = "!!float", strconv.FormatFloat(t, 'g', -1, 64)
+	case time.Time:
+		n.Tag, n.Value = "!!str", t.Format(time.RFC3339Nano)
+	default:
+		// local dates and times
+		n.Tag, n.Value = "!!str", fmt.Sprint(t)
+	}
+	return n
+}
+
+// tomlKeyPositions finds the lines of the keys and tables of TOML data,
+// which the TOML decoder doesn't tell. Keys of inline tables aren't
+// found, their values are positioned on the line of the table.
+func tomlKeyPositions(data []byte) map[string]configPosition {
+	positions := map[string]configPosition{}
+	arrays := map[string]int{}
+	table := ""
+
+	// a.b of the second [[a]] is a[1].b
+	indexed := func(p string) string {
+		best := ""
+		for a := range arrays {
+			if (p == a || strings.HasPrefix(p, a+".")) && len(a) > len(best) {
+				best = a
+			}
+		}
+		if best == "" || p == best {
+			return p
+		}
+		return fmt.Sprintf("%s[%d]%s", best, arrays[best]-1, p[len(best):])
+	}
+
+	for i, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimSpace(line)
+		pos := configPosition{Line: i + 1, Column: 1 + len(line) - len(strings.TrimLeft(line, " \t"))}
+
+		switch {
+		case trimmed == "" || strings.HasPrefix(trimmed, "#"):
+		case strings.HasPrefix(trimmed, "[["):
+			name, _, _ := strings.Cut(trimmed[2:], "]]")
+			p := indexed(tomlKeyPath(name))
+			if _, ok := positions[p]; !ok {
+				positions[p] = pos
+			}
+			table = fmt.Sprintf("%s[%d]", p, arrays[p])
+			arrays[p]++
+			positions[table] = pos
+		case strings.HasPrefix(trimmed, "["):
+			name, _, _ := strings.Cut(trimmed[1:], "]")
+			table = indexed(tomlKeyPath(name))
+			positions[table] = pos
+		default:
+			key, _, ok := strings.Cut(trimmed, "=")
+			if ok {
+				positions[joinConfigPath(table, tomlKeyPath(key))] = pos
+			}
+		}
+	}
+	return positions
+}
+
+// tomlKeyPath returns the path of a dotted, maybe quoted, TOML key.
+func tomlKeyPath(key string) string {
+	parts := strings.Split(key, ".")
+	for i, part := range parts {
+		parts[i] = strings.Trim(strings.TrimSpace(part), `"'`)
+	}
+	return strings.Join(parts, ".")
+}
+
+func joinConfigPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// parentConfigPath returns the path of the object or array holding the
+// value of path.
+func parentConfigPath(path string) string {
+	i := strings.LastIndexAny(path, ".[")
+	if i < 0 {
+		return ""
+	}
+	return path[:i]
+}
+
+// configNodeValue converts n to the values JSON decodes to, numbers
+// being json.Number.
+func configNodeValue(n *yaml.Node) (any, error) {
+	switch n.Kind {
+	case yaml.MappingNode:
+		m := map[string]any{}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			v, err := configNodeValue(n.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			m[n.Content[i].Value] = v
+		}
+		return m, nil
+	case yaml.SequenceNode:
+		s := make([]any, 0, len(n.Content))
+		for _, c := range n.Content {
+			v, err := configNodeValue(c)
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, v)
+		}
+		return s, nil
+	}
+
+	switch n.Tag {
+	case "!!null":
+		return nil, nil
+	case "!!bool":
+		var b bool
+		err := n.Decode(&b)
+		return b, err
+	case "!!int", "!!float":
+		if json.Valid([]byte(n.Value)) {
+			return json.Number(n.Value), nil
+		}
+		// like 0x1f, +1 or 1_000 in YAML and TOML
+		var v any
+		if err := n.Decode(&v); err != nil {
+			return nil, err
+		}
+		switch t := v.(type) {
+		case int:
+			return json.Number(strconv.Itoa(t)), nil
+		case int64:
+			return json.Number(strconv.FormatInt(t, 10)), nil
+		case uint64:
+			return json.Number(strconv.FormatUint(t, 10)), nil
+		case float64:
+			if math.IsInf(t, 0) || math.IsNaN(t) {
+				return nil, fmt.Errorf("line %d: %s isn't a valid config number", n.Line, n.Value)
+			}
+			return json.Number(strconv.FormatFloat(t, 'g', -1, 64)), nil
+		}
+		return nil, fmt.Errorf("line %d: %s isn't a valid config number", n.Line, n.Value)
+	}
+	return n.Value, nil
+}
+
+// encodeConfigNode writes n in format.
+func encodeConfigNode(n *yaml.Node, format string) ([]byte, error) {
+	switch format {
+	case configFormatYAML:
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(n); err != nil {
+			return nil, err
+		}
+		if err := enc.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case configFormatTOML:
+		v, err := configNodeValue(n)
+		if err != nil {
+			return nil, err
+		}
+		v, err = tomlValue(v, "")
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		enc := toml.NewEncoder(&buf)
+		enc.Indent = ""
+		if err := enc.Encode(v); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case configFormatJSON:
+		var buf bytes.Buffer
+		if err := writeJSONNode(&buf, n); err != nil {
+			return nil, err
+		}
+		var out bytes.Buffer
+		if err := json.Indent(&out, buf.Bytes(), "", "  "); err != nil {
+			return nil, err
+		}
+		out.WriteByte('\n')
+		return out.Bytes(), nil
+	}
+	return nil, fmt.Errorf("unknown config format %q, expected one of %s", format, strings.Join(configFormats, ", "))
+}
+
+// writeJSONNode writes n as JSON keeping the order of its keys.
+func writeJSONNode(buf *bytes.Buffer, n *yaml.Node) error {
+	switch n.Kind {
+	case yaml.MappingNode:
+		buf.WriteByte('{')
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			k, _ := json.Marshal(n.Content[i].Value)
+			buf.Write(k)
+			buf.WriteByte(':')
+			if err := writeJSONNode(buf, n.Content[i+1]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+		return nil
+	case yaml.SequenceNode:
+		buf.WriteByte('[')
+		for i, c := range n.Content {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeJSONNode(buf, c); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+		return nil
+	}
+
+	v, err := configNodeValue(n)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	buf.Write(body)
+	return nil
+}
+
+// tomlValue converts the JSON numbers of v for TOML, which has no null.
+func tomlValue(v any, path string) (any, error) {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, e := range t {
+			if e == nil {
+				delete(t, k)
+				continue
+			}
+			c, err := tomlValue(e, joinConfigPath(path, k))
+			if err != nil {
+				return nil, err
+			}
+			t[k] = c
+		}
+	case []any:
+		for i, e := range t {
+			if e == nil {
+				return nil, fmt.Errorf("%s[%d]: null can't be written in TOML", path, i)
+			}
+			c, err := tomlValue(e, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			t[i] = c
+		}
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i, nil
+		}
+		return t.Float64()
+	}
+	return v, nil
+}
@@ -0,0 +1,221 @@
+package cmd
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/nanovms/ops/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnWarpConfigFormats(t *testing.T) {
+	expected := &types.Config{
+		Program: "node",
+		Args:    []string{"hi.js"},
+		Env:     map[string]string{"PORT": "8080"},
+		RunConfig: types.RunConfig{
+			Memory: "2G",
+			CPUs:   2,
+			Ports:  []string{"8080"},
+		},
+		CloudConfig: types.ProviderConfig{
+			Tags: []types.Tag{{Key: "env", Value: "prod"}},
+		},
+	}
+
+	dir := writeConfigFiles(t, map[string]string{
+		"config.json": `{
+			"Program": "node",
+			"Args": ["hi.js"],
+			"Env": {"PORT": "8080"},
+			"RunConfig": {"Memory": "2G", "CPUs": 2, "Ports": ["8080"]},
+			"CloudConfig": {"Tags": [{"key": "env", "value": "prod"}]}
+		}`,
+		"config.yaml": `
+Program: node
+Args: [hi.js]
+Env:
+  PORT: "8080"
+RunConfig: &run
+  Memory: 2G
+  CPUs: 2
+  Ports: ["8080"]
+CloudConfig:
+  Tags:
+    - key: env
+      value: prod
+`,
+		"config.toml": `
+Program = "node"
+Args = ["hi.js"]
+
+[Env]
+PORT = "8080"
+
+[RunConfig]
+Memory = "2G"
+CPUs = 2
+Ports = ["8080"]
+
+[[CloudConfig.Tags]]
+key = "env"
+value = "prod"
+`,
+	})
+
+	for _, name := range []string{"config.json", "config.yaml", "config.toml"} {
+		c := &types.Config{}
+		err := unWarpConfig(filepath.Join(dir, name), c)
+		assert.Nil(t, err, name)
+		assert.Equal(t, expected, c, name)
+	}
+}
+
+func TestUnWarpConfigYAMLMerge(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"base.json": `{"Env": {"A": "base"}}`,
+		"config.yml": `
+Extends: base.json
+RunConfig: &run
+  Memory: 1G
+  CPUs: 2
+Profiles:
+  prod:
+    RunConfig:
+      <<: *run
+      Memory: 4G
+`,
+	})
+
+	c := &types.Config{}
+	err := unWarpConfigProfile(filepath.Join(dir, "config.yml"), "prod", c)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"A": "base"}, c.Env)
+	assert.Equal(t, types.RunConfig{Memory: "4G", CPUs: 2}, c.RunConfig)
+}
+
+func TestValidateConfigDataFormats(t *testing.T) {
+	_, err := validateConfigData("config.yaml", []byte(`
+RunConfig:
+  Memory: lots
+  CPUs: "2"
+Version: 1.0
+`))
+	assert.EqualError(t, err, `config.yaml:3:11: RunConfig.Memory: invalid size "lots", expected a number with an optional unit like 512M or 2G
+config.yaml:4:9: RunConfig.CPUs: must be integer, not string
+config.yaml:5:10: Version: must be string, not number`)
+
+	_, err = validateConfigData("config.toml", []byte(`Program = "x"
+
+[RunConfig]
+Memory = "lots"
+CPUs = "2"
+
+[[CloudConfig.Tags]]
+key = "a"
+
+[[CloudConfig.Tags]]
+key = "b"
+value = 1
+`))
+	assert.EqualError(t, err, `config.toml:4:1: RunConfig.Memory: invalid size "lots", expected a number with an optional unit like 512M or 2G
+config.toml:5:1: RunConfig.CPUs: must be integer, not string
+config.toml:12:1: CloudConfig.Tags[1].value: must be string, not number`)
+}
+
+func TestEncodeConfigNode(t *testing.T) {
+	data := []byte(`{
+  "Program": "node",
+  "Env": {"B": "2", "A": "${HOME}"},
+  "RunConfig": {"Memory": "2G", "CPUs": 2, "Accel": true}
+}`)
+
+	n, err := parseConfigNode("config.json", data)
+	assert.Nil(t, err)
+
+	body, err := encodeConfigNode(n, configFormatYAML)
+	assert.Nil(t, err)
+	assert.Equal(t, `Program: node
+Env:
+  B: "2"
+  A: ${HOME}
+RunConfig:
+  Memory: 2G
+  CPUs: 2
+  Accel: true
+`, string(body))
+
+	body, err = encodeConfigNode(n, configFormatTOML)
+	assert.Nil(t, err)
+	assert.Equal(t, `Program = "node"
+
+[Env]
+A = "${HOME}"
+B = "2"
+
+[RunConfig]
+Accel = true
+CPUs = 2
+Memory = "2G"
+`, string(body))
+
+	// back to JSON in the same order
+	for _, format := range []string{configFormatYAML, configFormatJSON} {
+		body, err = encodeConfigNode(n, format)
+		assert.Nil(t, err)
+		back, err := parseConfigNode("config."+format, body)
+		assert.Nil(t, err)
+		body, err = encodeConfigNode(back, configFormatJSON)
+		assert.Nil(t, err)
+		assert.Equal(t, `{
+  "Program": "node",
+  "Env": {
+    "B": "2",
+    "A": "${HOME}"
+  },
+  "RunConfig": {
+    "Memory": "2G",
+    "CPUs": 2,
+    "Accel": true
+  }
+}
+`, string(body))
+	}
+
+	_, err = encodeConfigNode(n, "xml")
+	assert.ErrorContains(t, err, `unknown config format "xml"`)
+}
+
+func TestEncodeConfig(t *testing.T) {
+	body, err := encodeConfig(&types.Config{Program: "node", Args: []string{"hi.js"}}, configFormatYAML)
+	assert.Nil(t, err)
+	// zero CloudConfig and RunConfig are omitted like in JSON
+	assert.Equal(t, "Args:\n  - hi.js\nProgram: node\n", string(body))
+}
+
+func TestTOMLKeyPositions(t *testing.T) {
+	positions := tomlKeyPositions([]byte(`Program = "x"
+RunConfig.Memory = "2G"
+
+[[CloudConfig.Tags]]
+key = "a"
+
+[[CloudConfig.Tags]]
+  key = "b"
+
+["Env"]
+A = "1" # comment
+`))
+
+	assert.Equal(t, map[string]configPosition{
+		"Program":                 {Line: 1, Column: 1},
+		"RunConfig.Memory":        {Line: 2, Column: 1},
+		"CloudConfig.Tags":        {Line: 4, Column: 1},
+		"CloudConfig.Tags[0]":     {Line: 4, Column: 1},
+		"CloudConfig.Tags[0].key": {Line: 5, Column: 1},
+		"CloudConfig.Tags[1]":     {Line: 7, Column: 1},
+		"CloudConfig.Tags[1].key": {Line: 8, Column: 3},
+		"Env":                     {Line: 10, Column: 1},
+		"Env.A":                   {Line: 11, Column: 1},
+	}, positions)
+}
@@ -0,0 +1,357 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/nanovms/ops/types"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigError is a problem found in a config file.
+type ConfigError struct {
+	File    string `json:"file"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Path    string `json:"path,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e ConfigError) Error() string {
+	pos := e.File
+	if e.Line > 0 {
+		pos = fmt.Sprintf("%s:%d:%d", e.File, e.Line, e.Column)
+	}
+	if e.Path != "" {
+		return fmt.Sprintf("%s: %s: %s", pos, e.Path, e.Message)
+	}
+	return pos + ": " + e.Message
+}
+
+// ConfigErrors are all the problems found in a config file.
+type ConfigErrors []ConfigError
+
+func (e ConfigErrors) Error() string {
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = err.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// configPosition is the line and column of a value of a config file.
+type configPosition struct {
+	Line   int
+	Column int
+}
+
+// providerRequiredFields are the CloudConfig fields a provider can't do
+// without.
+var providerRequiredFields = map[string][]string{
+	"gcp":   {"ProjectID", "Zone", "BucketName"},
+	"aws":   {"Zone", "BucketName"},
+	"azure": {"BucketName"},
+}
+
+// configFileSchema returns the schema of config files: a config with
+// the files it extends and its profiles.
+func configFileSchema() *types.Schema {
+	s := types.ConfigSchema()
+
+	profile := types.ConfigSchema()
+	profile.Draft = ""
+
+	s.Properties["Extends"] = &types.Schema{
+		Description: "config files merged beneath this one",
+		OneOf: []*types.Schema{
+			{Type: "string"},
+			{Type: "array", Items: &types.Schema{Type: "string"}},
+		},
+	}
+	s.Properties["Profiles"] = &types.Schema{
+		Type:                 "object",
+		Description:          "configs merged over this one, selected by name with --profile",
+		AdditionalProperties: profile,
+	}
+	return s
+}
+
+// configValidator checks the nodes of a config file against a schema.
+type configValidator struct {
+	file      string
+	errs      ConfigErrors
+	positions map[string]configPosition
+}
+
+// validateConfigData checks the config file data against the config
+// schema and returns the positions of its values by path, like
+// RunConfig.Memory.
+func validateConfigData(file string, data []byte) (map[string]configPosition, error) {
+	n, err := parseConfigNode(file, data)
+	if err != nil {
+		return nil, err
+	}
+	return validateConfigNode(file, n)
+}
+
+// validateConfigNode checks the nodes of the config file against the
+// config schema and returns the positions of its values by path.
+func validateConfigNode(file string, n *yaml.Node) (map[string]configPosition, error) {
+	v := &configValidator{
+		file:      file,
+		positions: map[string]configPosition{},
+	}
+
+	v.value(configFileSchema(), n, "")
+	// TOML values are checked in the order of their keys
+	sort.SliceStable(v.errs, func(i, j int) bool {
+		if v.errs[i].Line != v.errs[j].Line {
+			return v.errs[i].Line < v.errs[j].Line
+		}
+		return v.errs[i].Column < v.errs[j].Column
+	})
+	if len(v.errs) > 0 {
+		return v.positions, v.errs
+	}
+	return v.positions, nil
+}
+
+func (v *configValidator) errorf(n *yaml.Node, path string, format string, a ...any) {
+	v.errs = append(v.errs, ConfigError{
+		File:    v.file,
+		Line:    n.Line,
+		Column:  n.Column,
+		Path:    path,
+		Message: fmt.Sprintf(format, a...),
+	})
+}
+
+func (v *configValidator) value(s *types.Schema, n *yaml.Node, path string) {
+	if path != "" {
+		v.positions[path] = configPosition{Line: n.Line, Column: n.Column}
+	}
+
+	kind := nodeType(n)
+	// null leaves the field as it is
+	if kind == "null" {
+		return
+	}
+
+	if len(s.OneOf) > 0 {
+		var kinds []string
+		for _, o := range s.OneOf {
+			kinds = append(kinds, o.Type)
+		}
+		matched := false
+		for _, o := range s.OneOf {
+			if typeMatches(kind, o.Type) {
+				s, matched = o, true
+				break
+			}
+		}
+		if !matched {
+			v.errorf(n, path, "must be %s, not %s", strings.Join(kinds, " or "), kind)
+			return
+		}
+	}
+
+	if !typeMatches(kind, s.Type) {
+		v.errorf(n, path, "must be %s, not %s", s.Type, kind)
+		return
+	}
+
+	switch s.Type {
+	case "object":
+		v.object(s, n, path)
+	case "array":
+		for i, c := range n.Content {
+			v.value(s.Items, c, fmt.Sprintf("%s[%d]", path, i))
+		}
+	case "string":
+		// interpolated values are checked once interpolated
+		if s.Pattern != "" && !configInterpolationRegex.MatchString(n.Value) && !regexp.MustCompile(s.Pattern).MatchString(n.Value) {
+			v.errorf(n, path, "invalid size %q, expected a number with an optional unit like 512M or 2G", n.Value)
+		}
+		if len(s.Enum) > 0 && !configInterpolationRegex.MatchString(n.Value) && !slices.Contains(s.Enum, n.Value) {
+			v.errorf(n, path, "must be one of %s, not %q", strings.Join(s.Enum, ", "), n.Value)
+		}
+	case "integer":
+		if n.Tag != "!!int" {
+			v.errorf(n, path, "must be integer, not %s", n.Value)
+		}
+	}
+}
+
+func (v *configValidator) object(s *types.Schema, n *yaml.Node, path string) {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k := n.Content[i]
+
+		name, fs, ok := schemaProperty(s.Properties, k.Value)
+		p := joinConfigPath(path, name)
+		if !ok {
+			if ap, isSchema := s.AdditionalProperties.(*types.Schema); isSchema {
+				fs = ap
+			} else if s.AdditionalProperties == false {
+				v.errorf(k, p, "%s", unknownFieldMessage(k.Value, s.Properties))
+				continue
+			} else {
+				fs = &types.Schema{}
+			}
+		}
+
+		v.value(fs, n.Content[i+1], p)
+	}
+}
+
+// schemaProperty returns the name and schema of the property key of
+// properties, matched case-insensitively like the json decoding of the
+// config does.
+func schemaProperty(properties map[string]*types.Schema, key string) (string, *types.Schema, bool) {
+	if fs, ok := properties[key]; ok {
+		return key, fs, true
+	}
+	for name, fs := range properties {
+		if strings.EqualFold(name, key) {
+			return name, fs, true
+		}
+	}
+	return key, nil, false
+}
+
+// nodeType returns the JSON type of n.
+func nodeType(n *yaml.Node) string {
+	switch n.Kind {
+	case yaml.MappingNode:
+		return "object"
+	case yaml.SequenceNode:
+		return "array"
+	}
+	switch n.Tag {
+	case "!!int", "!!float":
+		return "number"
+	case "!!bool":
+		return "boolean"
+	case "!!null":
+		return "null"
+	}
+	return "string"
+}
+
+func typeMatches(kind string, t string) bool {
+	switch t {
+	case "integer":
+		return kind == "number"
+	case "":
+		return true
+	}
+	return kind == t
+}
+
+// unknownFieldMessage tells key isn't a field, suggesting the field of
+// properties it's most likely a typo of.
+func unknownFieldMessage(key string, properties map[string]*types.Schema) string {
+	names := make([]string, 0, len(properties))
+	for name := range properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	suggestion := ""
+	best := 3
+	for _, name := range names {
+		if d := editDistance(strings.ToLower(key), strings.ToLower(name)); d < best {
+			suggestion, best = name, d
+		}
+	}
+
+	if suggestion != "" {
+		return fmt.Sprintf("unknown field %q, did you mean %q?", key, suggestion)
+	}
+	return fmt.Sprintf("unknown field %q", key)
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// checkProviderConfig returns the problems of the config c, loaded from
+// file with profile and merged with the command flags, for its cloud
+// provider.
+func checkProviderConfig(file string, profile string, c *types.Config) error {
+	if _, ok := providerRequiredFields[c.CloudConfig.Platform]; !ok {
+		return nil
+	}
+
+	body, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	positions, _ := validateConfigData(file, body)
+
+	if errs := validateProviderConfig(file, profile, c, positions); len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateProviderConfig returns the problems of the effective config c
+// of file for its cloud provider, located with the positions of file.
+func validateProviderConfig(file string, profile string, c *types.Config, positions map[string]configPosition) ConfigErrors {
+	required, ok := providerRequiredFields[c.CloudConfig.Platform]
+	if !ok {
+		return nil
+	}
+
+	var pos configPosition
+	for _, p := range []string{"CloudConfig.Platform", "CloudConfig"} {
+		if found, ok := positions["Profiles."+profile+"."+p]; ok && profile != "" {
+			pos = found
+			break
+		}
+		if found, ok := positions[p]; ok {
+			pos = found
+			break
+		}
+	}
+
+	fields := map[string]string{
+		"ProjectID":  c.CloudConfig.ProjectID,
+		"Zone":       c.CloudConfig.Zone,
+		"BucketName": c.CloudConfig.BucketName,
+	}
+
+	var errs ConfigErrors
+	for _, f := range required {
+		if fields[f] == "" {
+			errs = append(errs, ConfigError{
+				File:    file,
+				Line:    pos.Line,
+				Column:  pos.Column,
+				Path:    "CloudConfig." + f,
+				Message: fmt.Sprintf("is required for platform %s", c.CloudConfig.Platform),
+			})
+		}
+	}
+	return errs
+}
@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/nanovms/ops/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateConfigData(t *testing.T) {
+	data := []byte(`{
+  "Runconfig": {"Memory": "2 gigs"},
+  "Klib": ["ntp"],
+  "BaseVolumeSz": "2 gigs",
+  "RunConfig": {
+    "Memory": "lots",
+    "CPUs": "2",
+    "Nics": [{"IPAdress": "10.0.0.2"}]
+  },
+  "CloudConfig": {"Tags": [{"key": "env", "value": 1}]},
+  "Env": {"ANY": "thing"},
+  "ManifestPassthrough": {"anything": {"goes": [1, true]}},
+  "Secrets": {"Mode": "vault", "Values": {"A": {"Env": "A", "Path": "a"}}},
+  "Extends": 3,
+  "Profiles": {"prod": {"Extends": "base.json", "RunConfig": {"Memory": "${MEMORY}"}}}
+}`)
+
+	_, err := validateConfigData("config.json", data)
+	errs, ok := err.(ConfigErrors)
+	assert.True(t, ok)
+
+	got := []string{}
+	for _, e := range errs {
+		got = append(got, e.Error())
+	}
+	assert.Equal(t, []string{
+		`config.json:2:27: RunConfig.Memory: invalid size "2 gigs", expected a number with an optional unit like 512M or 2G`,
+		`config.json:3:3: Klib: unknown field "Klib", did you mean "Klibs"?`,
+		`config.json:4:19: BaseVolumeSz: invalid size "2 gigs", expected a number with an optional unit like 512M or 2G`,
+		`config.json:6:15: RunConfig.Memory: invalid size "lots", expected a number with an optional unit like 512M or 2G`,
+		`config.json:7:13: RunConfig.CPUs: must be integer, not string`,
+		`config.json:8:15: RunConfig.Nics[0].IPAdress: unknown field "IPAdress", did you mean "IPAddress"?`,
+		`config.json:10:52: CloudConfig.Tags[0].value: must be string, not number`,
+		`config.json:13:23: Secrets.Mode: must be one of metadata, encrypted, not "vault"`,
+		`config.json:13:61: Secrets.Values.A.Path: unknown field "Path"`,
+		`config.json:14:14: Extends: must be string or array, not number`,
+		`config.json:15:25: Profiles.prod.Extends: unknown field "Extends"`,
+	}, got)
+}
+
+func TestValidateConfigDataValid(t *testing.T) {
+	data := []byte(`{
+		"Program": "node",
+		"Args": ["hi.js"],
+		"BaseVolumeSz": "512m",
+		"home": "/home/app",
+		"RunConfig": {"Memory": "2G", "CPUs": 2, "Ports": null},
+		"CloudConfig": {"RootVolume": {"size": 20}},
+		"Extends": ["base.json"]
+	}`)
+
+	positions, err := validateConfigData("config.json", data)
+	assert.Nil(t, err)
+	assert.Equal(t, configPosition{Line: 6, Column: 27}, positions["RunConfig.Memory"])
+}
+
+func TestValidateConfigFile(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"base.json": `{"CloudConfig": {"Platform": "gcp", "Zone": "us-west1-b"}}`,
+		"config.json": `{
+  "Extends": "base.json",
+  "Profiles": {
+    "prod": {"CloudConfig": {"ProjectID": "prj", "BucketName": "bkt"}}
+  }
+}`,
+	})
+	config := filepath.Join(dir, "config.json")
+
+	errs := validateConfigFile(config, "")
+	assert.Len(t, errs, 2)
+	assert.Equal(t, "CloudConfig.ProjectID", errs[0].Path)
+	assert.Equal(t, "CloudConfig.BucketName", errs[1].Path)
+	assert.Equal(t, "is required for platform gcp", errs[0].Message)
+
+	assert.Empty(t, validateConfigFile(config, "prod"))
+}
+
+func TestUnWarpConfigValidates(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"base.json":   `{"RunConfig": {"Memroy": "2G"}}`,
+		"config.json": `{"Extends": "base.json"}`,
+	})
+
+	err := unWarpConfig(filepath.Join(dir, "config.json"), nil)
+	assert.ErrorContains(t, err, `base.json:1:16: RunConfig.Memroy: unknown field "Memroy", did you mean "Memory"?`)
+
+	// fields are matched case-insensitively like the json decoding does
+	dir = writeConfigFiles(t, map[string]string{
+		"config.json": `{"runconfig": {"memory": "3G"}, "profiles": {"prod": {"runconfig": {"memory": "4G"}}}}`,
+	})
+	c := &types.Config{}
+	assert.Nil(t, unWarpConfigProfile(filepath.Join(dir, "config.json"), "prod", c))
+	assert.Equal(t, "4G", c.RunConfig.Memory)
+}
+
+func TestEditDistance(t *testing.T) {
+	assert.Equal(t, 0, editDistance("klibs", "klibs"))
+	assert.Equal(t, 1, editDistance("klib", "klibs"))
+	assert.Equal(t, 2, editDistance("ipadress", "ipaddres"))
+	assert.Equal(t, 5, editDistance("", "klibs"))
+}
+
+func TestMergeConfigChecksProvider(t *testing.T) {
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "")
+	t.Setenv("GOOGLE_CLOUD_ZONE", "")
+
+	dir := writeConfigFiles(t, map[string]string{
+		"config.json": `{"CloudConfig": {"Platform": "gcp", "BucketName": "images"}}`,
+	})
+	file := filepath.Join(dir, "config.json")
+
+	merge := func(args ...string) error {
+		flagSet := newConfigFlagSet()
+		PersistProviderCommandFlags(flagSet)
+		assert.Nil(t, flagSet.Parse(append([]string{"-c", file, "-t", "gcp"}, args...)))
+
+		container := NewMergeConfigContainer(NewConfigCommandFlags(flagSet), NewProviderCommandFlags(flagSet))
+		return container.Merge(&types.Config{})
+	}
+
+	err := merge()
+	assert.ErrorContains(t, err, "CloudConfig.ProjectID: is required for platform gcp")
+
+	// fields given with flags are checked once merged
+	assert.Nil(t, merge("--projectid", "prod", "--zone", "us-west1-a"))
+}
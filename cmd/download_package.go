@@ -114,7 +114,7 @@ func MovePackageFiles(origin string, target string) string {
 	manifestPath := path.Join(origin, "package.manifest")
 	pkgConfig := &types.Config{}
 
-	err := unWarpConfig(manifestPath, pkgConfig)
+	err := unWarpPackageManifest(manifestPath, pkgConfig)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -206,9 +206,18 @@ func downloadAndExtractPackage(packagesDirPath, pkg string, config *types.Config
 		return "", err
 	}
 
+	// the extracted package is named after the locked version
+	pkg, err = api.PinPackage(pkg, api.TargetArch(config))
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
 	expackage := path.Join(packagesDirPath, strings.ReplaceAll(pkg, ":", "_"))
 	opsPackage, err := api.DownloadPackage(pkg, config)
 	if err != nil {
+		if api.IsPackageVerificationError(err) {
+			exitWithError(err.Error() + " - the downloaded archive was discarded")
+		}
 		log.Fatal(err)
 	}
 
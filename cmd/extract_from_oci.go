@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	api "github.com/nanovms/ops/lepton"
+	"github.com/nanovms/ops/types"
+)
+
+// ExtractFromOCIImage creates a package from an executable of an OCI
+// image and its shared libraries without a docker daemon. The image is
+// pulled from a registry or read from an OCI layout or tarball.
+func ExtractFromOCIImage(imageName string, packageName string, parch string, targetExecutable string, verbose bool, copyWholeFS bool, nodiscover bool, args []string) (string, string) {
+	var err error
+	var version string
+	var name string
+	if packageName == "" {
+		name, version, err = ImageNameToPackageNameAndVersion(imageName)
+		if err != nil {
+			// layouts and tarballs are named after the file
+			name = strings.TrimSuffix(filepath.Base(imageName), filepath.Ext(imageName))
+		}
+		packageName = strings.TrimRight(name+"_"+version, "_")
+	}
+
+	rootfs, err := os.MkdirTemp("", "ops-rootfs")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(rootfs)
+
+	if verbose {
+		fmt.Printf("Flattening %s into %s\n", imageName, rootfs)
+	}
+
+	imageConfig, err := api.PullOCIImage(imageName, parch, rootfs)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// like docker run, the program is the entrypoint or the cmd
+	var imageArgs []string
+	if targetExecutable == "" {
+		command := append(append([]string{}, imageConfig.Config.Entrypoint...), imageConfig.Config.Cmd...)
+		if len(command) == 0 {
+			log.Fatal("the image has no entrypoint nor cmd, use --file")
+		}
+		targetExecutable, imageArgs = command[0], command[1:]
+	}
+
+	targetExecutablePath, err := api.OCIFindExecutable(rootfs, imageConfig, targetExecutable)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	tempDirectory, err := os.MkdirTemp("", "*")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if verbose {
+		fmt.Printf("Extracting files into %s\n", tempDirectory)
+	}
+
+	sysroot := tempDirectory + "/sysroot"
+	targetExecutableName := path.Base(targetExecutable)
+
+	err = copyFile(filepath.Join(rootfs, targetExecutablePath), tempDirectory+"/"+targetExecutableName)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if !nodiscover && !copyWholeFS {
+		libs, err := api.SharedLibs(rootfs, targetExecutablePath, nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		for libraryPath, hostPath := range libs {
+			if verbose {
+				fmt.Printf("Library: %s\n", libraryPath)
+			}
+
+			libraryDestination := sysroot + path.Clean(libraryPath)
+			err = os.MkdirAll(path.Dir(libraryDestination), 0755)
+			if err != nil {
+				log.Fatal(err)
+			}
+			err = copyFile(hostPath, libraryDestination)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	if copyWholeFS {
+		if verbose {
+			fmt.Println("Copying whole image fs into sysroot")
+		}
+		err = xrename(rootfs, sysroot)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// like docker if the user doesn't provide version of the image we consider "latest" as the version
+	if version == "" {
+		version = "latest"
+	}
+
+	rargs := []string{"/" + targetExecutableName}
+	rargs = append(rargs, imageArgs...)
+	rargs = append(rargs, args...)
+
+	c := &types.Config{
+		Program: packageName + "/" + targetExecutableName,
+		Args:    rargs,
+		Version: version,
+	}
+
+	for _, e := range imageConfig.Config.Env {
+		k, v, _ := strings.Cut(e, "=")
+		if k == "PATH" {
+			continue
+		}
+		if c.Env == nil {
+			c.Env = map[string]string{}
+		}
+		c.Env[k] = v
+	}
+
+	json, _ := json.MarshalIndent(c, "", "  ")
+
+	err = os.WriteFile(path.Join(tempDirectory, "package.manifest"), json, 0666)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	packageDirectory := MovePackageFiles(tempDirectory, path.Join(api.LocalPackagesRoot, parch, packageName))
+
+	return packageName, packageDirectory
+}
@@ -30,5 +30,13 @@ func (m *MergeConfigContainer) Merge(config *types.Config) error {
 		}
 	}
 
+	// provider fields may be given with flags, they are checked once
+	// the flags are merged
+	for _, f := range m.flags {
+		if configFlags, ok := f.(*ConfigCommandFlags); ok && configFlags.Config != "" {
+			return checkProviderConfig(configFlags.Config, configFlags.Profile, config)
+		}
+	}
+
 	return nil
 }
@@ -29,6 +29,7 @@ type BuildImageCommandFlags struct {
 	NetConsolePort  string
 	NetConsoleIP    string
 	Consoles        []string
+	NoCache         bool
 }
 
 // MergeToConfig overrides configuration passed by argument with command flags values
@@ -98,6 +99,10 @@ func (flags *BuildImageCommandFlags) MergeToConfig(c *types.Config) (err error)
 
 	c.DisableArgsCopy = flags.DisableArgsCopy
 
+	if flags.NoCache {
+		c.NoBuildCache = true
+	}
+
 	if c.Program != "" {
 		c.Args = append([]string{c.Program}, c.Args...)
 	}
@@ -210,6 +215,11 @@ func NewBuildImageCommandFlags(cmdFlags *pflag.FlagSet) (flags *BuildImageComman
 		exitWithError(err.Error())
 	}
 
+	flags.NoCache, err = cmdFlags.GetBool("no-cache")
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
 	return
 }
 
@@ -230,6 +240,7 @@ func PersistBuildImageCommandFlags(cmdFlags *pflag.FlagSet) {
 	cmdFlags.StringP("netconsole-port", "", "4444", "set net console port")
 	cmdFlags.StringP("netconsole-ip", "", "10.0.2.2", "set net console ip")
 	cmdFlags.StringArrayP("consoles", "", []string{}, "set different consoles to forward logs to")
+	cmdFlags.Bool("no-cache", false, "build the image even if an identical one is in the build cache")
 }
 
 func setNanosBaseImage(c *types.Config) {
@@ -241,7 +252,7 @@ func setNanosBaseImage(c *types.Config) {
 	} else if c.NanosVersion != "" && c.NanosVersion != "0.0" {
 		currversion = c.NanosVersion
 	} else {
-		currversion, err = getCurrentVersion()
+		currversion, err = getCurrentVersion(c.Arch)
 	}
 
 	panicOnError(err)
@@ -9,7 +9,6 @@ import (
 	"strings"
 
 	"github.com/nanovms/ops/lepton"
-	"github.com/nanovms/ops/log"
 	"github.com/nanovms/ops/types"
 	"github.com/spf13/pflag"
 )
@@ -23,7 +22,8 @@ var (
 
 // ConfigCommandFlags handles config file path flag and build configuration from the file
 type ConfigCommandFlags struct {
-	Config string
+	Config  string
+	Profile string
 }
 
 // MergeToConfig reads a json configuration file
@@ -34,7 +34,7 @@ func (flags *ConfigCommandFlags) MergeToConfig(c *types.Config) (err error) {
 			c = &types.Config{}
 		}
 
-		err = unWarpConfig(flags.Config, c)
+		err = unWarpConfigProfile(flags.Config, flags.Profile, c)
 
 		c.LocalFilesParentDirectory = path.Dir(flags.Config)
 
@@ -48,13 +48,26 @@ func (flags *ConfigCommandFlags) MergeToConfig(c *types.Config) (err error) {
 
 // unWarpConfig parses lepton config file from file
 func unWarpConfig(file string, c *types.Config) (err error) {
-	data, err := os.ReadFile(file)
+	return unWarpConfigProfile(file, "", c)
+}
+
+// unWarpConfigProfile parses the lepton config file with the files it
+// extends and the profile selected
+func unWarpConfigProfile(file string, profile string, c *types.Config) (err error) {
+	data, err := resolveConfigFile(file, profile)
 	if err != nil {
-		log.Fatalf("error reading config: %v\nIf you are trying to use interpolation in your\nconfig's JSON set 'ops_render_config=true' in your ENV", err)
+		return err
 	}
-	if os.Getenv("ops_render_config") == "true" {
-		loadedEnvJSON := os.ExpandEnv(string(data))
-		return ConvertJSONToConfig([]byte(loadedEnvJSON), c)
+	return ConvertJSONToConfig(data, c)
+}
+
+// unWarpPackageManifest parses the package.manifest of a package. Unlike
+// user configs, manifests come from packages publishers so they are read
+// as they are, without interpolation, Extends or Profiles.
+func unWarpPackageManifest(file string, c *types.Config) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("error reading package manifest: %w", err)
 	}
 	return ConvertJSONToConfig(data, c)
 }
@@ -101,10 +114,16 @@ func NewConfigCommandFlags(cmdFlags *pflag.FlagSet) (flags *ConfigCommandFlags)
 
 	flags.Config = strings.TrimSpace(flags.Config)
 
+	flags.Profile, err = cmdFlags.GetString("profile")
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
 	return
 }
 
 // PersistConfigCommandFlags append a command the required flags to run an image
 func PersistConfigCommandFlags(cmdFlags *pflag.FlagSet) {
 	cmdFlags.StringP("config", "c", "", "ops config file")
+	cmdFlags.String("profile", "", "profile of the config file to use, like dev or prod")
 }
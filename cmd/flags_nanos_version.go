@@ -12,16 +12,15 @@ type NanosVersionCommandFlags struct {
 	NanosVersion string
 }
 
-func archPath() string {
+// archPath returns the release suffix used for arch; an empty arch is
+// the host architecture.
+func archPath(arch string) string {
 	parch := ""
-	if lepton.AltGOARCH != "" {
-		if lepton.AltGOARCH == "arm64" {
-			parch = "arm"
-		}
-	} else {
-		if lepton.RealGOARCH == "arm64" {
-			parch = "arm"
-		}
+	if arch == "" {
+		arch = lepton.RealGOARCH
+	}
+	if arch == "arm64" {
+		parch = "arm"
 	}
 
 	return parch
@@ -32,7 +31,7 @@ func (flags *NanosVersionCommandFlags) MergeToConfig(config *types.Config) (err
 	nanosVersion := flags.NanosVersion
 
 	if nanosVersion != "" {
-		arch := archPath()
+		arch := archPath(config.Arch)
 
 		var exists bool
 
@@ -28,10 +28,8 @@ func (flags *NightlyCommandFlags) MergeToConfig(config *types.Config) (err error
 	}
 
 	if flags.Arch != "" {
-		api.AltGOARCH = flags.Arch
-		// this is dumb, FIXME
-		api.NightlyLocalFolderm = api.NightlyLocalFolder()
-		api.NightlyReleaseURLm = api.NightlyReleaseURL()
+		config.Arch = flags.Arch
+		config.RunConfig.Arch = flags.Arch
 	}
 
 	if config.NightlyBuild {
@@ -80,14 +78,13 @@ func getKernelVersion(version string) string {
 	return path.Join(api.GetOpsHome(), version, "kernel.img")
 }
 
-func setKernelVersion(version string) string {
-	if api.AltGOARCH != "" {
-		if api.AltGOARCH == "arm64" {
-			return version + "-arm"
-		}
-		return version
+// setKernelVersion returns the release folder of version for arch; an
+// empty arch is the host architecture.
+func setKernelVersion(version string, arch string) string {
+	if arch == "" {
+		arch = runtime.GOARCH
 	}
-	if runtime.GOARCH == "arm64" {
+	if arch == "arm64" {
 		return version + "-arm"
 	}
 	return version
@@ -98,7 +95,7 @@ func updateNanosToolsPaths(c *types.Config, version string) {
 		version = "nightly"
 	}
 
-	version = setKernelVersion(version)
+	version = setKernelVersion(version, c.Arch)
 
 	if c.Boot == "" {
 		bootPath := path.Join(api.GetOpsHome(), version, "boot.img")
@@ -133,19 +130,26 @@ func downloadNightlyImages(c *types.Config) (string, error) {
 	var err error
 	err = api.DownloadNightlyImages(c)
 
-	if runtime.GOARCH == "arm64" || api.AltGOARCH == "arm64" {
+	if api.TargetArch(c) == "arm64" {
 		return "nightly-arm", err
 	}
 	return "nightly", err
 
 }
 
-func getCurrentVersion() (string, error) {
+// getCurrentVersion returns the local nanos release, downloading the
+// latest one for arch if there is none yet. An empty arch downloads the
+// default (x86-64) release.
+func getCurrentVersion(arch string) (string, error) {
 	var err error
 
 	local, remote := api.LocalReleaseVersion, api.LatestReleaseVersion
 	if local == "0.0" {
-		err = api.DownloadReleaseImages(remote, "")
+		rarch := ""
+		if arch == "arm64" {
+			rarch = "arm"
+		}
+		err = api.DownloadReleaseImages(remote, rarch)
 		if err != nil {
 			return "", err
 		}
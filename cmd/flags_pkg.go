@@ -20,14 +20,15 @@ type PkgCommandFlags struct {
 	Package        string
 	SluggedPackage string
 	LocalPackage   bool
+	Arch           string
 }
 
 // Parch returns the user's preferred architecture which can ovveride
 // the machine's architecture with the --arch flag.
 func (flags *PkgCommandFlags) Parch() string {
 	parch := "amd64"
-	if api.AltGOARCH != "" {
-		if api.AltGOARCH == "arm64" {
+	if flags.Arch != "" {
+		if flags.Arch == "arm64" {
 			parch = "arm64"
 		}
 	} else {
@@ -80,55 +81,24 @@ func (flags *PkgCommandFlags) buildAlternatePath() string {
 	return pkgPath
 }
 
-func versionOrdinal(version string) string {
-	// ISO/IEC 14651:2011
-	const maxByte = 1<<8 - 1
-	vo := make([]byte, 0, len(version)+8)
-	j := -1
-	for i := 0; i < len(version); i++ {
-		b := version[i]
-		if '0' > b || b > '9' {
-			vo = append(vo, b)
-			j = -1
-			continue
-		}
-		if j == -1 {
-			vo = append(vo, 0x00)
-			j = len(vo) - 1
-		}
-		if vo[j] == 1 && vo[j+1] == '0' {
-			vo[j+1] = b
-			continue
-		}
-		if vo[j]+1 > maxByte {
-			panic("versionOrdinal: invalid version")
-		}
-		vo = append(vo, b)
-		vo[j]++
-	}
-	return string(vo)
-}
-
 // currently searches via namespace/pkg
 // should be revisited once api gets better querying in place
 // this should also cache the result somehow which it isn't doing yet.
-func getLatest(pkg string) string {
+func getLatest(pkg string, arch string, c *types.Config) string {
 	v := ""
 
 	npkg := strings.Split(pkg, "/")
 	nn := strings.ReplaceAll(npkg[1], ":latest", "")
 
-	plist, err := api.SearchPackages(nn)
+	plist, err := api.SearchPackageSources(c, nn, "")
 	if err != nil {
 		fmt.Println(err)
 	}
 
 	filter := []lepton.Package{}
 
-	r := ""
-	if api.AltGOARCH != "" {
-		r = api.AltGOARCH
-	} else {
+	r := arch
+	if r == "" {
 		r = api.RealGOARCH
 	}
 
@@ -146,8 +116,7 @@ func getLatest(pkg string) string {
 	chosen := filter[0]
 	v = filter[0].Version
 	for i := 1; i < len(filter); i++ {
-		a, b := versionOrdinal(filter[i].Version), versionOrdinal(chosen.Version)
-		if a > b {
+		if api.CompareVersions(filter[i].Version, chosen.Version) > 0 {
 			v = filter[i].Version
 			chosen = filter[i]
 		}
@@ -159,10 +128,21 @@ func getLatest(pkg string) string {
 
 // MergeToConfig merge package configuration to ops configuration
 func (flags *PkgCommandFlags) MergeToConfig(c *types.Config) (err error) {
+	if flags.Arch == "" {
+		flags.Arch = c.Arch
+	}
+
 	if flags.Package == "" {
 		return
 	}
 
+	if !flags.LocalPackage {
+		flags.Package, err = api.PinPackage(flags.Package, flags.Arch)
+		if err != nil {
+			return err
+		}
+	}
+
 	packagePath := flags.PackagePath()
 	if _, err := os.Stat(packagePath); os.IsNotExist(err) {
 		if flags.LocalPackage {
@@ -170,7 +150,7 @@ func (flags *PkgCommandFlags) MergeToConfig(c *types.Config) (err error) {
 		}
 
 		if strings.Contains(flags.Package, ":latest") {
-			flags.Package = getLatest(flags.Package)
+			flags.Package = getLatest(flags.Package, flags.Arch, c)
 		}
 
 		downloadPackage(flags.Package, c)
@@ -184,7 +164,7 @@ func (flags *PkgCommandFlags) MergeToConfig(c *types.Config) (err error) {
 	}
 
 	pkgConfig := &types.Config{}
-	err = unWarpConfig(manifestPath, pkgConfig)
+	err = unWarpPackageManifest(manifestPath, pkgConfig)
 	if err != nil {
 		return err
 	}
@@ -253,6 +233,9 @@ func NewPkgCommandFlags(cmdFlags *pflag.FlagSet) (flags *PkgCommandFlags) {
 	// error handling is ignored because load command reads package from argument
 	flags.Package, _ = cmdFlags.GetString("package")
 
+	// not every command using packages has an --arch flag
+	flags.Arch, _ = cmdFlags.GetString("arch")
+
 	return
 }
 
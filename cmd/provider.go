@@ -1,8 +1,15 @@
 package cmd
 
 import (
+	"fmt"
+	"path"
+
 	api "github.com/nanovms/ops/lepton"
 	"github.com/nanovms/ops/provider"
+	"github.com/nanovms/ops/provider/aws"
+	"github.com/nanovms/ops/provider/gcp"
+	"github.com/nanovms/ops/provider/hetzner"
+	"github.com/nanovms/ops/provider/onprem"
 	"github.com/nanovms/ops/types"
 )
 
@@ -16,3 +23,43 @@ func getProviderAndContext(c *types.Config, providerName string) (api.Provider,
 
 	return p, ctx, nil
 }
+
+// imageLabelPlatforms are the platforms listing the labels of images,
+// the signature of their images can be verified.
+var imageLabelPlatforms = map[string]bool{
+	aws.ProviderName:     true,
+	gcp.ProviderName:     true,
+	hetzner.ProviderName: true,
+}
+
+// verifyInstanceImage checks the image instances of ctx are created from
+// is signed as the trust policy of the config requires: local images
+// with their signature file, images of providers with their labels.
+func verifyInstanceImage(p api.Provider, ctx *api.Context) error {
+	c := ctx.Config()
+	if !api.TrustPolicyEnforced(c) {
+		return nil
+	}
+
+	if _, ok := p.(*onprem.OnPrem); ok {
+		return api.VerifyLocalImage(c, path.Join(api.LocalImageDir, c.CloudConfig.ImageName))
+	}
+
+	if !imageLabelPlatforms[c.CloudConfig.Platform] {
+		return &api.ImageVerificationError{
+			Image:  c.CloudConfig.ImageName,
+			Reason: fmt.Sprintf("the trust policy is unsupported on %s, it has no image labels to verify", c.CloudConfig.Platform),
+		}
+	}
+
+	images, err := p.GetImages(ctx, "")
+	if err != nil {
+		return err
+	}
+	for _, image := range images {
+		if image.Name == c.CloudConfig.ImageName {
+			return api.VerifyCloudImage(c, &image)
+		}
+	}
+	return &api.ImageVerificationError{Image: c.CloudConfig.ImageName, Reason: "image not found"}
+}
@@ -50,6 +50,9 @@ func RunLocalInstance(c *types.Config) (err error) {
 	fmt.Println("running local instance")
 
 	c.RunConfig.Kernel = c.Kernel
+	if c.RunConfig.Arch == "" {
+		c.RunConfig.Arch = c.Arch
+	}
 
 	if c.RunConfig.QMP {
 		c.RunConfig.Mgmt = qemu.GenMgmtPort()
@@ -11,6 +11,7 @@ import (
 
 	api "github.com/nanovms/ops/lepton"
 	"github.com/nanovms/ops/provider"
+	"github.com/nanovms/ops/provider/onprem"
 	"github.com/nanovms/ops/types"
 
 	"github.com/nanovms/ops/protos/imageservice"
@@ -145,6 +146,32 @@ func (*server) GetVolumes(_ context.Context, in *volumeservice.VolumeListRequest
 	return pb, nil
 }
 
+// metricsHandler exposes the resource usage of onprem instances and
+// build/upload durations for prometheus.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	c := &types.Config{}
+	pc := &types.ProviderConfig{}
+
+	p, err := provider.CloudProvider("onprem", pc)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	op, ok := p.(*onprem.OnPrem)
+	if !ok {
+		http.Error(w, "onprem provider not found", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	err = op.WriteMetrics(api.NewContext(c), w)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
 func main() {
 	fmt.Println("Note: If on a mac this expects ops to have suid bit set for networking.")
 	fmt.Println("if you used the installer you are set otherwise run the following command\n" +
@@ -207,13 +234,18 @@ func Daemonize() {
 		os.Exit(1)
 	}
 
+	mux := http.NewServeMux()
+	mux.Handle("/", gwmux)
+	mux.HandleFunc("/metrics", metricsHandler)
+
 	gwServer := &http.Server{
 		Addr:    ":8090",
-		Handler: gwmux,
+		Handler: mux,
 	}
 
 	log.Println("Serving json on http://0.0.0.0:8090")
 	fmt.Println("try issuing a request:\tcurl -XGET -k http://localhost:8090/v1/images | jq")
+	fmt.Println("prometheus metrics are at http://localhost:8090/metrics")
 	err = gwServer.ListenAndServe()
 	if err != nil {
 		fmt.Println(err)
@@ -1,11 +1,15 @@
 package fs
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"os"
 	"path"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strings"
 )
 
@@ -421,6 +425,29 @@ func (m *Manifest) AddPassthrough(key string, value any) {
 func (m *Manifest) finalize() {
 }
 
+// BootFiles returns the host paths of the files of the boot filesystem,
+// like /kernel and /klib/<name>, by path.
+func (m *Manifest) BootFiles() map[string]string {
+	files := map[string]string{}
+	if m.boot == nil {
+		return files
+	}
+
+	var walk func(dir map[string]any, prefix string)
+	walk = func(dir map[string]any, prefix string) {
+		for name, v := range dir {
+			switch v := v.(type) {
+			case string:
+				files[path.Join(prefix, name)] = v
+			case map[string]any:
+				walk(v, path.Join(prefix, name))
+			}
+		}
+	}
+	walk(m.bootDir(), "/")
+	return files
+}
+
 func (m *Manifest) bootDir() map[string]any {
 	return getRootDir(m.boot)
 }
@@ -429,6 +456,74 @@ func (m *Manifest) rootDir() map[string]any {
 	return getRootDir(m.root)
 }
 
+// Digest returns a digest of the manifest and of the content of its
+// files, as returned by fileHash, that changes whenever the image it
+// makes would.
+func (m *Manifest) Digest(fileHash func(hostpath string) (string, error)) (string, error) {
+	h := sha256.New()
+
+	var writeDir func(dir map[string]any) error
+	writeDir = func(dir map[string]any) error {
+		names := make([]string, 0, len(dir))
+		for name := range dir {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			switch v := dir[name].(type) {
+			case link:
+				fmt.Fprintf(h, "link %q %q\n", name, v.path)
+			case string:
+				sum, err := fileHash(v)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(h, "file %q %s\n", name, sum)
+			case map[string]any:
+				fmt.Fprintf(h, "dir %q\n", name)
+				if err := writeDir(v); err != nil {
+					return err
+				}
+				fmt.Fprintf(h, "end\n")
+			}
+		}
+		return nil
+	}
+
+	for _, fsRoot := range []map[string]any{m.boot, m.root} {
+		if fsRoot == nil {
+			fmt.Fprintf(h, "nofs\n")
+			continue
+		}
+
+		keys := make([]string, 0, len(fsRoot))
+		for k := range fsRoot {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			if k == "children" {
+				fmt.Fprintf(h, "children\n")
+				if err := writeDir(getRootDir(fsRoot)); err != nil {
+					return "", err
+				}
+				continue
+			}
+
+			// maps are encoded with sorted keys
+			value, err := json.Marshal(fsRoot[k])
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(h, "%q %s\n", k, value)
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
 // LookupFile look up file path in target root directory
 func LookupFile(targetRoot string, path string) (string, error) {
 	if targetRoot != "" {
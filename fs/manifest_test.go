@@ -28,3 +28,50 @@ func TestManifestWithEnv(t *testing.T) {
 	env := m.root["environment"].(map[string]any)
 	assert.Equal(t, "value1", env["var1"])
 }
+
+func TestManifestDigest(t *testing.T) {
+	hashes := map[string]string{"/host/app": "a1", "/host/lib.so": "b1"}
+	fileHash := func(hostpath string) (string, error) {
+		return hashes[hostpath], nil
+	}
+
+	build := func() *Manifest {
+		m := NewManifest("")
+		m.AddArgument("/app")
+		m.AddEnvironmentVariable("A", "1")
+		m.AddEnvironmentVariable("B", "2")
+		mkDirPath(m.rootDir(), "lib")["lib.so"] = "/host/lib.so"
+		m.rootDir()["app"] = "/host/app"
+		m.rootDir()["sh"] = link{path: "/app"}
+		return m
+	}
+
+	d1, err := build().Digest(fileHash)
+	assert.Nil(t, err)
+	d2, err := build().Digest(fileHash)
+	assert.Nil(t, err)
+	assert.Equal(t, d1, d2)
+
+	hashes["/host/lib.so"] = "b2"
+	d3, err := build().Digest(fileHash)
+	assert.Nil(t, err)
+	assert.NotEqual(t, d1, d3)
+
+	m := build()
+	m.AddEnvironmentVariable("B", "3")
+	d4, err := m.Digest(fileHash)
+	assert.Nil(t, err)
+	assert.NotEqual(t, d3, d4)
+}
+
+func TestManifestBootFiles(t *testing.T) {
+	m := NewManifest("")
+	assert.Equal(t, map[string]string{}, m.BootFiles())
+
+	addDummyKlib(m, "tls")
+	m.bootDir()["kernel"] = "/host/kernel.img"
+	assert.Equal(t, map[string]string{
+		"/kernel":   "/host/kernel.img",
+		"/klib/tls": "dummy",
+	}, m.BootFiles())
+}
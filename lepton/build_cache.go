@@ -0,0 +1,340 @@
+package lepton
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nanovms/ops/fs"
+	"github.com/nanovms/ops/types"
+)
+
+// buildCacheMaxEntries is how many images the build cache keeps; the
+// least recently used ones are removed first.
+const buildCacheMaxEntries = 10
+
+// BuildCacheDir keeps the images built, named after the hash of what
+// they were built from, to reuse them when nothing changed.
+func BuildCacheDir() string {
+	return path.Join(GetOpsHome(), "build-cache")
+}
+
+func buildCacheStatsFile() string {
+	return path.Join(BuildCacheDir(), "stats.json")
+}
+
+func buildCacheFilesFile() string {
+	return path.Join(BuildCacheDir(), "files.json")
+}
+
+// BuildCacheStats tells how useful the build cache is.
+type BuildCacheStats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+}
+
+// ReadBuildCacheStats returns the hits and misses of the build cache.
+func ReadBuildCacheStats() (BuildCacheStats, error) {
+	var stats BuildCacheStats
+
+	body, err := os.ReadFile(buildCacheStatsFile())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return stats, nil
+		}
+		return stats, err
+	}
+
+	err = json.Unmarshal(body, &stats)
+	return stats, err
+}
+
+func recordBuildCacheLookup(hit bool) error {
+	return withFileLock(buildCacheStatsFile(), func() error {
+		stats, err := ReadBuildCacheStats()
+		if err != nil {
+			return err
+		}
+
+		if hit {
+			stats.Hits++
+		} else {
+			stats.Misses++
+		}
+
+		body, err := json.Marshal(stats)
+		if err != nil {
+			return err
+		}
+		return writeFileAtomic(buildCacheStatsFile(), body)
+	})
+}
+
+func writeFileAtomic(p string, body []byte) error {
+	err := os.MkdirAll(filepath.Dir(p), 0755)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(p), filepath.Base(p))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err = f.Write(body); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), p)
+}
+
+// cachedFileHash is the sha256 of a file as of its size and mtime.
+type cachedFileHash struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	SHA256  string    `json:"sha256"`
+}
+
+// fileHasher hashes the files of images, reusing the hashes of files
+// that didn't change since the last build.
+type fileHasher struct {
+	hashes  map[string]cachedFileHash
+	changed bool
+}
+
+func newFileHasher() *fileHasher {
+	h := &fileHasher{hashes: map[string]cachedFileHash{}}
+
+	body, err := os.ReadFile(buildCacheFilesFile())
+	if err == nil {
+		// a broken index only makes files to be hashed again
+		json.Unmarshal(body, &h.hashes)
+	}
+	return h
+}
+
+func (h *fileHasher) hash(hostpath string) (string, error) {
+	info, err := os.Stat(hostpath)
+	if err != nil {
+		return "", err
+	}
+
+	if cached, ok := h.hashes[hostpath]; ok && cached.Size == info.Size() && cached.ModTime.Equal(info.ModTime()) {
+		return cached.SHA256, nil
+	}
+
+	sum, err := FileSHA256(hostpath)
+	if err != nil {
+		return "", err
+	}
+
+	h.hashes[hostpath] = cachedFileHash{Size: info.Size(), ModTime: info.ModTime(), SHA256: sum}
+	h.changed = true
+	return sum, nil
+}
+
+func (h *fileHasher) save() error {
+	if !h.changed {
+		return nil
+	}
+
+	// forget files that are gone
+	for p := range h.hashes {
+		if _, err := os.Stat(p); err != nil {
+			delete(h.hashes, p)
+		}
+	}
+
+	body, err := json.Marshal(h.hashes)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(buildCacheFilesFile(), body)
+}
+
+// buildCacheKey hashes the manifest, the content of the files, kernel
+// and klibs included, and the parts of the config that change how mkfs
+// writes the image. The uefi loader of c must be resolved.
+func buildCacheKey(c *types.Config, m *fs.Manifest) (string, error) {
+	hasher := newFileHasher()
+
+	digest, err := m.Digest(hasher.hash)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "ops %s\nmanifest %s\nsize %q\ntfsv4 %v\nuefi %v\n", Version, digest, c.BaseVolumeSz, c.TFSv4, c.Uefi)
+
+	if c.Boot != "" {
+		sum, err := hasher.hash(c.Boot)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "boot %s\n", sum)
+	}
+	if c.Uefi && c.UefiBoot != "" {
+		sum, err := hasher.hash(c.UefiBoot)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "uefi boot %s\n", sum)
+	}
+
+	// cached images keep the provenance of the build that made them,
+	// only their build time may differ
+	config, err := configDigest(c)
+	if err != nil {
+		return "", err
+	}
+	commit, dirty := gitCommit(c.LocalFilesParentDirectory)
+	fmt.Fprintf(h, "config %s\ngit %s %v\n", config, commit, dirty)
+
+	if err := hasher.save(); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func buildCacheImage(key string) string {
+	return path.Join(BuildCacheDir(), key+".img")
+}
+
+// reuseCachedImage copies the image built with key, if any, to image.
+func reuseCachedImage(key string, image string) (bool, error) {
+	cached := buildCacheImage(key)
+	if _, err := os.Stat(cached); err != nil {
+		return false, nil
+	}
+
+	err := copyImageFile(cached, image)
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+	os.Chtimes(cached, now, now)
+	return true, nil
+}
+
+// storeCachedImage keeps a copy of image built with key and removes the
+// least recently used images over buildCacheMaxEntries.
+func storeCachedImage(key string, image string) error {
+	err := os.MkdirAll(BuildCacheDir(), 0755)
+	if err != nil {
+		return err
+	}
+
+	tmp := buildCacheImage(key) + ".tmp"
+	err = copyImageFile(image, tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	err = os.Rename(tmp, buildCacheImage(key))
+	if err != nil {
+		return err
+	}
+
+	images, err := buildCacheImages()
+	if err != nil {
+		return err
+	}
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].ModTime().After(images[j].ModTime())
+	})
+	for i := buildCacheMaxEntries; i < len(images); i++ {
+		os.Remove(path.Join(BuildCacheDir(), images[i].Name()))
+	}
+
+	return nil
+}
+
+func buildCacheImages() ([]os.FileInfo, error) {
+	entries, err := os.ReadDir(BuildCacheDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var images []os.FileInfo
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".img") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		images = append(images, info)
+	}
+	return images, nil
+}
+
+// copyImageFile copies the image src to dst leaving zeroed blocks as
+// holes, images sized with BaseVolumeSz being mostly empty.
+func copyImageFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	err = os.MkdirAll(filepath.Dir(dst), 0755)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	var size int64
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := in.Read(buf)
+		if n > 0 {
+			var werr error
+			if bytes.Count(buf[:n], []byte{0}) == n {
+				_, werr = out.Seek(int64(n), io.SeekCurrent)
+			} else {
+				_, werr = out.Write(buf[:n])
+			}
+			if werr != nil {
+				return werr
+			}
+			size += int64(n)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	err = out.Truncate(size)
+	if err != nil {
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,176 @@
+package lepton
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/nanovms/ops/fs"
+	"github.com/nanovms/ops/testutils"
+	"github.com/nanovms/ops/types"
+)
+
+func TestBuildCache(t *testing.T) {
+	t.Setenv("OPS_HOME", t.TempDir())
+
+	dir := t.TempDir()
+	testutils.WriteFiles(t, dir, map[string]string{"app": "app v1"})
+
+	c := &types.Config{}
+	c.BaseVolumeSz = "4m"
+	build := func(image string) {
+		m := fs.NewManifest("")
+		m.AddArgument("/app")
+		if err := m.AddFile("/app", filepath.Join(dir, "app")); err != nil {
+			t.Fatal(err)
+		}
+		c.RunConfig.ImageName = filepath.Join(dir, image)
+		if err := createImageFile(c, m, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	build("first.img")
+	build("second.img")
+
+	first, second := testutils.ReadFile(t, filepath.Join(dir, "first.img")), testutils.ReadFile(t, filepath.Join(dir, "second.img"))
+	if first != second || len(second) != 4*1024*1024 {
+		t.Fatal("expected the second image to be the cached first one")
+	}
+
+	stats, err := ReadBuildCacheStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Hits != 1 || stats.Misses != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	// same size, new content
+	testutils.WriteFiles(t, dir, map[string]string{"app": "app v2"})
+	build("third.img")
+	if third := testutils.ReadFile(t, filepath.Join(dir, "third.img")); !bytes.Contains([]byte(third), []byte("app v2")) {
+		t.Fatal("expected the image to be rebuilt when a file changed")
+	}
+
+	c.NoBuildCache = true
+	build("fourth.img")
+	if stats, _ := ReadBuildCacheStats(); stats.Hits != 1 || stats.Misses != 2 {
+		t.Fatalf("expected --no-cache to skip the cache, got %+v", stats)
+	}
+
+	entries, err := CacheEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	builds := 0
+	for _, e := range entries {
+		if e.Kind == CacheKindBuild {
+			builds++
+		}
+	}
+	if builds != 2 {
+		t.Fatalf("expected 2 cached builds, got %d", builds)
+	}
+
+	if _, err := os.Stat(buildCacheFilesFile()); err != nil {
+		t.Fatal("expected file hashes to be kept")
+	}
+}
+
+func TestBuildCacheKeyUefi(t *testing.T) {
+	t.Setenv("OPS_HOME", t.TempDir())
+
+	dir := t.TempDir()
+	testutils.WriteFiles(t, dir, map[string]string{
+		"app":                     "app",
+		"0.1.50/bootx64.efi":      "x86 loader",
+		"0.1.50-arm/bootaa64.efi": "arm loader",
+	})
+
+	m := fs.NewManifest("")
+	if err := m.AddFile("/app", filepath.Join(dir, "app")); err != nil {
+		t.Fatal(err)
+	}
+	key := func(c *types.Config) string {
+		if err := resolveUefiBoot(c); err != nil {
+			t.Fatal(err)
+		}
+		k, err := buildCacheKey(c, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+
+	uefiBoot := filepath.Join(dir, "0.1.50/bootx64.efi")
+	plain := key(&types.Config{UefiBoot: uefiBoot})
+	uefi := key(&types.Config{Uefi: true, UefiBoot: uefiBoot})
+	arm := key(&types.Config{Uefi: true, UefiBoot: uefiBoot, Kernel: filepath.Join(dir, "0.1.50-arm/kernel.img")})
+	if plain == uefi || uefi == arm || plain == arm {
+		t.Fatal("expected uefi images of each arch to have their own key")
+	}
+
+	if err := resolveUefiBoot(&types.Config{Uefi: true}); err == nil {
+		t.Fatal("expected an error without an uefi loader")
+	}
+}
+
+func TestBuildCacheKeyProvenance(t *testing.T) {
+	t.Setenv("OPS_HOME", t.TempDir())
+
+	dir := t.TempDir()
+	testutils.WriteFiles(t, dir, map[string]string{"app": "app"})
+
+	m := fs.NewManifest("")
+	if err := m.AddFile("/app", filepath.Join(dir, "app")); err != nil {
+		t.Fatal(err)
+	}
+	key := func(c *types.Config) string {
+		k, err := buildCacheKey(c, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+
+	c := &types.Config{LocalFilesParentDirectory: dir}
+	c.RunConfig.ImageName = filepath.Join(dir, "first.img")
+	first := key(c)
+
+	c.RunConfig.ImageName = filepath.Join(dir, "second.img")
+	if key(c) != first {
+		t.Fatal("expected the image path not to change the key")
+	}
+
+	c.Env = map[string]string{"MODE": "prod"}
+	configured := key(c)
+	if configured == first {
+		t.Fatal("expected the config to change the key")
+	}
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("no git:", err)
+	}
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	git("init", "-q")
+	git("add", "app")
+	git("commit", "-q", "-m", "app")
+	committed := key(c)
+	if committed == configured {
+		t.Fatal("expected the git commit to change the key")
+	}
+
+	testutils.WriteFiles(t, dir, map[string]string{"notes": "wip"})
+	if key(c) == committed {
+		t.Fatal("expected uncommitted changes to change the key")
+	}
+}
@@ -0,0 +1,360 @@
+package lepton
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nanovms/ops/types"
+)
+
+// Kinds of cache entries.
+const (
+	CacheKindPackage = "package" // extracted package
+	CacheKindArchive = "archive" // downloaded package archive
+	CacheKindKernel  = "kernel"  // nanos release
+	CacheKindNightly = "nightly" // nanos nightly build
+	CacheKindBuild   = "build"   // image of the build cache
+)
+
+// CacheEntry is something ops keeps on disk that can be pruned.
+type CacheEntry struct {
+	Kind     string    `json:"kind"`
+	Name     string    `json:"name"`
+	Version  string    `json:"version,omitempty"`
+	Arch     string    `json:"arch,omitempty"`
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	LastUsed time.Time `json:"last_used"`
+
+	// InUse tells why the entry must not be pruned, if it mustn't.
+	InUse string `json:"in_use,omitempty"`
+}
+
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// touchCacheEntry records the entry of the cache at p was just used:
+// entries are listed with their modification time as their last use.
+// Paths outside of the ops home and the packages are left alone.
+func touchCacheEntry(p string) {
+	if p == "" || (!isSubPath(p, GetOpsHome()) && !isSubPath(p, PackagesRoot)) {
+		return
+	}
+
+	now := time.Now()
+	os.Chtimes(p, now, now)
+}
+
+// isSubPath tells if p is in dir.
+func isSubPath(p, dir string) bool {
+	rel, err := filepath.Rel(dir, p)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func newCacheEntry(kind, name, version, arch, p string) (CacheEntry, error) {
+	info, err := os.Stat(p)
+	if err != nil {
+		return CacheEntry{}, err
+	}
+
+	size, err := dirSize(p)
+	if err != nil {
+		return CacheEntry{}, err
+	}
+
+	return CacheEntry{
+		Kind:     kind,
+		Name:     name,
+		Version:  version,
+		Arch:     arch,
+		Path:     p,
+		Size:     size,
+		LastUsed: info.ModTime(),
+	}, nil
+}
+
+// splitPackageDir splits <name>_<version>.
+func splitPackageDir(dir string) (string, string) {
+	i := strings.LastIndex(dir, "_")
+	if i < 0 {
+		return dir, ""
+	}
+	return dir[:i], dir[i+1:]
+}
+
+var releaseDirRegex = regexp.MustCompile(`^\d+\.\d+\.\d+(-arm)?$`)
+
+// CacheEntries lists the packages, package archives, nanos releases and
+// nightly builds kept in the ops home.
+func CacheEntries() ([]CacheEntry, error) {
+	entries := []CacheEntry{}
+
+	// extracted packages: packages/<arch>/<ns>/<name>_<version>
+	for _, arch := range []string{"amd64", "arm64"} {
+		dirs, _ := filepath.Glob(filepath.Join(PackagesRoot, arch, "*", "*"))
+		for _, d := range dirs {
+			name, version := splitPackageDir(filepath.Base(d))
+			e, err := newCacheEntry(CacheKindPackage, filepath.Base(filepath.Dir(d))+"/"+name, version, arch, d)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, e)
+		}
+	}
+
+	// archives: packages/<ns>/<name>_<version>/<arch>.tar.gz
+	dirs, _ := filepath.Glob(filepath.Join(PackagesRoot, "*", "*"))
+	for _, d := range dirs {
+		ns := filepath.Base(filepath.Dir(d))
+		if ns == "amd64" || ns == "arm64" {
+			continue
+		}
+		if info, err := os.Stat(d); err != nil || !info.IsDir() {
+			continue
+		}
+
+		name, version := splitPackageDir(filepath.Base(d))
+		e, err := newCacheEntry(CacheKindArchive, ns+"/"+name, version, "", d)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	home := GetOpsHome()
+	homeEntries, err := os.ReadDir(home)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, he := range homeEntries {
+		if !he.IsDir() || !releaseDirRegex.MatchString(he.Name()) {
+			continue
+		}
+
+		arch := "amd64"
+		version := he.Name()
+		if strings.HasSuffix(version, "-arm") {
+			arch = "arm64"
+			version = strings.TrimSuffix(version, "-arm")
+		}
+
+		e, err := newCacheEntry(CacheKindKernel, "nanos", version, arch, path.Join(home, he.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if version == LocalReleaseVersion {
+			e.InUse = "current release"
+		}
+		entries = append(entries, e)
+	}
+
+	for _, arch := range []string{"amd64", "arm64"} {
+		d := NightlyLocalFolder(arch)
+		if _, err := os.Stat(d); err != nil {
+			continue
+		}
+
+		e, err := newCacheEntry(CacheKindNightly, "nanos", "nightly", arch, d)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	images, err := buildCacheImages()
+	if err != nil {
+		return nil, err
+	}
+	for _, img := range images {
+		key := strings.TrimSuffix(img.Name(), ".img")
+		e, err := newCacheEntry(CacheKindBuild, "image", key[:min(12, len(key))], "", path.Join(BuildCacheDir(), img.Name()))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	err = markImagePackages(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// MarkLockedPackages marks the packages of the lockfiles, and their
+// archives, as in use.
+func MarkLockedPackages(entries []CacheEntry, lockFiles []string) error {
+	for _, f := range lockFiles {
+		lock, err := ReadPackageLock(f)
+		if err != nil {
+			return err
+		}
+		if lock == nil {
+			continue
+		}
+
+		for _, lp := range lock.Packages {
+			for i := range entries {
+				e := &entries[i]
+				if (e.Kind != CacheKindPackage && e.Kind != CacheKindArchive) || e.InUse != "" {
+					continue
+				}
+				// archives of all arches are in the same directory
+				if e.Name == lp.Namespace+"/"+lp.Name && e.Version == lp.Version && (e.Kind == CacheKindArchive || e.Arch == lp.Arch) {
+					e.InUse = "locked in " + f
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// MarkConfigKernel marks the nanos release the config file pins, with
+// NanosVersion or the path of its kernel, as in use.
+func MarkConfigKernel(entries []CacheEntry, c *types.Config, file string) {
+	arch := TargetArch(c)
+	for i := range entries {
+		e := &entries[i]
+		if e.Kind != CacheKindKernel || e.InUse != "" {
+			continue
+		}
+
+		pinned := c.NanosVersion != "" && strings.TrimPrefix(c.NanosVersion, "v") == e.Version && e.Arch == arch
+		if pinned || (c.Kernel != "" && filepath.Clean(filepath.Dir(c.Kernel)) == filepath.Clean(e.Path)) {
+			e.InUse = "pinned by " + file
+		}
+	}
+}
+
+// imagePackagesFile records the packages local images were built from.
+func imagePackagesFile() string {
+	return path.Join(GetOpsHome(), "image-packages.json")
+}
+
+func readImagePackages() (map[string][]string, error) {
+	images := map[string][]string{}
+
+	body, err := os.ReadFile(imagePackagesFile())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return images, nil
+		}
+		return nil, err
+	}
+
+	err = json.Unmarshal(body, &images)
+	return images, err
+}
+
+// recordImagePackages remembers that image was built from the packages
+// at dirs so they aren't pruned while the image exists.
+func recordImagePackages(image string, dirs []string) error {
+	return withFileLock(imagePackagesFile(), func() error {
+		images, err := readImagePackages()
+		if err != nil {
+			return err
+		}
+
+		// forget images that were deleted
+		for img := range images {
+			if _, err := os.Stat(img); os.IsNotExist(err) {
+				delete(images, img)
+			}
+		}
+
+		clean := []string{}
+		for _, d := range dirs {
+			clean = append(clean, filepath.Clean(d))
+		}
+		images[image] = clean
+
+		body, err := json.MarshalIndent(images, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		return writeFileAtomic(imagePackagesFile(), body)
+	})
+}
+
+func markImagePackages(entries []CacheEntry) error {
+	images, err := readImagePackages()
+	if err != nil {
+		return err
+	}
+
+	for img, dirs := range images {
+		if _, err := os.Stat(img); err != nil {
+			continue
+		}
+
+		for _, d := range dirs {
+			for i := range entries {
+				if filepath.Clean(entries[i].Path) == d && entries[i].InUse == "" {
+					entries[i].InUse = "used by image " + filepath.Base(img)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// SelectPrunable returns the entries not in use that were last used
+// before olderThan ago (if not zero) and, if maxSize isn't zero, the
+// least recently used ones to remove for the entries to fit in maxSize.
+func SelectPrunable(entries []CacheEntry, olderThan time.Duration, maxSize int64, now time.Time) []CacheEntry {
+	sorted := make([]CacheEntry, len(entries))
+	copy(sorted, entries)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].LastUsed.Before(sorted[j].LastUsed)
+	})
+
+	var total int64
+	for _, e := range sorted {
+		total += e.Size
+	}
+
+	prune := []CacheEntry{}
+	for _, e := range sorted {
+		if e.InUse != "" {
+			continue
+		}
+
+		old := olderThan > 0 && now.Sub(e.LastUsed) > olderThan
+		over := maxSize > 0 && total > maxSize
+		if !old && !over {
+			continue
+		}
+
+		prune = append(prune, e)
+		total -= e.Size
+	}
+
+	return prune
+}
+
+// RemoveCacheEntry deletes an entry from disk.
+func RemoveCacheEntry(e CacheEntry) error {
+	return os.RemoveAll(e.Path)
+}
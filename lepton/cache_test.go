@@ -0,0 +1,172 @@
+package lepton
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nanovms/ops/testutils"
+	"github.com/nanovms/ops/types"
+)
+
+func TestSelectPrunable(t *testing.T) {
+	now := time.Now()
+	day := 24 * time.Hour
+
+	entries := []CacheEntry{
+		{Name: "old", Size: 100, LastUsed: now.Add(-40 * day)},
+		{Name: "locked", Size: 100, LastUsed: now.Add(-50 * day), InUse: "locked in ops.lock"},
+		{Name: "recent", Size: 300, LastUsed: now.Add(-2 * day)},
+		{Name: "new", Size: 500, LastUsed: now},
+	}
+
+	names := func(entries []CacheEntry) []string {
+		n := []string{}
+		for _, e := range entries {
+			n = append(n, e.Name)
+		}
+		return n
+	}
+
+	tests := []struct {
+		olderThan time.Duration
+		maxSize   int64
+		want      []string
+	}{
+		{30 * day, 0, []string{"old"}},
+		{0, 700, []string{"old", "recent"}},
+		{0, 1000, []string{}},
+		{30 * day, 1000, []string{"old"}},
+	}
+
+	for _, tt := range tests {
+		got := names(SelectPrunable(entries, tt.olderThan, tt.maxSize, now))
+		if len(got) != len(tt.want) {
+			t.Fatalf("olderThan %v maxSize %d: got %v, want %v", tt.olderThan, tt.maxSize, got, tt.want)
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Fatalf("olderThan %v maxSize %d: got %v, want %v", tt.olderThan, tt.maxSize, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestCacheEntriesKeepsUsedPackages(t *testing.T) {
+	t.Setenv("OPS_HOME", t.TempDir())
+	withTestPackagesRoot(t)
+
+	used := PackageDirectory("eyberg/node:20.5.0", "amd64")
+	locked := PackageDirectory("eyberg/redis:7.0.0", "amd64")
+	unused := PackageDirectory("eyberg/redis:6.0.0", "amd64")
+	for _, d := range []string{used, locked, unused} {
+		writeTestPackage(t, d, nil, map[string]string{"/bin/x": "x"})
+	}
+
+	imageDir := t.TempDir()
+	testutils.WriteFiles(t, imageDir, map[string]string{"node": "image"})
+	image := filepath.Join(imageDir, "node")
+	if err := recordImagePackages(image, []string{used}); err != nil {
+		t.Fatal(err)
+	}
+
+	lockFile := filepath.Join(t.TempDir(), "ops.lock")
+	lock := &PackageLock{Packages: []LockedPackage{{Namespace: "eyberg", Name: "redis", Version: "7.0.0", Arch: "amd64"}}}
+	if err := lock.Write(lockFile); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := CacheEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := MarkLockedPackages(entries, []string{lockFile}); err != nil {
+		t.Fatal(err)
+	}
+
+	prune := SelectPrunable(entries, time.Nanosecond, 0, time.Now().Add(time.Hour))
+	if len(prune) != 1 || prune[0].Path != unused {
+		t.Fatalf("expected only %s to be pruned, got %+v", unused, prune)
+	}
+}
+
+func TestRecordImagePackagesConcurrently(t *testing.T) {
+	t.Setenv("OPS_HOME", t.TempDir())
+
+	// like the images of a compose built at once
+	imageDir := t.TempDir()
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		image := filepath.Join(imageDir, fmt.Sprintf("image-%d", i))
+		testutils.WriteFiles(t, imageDir, map[string]string{filepath.Base(image): "image"})
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := recordImagePackages(image, []string{image + "-package"}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	images, err := readImagePackages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 20 {
+		t.Fatalf("expected the packages of 20 images, got %d", len(images))
+	}
+}
+
+func TestCacheEntriesLastUseAndPins(t *testing.T) {
+	t.Setenv("OPS_HOME", t.TempDir())
+	withTestPackagesRoot(t)
+
+	pkg := PackageDirectory("eyberg/redis:7.0.0", "amd64")
+	writeTestPackage(t, pkg, nil, map[string]string{"/bin/x": "x"})
+	archives := filepath.Join(PackagesRoot, "eyberg", "redis_7.0.0")
+	testutils.WriteFiles(t, archives, map[string]string{"amd64.tar.gz": "archive"})
+	kernels := []string{filepath.Join(GetOpsHome(), "9.9.1"), filepath.Join(GetOpsHome(), "9.9.2")}
+	for _, k := range kernels {
+		testutils.WriteFiles(t, k, map[string]string{"kernel.img": "kernel"})
+	}
+
+	old := time.Now().Add(-48 * time.Hour)
+	for _, p := range append([]string{pkg, archives}, kernels...) {
+		if err := os.Chtimes(p, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	touchCacheEntry(pkg)
+
+	lockFile := filepath.Join(t.TempDir(), "ops.lock")
+	lock := &PackageLock{Packages: []LockedPackage{{Namespace: "eyberg", Name: "redis", Version: "7.0.0", Arch: "amd64"}}}
+	if err := lock.Write(lockFile); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := CacheEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := MarkLockedPackages(entries, []string{lockFile}); err != nil {
+		t.Fatal(err)
+	}
+	MarkConfigKernel(entries, &types.Config{Arch: "amd64", NanosVersion: "9.9.1"}, "config.json")
+
+	for _, e := range entries {
+		if e.Path == pkg && time.Since(e.LastUsed) > time.Hour {
+			t.Fatalf("expected the package to be used recently, got %v", e.LastUsed)
+		}
+	}
+
+	prune := SelectPrunable(entries, time.Hour, 0, time.Now())
+	if len(prune) != 1 || prune[0].Path != kernels[1] {
+		t.Fatalf("expected only %s to be pruned, got %+v", kernels[1], prune)
+	}
+}
@@ -34,8 +34,16 @@ var PackageManifestURL string = PkghubBaseURL + "/v2/manifest.json"
 // PackageManifestFileName is manifest file path
 const PackageManifestFileName string = "manifest.json"
 
-// PkghubBaseURL is the base url of packagehub
-var PkghubBaseURL string = "https://repo.ops.city"
+// PkghubBaseURL is the base url of packagehub. It can be pointed at a
+// private registry (see 'ops pkg serve') with OPS_PKGHUB_BASE_URL.
+var PkghubBaseURL string = pkghubBaseURL()
+
+func pkghubBaseURL() string {
+	if u := os.Getenv("OPS_PKGHUB_BASE_URL"); u != "" {
+		return strings.TrimRight(u, "/")
+	}
+	return "https://repo.ops.city"
+}
 
 var (
 	// LocalVolumeDir is the default local volume directory
@@ -147,8 +155,24 @@ var realGOOS = getGOOS()
 // RealGOARCH is the underlying host architecture.
 var RealGOARCH = getGOARCH()
 
-// AltGOARCH is an optional user-supplied cross-build arch for both build and run.
-var AltGOARCH = ""
+// TargetArch returns the architecture c builds and runs for. It is the
+// host architecture unless c asks for another one (eg: via --arch).
+func TargetArch(c *types.Config) string {
+	if c != nil && c.Arch != "" {
+		return c.Arch
+	}
+
+	return RealGOARCH
+}
+
+// archOrHost returns arch or the host architecture if arch is empty.
+func archOrHost(arch string) string {
+	if arch != "" {
+		return arch
+	}
+
+	return RealGOARCH
+}
 
 func getGOARCH() string {
 	return runtime.GOARCH
@@ -164,9 +188,9 @@ func getGOOS() string {
 }
 
 // LocalTimeStamp gives local timestamp from download nightly build
-func LocalTimeStamp() (string, error) {
-	timestamp := nightlyTimestamp()
-	data, err := os.ReadFile(path.Join(NightlyLocalFolderm, timestamp))
+func LocalTimeStamp(arch string) (string, error) {
+	timestamp := nightlyTimestamp(arch)
+	data, err := os.ReadFile(path.Join(NightlyLocalFolder(arch), timestamp))
 
 	// first time download?
 	if os.IsNotExist(err) {
@@ -180,8 +204,8 @@ func LocalTimeStamp() (string, error) {
 }
 
 // RemoteTimeStamp gives latest nightly build timestamp
-func RemoteTimeStamp() (string, error) {
-	timestamp := nightlyTimestamp()
+func RemoteTimeStamp(arch string) (string, error) {
+	timestamp := nightlyTimestamp(arch)
 	resp, err := http.Get(nightlyReleaseBaseURL + timestamp)
 	if err != nil {
 		return "", err
@@ -194,9 +218,9 @@ func RemoteTimeStamp() (string, error) {
 	return string(data), nil
 }
 
-func updateLocalTimestamp(timestamp string) error {
-	fname := nightlyTimestamp()
-	return os.WriteFile(path.Join(NightlyLocalFolderm, fname), []byte(timestamp), 0755)
+func updateLocalTimestamp(arch string, timestamp string) error {
+	fname := nightlyTimestamp(arch)
+	return os.WriteFile(path.Join(NightlyLocalFolder(arch), fname), []byte(timestamp), 0755)
 }
 
 // UpdateLocalRelease updates nanos version used on ops operations
@@ -258,7 +282,10 @@ func getLastReleaseLocalFolder() string {
 
 func getKlibsDir(nightly bool, nanosVersion string, arm bool) string {
 	if nightly {
-		return NightlyLocalFolder() + "/klibs"
+		if arm {
+			return NightlyLocalFolder("arm64") + "/klibs"
+		}
+		return NightlyLocalFolder("amd64") + "/klibs"
 	} else if nanosVersion != "" && nanosVersion != "0.0" {
 		if arm {
 			return getReleaseLocalFolder(nanosVersion) + "-arm/klibs"
@@ -0,0 +1,49 @@
+package lepton
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// fileLockTimeout is how long withFileLock waits for another process
+// to release a lock, locks older than that are considered abandoned.
+var fileLockTimeout = 10 * time.Second
+
+// withFileLock runs f holding the lock of the file p, shared by all ops
+// processes, so read-modify-write updates of p aren't lost.
+func withFileLock(p string, f func() error) error {
+	lock := p + ".lock"
+	err := os.MkdirAll(filepath.Dir(lock), 0755)
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(fileLockTimeout)
+	for {
+		fd, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			fd.Close()
+			break
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return err
+		}
+
+		// the process holding it died
+		if info, err := os.Stat(lock); err == nil && time.Since(info.ModTime()) > fileLockTimeout {
+			os.Remove(lock)
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %s", lock)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	defer os.Remove(lock)
+
+	return f()
+}
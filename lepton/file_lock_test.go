@@ -0,0 +1,53 @@
+package lepton
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nanovms/ops/testutils"
+)
+
+func TestWithFileLock(t *testing.T) {
+	counter := filepath.Join(t.TempDir(), "counter")
+
+	// goroutines stand for processes, only the lock file orders them
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := withFileLock(counter, func() error {
+				body, _ := os.ReadFile(counter)
+				n, _ := strconv.Atoi(string(body))
+				return writeFileAtomic(counter, []byte(strconv.Itoa(n+1)))
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if body := testutils.ReadFile(t, counter); body != "20" {
+		t.Fatalf("expected 20 updates, got %s", body)
+	}
+
+	// abandoned locks are taken over
+	if err := os.WriteFile(counter+".lock", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(counter+".lock", old, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := withFileLock(counter, func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(counter + ".lock"); !os.IsNotExist(err) {
+		t.Fatal("expected the lock to be released")
+	}
+}
@@ -0,0 +1,98 @@
+package lepton
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+
+	"github.com/nanovms/ops/log"
+	"github.com/nanovms/ops/types"
+)
+
+// hooks
+const (
+	HookPreBuild   = "PreBuild"
+	HookPostBuild  = "PostBuild"
+	HookPreDeploy  = "PreDeploy"
+	HookPostDeploy = "PostDeploy"
+)
+
+func hookCommand(c *types.Config, hook string) string {
+	switch hook {
+	case HookPreBuild:
+		return c.Hooks.PreBuild
+	case HookPostBuild:
+		return c.Hooks.PostBuild
+	case HookPreDeploy:
+		return c.Hooks.PreDeploy
+	case HookPostDeploy:
+		return c.Hooks.PostDeploy
+	}
+	return ""
+}
+
+// hookEnv returns the environment variables hooks get besides the ones
+// of ops.
+func hookEnv(c *types.Config, hook string) ([]string, error) {
+	imagePath := c.RunConfig.ImageName
+	if imagePath != "" {
+		if abs, err := filepath.Abs(imagePath); err == nil {
+			imagePath = abs
+		}
+	}
+
+	// the user data has the secrets once they are injected
+	hc := *c
+	if len(c.Secrets.Values) > 0 {
+		hc.CloudConfig.UserData = ""
+	}
+	config, err := json.Marshal(hc)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{
+		"OPS_HOOK=" + hook,
+		"OPS_IMAGE_PATH=" + imagePath,
+		"OPS_IMAGE_NAME=" + c.CloudConfig.ImageName,
+		"OPS_INSTANCE_NAME=" + c.RunConfig.InstanceName,
+		"OPS_PROGRAM=" + c.Program,
+		"OPS_PLATFORM=" + c.CloudConfig.Platform,
+		"OPS_CONFIG=" + string(config),
+	}, nil
+}
+
+// RunHook runs the command of hook of c, if any, with the shell of the
+// host from the directory of the config file. It fails if the command
+// does.
+func RunHook(c *types.Config, hook string) error {
+	command := hookCommand(c, hook)
+	if command == "" {
+		return nil
+	}
+
+	env, err := hookEnv(c, hook)
+	if err != nil {
+		return err
+	}
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("/bin/sh", "-c", command)
+	}
+	cmd.Dir = c.LocalFilesParentDirectory
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	log.Infof("running %s hook: %s", hook, command)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s hook failed: %v", hook, err)
+	}
+	return nil
+}
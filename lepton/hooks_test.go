@@ -0,0 +1,47 @@
+package lepton
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/nanovms/ops/testutils"
+	"github.com/nanovms/ops/types"
+)
+
+func TestRunHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hooks run with sh")
+	}
+
+	dir := t.TempDir()
+	c := &types.Config{Program: "app", LocalFilesParentDirectory: dir}
+	c.RunConfig.ImageName = filepath.Join(dir, "app.img")
+	c.Hooks.PreBuild = `printf '%s\n%s\n%s' "$OPS_HOOK" "$OPS_IMAGE_PATH" "$OPS_CONFIG" > hook.out`
+	c.Hooks.PostBuild = "exit 3"
+
+	if err := RunHook(c, HookPreBuild); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.SplitN(testutils.ReadFile(t, filepath.Join(dir, "hook.out")), "\n", 3)
+	if lines[0] != HookPreBuild || lines[1] != c.RunConfig.ImageName {
+		t.Fatalf("unexpected hook environment %q", lines)
+	}
+	config := &types.Config{}
+	if err := json.Unmarshal([]byte(lines[2]), config); err != nil || config.Program != "app" {
+		t.Fatalf("unexpected OPS_CONFIG %q: %v", lines[2], err)
+	}
+
+	err := RunHook(c, HookPostBuild)
+	if err == nil || err.Error() != "PostBuild hook failed: exit status 3" {
+		t.Fatalf("expected the failing hook to fail, got %v", err)
+	}
+
+	// no command, nothing to run
+	if err := RunHook(c, HookPreDeploy); err != nil {
+		t.Fatal(err)
+	}
+}
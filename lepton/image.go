@@ -25,31 +25,53 @@ var LocalImageDir = path.Join(GetOpsHome(), "images")
 // BuildImage builds a unikernel image for user
 // supplied ELF binary.
 func BuildImage(c types.Config) error {
+	start := time.Now()
+
+	if err := RunHook(&c, HookPreBuild); err != nil {
+		return err
+	}
 
 	m, err := BuildManifest(&c)
 	if err != nil {
 		return fmt.Errorf("failed building manifest: %v", err)
 	}
 
-	if err = createImageFile(&c, m); err != nil {
+	if err = createImageFile(&c, m, nil); err != nil {
 		return fmt.Errorf("failed creating image file: %v", err)
 	}
 
-	return nil
+	RecordDuration(OpBuild, c.CloudConfig.Platform, time.Since(start))
+
+	return RunHook(&c, HookPostBuild)
 }
 
 // BuildImageFromPackage builds nanos image using a package
 func BuildImageFromPackage(packagepath string, c types.Config) error {
-	m, err := BuildPackageManifest(packagepath, &c)
+	start := time.Now()
+
+	if err := RunHook(&c, HookPreBuild); err != nil {
+		return err
+	}
+
+	m, depDirs, err := buildPackageManifest(packagepath, &c)
 	if err != nil {
 		return err
 	}
+	dirs := append([]string{packagepath}, depDirs...)
 
-	if err := createImageFile(&c, m); err != nil {
+	if err := createImageFile(&c, m, dirs); err != nil {
 		return err
 	}
 
-	return nil
+	RecordDuration(OpBuild, c.CloudConfig.Platform, time.Since(start))
+
+	// keeps 'ops cache prune' from removing packages of local images;
+	// not being able to record that shouldn't fail the build
+	if err := recordImagePackages(c.RunConfig.ImageName, dirs); err != nil {
+		log.Warnf("failed recording packages of image: %v", err)
+	}
+
+	return RunHook(&c, HookPostBuild)
 }
 
 func createFile(filepath string) (*os.File, error) {
@@ -187,6 +209,14 @@ func addFilesFromPackage(packagepath string, m *fs.Manifest, ppath string) {
 
 // BuildPackageManifest builds manifest using package
 func BuildPackageManifest(packagepath string, c *types.Config) (*fs.Manifest, error) {
+	m, _, err := buildPackageManifest(packagepath, c)
+	return m, err
+}
+
+// buildPackageManifest builds the manifest of the package at packagepath
+// and returns it with the directories of the dependencies of the
+// package.
+func buildPackageManifest(packagepath string, c *types.Config) (*fs.Manifest, []string, error) {
 	ppath, err := os.Getwd() // save wd as it gets changed later on
 	if err != nil {
 		fmt.Println(err)
@@ -196,6 +226,24 @@ func BuildPackageManifest(packagepath string, c *types.Config) (*fs.Manifest, er
 
 	addFilesFromPackage(packagepath, m, ppath)
 
+	deps, err := ManifestDependencies(packagepath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var depDirs []string
+	if len(deps) > 0 {
+		depDirs, err = fetchDependencies(deps, c, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		err = addDependencyFiles(m, packagepath, depDirs)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
 	m.SetProgram(c.Program)
 
 	ss := strings.Split(c.Program, "/")
@@ -216,7 +264,7 @@ func BuildPackageManifest(packagepath string, c *types.Config) (*fs.Manifest, er
 	if string(c.Program[0]) != "/" {
 		err = m.AddFile("/"+p, packagepath+"/"+p)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 	}
 
@@ -226,7 +274,7 @@ func BuildPackageManifest(packagepath string, c *types.Config) (*fs.Manifest, er
 
 	err = setManifestFromConfig(m, c, ppath)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	if !c.DisableArgsCopy && len(c.Args) > 1 {
@@ -242,7 +290,7 @@ func BuildPackageManifest(packagepath string, c *types.Config) (*fs.Manifest, er
 			}
 
 			if err != nil {
-				return nil, err
+				return nil, nil, err
 			}
 		}
 	}
@@ -251,7 +299,7 @@ func BuildPackageManifest(packagepath string, c *types.Config) (*fs.Manifest, er
 		m.AddPassthrough(k, v)
 	}
 
-	return m, nil
+	return m, depDirs, nil
 }
 
 func setManifestFromConfig(m *fs.Manifest, c *types.Config, ppath string) error {
@@ -338,18 +386,18 @@ func setManifestFromConfig(m *fs.Manifest, c *types.Config, ppath string) error
 	m.AddEnvironmentVariable("OPS_VERSION", Version)
 	m.AddEnvironmentVariable("NANOS_VERSION", c.NanosVersion)
 
-	arch := RealGOARCH
-	if AltGOARCH != "" {
-		arch = AltGOARCH
-	}
-
-	m.AddEnvironmentVariable("NANOS_ARCH", arch)
+	m.AddEnvironmentVariable("NANOS_ARCH", TargetArch(c))
 
 	m.AddEnvironmentVariable("IMAGE_NAME", c.CloudConfig.ImageName)
 	for k, v := range c.Env {
 		m.AddEnvironmentVariable(k, v)
 	}
 
+	err := addSecretsToManifest(m, c)
+	if err != nil {
+		return err
+	}
+
 	if _, hasRadarKey := c.Env["RADAR_KEY"]; hasRadarKey {
 		m.AddKlibs([]string{"tls", "radar"})
 
@@ -489,7 +537,71 @@ func addMappedFiles(src string, dest string, m *fs.Manifest) error {
 	return err
 }
 
-func createImageFile(c *types.Config, m *fs.Manifest) error {
+// resolveUefiBoot checks the uefi loader of UEFI images of c is set and
+// points it to the loader of the arch of the kernel.
+func resolveUefiBoot(c *types.Config) error {
+	if !c.Uefi {
+		return nil
+	}
+	if c.UefiBoot == "" {
+		return errors.New("this Nanos version does not support UEFI, consider changing image type")
+	}
+
+	if strings.Contains(c.Kernel, "arm") && strings.HasSuffix(c.UefiBoot, "/bootx64.efi") {
+		c.UefiBoot = strings.Replace(c.UefiBoot, "/bootx64.efi", "-arm/bootaa64.efi", -1)
+	}
+	return nil
+}
+
+// createImageFile makes the image of c with the files of m, built from
+// the packages of the directories packages.
+func createImageFile(c *types.Config, m *fs.Manifest, packages []string) error {
+	// resolved before the cache lookup as the uefi loader is part of the
+	// cache key
+	if err := resolveUefiBoot(c); err != nil {
+		cleanup(c)
+		return err
+	}
+
+	// keeps 'ops cache prune' from removing what was just used
+	for _, dir := range packages {
+		touchCacheEntry(dir)
+	}
+	if c.Kernel != "" {
+		touchCacheEntry(filepath.Dir(c.Kernel))
+	}
+
+	var cacheKey string
+	if !c.NoBuildCache {
+		var err error
+		cacheKey, err = buildCacheKey(c, m)
+		if err != nil {
+			return err
+		}
+
+		reused, err := reuseCachedImage(cacheKey, c.RunConfig.ImageName)
+		if err != nil {
+			return err
+		}
+		if err := recordBuildCacheLookup(reused); err != nil {
+			log.Warnf("failed recording build cache stats: %v", err)
+		}
+		if reused {
+			if c.RunConfig.ShowDebug {
+				fmt.Printf("Reusing cached image %s\n", buildCacheImage(cacheKey))
+			}
+			cleanup(c)
+			return nil
+		}
+	}
+
+	// added once the image isn't found in the cache as it has the build
+	// time; cached images keep the provenance of the build that made them
+	if err := addProvenanceToManifest(c, m, packages); err != nil {
+		cleanup(c)
+		return err
+	}
+
 	// produce final image, boot + kernel + elf
 	fd, err := createFile(c.RunConfig.ImageName)
 	defer func() {
@@ -513,14 +625,6 @@ func createImageFile(c *types.Config, m *fs.Manifest) error {
 
 	mkfsCommand.SetBoot(c.Boot)
 	if c.Uefi {
-		if c.UefiBoot == "" {
-			return errors.New("this Nanos version does not support UEFI, consider changing image type")
-		}
-
-		if strings.Contains(c.Kernel, "arm") {
-			c.UefiBoot = strings.Replace(c.UefiBoot, "/bootx64.efi", "-arm/bootaa64.efi", -1)
-		}
-
 		mkfsCommand.SetUefi(c.UefiBoot)
 	}
 
@@ -535,6 +639,13 @@ func createImageFile(c *types.Config, m *fs.Manifest) error {
 		return err
 	}
 
+	if cacheKey != "" {
+		// not being able to cache the image shouldn't fail the build
+		if err := storeCachedImage(cacheKey, c.RunConfig.ImageName); err != nil {
+			log.Warnf("failed caching image: %v", err)
+		}
+	}
+
 	return nil
 }
 
@@ -552,28 +663,31 @@ func (bc dummy) Write(p []byte) (int, error) {
 
 // DownloadNightlyImages downloads nightly build for nanos
 func DownloadNightlyImages(c *types.Config) error {
-	local, err := LocalTimeStamp()
+	arch := TargetArch(c)
+
+	local, err := LocalTimeStamp(arch)
 	if err != nil {
 		return err
 	}
-	remote, err := RemoteTimeStamp()
+	remote, err := RemoteTimeStamp(arch)
 	if err != nil {
 		return err
 	}
 
-	if _, err := os.Stat(NightlyLocalFolderm); os.IsNotExist(err) {
-		os.MkdirAll(NightlyLocalFolderm, 0755)
+	localFolder := NightlyLocalFolder(arch)
+	if _, err := os.Stat(localFolder); os.IsNotExist(err) {
+		os.MkdirAll(localFolder, 0755)
 	}
-	localtar := path.Join(NightlyLocalFolderm, nightlyFileName())
+	localtar := path.Join(localFolder, nightlyFileName(arch))
 	// we have an update, let's download since it's nightly
 
 	if remote != local || c.Force {
-		if err = DownloadFileWithProgress(localtar, NightlyReleaseURLm, 600); err != nil {
+		if err = DownloadFileWithProgress(localtar, NightlyReleaseURL(arch), 600); err != nil {
 			return err
 		}
 		// update local timestamp
-		updateLocalTimestamp(remote)
-		ExtractPackage(localtar, NightlyLocalFolderm, c)
+		updateLocalTimestamp(arch, remote)
+		ExtractPackage(localtar, localFolder, c)
 	}
 
 	return nil
@@ -618,7 +732,7 @@ func CheckNanosVersionExists(version string, arch string) (bool, error) {
 // arch defaults to x86-64 if empty
 func DownloadReleaseImages(version string, arch string) error {
 	url := getReleaseURL(version)
-	if arch == "arm" || AltGOARCH == "arm64" {
+	if arch == "arm" {
 		url = strings.Replace(url, ".tar.gz", "-virt.tar.gz", -1)
 	}
 
@@ -627,7 +741,7 @@ func DownloadReleaseImages(version string, arch string) error {
 
 	localFolder := getReleaseLocalFolder(version)
 
-	if arch == "arm" || AltGOARCH == "arm64" {
+	if arch == "arm" {
 		localFolder = localFolder + "-arm"
 	}
 
@@ -671,14 +785,23 @@ func DownloadFileWithProgress(filepath string, url string, timeout int) error {
 
 // DownloadFile downloads file using URL
 func DownloadFile(fpath string, url string, timeout int, showProgress bool) error {
+	// we dont care about the error here
+	creds, _ := ReadCredsFromLocal()
+
+	apiKey := ""
+	if creds != nil {
+		apiKey = creds.APIKey
+	}
+
+	return downloadFile(fpath, url, timeout, showProgress, apiKey)
+}
+
+func downloadFile(fpath string, url string, timeout int, showProgress bool, apiKey string) error {
 	out, err := os.CreateTemp(filepath.Dir(fpath), fmt.Sprintf("*%s", filepath.Base(fpath)))
 	if err != nil {
 		return err
 	}
 
-	// we dont care about the error here
-	creds, _ := ReadCredsFromLocal()
-
 	// Get the data
 	c := &http.Client{
 		Timeout: time.Duration(timeout) * time.Second,
@@ -688,8 +811,8 @@ func DownloadFile(fpath string, url string, timeout int, showProgress bool) erro
 		return err
 	}
 
-	if creds != nil {
-		req.Header.Set(APIKeyHeader, creds.APIKey)
+	if apiKey != "" {
+		req.Header.Set(APIKeyHeader, apiKey)
 	}
 
 	resp, err := c.Do(req)
@@ -0,0 +1,308 @@
+package lepton
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/nanovms/ops/types"
+)
+
+// ImageSignatureMediaType is the media type of detached image
+// signatures. They are package signatures of the sha256 digest of the
+// image file.
+const ImageSignatureMediaType = "application/vnd.ops.image.signature.v1+json"
+
+// labels of the signature of uploaded images
+const (
+	imageDigestLabel       = "ops-image-digest"
+	imageSignatureLabel    = "ops-signature-"
+	imageSignatureKeyLabel = "ops-signature-key"
+	// labels values are at most 63 characters
+	imageSignatureLabelSize = 52
+)
+
+// labelEncoding encodes binary label values with the characters all
+// providers allow.
+var labelEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// ImageVerificationError is returned when an image isn't signed as the
+// trust policy requires.
+type ImageVerificationError struct {
+	Image  string
+	Reason string
+}
+
+func (e *ImageVerificationError) Error() string {
+	return fmt.Sprintf("verification of image %s failed: %s", e.Image, e.Reason)
+}
+
+// ImageSignatureDir is where the signatures of local images are kept,
+// as <image>.sig files.
+func ImageSignatureDir() string {
+	return path.Join(GetOpsHome(), "signatures")
+}
+
+// ImageSignaturePath returns the signature file of the image imagePath.
+func ImageSignaturePath(imagePath string) string {
+	return path.Join(ImageSignatureDir(), filepath.Base(imagePath)+".sig")
+}
+
+// ParseEd25519PrivateKey parses a PEM encoded (PKCS #8) or base64 raw
+// ed25519 private key or seed.
+func ParseEd25519PrivateKey(body []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(body)
+	if block == nil {
+		raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(body)))
+		if err != nil {
+			return nil, errors.New("not an ed25519 private key")
+		}
+		switch len(raw) {
+		case ed25519.SeedSize:
+			return ed25519.NewKeyFromSeed(raw), nil
+		case ed25519.PrivateKeySize:
+			return ed25519.PrivateKey(raw), nil
+		}
+		return nil, errors.New("not an ed25519 private key")
+	}
+
+	priv, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := priv.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("not an ed25519 private key")
+	}
+
+	return key, nil
+}
+
+// SignImage signs the image file imagePath with key and writes the
+// signature to its signature file.
+func SignImage(imagePath string, keyID string, key ed25519.PrivateKey) error {
+	digest, err := fileDigest(imagePath)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.MarshalIndent(newSignature(ImageSignatureMediaType, digest, keyID, key), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(ImageSignatureDir(), 0755)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(ImageSignaturePath(imagePath), body)
+}
+
+// TrustPolicyEnforced tells if instances of c may only be created from
+// signed images.
+func TrustPolicyEnforced(c *types.Config) bool {
+	return c.TrustPolicy.RequireSigned || len(c.TrustPolicy.Keys) > 0
+}
+
+// trustedImageKeys returns the keys of the trust store policy accepts
+// by key id.
+func trustedImageKeys(policy types.TrustPolicy) (map[string]ed25519.PublicKey, error) {
+	keys, err := LoadTrustedKeys()
+	if err != nil {
+		return nil, err
+	}
+	if len(policy.Keys) == 0 {
+		return keys, nil
+	}
+
+	allowed := map[string]ed25519.PublicKey{}
+	for _, id := range policy.Keys {
+		key, ok := keys[id]
+		if !ok {
+			return nil, fmt.Errorf("trust policy key %s is not in %s", id, TrustStoreDir())
+		}
+		allowed[id] = key
+	}
+	return allowed, nil
+}
+
+// verifyImageSignature checks signature is the signature of digest by
+// one of keys, matched by keyID if set.
+func verifyImageSignature(image string, digest []byte, signature []byte, keyID func(id string) bool, keys map[string]ed25519.PublicKey) error {
+	fail := func(format string, a ...any) error {
+		return &ImageVerificationError{Image: image, Reason: fmt.Sprintf(format, a...)}
+	}
+
+	candidates := 0
+	for id, key := range keys {
+		if !keyID(id) {
+			continue
+		}
+		candidates++
+		if ed25519.Verify(key, digest, signature) {
+			return nil
+		}
+	}
+
+	if candidates == 0 {
+		return fail("not signed by a key of the trust policy (keys are in %s)", TrustStoreDir())
+	}
+	return fail("invalid signature")
+}
+
+// VerifyLocalImage checks the image file imagePath is signed as the
+// trust policy of c requires.
+func VerifyLocalImage(c *types.Config, imagePath string) error {
+	if !TrustPolicyEnforced(c) {
+		return nil
+	}
+
+	image := filepath.Base(imagePath)
+	fail := func(format string, a ...any) error {
+		return &ImageVerificationError{Image: image, Reason: fmt.Sprintf(format, a...)}
+	}
+
+	body, err := os.ReadFile(ImageSignaturePath(imagePath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fail("image is not signed, sign it with 'ops image sign %s'", image)
+		}
+		return err
+	}
+
+	var sig PackageSignature
+	err = json.Unmarshal(body, &sig)
+	if err != nil || sig.MediaType != ImageSignatureMediaType {
+		return fail("invalid signature file %s", ImageSignaturePath(imagePath))
+	}
+
+	digest, err := fileDigest(imagePath)
+	if err != nil {
+		return err
+	}
+
+	signedDigest, err := base64.StdEncoding.DecodeString(sig.MessageSignature.MessageDigest.Digest)
+	if err != nil || !bytes.Equal(signedDigest, digest) {
+		return fail("image changed since it was signed")
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(sig.MessageSignature.Signature)
+	if err != nil {
+		return fail("invalid signature: %v", err)
+	}
+
+	keys, err := trustedImageKeys(c.TrustPolicy)
+	if err != nil {
+		return err
+	}
+
+	return verifyImageSignature(image, digest, signature, func(id string) bool {
+		return sig.KeyID == "" || sig.KeyID == id
+	}, keys)
+}
+
+// ImageSignatureLabels returns the signature of the image file
+// imagePath as labels of the image uploaded from it, none if it isn't
+// signed or was changed since it was signed.
+func ImageSignatureLabels(imagePath string) []types.Tag {
+	body, err := os.ReadFile(ImageSignaturePath(imagePath))
+	if err != nil {
+		return nil
+	}
+
+	var sig PackageSignature
+	if json.Unmarshal(body, &sig) != nil || sig.MediaType != ImageSignatureMediaType {
+		return nil
+	}
+
+	digest, err := fileDigest(imagePath)
+	if err != nil {
+		return nil
+	}
+	signedDigest, err := base64.StdEncoding.DecodeString(sig.MessageSignature.MessageDigest.Digest)
+	if err != nil || !bytes.Equal(signedDigest, digest) {
+		return nil
+	}
+	signature, err := base64.StdEncoding.DecodeString(sig.MessageSignature.Signature)
+	if err != nil {
+		return nil
+	}
+
+	imageLabel := true
+	attr := &types.TagAttribute{ImageLabel: &imageLabel}
+
+	tags := []types.Tag{{Key: imageDigestLabel, Value: labelEncoding.EncodeToString(digest), Attribute: attr}}
+	encoded := labelEncoding.EncodeToString(signature)
+	for i := 0; i*imageSignatureLabelSize < len(encoded); i++ {
+		chunk := encoded[i*imageSignatureLabelSize : min((i+1)*imageSignatureLabelSize, len(encoded))]
+		tags = append(tags, types.Tag{Key: fmt.Sprintf("%s%d", imageSignatureLabel, i), Value: chunk, Attribute: attr})
+	}
+	if sig.KeyID != "" {
+		tags = append(tags, types.Tag{Key: imageSignatureKeyLabel, Value: labelValue(sig.KeyID), Attribute: attr})
+	}
+	return tags
+}
+
+// VerifyCloudImage checks the image uploaded to a provider is signed as
+// the trust policy of c requires, with the signature labels it was
+// uploaded with.
+func VerifyCloudImage(c *types.Config, image *CloudImage) error {
+	if !TrustPolicyEnforced(c) {
+		return nil
+	}
+
+	fail := func(format string, a ...any) error {
+		return &ImageVerificationError{Image: image.Name, Reason: fmt.Sprintf(format, a...)}
+	}
+
+	// providers list labels as key:value or key=value
+	labels := map[string]string{}
+	for _, l := range image.Labels {
+		if i := strings.IndexAny(l, ":="); i >= 0 {
+			labels[l[:i]] = l[i+1:]
+		}
+	}
+
+	if labels[imageDigestLabel] == "" {
+		return fail("image has no signature labels, sign the local image with 'ops image sign' before creating it")
+	}
+
+	digest, err := labelEncoding.DecodeString(labels[imageDigestLabel])
+	if err != nil {
+		return fail("invalid digest label")
+	}
+
+	var encoded string
+	for i := 0; ; i++ {
+		chunk, ok := labels[fmt.Sprintf("%s%d", imageSignatureLabel, i)]
+		if !ok {
+			break
+		}
+		encoded += chunk
+	}
+	signature, err := labelEncoding.DecodeString(encoded)
+	if err != nil || len(signature) == 0 {
+		return fail("invalid signature labels")
+	}
+
+	keys, err := trustedImageKeys(c.TrustPolicy)
+	if err != nil {
+		return err
+	}
+
+	keyID := labels[imageSignatureKeyLabel]
+	return verifyImageSignature(image.Name, digest, signature, func(id string) bool {
+		return keyID == "" || labelValue(id) == keyID
+	}, keys)
+}
@@ -0,0 +1,142 @@
+package lepton
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/nanovms/ops/testutils"
+	"github.com/nanovms/ops/types"
+)
+
+func writeTestImage(t *testing.T, body string) string {
+	dir := t.TempDir()
+	testutils.WriteFiles(t, dir, map[string]string{"app.img": body})
+	return path.Join(dir, "app.img")
+}
+
+func TestVerifyLocalImage(t *testing.T) {
+	t.Setenv("OPS_HOME", t.TempDir())
+
+	image := writeTestImage(t, "image")
+	key := trustTestKey(t, "release")
+	c := &types.Config{}
+
+	// no trust policy, nothing to check
+	if err := VerifyLocalImage(c, image); err != nil {
+		t.Fatal(err)
+	}
+
+	c.TrustPolicy.RequireSigned = true
+	if err := VerifyLocalImage(c, image); err == nil {
+		t.Fatal("expected unsigned image to be refused")
+	}
+
+	if err := SignImage(image, "release", key); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyLocalImage(c, image); err != nil {
+		t.Fatal(err)
+	}
+
+	// only images signed with the keys of the policy
+	trustTestKey(t, "other")
+	c.TrustPolicy.Keys = []string{"other"}
+	if err := VerifyLocalImage(c, image); err == nil {
+		t.Fatal("expected image signed with another key to be refused")
+	}
+	c.TrustPolicy.Keys = []string{"release"}
+	if err := VerifyLocalImage(c, image); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(image, []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyLocalImage(c, image); err == nil {
+		t.Fatal("expected changed image to be refused")
+	}
+}
+
+func TestVerifyCloudImage(t *testing.T) {
+	t.Setenv("OPS_HOME", t.TempDir())
+
+	image := writeTestImage(t, "image")
+	key := trustTestKey(t, "Release.Key")
+	c := &types.Config{TrustPolicy: types.TrustPolicy{RequireSigned: true}}
+
+	if tags := ImageSignatureLabels(image); tags != nil {
+		t.Fatalf("expected no labels for an unsigned image, got %v", tags)
+	}
+
+	if err := SignImage(image, "Release.Key", key); err != nil {
+		t.Fatal(err)
+	}
+
+	cloudImage := &CloudImage{Name: "app"}
+	for _, tag := range ImageSignatureLabels(image) {
+		if len(tag.Value) > 63 || labelValue(tag.Value) != tag.Value {
+			t.Fatalf("invalid label value %q", tag.Value)
+		}
+		cloudImage.Labels = append(cloudImage.Labels, tag.Key+":"+tag.Value)
+	}
+
+	if err := VerifyCloudImage(c, cloudImage); err != nil {
+		t.Fatal(err)
+	}
+
+	// labels as listed by hetzner
+	hetznerImage := &CloudImage{Name: "app"}
+	for _, l := range cloudImage.Labels {
+		hetznerImage.Labels = append(hetznerImage.Labels, strings.Replace(l, ":", "=", 1))
+	}
+	if err := VerifyCloudImage(c, hetznerImage); err != nil {
+		t.Fatal(err)
+	}
+
+	// a signature of another image
+	cloudImage.Labels[0] = imageDigestLabel + ":" + labelEncoding.EncodeToString(make([]byte, 32))
+	if err := VerifyCloudImage(c, cloudImage); err == nil {
+		t.Fatal("expected mismatched signature to be refused")
+	}
+
+	if err := VerifyCloudImage(c, &CloudImage{Name: "app", Labels: []string{"createdby:ops"}}); err == nil {
+		t.Fatal("expected unsigned image to be refused")
+	}
+}
+
+func TestParseEd25519PrivateKey(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, body := range [][]byte{
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}),
+		[]byte(base64.StdEncoding.EncodeToString(priv.Seed())),
+		[]byte(base64.StdEncoding.EncodeToString(priv)),
+	} {
+		key, err := ParseEd25519PrivateKey(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !key.Equal(priv) {
+			t.Fatalf("unexpected key from %s", body)
+		}
+	}
+
+	if _, err := ParseEd25519PrivateKey([]byte("nope")); err == nil {
+		t.Fatal("expected an error")
+	}
+}
@@ -0,0 +1,149 @@
+package lepton
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ldSoCacheMagic starts the ld.so.cache format written by glibc since
+// 2.2, alone or after the legacy "ld.so-1.7.0" one.
+const ldSoCacheMagic = "glibc-ld.so.cache1.1"
+
+// readLdSoCache reads the ld.so.cache of root and returns the paths of
+// the libraries by soname, in the order of the cache.
+func readLdSoCache(root string) (map[string][]string, error) {
+	body, err := os.ReadFile(filepath.Join(root, "etc", "ld.so.cache"))
+	if err != nil {
+		return nil, err
+	}
+	return parseLdSoCache(body)
+}
+
+func parseLdSoCache(body []byte) (map[string][]string, error) {
+	start := bytes.Index(body, []byte(ldSoCacheMagic))
+	if start < 0 {
+		return nil, errors.New("unsupported ld.so.cache format")
+	}
+	cache := body[start:]
+
+	// magic, nlibs, len_strings, flags, padding, extension_offset, unused
+	const headerSize = 48
+	const entrySize = 24
+	if len(cache) < headerSize {
+		return nil, errors.New("truncated ld.so.cache")
+	}
+
+	// the cache is in the byte order of the system it was made for
+	order := binary.ByteOrder(binary.LittleEndian)
+	nlibs := order.Uint32(cache[20:])
+	if uint64(nlibs)*entrySize > uint64(len(cache)-headerSize) {
+		order = binary.BigEndian
+		nlibs = order.Uint32(cache[20:])
+	}
+	if uint64(nlibs)*entrySize > uint64(len(cache)-headerSize) {
+		return nil, errors.New("truncated ld.so.cache")
+	}
+
+	// string offsets are relative to the header
+	str := func(off uint32) string {
+		if int(off) >= len(cache) {
+			return ""
+		}
+		s := cache[off:]
+		if i := bytes.IndexByte(s, 0); i >= 0 {
+			s = s[:i]
+		}
+		return string(s)
+	}
+
+	libs := map[string][]string{}
+	for i := 0; i < int(nlibs); i++ {
+		entry := cache[headerSize+i*entrySize:]
+		key, value := str(order.Uint32(entry[4:])), str(order.Uint32(entry[8:]))
+		if key == "" || value == "" {
+			continue
+		}
+		libs[key] = append(libs[key], value)
+	}
+
+	return libs, nil
+}
+
+// readLdSoConf returns the library directories of the ld.so.conf of
+// root, following includes.
+func readLdSoConf(root string) []string {
+	var dirs []string
+	seen := map[string]bool{}
+
+	var read func(conf string)
+	read = func(conf string) {
+		if seen[conf] {
+			return
+		}
+		seen[conf] = true
+
+		f, err := os.Open(filepath.Join(root, conf))
+		if err != nil {
+			return
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line, _, _ := strings.Cut(scanner.Text(), "#")
+			fields := strings.Fields(line)
+			if len(fields) == 0 {
+				continue
+			}
+
+			switch fields[0] {
+			case "include":
+				for _, pattern := range fields[1:] {
+					if !path.IsAbs(pattern) {
+						pattern = path.Join(path.Dir(conf), pattern)
+					}
+					matches, _ := filepath.Glob(filepath.Join(root, pattern))
+					for _, m := range matches {
+						rel, err := filepath.Rel(root, m)
+						if err == nil {
+							read(path.Join("/", filepath.ToSlash(rel)))
+						}
+					}
+				}
+			case "hwcap":
+			default:
+				// dirs may be separated by commas or colons too
+				for _, d := range strings.FieldsFunc(line, func(r rune) bool {
+					return r == ',' || r == ':' || r == ' ' || r == '\t'
+				}) {
+					dirs = append(dirs, path.Clean(d))
+				}
+			}
+		}
+	}
+
+	read("/etc/ld.so.conf")
+	return dirs
+}
+
+// readMuslPath returns the library directories musl's dynamic linker
+// interp is configured with in root, or its defaults.
+func readMuslPath(root string, interp string) []string {
+	// /lib/ld-musl-x86_64.so.1 reads /etc/ld-musl-x86_64.path
+	arch := strings.TrimSuffix(strings.TrimPrefix(path.Base(interp), "ld-musl-"), ".so.1")
+
+	body, err := os.ReadFile(filepath.Join(root, "etc", "ld-musl-"+arch+".path"))
+	if err != nil {
+		return []string{"/lib", "/usr/local/lib", "/usr/lib"}
+	}
+
+	return strings.FieldsFunc(string(body), func(r rune) bool {
+		return r == '\n' || r == ':'
+	})
+}
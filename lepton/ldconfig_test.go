@@ -0,0 +1,78 @@
+package lepton
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/nanovms/ops/testutils"
+)
+
+func TestParseLdSoCache(t *testing.T) {
+	strs := []string{"libc.so.6", "/lib/x86_64-linux-gnu/libc.so.6", "libm.so.6", "/usr/lib/libm.so.6"}
+
+	// header, 2 entries, then the strings
+	cache := make([]byte, 48+2*24)
+	copy(cache, ldSoCacheMagic)
+	binary.LittleEndian.PutUint32(cache[20:], 2)
+
+	offsets := []uint32{}
+	for _, s := range strs {
+		offsets = append(offsets, uint32(len(cache)))
+		cache = append(append(cache, s...), 0)
+	}
+	for i := 0; i < 2; i++ {
+		entry := cache[48+i*24:]
+		binary.LittleEndian.PutUint32(entry, 0x0303)
+		binary.LittleEndian.PutUint32(entry[4:], offsets[2*i])
+		binary.LittleEndian.PutUint32(entry[8:], offsets[2*i+1])
+	}
+
+	// the legacy format comes first in compat caches
+	legacy := append([]byte("ld.so-1.7.0\x00\x00\x00\x00\x00"), cache...)
+
+	for _, body := range [][]byte{cache, legacy} {
+		libs, err := parseLdSoCache(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := map[string][]string{
+			"libc.so.6": {"/lib/x86_64-linux-gnu/libc.so.6"},
+			"libm.so.6": {"/usr/lib/libm.so.6"},
+		}
+		if !reflect.DeepEqual(libs, want) {
+			t.Fatalf("got %v, want %v", libs, want)
+		}
+	}
+
+	if _, err := parseLdSoCache(cache[:40]); err == nil {
+		t.Fatal("expected a truncated cache to fail")
+	}
+}
+
+func TestReadLdSoConf(t *testing.T) {
+	root := t.TempDir()
+	testutils.WriteFiles(t, root, map[string]string{
+		"etc/ld.so.conf":                     "include /etc/ld.so.conf.d/*.conf\n/opt/lib # vendor\n",
+		"etc/ld.so.conf.d/libc.conf":         "# libc default configuration\n/usr/local/lib\n",
+		"etc/ld.so.conf.d/x86_64.conf":       "/lib/x86_64-linux-gnu:/usr/lib/x86_64-linux-gnu\ninclude extra/*.conf\n",
+		"etc/ld.so.conf.d/extra/nvidia.conf": "hwcap 0 nosegneg\n/usr/lib/nvidia\n",
+	})
+
+	want := []string{"/usr/local/lib", "/lib/x86_64-linux-gnu", "/usr/lib/x86_64-linux-gnu", "/usr/lib/nvidia", "/opt/lib"}
+	if dirs := readLdSoConf(root); !reflect.DeepEqual(dirs, want) {
+		t.Fatalf("got %v, want %v", dirs, want)
+	}
+}
+
+func TestReadMuslPath(t *testing.T) {
+	root := t.TempDir()
+	if dirs := readMuslPath(root, "/lib/ld-musl-x86_64.so.1"); len(dirs) != 3 || dirs[0] != "/lib" {
+		t.Fatalf("unexpected default dirs %v", dirs)
+	}
+
+	testutils.WriteFiles(t, root, map[string]string{"etc/ld-musl-aarch64.path": "/lib\n/usr/lib:/opt/lib\n"})
+	if dirs := readMuslPath(root, "/lib/ld-musl-aarch64.so.1"); !reflect.DeepEqual(dirs, []string{"/lib", "/usr/lib", "/opt/lib"}) {
+		t.Fatalf("unexpected dirs %v", dirs)
+	}
+}
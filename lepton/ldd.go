@@ -2,6 +2,8 @@ package lepton
 
 import (
 	"fmt"
+
+	"github.com/nanovms/ops/types"
 )
 
 // ValidateELF validates ELF executable format given the file path
@@ -14,3 +16,10 @@ func ValidateELF(executablePath string) {
 		exitWithError(fmt.Sprintf(`only ELF binaries are supported. Is this a Linux binary? run "file %s" on it`, executablePath))
 	}
 }
+
+// SharedLibs returns the shared libraries program, a path in the root
+// filesystem targetRoot, depends on, mapped from their path in the
+// root filesystem to their path on the host.
+func SharedLibs(targetRoot string, program string, c *types.Config) (map[string]string, error) {
+	return getSharedLibs(targetRoot, program, c)
+}
@@ -2,29 +2,15 @@ package lepton
 
 import (
 	"debug/elf"
-	"io"
-	"os"
-	"path/filepath"
 	"strings"
 
-	"github.com/go-errors/errors"
-	"github.com/nanovms/ops/constants"
-	"github.com/nanovms/ops/fs"
-	"github.com/nanovms/ops/log"
 	"github.com/nanovms/ops/types"
 )
 
-// GetElfFileInfo returns an object with elf information of the path program
+// GetElfFileInfo returns an object with elf information of the path program,
+// closing it closes the file
 func GetElfFileInfo(path string) (*elf.File, error) {
-	fd, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	efd, err := elf.NewFile(fd)
-	if err != nil {
-		return nil, err
-	}
-	return efd, nil
+	return elf.Open(path)
 }
 
 // HasDebuggingSymbols checks whether elf file has debugging symbols
@@ -49,148 +35,10 @@ func IsDynamicLinked(efd *elf.File) bool {
 	return false
 }
 
-func expandVars(origin string, s string) string {
-	return strings.Replace(s, "$ORIGIN", origin, -1)
-}
-
-// returns the image path and host path
-func findLib(targetRoot string, origin string, libDirs []string, path string) (string, string, error) {
-	if path[0] == '/' {
-		rpath, err := fs.LookupFile(targetRoot, path)
-		if err != nil {
-			return "", "", err
-		}
-		return path, rpath, nil
-	}
-
-	for _, libDir := range libDirs {
-		lib := filepath.Join(expandVars(origin, libDir), path)
-		rlib, err := fs.LookupFile(targetRoot, lib)
-		if err == nil {
-			return lib, rlib, nil
-		} else if !os.IsNotExist(err) {
-			return "", "", err
-		}
-	}
-
-	return "", "", os.ErrNotExist
-}
-
-func _getSharedLibs(libs map[string]string, targetRoot string, path string, c *types.Config) error {
-	path, err := fs.LookupFile(targetRoot, path)
-	if err != nil {
-		return errors.WrapPrefix(err, path, 0)
-	}
-
-	fd, err := elf.Open(path)
-	if err != nil {
-		if strings.Contains(err.Error(), "bad magic number") {
-			log.Fatalf(constants.ErrorColor, "Only ELF binaries are supported. Is this a Mach-0 (macOS) binary? run 'file "+path+"' on it\n")
-		}
-		return errors.WrapPrefix(err, path, 0)
-	}
-	defer fd.Close()
-
-	var libDirs []string
-
-	// 1. LD_LIBRARY_PATH
-	var ldLibraryPath []string
-	val := os.Getenv("LD_LIBRARY_PATH")
-	if len(strings.TrimSpace(val)) > 0 {
-		ldLibraryPath = strings.Split(val, ":")
-	}
-
-	// 2. DT_RUNPATH
-	dtRunpath, err := fd.DynString(elf.DT_RUNPATH)
-	if err != nil {
-		return err
-	}
-	if len(dtRunpath) == 0 {
-		// DT_RPATH should take precedence over LD_LIBRARY_PATH
-		dtRpath, err := fd.DynString(elf.DT_RPATH)
-		if err != nil {
-			return err
-		}
-		for _, d := range dtRpath {
-			libDirs = append(libDirs, strings.Split(d, ":")...)
-		}
-		libDirs = append(libDirs, ldLibraryPath...)
-	} else {
-		libDirs = append(libDirs, ldLibraryPath...)
-		for _, d := range dtRunpath {
-			libDirs = append(libDirs, strings.Split(d, ":")...)
-		}
-	}
-
-	// 3. read LD_LIBRARY_PATH from config
-	if c != nil {
-		if configEnv, hasLibPaths := c.Env["LD_LIBRARY_PATH"]; hasLibPaths {
-			libDirs = append(libDirs, strings.Split(configEnv, ":")...)
-		}
-	}
-	libDirs = append(libDirs, "/lib64", "/lib/x86_64-linux-gnu", "/usr/lib", "/usr/lib64", "/usr/lib/x86_64-linux-gnu")
-
-	dtNeeded, err := fd.DynString(elf.DT_NEEDED)
-	if err != nil {
-		return err
-	}
-
-	for _, libpath := range dtNeeded {
-		if len(libpath) == 0 {
-			continue
-		}
-
-		// append library
-		libpath, absLibpath, err := findLib(targetRoot, filepath.Dir(path), libDirs, libpath)
-		if err != nil {
-			return errors.WrapPrefix(err, libpath, 0)
-		}
-		if _, ok := libs[libpath]; !ok {
-			libs[libpath] = absLibpath
-
-			// append library dependencies
-			err := _getSharedLibs(libs, targetRoot, absLibpath, c)
-			if err != nil {
-				return err
-			}
-		}
-	}
-
-	for _, prog := range fd.Progs {
-		if prog.ProgHeader.Type != elf.PT_INTERP {
-			continue
-		}
-		r := prog.Open()
-		ipathbuf, err := io.ReadAll(r)
-		if err != nil {
-			return err
-		}
-		// don't include the terminating NUL in path string
-		ipath := string(ipathbuf[:len(ipathbuf)-1])
-		ipath = filepath.Base(ipath)
-		ipath, absIpath, err := findLib(targetRoot, filepath.Dir(path), libDirs, ipath)
-		if err != nil {
-			return errors.WrapPrefix(err, ipath, 0)
-		}
-		if _, ok := libs[ipath]; !ok {
-			libs[ipath] = absIpath
-			err := _getSharedLibs(libs, targetRoot, ipath, c)
-			if err != nil {
-				return err
-			}
-		}
-		break
-	}
-	return nil
-}
-
+// getSharedLibs returns the shared libraries the program at path needs,
+// see resolveSharedLibs.
 func getSharedLibs(targetRoot string, path string, c *types.Config) (map[string]string, error) {
-	libs := make(map[string]string)
-	err := _getSharedLibs(libs, targetRoot, path, c)
-	if err != nil {
-		return nil, err
-	}
-	return libs, nil
+	return resolveSharedLibs(targetRoot, path, c)
 }
 
 // isELF returns true if file is valid ELF
@@ -2,28 +2,15 @@ package lepton
 
 import (
 	"debug/elf"
-	"fmt"
-	"io"
-	"os"
-	"os/exec"
-	"path/filepath"
 	"strings"
 
-	"github.com/go-errors/errors"
 	"github.com/nanovms/ops/types"
 )
 
-// GetElfFileInfo returns an object with elf information of the path program
+// GetElfFileInfo returns an object with elf information of the path program,
+// closing it closes the file
 func GetElfFileInfo(path string) (*elf.File, error) {
-	fd, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	efd, err := elf.NewFile(fd)
-	if err != nil {
-		return nil, err
-	}
-	return efd, nil
+	return elf.Open(path)
 }
 
 // HasDebuggingSymbols checks whether elf file has debugging symbols
@@ -46,125 +33,10 @@ func IsDynamicLinked(efd *elf.File) bool {
 	return true
 }
 
-func getlddOutput(pth string) string {
-	cmd := exec.Command("ldd", pth)
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
-	}
-
-	return string(out)
-}
-
-// works only on linux, need to
-// replace looking up in dynamic section in ELF
+// getSharedLibs returns the shared libraries the program at path needs,
+// see resolveSharedLibs.
 func getSharedLibs(targetRoot string, path string, c *types.Config) (map[string]string, error) {
-	var notExistLib []string
-	var absTargetRoot string
-	if targetRoot != "" {
-		var err error
-		absTargetRoot, err = filepath.Abs(targetRoot)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		absTargetRoot = "/"
-	}
-	if filepath.IsAbs(path) {
-		path = filepath.Join(targetRoot, path)
-	}
-	path, err := filepath.Abs(path)
-	if err != nil {
-		return nil, err
-	}
-
-	ValidateELF(path)
-
-	if _, err := os.Stat(path); err != nil {
-		return nil, errors.Wrap(err, 1)
-	}
-	// LD_TRACE_LOADED_OBJECTS need to fork with out executing it.
-	// TODO:move away from LD_TRACE_LOADED_OBJECTS
-	dir, _ := os.Getwd()
-	var deps = make(map[string]string)
-
-	elfFile, err := GetElfFileInfo(path)
-
-	if err == nil && IsDynamicLinked(elfFile) {
-		env := os.Environ()
-		env = append(env, "LD_TRACE_LOADED_OBJECTS=1")
-		if targetRoot != "" {
-			env = append(env, "LD_LIBRARY_PATH="+targetRoot)
-		}
-		cmd := exec.Command(path)
-		cmd.Env = env
-		out, _ := cmd.StdoutPipe()
-
-		err = cmd.Start()
-		if err != nil {
-			return deps, err
-		}
-
-		bytes, err := io.ReadAll(out)
-		if err != nil {
-			return deps, err
-		}
-		out.Close()
-
-		// vsdo is user space mapped, there is no backing lib
-		vsdo := "linux-vdso.so"
-		sout := string(bytes)
-		if !strings.Contains(sout, "ld-linux") {
-			sout = getlddOutput(path)
-		}
-
-		lines := strings.Split(sout, "\n")
-		for i := 0; i < len(lines); i++ {
-			text := strings.TrimSpace(lines[i])
-			if text == "" || strings.HasPrefix(text, vsdo) {
-				continue
-			}
-			parts := strings.Split(text, " ")
-			if strings.HasPrefix(parts[0], vsdo) {
-				continue
-			}
-			libpath, err := filepath.Abs(parts[len(parts)-2])
-			if err != nil {
-				continue
-			}
-			if !strings.HasPrefix(libpath, absTargetRoot) {
-				// LD_LIBRARY_PATH didn't work: manually add target root path
-				libpath = filepath.Join(absTargetRoot, libpath)
-			}
-			if strings.HasPrefix(libpath, dir) {
-				libpath = libpath[len(dir)+1:]
-			}
-			if strings.Contains(text, "not found") {
-				notExistLib = append(notExistLib, text)
-			}
-			libpath = strings.TrimSpace(libpath)
-			deps[strings.TrimPrefix(libpath, absTargetRoot)] = libpath
-		}
-
-		if len(notExistLib) != 0 {
-			errMessage := "Library: "
-			for i := range notExistLib {
-				errMessage += notExistLib[i]
-				if i+1 != len(notExistLib) {
-					errMessage += "\n"
-				}
-			}
-			fmt.Println("Ops can't find the following missing libraries:")
-			return nil, errors.New(errMessage)
-		}
-
-		defer out.Close()
-		if err := cmd.Wait(); err != nil {
-			return nil, err
-		}
-	}
-	return deps, nil
+	return resolveSharedLibs(targetRoot, path, c)
 }
 
 // isELF returns true if file is valid ELF
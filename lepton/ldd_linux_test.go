@@ -5,7 +5,12 @@ package lepton
 
 import (
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/nanovms/ops/testutils"
 )
 
 func TestGetSharedLibsSystemLs(t *testing.T) {
@@ -22,3 +27,82 @@ func TestGetSharedLibsSystemLs(t *testing.T) {
 		t.Logf("%s -> %s", k, v)
 	}
 }
+
+func TestGetSharedLibsTargetRoot(t *testing.T) {
+	if _, err := os.Stat("/bin/ls"); err != nil {
+		t.Skip("could not stat /bin/ls:", err)
+	}
+	hostLibs, err := getSharedLibs("", "/bin/ls", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hostLibs) == 0 {
+		t.Skip("/bin/ls is statically linked")
+	}
+
+	// a root with only the program lists every missing library
+	root := t.TempDir()
+	copyToRoot := func(imagePath, hostPath string) {
+		body, err := os.ReadFile(hostPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutils.WriteFiles(t, root, map[string]string{imagePath: string(body)})
+	}
+	copyToRoot("/usr/bin/app", "/bin/ls")
+
+	_, err = getSharedLibs(root, "/usr/bin/app", nil)
+	if err == nil || !strings.Contains(err.Error(), "libc.so.6, needed by /usr/bin/app") {
+		t.Fatalf("expected missing libraries to be reported, got %v", err)
+	}
+
+	for libpath, hostpath := range hostLibs {
+		copyToRoot(libpath, hostpath)
+	}
+
+	libs, err := getSharedLibs(root, "/usr/bin/app", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for libpath := range hostLibs {
+		if hostpath := libs[libpath]; hostpath != filepath.Join(root, libpath) {
+			t.Fatalf("%s resolved to %s out of the target root", libpath, hostpath)
+		}
+	}
+}
+
+func TestGetSharedLibsOrigin(t *testing.T) {
+	if _, err := exec.LookPath("cc"); err != nil {
+		t.Skip("no C compiler:", err)
+	}
+
+	dir := t.TempDir()
+	testutils.WriteFiles(t, dir, map[string]string{
+		"lib.c":  "int lib(void) { return 0; }\n",
+		"main.c": "int lib(void);\nint main(void) { return lib(); }\n",
+	})
+	cc := func(args ...string) {
+		cmd := exec.Command("cc", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("cc %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "lib"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	cc("-shared", "-fPIC", "-o", "lib/liborigin.so", "lib.c")
+	cc("-o", "app", "main.c", "-Llib", "-lorigin", "-Wl,-rpath,$ORIGIN/lib")
+
+	// $ORIGIN of a program given relative to the current directory is
+	// where it is on the host
+	t.Chdir(dir)
+	libs, err := getSharedLibs("", "./app", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lib := filepath.Join(dir, "lib", "liborigin.so")
+	if libs[lib] != lib {
+		t.Fatalf("expected %s to be resolved next to the program, got %v", lib, libs)
+	}
+}
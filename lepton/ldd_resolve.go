@@ -0,0 +1,285 @@
+package lepton
+
+import (
+	"debug/elf"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-errors/errors"
+	"github.com/nanovms/ops/fs"
+	"github.com/nanovms/ops/types"
+)
+
+// multiarchTriplets are the debian multiarch library directories of each
+// machine.
+var multiarchTriplets = map[elf.Machine]string{
+	elf.EM_X86_64:  "x86_64-linux-gnu",
+	elf.EM_AARCH64: "aarch64-linux-gnu",
+	elf.EM_386:     "i386-linux-gnu",
+	elf.EM_RISCV:   "riscv64-linux-gnu",
+}
+
+type missingLib struct {
+	name     string
+	neededBy string
+}
+
+// libResolver finds the shared libraries of an ELF program like the
+// dynamic linker of the target root would, without running the program,
+// so that programs of another arch can be resolved too.
+type libResolver struct {
+	targetRoot string
+	class      elf.Class
+	machine    elf.Machine
+
+	envDirs     []string // LD_LIBRARY_PATH
+	cache       map[string][]string
+	confDirs    []string
+	defaultDirs []string
+
+	libs    map[string]string
+	loaded  map[string]bool // sonames of the libs
+	missing []missingLib
+}
+
+// lookup returns the host path of the image path p if it is an ELF
+// object the program can load.
+func (r *libResolver) lookup(p string) (string, bool) {
+	hostpath, err := fs.LookupFile(r.targetRoot, p)
+	if err != nil {
+		return "", false
+	}
+	// libraries come from the target root only
+	if r.targetRoot != "" && !strings.HasPrefix(hostpath, r.targetRoot+"/") {
+		return "", false
+	}
+
+	fd, err := elf.Open(hostpath)
+	if err != nil {
+		return "", false
+	}
+	defer fd.Close()
+
+	return hostpath, fd.Class == r.class && fd.Machine == r.machine
+}
+
+func (r *libResolver) find(name string, rpath []string, runpath []string) (string, string, bool) {
+	if strings.Contains(name, "/") {
+		hostpath, ok := r.lookup(name)
+		return name, hostpath, ok
+	}
+
+	var dirs []string
+	if len(runpath) == 0 {
+		dirs = append(dirs, rpath...)
+	}
+	dirs = append(dirs, r.envDirs...)
+	dirs = append(dirs, runpath...)
+
+	var candidates []string
+	for _, d := range dirs {
+		candidates = append(candidates, path.Join(d, name))
+	}
+	candidates = append(candidates, r.cache[name]...)
+	for _, d := range append(r.confDirs, r.defaultDirs...) {
+		candidates = append(candidates, path.Join(d, name))
+	}
+
+	for _, p := range candidates {
+		if hostpath, ok := r.lookup(p); ok {
+			return p, hostpath, true
+		}
+	}
+
+	return "", "", false
+}
+
+func dynPaths(fd *elf.File, tag elf.DynTag, origin string) []string {
+	values, _ := fd.DynString(tag)
+
+	var dirs []string
+	for _, v := range values {
+		for _, d := range strings.Split(v, ":") {
+			if d == "" {
+				continue
+			}
+			d = strings.ReplaceAll(d, "${ORIGIN}", origin)
+			dirs = append(dirs, strings.ReplaceAll(d, "$ORIGIN", origin))
+		}
+	}
+	return dirs
+}
+
+// resolve adds the libraries the object at the image path p, opened as
+// fd, needs, $ORIGIN being origin. rpath is the DT_RPATH of the objects
+// loading it.
+func (r *libResolver) resolve(p string, origin string, fd *elf.File, rpath []string) error {
+	runpath := dynPaths(fd, elf.DT_RUNPATH, origin)
+	rpath = append(dynPaths(fd, elf.DT_RPATH, origin), rpath...)
+
+	needed, err := fd.DynString(elf.DT_NEEDED)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range needed {
+		if name == "" || r.loaded[name] {
+			continue
+		}
+
+		libpath, hostpath, ok := r.find(name, rpath, runpath)
+		if !ok {
+			r.missing = append(r.missing, missingLib{name, p})
+			continue
+		}
+		r.loaded[name] = true
+		if _, ok := r.libs[libpath]; ok {
+			continue
+		}
+		r.libs[libpath] = hostpath
+
+		lib, err := elf.Open(hostpath)
+		if err != nil {
+			return errors.WrapPrefix(err, hostpath, 0)
+		}
+		err = r.resolve(libpath, path.Dir(libpath), lib, rpath)
+		lib.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func interpreter(fd *elf.File) (string, error) {
+	for _, prog := range fd.Progs {
+		if prog.Type != elf.PT_INTERP {
+			continue
+		}
+		body, err := io.ReadAll(prog.Open())
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(body), "\x00"), nil
+	}
+	return "", nil
+}
+
+// resolveSharedLibs returns the shared libraries, with the dynamic linker,
+// the program at path needs, mapped from their path in the image to
+// their path on the host. They are looked up in the DT_RPATH,
+// LD_LIBRARY_PATH, DT_RUNPATH, ld.so.cache, ld.so.conf and default
+// library directories of targetRoot, or of the host if it is empty.
+func resolveSharedLibs(targetRoot string, path string, c *types.Config) (map[string]string, error) {
+	var err error
+	if targetRoot != "" {
+		targetRoot, err = filepath.Abs(targetRoot)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the path of the program in the image, see AddUserProgram
+	imagePath := filepath.Join("/", path)
+	origin := filepath.Dir(imagePath)
+	if filepath.IsAbs(path) {
+		path, err = fs.LookupFile(targetRoot, path)
+	} else {
+		// a program from the host finds the libraries of its $ORIGIN
+		// where it is on the host
+		path, err = filepath.Abs(path)
+		origin = filepath.Dir(path)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, 1)
+	}
+
+	ValidateELF(path)
+
+	fd, err := elf.Open(path)
+	if err != nil {
+		return nil, errors.WrapPrefix(err, path, 0)
+	}
+	defer fd.Close()
+
+	deps := make(map[string]string)
+
+	interp, err := interpreter(fd)
+	if err != nil {
+		return nil, err
+	}
+	if interp == "" && !IsDynamicLinked(fd) {
+		return deps, nil
+	}
+
+	r := &libResolver{
+		targetRoot: targetRoot,
+		class:      fd.Class,
+		machine:    fd.Machine,
+		libs:       deps,
+		loaded:     map[string]bool{},
+	}
+
+	if v := os.Getenv("LD_LIBRARY_PATH"); strings.TrimSpace(v) != "" {
+		r.envDirs = strings.Split(v, ":")
+	}
+	if c != nil {
+		if v, ok := c.Env["LD_LIBRARY_PATH"]; ok {
+			r.envDirs = append(r.envDirs, strings.Split(v, ":")...)
+		}
+	}
+
+	root := targetRoot
+	if root == "" {
+		root = "/"
+	}
+
+	if strings.HasPrefix(filepath.Base(interp), "ld-musl-") {
+		r.defaultDirs = readMuslPath(root, interp)
+	} else {
+		r.cache, _ = readLdSoCache(root)
+		r.confDirs = readLdSoConf(root)
+
+		if triplet, ok := multiarchTriplets[fd.Machine]; ok {
+			r.defaultDirs = append(r.defaultDirs, "/lib/"+triplet, "/usr/lib/"+triplet)
+		}
+		if fd.Class == elf.ELFCLASS64 {
+			r.defaultDirs = append(r.defaultDirs, "/lib64", "/usr/lib64")
+		}
+		r.defaultDirs = append(r.defaultDirs, "/lib", "/usr/lib")
+	}
+
+	if interp != "" {
+		hostpath, ok := r.lookup(interp)
+		if !ok {
+			r.missing = append(r.missing, missingLib{interp, imagePath})
+		} else {
+			deps[interp] = hostpath
+			r.loaded[filepath.Base(interp)] = true
+		}
+	}
+
+	err = r.resolve(imagePath, origin, fd, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(r.missing) != 0 {
+		where := "on the host"
+		if targetRoot != "" {
+			where = "in " + targetRoot
+		}
+		msg := fmt.Sprintf("ops can't find the following libraries %s:", where)
+		for _, m := range r.missing {
+			msg += fmt.Sprintf("\n  %s, needed by %s", m.name, m.neededBy)
+		}
+		return nil, errors.New(msg)
+	}
+
+	return deps, nil
+}
@@ -6,7 +6,6 @@ import (
 	"errors"
 	"net/http"
 	"net/url"
-	"runtime"
 )
 
 // APIMetadataRequest is payload sent to get metadata for a package
@@ -17,31 +16,42 @@ type APIMetadataRequest struct {
 	Arch      string `json:"arch"`
 }
 
-// GetPackageMetadata get metadata for the package
-func GetPackageMetadata(namespace, pkgName, version string) (*Package, error) {
-	var err error
+// ErrPackageNotFound is returned when a registry doesn't have a package.
+var ErrPackageNotFound = errors.New("package not found")
 
+// GetPackageMetadata get metadata for the package built for arch (the
+// host architecture if empty)
+func GetPackageMetadata(namespace, pkgName, version, arch string) (*Package, error) {
 	// we ignore the error here
 	creds, _ := ReadCredsFromLocal()
 
+	apiKey := ""
+	if creds != nil {
+		apiKey = creds.APIKey
+	}
+
+	return fetchPackageMetadata(PkghubBaseURL, apiKey, namespace, pkgName, version, arch)
+}
+
+// fetchPackageMetadata gets the metadata of a package from the pkghub
+// compatible registry at baseURL.
+func fetchPackageMetadata(baseURL, apiKey, namespace, pkgName, version, arch string) (*Package, error) {
+	var err error
+
 	ar := APIMetadataRequest{
 		Namespace: namespace,
 		PkgName:   pkgName,
 		Version:   version,
 	}
 
-	if AltGOARCH != "" {
-		if AltGOARCH != "amd64" {
-			ar.Arch = AltGOARCH
-		}
-	} else {
-		if runtime.GOARCH == "arm64" {
-			ar.Arch = "arm64"
-		}
+	if archOrHost(arch) != "amd64" {
+		ar.Arch = archOrHost(arch)
 	}
 
-	// this would never error out
-	metadataURL, _ := url.Parse(PkghubBaseURL + "/api/v1/pkg/metadata")
+	metadataURL, err := url.Parse(baseURL + "/api/v1/pkg/metadata")
+	if err != nil {
+		return nil, err
+	}
 	data, err := json.Marshal(ar)
 	if err != nil {
 		return nil, err
@@ -52,8 +62,8 @@ func GetPackageMetadata(namespace, pkgName, version string) (*Package, error) {
 		return nil, err
 	}
 
-	if creds != nil {
-		req.Header.Set(APIKeyHeader, creds.APIKey)
+	if apiKey != "" {
+		req.Header.Set(APIKeyHeader, apiKey)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
@@ -61,8 +71,10 @@ func GetPackageMetadata(namespace, pkgName, version string) (*Package, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
+
 	if resp.StatusCode == http.StatusNotFound {
-		return nil, errors.New("package not found")
+		return nil, ErrPackageNotFound
 	}
 	if resp.StatusCode != http.StatusOK {
 		return nil, errors.New(resp.Status)
@@ -0,0 +1,104 @@
+package lepton
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Operations whose durations are recorded.
+const (
+	OpBuild  = "build"
+	OpUpload = "upload"
+)
+
+// DurationStats aggregates how long an operation took on a target.
+type DurationStats struct {
+	Operation string  `json:"operation"`
+	Target    string  `json:"target"`
+	Count     int64   `json:"count"`
+	Sum       float64 `json:"sum"`  // seconds
+	Last      float64 `json:"last"` // seconds
+}
+
+var durationsMu sync.Mutex
+
+func durationsPath() string {
+	return path.Join(GetOpsHome(), "metrics.json")
+}
+
+// RecordDuration adds a run of op on target to the durations kept in
+// ops home so they can be exported by the daemon. It is best effort and
+// never fails the operation being measured.
+func RecordDuration(op string, target string, d time.Duration) {
+	if target == "" {
+		target = "onprem"
+	}
+
+	// the lock is shared with other ops processes, the mutex keeps
+	// goroutines from waiting on each other's lock file
+	durationsMu.Lock()
+	defer durationsMu.Unlock()
+
+	withFileLock(durationsPath(), func() error {
+		stats, _ := ReadDurations()
+
+		found := false
+		for i := range stats {
+			if stats[i].Operation == op && stats[i].Target == target {
+				stats[i].Count++
+				stats[i].Sum += d.Seconds()
+				stats[i].Last = d.Seconds()
+				found = true
+			}
+		}
+		if !found {
+			stats = append(stats, DurationStats{
+				Operation: op,
+				Target:    target,
+				Count:     1,
+				Sum:       d.Seconds(),
+				Last:      d.Seconds(),
+			})
+		}
+
+		body, err := json.Marshal(stats)
+		if err != nil {
+			return err
+		}
+
+		// a reader never sees half a file
+		return writeFileAtomic(durationsPath(), body)
+	})
+}
+
+// ReadDurations returns the recorded durations sorted by operation and
+// target.
+func ReadDurations() ([]DurationStats, error) {
+	stats := []DurationStats{}
+
+	body, err := os.ReadFile(durationsPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return stats, nil
+		}
+		return stats, err
+	}
+
+	err = json.Unmarshal(body, &stats)
+	if err != nil {
+		return []DurationStats{}, err
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Operation != stats[j].Operation {
+			return stats[i].Operation < stats[j].Operation
+		}
+		return stats[i].Target < stats[j].Target
+	})
+
+	return stats, nil
+}
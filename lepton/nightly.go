@@ -5,60 +5,37 @@ import (
 	"strings"
 )
 
-// NightlyReleaseURLm give URL for nightly build.
-var NightlyReleaseURLm = NightlyReleaseURL()
-
-func nightlybase() string {
-	if AltGOARCH != "" {
-		if AltGOARCH == "arm64" {
-			return "nanos-nightly-linux-virt"
-		}
-
-		return "nanos-nightly-linux"
-
-	}
-
-	if RealGOARCH == "arm64" {
+func nightlybase(arch string) string {
+	if archOrHost(arch) == "arm64" {
 		return "nanos-nightly-linux-virt"
 	}
 
 	return "nanos-nightly-linux"
 }
 
-func nightlyFileName() string {
-	return nightlybase() + ".tar.gz"
+func nightlyFileName(arch string) string {
+	return nightlybase(arch) + ".tar.gz"
 }
 
-func nightlyTimestamp() string {
-	return nightlybase() + ".timestamp"
+func nightlyTimestamp(arch string) string {
+	return nightlybase(arch) + ".timestamp"
 }
 
-// NightlyReleaseURL points to the latest nightly release url that is
-// arch dependent upon flag set.
-func NightlyReleaseURL() string {
+// NightlyReleaseURL points to the latest nightly release url for arch
+// (the host architecture if empty).
+func NightlyReleaseURL(arch string) string {
 	var sb strings.Builder
 	sb.WriteString(nightlyReleaseBaseURL)
-	sb.WriteString(nightlyFileName())
+	sb.WriteString(nightlyFileName(arch))
 	return sb.String()
 }
 
-// NightlyLocalFolder points to the latest nightly release url that is
-// arch dependent upon flag set.
-func NightlyLocalFolder() string {
-	if AltGOARCH != "" {
-		if AltGOARCH == "arm64" {
-			return path.Join(GetOpsHome(), "nightly-arm")
-		}
-
-		return path.Join(GetOpsHome(), "nightly")
-	}
-
-	if RealGOARCH == "arm64" {
+// NightlyLocalFolder is the directory nightly builds for arch (the host
+// architecture if empty) are stored in.
+func NightlyLocalFolder(arch string) string {
+	if archOrHost(arch) == "arm64" {
 		return path.Join(GetOpsHome(), "nightly-arm")
 	}
 
 	return path.Join(GetOpsHome(), "nightly")
 }
-
-// NightlyLocalFolderm is directory path where nightly builds are stored
-var NightlyLocalFolderm = NightlyLocalFolder()
@@ -5,7 +5,7 @@ import "testing"
 func TestNightlyFilename(t *testing.T) {
 	RealGOARCH = "amd64"
 
-	fname := nightlybase()
+	fname := nightlybase("")
 
 	if fname != "nanos-nightly-linux" {
 		t.Fatalf("invalid nightly filename: %s", fname)
@@ -13,25 +13,23 @@ func TestNightlyFilename(t *testing.T) {
 
 	RealGOARCH = "arm64"
 
-	fname = nightlybase()
+	fname = nightlybase("")
 
 	if fname != "nanos-nightly-linux-virt" {
 		t.Fatalf("invalid nightly filename: %s", fname)
 	}
 
 	RealGOARCH = "arm64"
-	AltGOARCH = "amd64"
 
-	fname = nightlybase()
+	fname = nightlybase("amd64")
 
 	if fname != "nanos-nightly-linux" {
 		t.Fatalf("invalid nightly filename: %s", fname)
 	}
 
 	RealGOARCH = "amd64"
-	AltGOARCH = "arm64"
 
-	fname = nightlybase()
+	fname = nightlybase("arm64")
 
 	if fname != "nanos-nightly-linux-virt" {
 		t.Fatalf("invalid nightly filename: %s", fname)
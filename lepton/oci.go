@@ -0,0 +1,893 @@
+package lepton
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/distribution/reference"
+)
+
+// OCIImageConfig is the part of the configuration of an OCI image
+// packages are made from.
+type OCIImageConfig struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Config       struct {
+		Entrypoint []string `json:"Entrypoint"`
+		Cmd        []string `json:"Cmd"`
+		Env        []string `json:"Env"`
+		WorkingDir string   `json:"WorkingDir"`
+	} `json:"config"`
+}
+
+type ociPlatform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+}
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType,omitempty"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size,omitempty"`
+	Platform    *ociPlatform      `json:"platform,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// ociManifest is an image manifest or, if Manifests is set, an index
+// (docker manifest list).
+type ociManifest struct {
+	MediaType string          `json:"mediaType,omitempty"`
+	Config    ociDescriptor   `json:"config"`
+	Layers    []ociDescriptor `json:"layers"`
+	Manifests []ociDescriptor `json:"manifests,omitempty"`
+}
+
+var ociManifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// ociStore is where the manifests and blobs of an image are read from.
+type ociStore interface {
+	// manifest returns the manifest or index ref points to; ref is a
+	// tag or a digest.
+	manifest(ref string) ([]byte, error)
+	blob(digest string) (io.ReadCloser, error)
+}
+
+// ociRegistry reads images from a registry speaking the distribution
+// api.
+type ociRegistry struct {
+	base  string // scheme and host
+	repo  string
+	auth  string // basic credentials of ~/.docker/config.json
+	token string
+}
+
+func newOCIRegistry(named reference.Named) *ociRegistry {
+	host := reference.Domain(named)
+	if host == "docker.io" {
+		host = "registry-1.docker.io"
+	}
+
+	scheme := "https"
+	if h, _, _ := strings.Cut(host, ":"); h == "localhost" || h == "127.0.0.1" {
+		scheme = "http"
+	}
+
+	return &ociRegistry{
+		base: scheme + "://" + host,
+		repo: reference.Path(named),
+		auth: dockerConfigAuth(reference.Domain(named)),
+	}
+}
+
+// dockerConfigAuth returns the credentials docker login stored for
+// host, if any.
+func dockerConfigAuth(host string) string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	body, err := os.ReadFile(filepath.Join(home, ".docker", "config.json"))
+	if err != nil {
+		return ""
+	}
+
+	var config struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+	}
+	if json.Unmarshal(body, &config) != nil {
+		return ""
+	}
+
+	keys := []string{host, "https://" + host}
+	if host == "docker.io" {
+		keys = append(keys, "https://index.docker.io/v1/")
+	}
+	for _, k := range keys {
+		if a, ok := config.Auths[k]; ok && a.Auth != "" {
+			return a.Auth
+		}
+	}
+	return ""
+}
+
+// authenticate gets a token as asked by the WWW-Authenticate challenge.
+func (r *ociRegistry) authenticate(challenge string) error {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	if strings.EqualFold(scheme, "basic") {
+		if r.auth == "" {
+			return errors.New("registry requires credentials, use docker login")
+		}
+		r.token = "Basic " + r.auth
+		return nil
+	}
+
+	values := map[string]string{}
+	for _, p := range strings.Split(params, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(p), "=")
+		if ok {
+			values[k] = strings.Trim(v, `"`)
+		}
+	}
+	if values["realm"] == "" {
+		return fmt.Errorf("unsupported authentication challenge %q", challenge)
+	}
+
+	u, err := url.Parse(values["realm"])
+	if err != nil {
+		return err
+	}
+	q := u.Query()
+	if values["service"] != "" {
+		q.Set("service", values["service"])
+	}
+	scope := values["scope"]
+	if scope == "" {
+		scope = "repository:" + r.repo + ":pull"
+	}
+	q.Set("scope", scope)
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	if r.auth != "" {
+		req.Header.Set("Authorization", "Basic "+r.auth)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("registry authentication failed: %s", resp.Status)
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&token)
+	if err != nil {
+		return err
+	}
+
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	r.token = "Bearer " + token.Token
+	return nil
+}
+
+func (r *ociRegistry) get(p string, accept []string) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest("GET", r.base+"/v2/"+r.repo+"/"+p, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range accept {
+			req.Header.Add("Accept", a)
+		}
+		if r.token != "" {
+			req.Header.Set("Authorization", r.token)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			resp.Body.Close()
+			err = r.authenticate(resp.Header.Get("WWW-Authenticate"))
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%s: %s", p, resp.Status)
+		}
+		return resp, nil
+	}
+}
+
+func (r *ociRegistry) manifest(ref string) ([]byte, error) {
+	resp, err := r.get("manifests/"+ref, ociManifestMediaTypes)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return io.ReadAll(resp.Body)
+}
+
+func (r *ociRegistry) blob(digest string) (io.ReadCloser, error) {
+	resp, err := r.get("blobs/"+digest, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// ociLayout reads images from a directory in the OCI image layout.
+type ociLayout struct {
+	dir string
+}
+
+func (l ociLayout) manifest(ref string) ([]byte, error) {
+	if strings.HasPrefix(ref, "sha256:") {
+		rc, err := l.blob(ref)
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return io.ReadAll(rc)
+	}
+
+	body, err := os.ReadFile(filepath.Join(l.dir, "index.json"))
+	if err != nil {
+		return nil, err
+	}
+	if ref == "" {
+		return body, nil
+	}
+
+	// only keep the manifests tagged ref
+	var index ociManifest
+	err = json.Unmarshal(body, &index)
+	if err != nil {
+		return nil, err
+	}
+
+	tagged := []ociDescriptor{}
+	for _, m := range index.Manifests {
+		name := m.Annotations["org.opencontainers.image.ref.name"]
+		if name == ref || strings.HasSuffix(name, ":"+ref) {
+			tagged = append(tagged, m)
+		}
+	}
+	if len(tagged) == 0 {
+		return nil, fmt.Errorf("no image tagged %s in %s", ref, l.dir)
+	}
+	index.Manifests = tagged
+
+	return json.Marshal(index)
+}
+
+func (l ociLayout) blob(digest string) (io.ReadCloser, error) {
+	alg, hex, ok := strings.Cut(digest, ":")
+	if !ok || strings.ContainsAny(hex, `/\.`) {
+		return nil, fmt.Errorf("invalid digest %s", digest)
+	}
+	return os.Open(filepath.Join(l.dir, "blobs", alg, hex))
+}
+
+// dockerArchive reads images saved by docker save without an OCI
+// layout; blobs are referenced by their path in the archive.
+type dockerArchive struct {
+	dir string
+}
+
+func (d dockerArchive) manifest(ref string) ([]byte, error) {
+	body, err := os.ReadFile(filepath.Join(d.dir, "manifest.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var images []struct {
+		Config   string
+		RepoTags []string
+		Layers   []string
+	}
+	err = json.Unmarshal(body, &images)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, img := range images {
+		match := ref == ""
+		for _, t := range img.RepoTags {
+			if t == ref || strings.HasSuffix(t, ":"+ref) {
+				match = true
+			}
+		}
+		if !match {
+			continue
+		}
+
+		m := ociManifest{Config: ociDescriptor{Digest: img.Config}}
+		for _, l := range img.Layers {
+			m.Layers = append(m.Layers, ociDescriptor{Digest: l})
+		}
+		return json.Marshal(m)
+	}
+
+	return nil, fmt.Errorf("no image tagged %s in the archive", ref)
+}
+
+func (d dockerArchive) blob(p string) (io.ReadCloser, error) {
+	clean := path.Clean("/" + p)
+	return os.Open(filepath.Join(d.dir, filepath.FromSlash(clean)))
+}
+
+// openOCIStore opens the image src which is either an OCI layout
+// directory, a tarball of docker save or of an OCI layout, or a
+// reference to an image of a registry. It returns the tag or digest to
+// look up, if any, and a cleanup function.
+func openOCIStore(src string) (ociStore, string, func(), error) {
+	nop := func() {}
+
+	info, err := os.Stat(src)
+	if err == nil && info.IsDir() {
+		return ociLayout{dir: src}, "", nop, nil
+	}
+
+	if err == nil {
+		dir, err := os.MkdirTemp("", "ops-oci")
+		if err != nil {
+			return nil, "", nop, err
+		}
+		cleanup := func() { os.RemoveAll(dir) }
+
+		err = extractOCITarball(src, dir)
+		if err != nil {
+			cleanup()
+			return nil, "", nop, err
+		}
+
+		if _, err := os.Stat(filepath.Join(dir, "index.json")); err == nil {
+			return ociLayout{dir: dir}, "", cleanup, nil
+		}
+		return dockerArchive{dir: dir}, "", cleanup, nil
+	}
+
+	named, err := reference.ParseNormalizedNamed(src)
+	if err != nil {
+		return nil, "", nop, fmt.Errorf("%s is neither a file nor an image reference: %v", src, err)
+	}
+	named = reference.TagNameOnly(named)
+
+	ref := ""
+	if d, ok := named.(reference.Digested); ok {
+		ref = d.Digest().String()
+	} else if t, ok := named.(reference.Tagged); ok {
+		ref = t.Tag()
+	}
+
+	return newOCIRegistry(named), ref, nop, nil
+}
+
+// extractOCITarball extracts the image tarball src to dir.
+func extractOCITarball(src, dir string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r, err := decompressLayer(f)
+	if err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		target := filepath.Join(dir, filepath.FromSlash(path.Clean("/"+hdr.Name)))
+		err = os.MkdirAll(filepath.Dir(target), 0755)
+		if err != nil {
+			return err
+		}
+
+		out, err := os.Create(target)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, tr)
+		out.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// resolveOCIManifest returns the manifest of the image for arch,
+// picking it from the index if ref points to one.
+func resolveOCIManifest(s ociStore, ref, arch string) (*ociManifest, error) {
+	body, err := s.manifest(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	var m ociManifest
+	err = json.Unmarshal(body, &m)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(m.Manifests) == 0 {
+		return &m, nil
+	}
+
+	available := []string{}
+	for _, d := range m.Manifests {
+		if d.Platform == nil {
+			// layouts with a single image may not set a platform
+			if len(m.Manifests) == 1 {
+				return resolveOCIManifest(s, d.Digest, arch)
+			}
+			continue
+		}
+		if d.Platform.OS != "" && d.Platform.OS != "linux" {
+			continue
+		}
+		if d.Platform.Architecture == arch {
+			return resolveOCIManifest(s, d.Digest, arch)
+		}
+		available = append(available, d.Platform.Architecture)
+	}
+
+	return nil, fmt.Errorf("image has no linux/%s variant, only %s", arch, strings.Join(available, ", "))
+}
+
+// verifiedReader checks the content read matches digest once EOF is
+// reached. Blobs of docker archives have no digest and aren't checked.
+type verifiedReader struct {
+	r      io.Reader
+	h      hash.Hash
+	digest string
+}
+
+func newVerifiedReader(r io.Reader, digest string) io.Reader {
+	if !strings.HasPrefix(digest, "sha256:") {
+		return r
+	}
+	return &verifiedReader{r: r, h: sha256.New(), digest: digest}
+}
+
+func (v *verifiedReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.h.Write(p[:n])
+	if err == io.EOF {
+		if got := fmt.Sprintf("sha256:%x", v.h.Sum(nil)); got != v.digest {
+			return n, fmt.Errorf("digest mismatch: expected %s, got %s", v.digest, got)
+		}
+	}
+	return n, err
+}
+
+// decompressLayer returns a reader of the tar of a possibly compressed
+// layer.
+func decompressLayer(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return gzip.NewReader(br)
+	case bytes.Equal(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return nil, errors.New("zstd compressed layers are not supported")
+	}
+	return br, nil
+}
+
+// ociSecurePath returns where the image path name is in root. Symlinks
+// of parent directories are resolved inside root so layers can't write
+// outside of it.
+func ociSecurePath(root, name string) (string, error) {
+	parts := strings.Split(strings.Trim(path.Clean("/"+name), "/"), "/")
+
+	resolved := "/"
+	hops := 0
+	for i := 0; i < len(parts)-1; i++ {
+		next := path.Join(resolved, parts[i])
+
+		fi, err := os.Lstat(filepath.Join(root, filepath.FromSlash(next)))
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		hops++
+		if hops > 255 {
+			return "", fmt.Errorf("too many levels of symbolic links in %s", name)
+		}
+
+		target, err := os.Readlink(filepath.Join(root, filepath.FromSlash(next)))
+		if err != nil {
+			return "", err
+		}
+		if !path.IsAbs(target) {
+			target = path.Join(resolved, target)
+		}
+
+		// start over from the target of the link
+		parts = append(strings.Split(strings.Trim(path.Clean("/"+target), "/"), "/"), parts[i+1:]...)
+		resolved = "/"
+		i = -1
+	}
+
+	return filepath.Join(root, filepath.FromSlash(path.Join(resolved, parts[len(parts)-1]))), nil
+}
+
+// applyOCILayer extracts a layer on top of the ones already extracted
+// in root, honoring whiteouts.
+func applyOCILayer(root string, r io.Reader) error {
+	tr := tar.NewReader(r)
+	written := map[string]bool{}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := path.Clean("/" + hdr.Name)
+		if name == "/" {
+			continue
+		}
+		dir, base := path.Split(name)
+
+		// opaque whiteout: hide what lower layers have in dir
+		if base == ".wh..wh..opq" {
+			err = removeLowerEntries(root, path.Clean(dir), written)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if strings.HasPrefix(base, ".wh.") {
+			target, err := ociSecurePath(root, path.Join(dir, strings.TrimPrefix(base, ".wh.")))
+			if err != nil {
+				return err
+			}
+			err = os.RemoveAll(target)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		target, err := ociSecurePath(root, name)
+		if err != nil {
+			return err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if fi, err := os.Lstat(target); err == nil && !fi.IsDir() {
+				os.Remove(target)
+			}
+			err = os.MkdirAll(target, os.FileMode(hdr.Mode)&0777|0700)
+		case tar.TypeReg:
+			err = writeLayerFile(target, tr, os.FileMode(hdr.Mode)&0777|0600)
+		case tar.TypeSymlink:
+			os.RemoveAll(target)
+			err = os.MkdirAll(filepath.Dir(target), 0755)
+			if err == nil {
+				err = os.Symlink(hdr.Linkname, target)
+			}
+		case tar.TypeLink:
+			var src string
+			src, err = ociSecurePath(root, hdr.Linkname)
+			if err != nil {
+				return err
+			}
+			os.RemoveAll(target)
+			if err = os.Link(src, target); err != nil {
+				err = copyLayerFile(src, target)
+			}
+		default:
+			// devices and fifos are of no use in a unikernel
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		written[name] = true
+	}
+}
+
+func writeLayerFile(target string, r io.Reader, mode os.FileMode) error {
+	// never write through a symlink of a lower layer
+	os.RemoveAll(target)
+
+	err := os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(f, r)
+	return err
+}
+
+// copyLayerFile copies src to target for hard links that can't be
+// linked. A symlink src is copied as a symlink, never followed, as it
+// could point anywhere on the host.
+func copyLayerFile(src, target string) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		link, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		err = os.MkdirAll(filepath.Dir(target), 0755)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(link, target)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("hard link to %s which is not a regular file", src)
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	return writeLayerFile(target, in, info.Mode())
+}
+
+// removeLowerEntries removes the entries of dir that weren't written
+// by the current layer.
+func removeLowerEntries(root, dir string, written map[string]bool) error {
+	target, err := ociSecurePath(root, path.Join(dir, "x"))
+	if err != nil {
+		return err
+	}
+	target = filepath.Dir(target)
+
+	entries, err := os.ReadDir(target)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, e := range entries {
+		p := path.Join(dir, e.Name())
+
+		keep := written[p]
+		for w := range written {
+			if strings.HasPrefix(w, p+"/") {
+				keep = true
+				break
+			}
+		}
+		if keep {
+			continue
+		}
+
+		err = os.RemoveAll(filepath.Join(target, e.Name()))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PullOCIImage flattens the layers of the image src for arch into dest
+// and returns its configuration. src is an image reference of a
+// registry, an OCI layout directory or a tarball made by docker save
+// or of an OCI layout. No docker daemon is needed.
+func PullOCIImage(src, arch, dest string) (*OCIImageConfig, error) {
+	arch = normalizePackageArch(archOrHost(arch))
+
+	store, ref, cleanup, err := openOCIStore(src)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	m, err := resolveOCIManifest(store, ref, arch)
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err := store.blob(m.Config.Digest)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(newVerifiedReader(rc, m.Config.Digest))
+	rc.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var config OCIImageConfig
+	err = json.Unmarshal(body, &config)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Architecture != "" && config.Architecture != arch {
+		return nil, fmt.Errorf("image is for %s, not %s", config.Architecture, arch)
+	}
+
+	err = os.MkdirAll(dest, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, l := range m.Layers {
+		rc, err := store.blob(l.Digest)
+		if err != nil {
+			return nil, err
+		}
+
+		err = func() error {
+			defer rc.Close()
+
+			r, err := decompressLayer(newVerifiedReader(rc, l.Digest))
+			if err != nil {
+				return err
+			}
+			err = applyOCILayer(dest, r)
+			if err != nil {
+				return err
+			}
+
+			// read what's after the end of the tar so the digest
+			// gets verified
+			_, err = io.Copy(io.Discard, r)
+			return err
+		}()
+		if err != nil {
+			return nil, fmt.Errorf("layer %s: %v", l.Digest, err)
+		}
+	}
+
+	return &config, nil
+}
+
+// ociEnvPath returns the PATH of the environment of an image.
+func ociEnvPath(env []string) string {
+	for _, e := range env {
+		if v, ok := strings.CutPrefix(e, "PATH="); ok {
+			return v
+		}
+	}
+	return "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+}
+
+// OCIFindExecutable looks the program up in the PATH of the image
+// extracted at root like a shell of the image would and returns its
+// path in the image with symlinks resolved.
+func OCIFindExecutable(root string, config *OCIImageConfig, program string) (string, error) {
+	candidates := []string{program}
+	if !strings.Contains(program, "/") {
+		candidates = nil
+		for _, dir := range strings.Split(ociEnvPath(config.Config.Env), ":") {
+			candidates = append(candidates, path.Join(dir, program))
+		}
+	} else if !path.IsAbs(program) {
+		candidates = []string{path.Join("/", config.Config.WorkingDir, program)}
+	}
+
+	for _, c := range candidates {
+		resolved, err := ociResolve(root, c)
+		if err != nil {
+			continue
+		}
+		if fi, err := os.Stat(filepath.Join(root, filepath.FromSlash(resolved))); err == nil && fi.Mode().IsRegular() {
+			return resolved, nil
+		}
+	}
+
+	return "", fmt.Errorf("%s not found in the image", program)
+}
+
+// ociResolve resolves every symlink of the image path p inside root.
+func ociResolve(root, p string) (string, error) {
+	for hops := 0; hops < 255; hops++ {
+		hostpath, err := ociSecurePath(root, p)
+		if err != nil {
+			return "", err
+		}
+
+		rel, err := filepath.Rel(root, hostpath)
+		if err != nil {
+			return "", err
+		}
+		p = "/" + filepath.ToSlash(rel)
+
+		fi, err := os.Lstat(hostpath)
+		if err != nil {
+			return "", err
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			return p, nil
+		}
+
+		target, err := os.Readlink(hostpath)
+		if err != nil {
+			return "", err
+		}
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(p), target)
+		}
+		p = target
+	}
+
+	return "", fmt.Errorf("too many levels of symbolic links in %s", p)
+}
@@ -0,0 +1,273 @@
+package lepton
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nanovms/ops/testutils"
+)
+
+type tarEntry struct {
+	name     string
+	typeflag byte
+	body     string // or link target
+}
+
+func tarLayer(t *testing.T, entries []tarEntry) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Typeflag: e.typeflag, Mode: 0755}
+		switch e.typeflag {
+		case tar.TypeReg:
+			hdr.Size = int64(len(e.body))
+		case tar.TypeSymlink, tar.TypeLink:
+			hdr.Linkname = e.body
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if e.typeflag == tar.TypeReg {
+			tw.Write([]byte(e.body))
+		}
+	}
+
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestApplyOCILayer(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "rootfs")
+	outside := t.TempDir()
+
+	layers := [][]tarEntry{
+		{
+			{"etc/", tar.TypeDir, ""},
+			{"etc/a", tar.TypeReg, "a"},
+			{"etc/b", tar.TypeReg, "b"},
+			{"var/cache/old", tar.TypeReg, "old"},
+			{"evil", tar.TypeSymlink, outside},
+			{"lib", tar.TypeSymlink, "usr/lib"},
+			{"usr/lib/libc.so", tar.TypeReg, "libc"},
+		},
+		{
+			{"etc/.wh.a", tar.TypeReg, ""},
+			{"var/cache/.wh..wh..opq", tar.TypeReg, ""},
+			{"var/cache/new", tar.TypeReg, "new"},
+			{"evil/pwned", tar.TypeReg, "pwned"},
+			{"../../escape", tar.TypeReg, "escape"},
+			{"lib/libm.so", tar.TypeReg, "libm"},
+			{"etc/b2", tar.TypeLink, "etc/b"},
+		},
+	}
+
+	for _, l := range layers {
+		r, err := decompressLayer(bytes.NewReader(tarLayer(t, l)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := applyOCILayer(root, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, p := range []string{"etc/a", "var/cache/old"} {
+		if _, err := os.Lstat(filepath.Join(root, p)); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be whited out", p)
+		}
+	}
+
+	for p, want := range map[string]string{"etc/b": "b", "etc/b2": "b", "var/cache/new": "new", "usr/lib/libm.so": "libm", "escape": "escape"} {
+		if got := testutils.ReadFile(t, filepath.Join(root, p)); got != want {
+			t.Fatalf("%s: got %q, want %q", p, got, want)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(outside, "pwned")); !os.IsNotExist(err) {
+		t.Fatal("expected a layer to be unable to write through a symlink out of the root")
+	}
+	if testutils.ReadFile(t, filepath.Join(root, outside, "pwned")) != "pwned" {
+		t.Fatal("expected the absolute symlink to be resolved inside the root")
+	}
+}
+
+func TestCopyLayerFileSymlink(t *testing.T) {
+	root := t.TempDir()
+	secret := filepath.Join(t.TempDir(), "secret")
+	testutils.WriteFiles(t, filepath.Dir(secret), map[string]string{"secret": "secret"})
+
+	if err := os.Symlink(secret, filepath.Join(root, "evil")); err != nil {
+		t.Fatal(err)
+	}
+	if err := copyLayerFile(filepath.Join(root, "evil"), filepath.Join(root, "copy")); err != nil {
+		t.Fatal(err)
+	}
+
+	link, err := os.Readlink(filepath.Join(root, "copy"))
+	if err != nil {
+		t.Fatal("expected a hard link to a symlink to be copied as a symlink")
+	}
+	if link != secret {
+		t.Fatalf("got link to %q, want %q", link, secret)
+	}
+
+	if err := os.Mkdir(filepath.Join(root, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := copyLayerFile(filepath.Join(root, "dir"), filepath.Join(root, "dir2")); err == nil {
+		t.Fatal("expected a hard link to a directory to be refused")
+	}
+}
+
+// writeOCILayout writes an image with a manifest per arch in the OCI
+// layout and returns the digest of the index.
+func writeOCILayout(t *testing.T, dir string, arches []string) string {
+	writeBlob := func(body []byte) string {
+		digest := fmt.Sprintf("sha256:%x", sha256.Sum256(body))
+		testutils.WriteFiles(t, dir, map[string]string{
+			"blobs/sha256/" + strings.TrimPrefix(digest, "sha256:"): string(body),
+		})
+		return digest
+	}
+
+	index := ociManifest{MediaType: "application/vnd.oci.image.index.v1+json"}
+	for _, arch := range arches {
+		config := OCIImageConfig{Architecture: arch, OS: "linux"}
+		config.Config.Entrypoint = []string{"app"}
+		config.Config.Cmd = []string{"--port", "8080"}
+		config.Config.Env = []string{"PATH=/usr/bin", "MODE=" + arch}
+		body, _ := json.Marshal(config)
+
+		layer := tarLayer(t, []tarEntry{
+			{"usr/lib/app/app", tar.TypeReg, "app-" + arch},
+			{"usr/bin/app", tar.TypeSymlink, "../lib/app/app"},
+		})
+
+		m := ociManifest{
+			MediaType: "application/vnd.oci.image.manifest.v1+json",
+			Config:    ociDescriptor{Digest: writeBlob(body)},
+			Layers:    []ociDescriptor{{Digest: writeBlob(layer)}},
+		}
+		body, _ = json.Marshal(m)
+
+		index.Manifests = append(index.Manifests, ociDescriptor{
+			MediaType: m.MediaType,
+			Digest:    writeBlob(body),
+			Platform:  &ociPlatform{Architecture: arch, OS: "linux"},
+		})
+	}
+
+	body, _ := json.Marshal(index)
+	testutils.WriteFiles(t, dir, map[string]string{"index.json": string(body)})
+	return writeBlob(body)
+}
+
+func TestPullOCIImageLayout(t *testing.T) {
+	layout := t.TempDir()
+	writeOCILayout(t, layout, []string{"amd64", "arm64"})
+
+	root := t.TempDir()
+	config, err := PullOCIImage(layout, "arm64", root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Architecture != "arm64" || config.Config.Entrypoint[0] != "app" {
+		t.Fatalf("unexpected config %+v", config)
+	}
+
+	exe, err := OCIFindExecutable(root, config, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exe != "/usr/lib/app/app" || testutils.ReadFile(t, filepath.Join(root, exe)) != "app-arm64" {
+		t.Fatalf("unexpected executable %s", exe)
+	}
+
+	if _, err := PullOCIImage(layout, "riscv64", t.TempDir()); err == nil || !strings.Contains(err.Error(), "only amd64, arm64") {
+		t.Fatalf("expected missing arch to be reported, got %v", err)
+	}
+
+	// corrupt the layers
+	blobs, _ := filepath.Glob(filepath.Join(layout, "blobs", "sha256", "*"))
+	for _, b := range blobs {
+		if body := testutils.ReadFile(t, b); bytes.HasPrefix([]byte(body), []byte{0x1f, 0x8b}) {
+			os.WriteFile(b, tarLayer(t, []tarEntry{{"usr/bin/app", tar.TypeReg, "evil"}}), 0644)
+		}
+	}
+	if _, err := PullOCIImage(layout, "arm64", t.TempDir()); err == nil || !strings.Contains(err.Error(), "digest mismatch") {
+		t.Fatalf("expected digest mismatch, got %v", err)
+	}
+}
+
+func TestPullOCIImageRegistry(t *testing.T) {
+	layout := t.TempDir()
+	writeOCILayout(t, layout, []string{"amd64"})
+	store := ociLayout{dir: layout}
+
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			if r.URL.Query().Get("scope") != "repository:acme/app:pull" {
+				http.Error(w, "bad scope", http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"token": "secret"}`))
+			return
+		}
+
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, ts.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		ref, isManifest := strings.CutPrefix(r.URL.Path, "/v2/acme/app/manifests/")
+		if isManifest {
+			if ref == "1.0" {
+				ref = ""
+			}
+			body, err := store.manifest(ref)
+			if err != nil {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write(body)
+			return
+		}
+
+		digest, _ := strings.CutPrefix(r.URL.Path, "/v2/acme/app/blobs/")
+		rc, err := store.blob(digest)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer rc.Close()
+		body, _ := io.ReadAll(rc)
+		w.Write(body)
+	}))
+	defer ts.Close()
+
+	root := t.TempDir()
+	src := strings.TrimPrefix(ts.URL, "http://") + "/acme/app:1.0"
+	config, err := PullOCIImage(src, "amd64", root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Config.Env[1] != "MODE=amd64" || testutils.ReadFile(t, filepath.Join(root, "usr/lib/app/app")) != "app-amd64" {
+		t.Fatalf("unexpected image %+v", config)
+	}
+}
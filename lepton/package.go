@@ -2,7 +2,6 @@ package lepton
 
 import (
 	"archive/tar"
-	"crypto/sha256"
 	"os/exec"
 	"regexp"
 
@@ -15,7 +14,6 @@ import (
 	"os"
 	"path"
 	"path/filepath"
-	"runtime"
 	"strings"
 
 	"github.com/go-errors/errors"
@@ -44,6 +42,13 @@ type Package struct {
 	Name        string `json:"name"`
 	Namespace   string `json:"namespace"`
 	Arch        string `json:"arch"`
+
+	// Dependencies are the packages this one is layered on.
+	Dependencies []string `json:"dependencies,omitempty"`
+
+	// Arches has the sha256 of the archive of each arch (amd64, arm64)
+	// the version was published for.
+	Arches map[string]string `json:"arches,omitempty"`
 }
 
 // PackageIdentifier is used to identify a namespaced package
@@ -85,31 +90,57 @@ func ParseIdentifier(identifier string) PackageIdentifier {
 	}
 }
 
-// DownloadPackage downloads package by identifier
+// DownloadPackage downloads package by identifier. Its dependencies
+// are downloaded and extracted as well.
 func DownloadPackage(identifier string, config *types.Config) (string, error) {
-	pkgIdf := ParseIdentifier(identifier)
+	archive, pkg, err := downloadPackageArchive(identifier, config)
+	if err != nil {
+		return "", err
+	}
 
-	pkg, err := GetPackageMetadata(pkgIdf.Namespace, pkgIdf.Name, pkgIdf.Version)
+	_, err = fetchDependencies(pkg.Dependencies, config, []string{packageIdentifier(pkg)})
 	if err != nil {
 		return "", err
 	}
 
-	if pkg == nil {
-		return "", fmt.Errorf("package %q does not exist", identifier)
+	return archive, nil
+}
+
+// downloadPackageArchive downloads and verifies the archive of a
+// package.
+func downloadPackageArchive(identifier string, config *types.Config) (string, *Package, error) {
+	arch := TargetArch(config)
+
+	lock, err := ReadPackageLock(PackageLockFile)
+	if err != nil {
+		return "", nil, err
+	}
+
+	identifier, err = lock.Pin(identifier, arch)
+	if err != nil {
+		return "", nil, err
+	}
+
+	pkgIdf := ParseIdentifier(identifier)
+	pkg, source, err := resolvePackage(config, pkgIdf.Namespace, pkgIdf.Name, pkgIdf.Version, arch)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if pkg == nil || !pkg.hasArch(arch) {
+		return "", nil, missingPackageError(config, identifier, arch)
+	}
+
+	// releases published for several arches have a sha256 per arch
+	if sha, ok := pkg.Arches[normalizePackageArch(archOrHost(arch))]; ok {
+		pkg.SHA256 = sha
 	}
 
 	fullpkgq := pkg.Namespace + "/" + pkg.Name + "_" + pkg.Version
-	archivename := pkg.Namespace + "/" + pkg.Name + "_" + pkg.Version + ".tar.gz"
 
 	parchpath := "amd64"
-	if AltGOARCH != "" {
-		if AltGOARCH == "arm64" {
-			parchpath = "arm64"
-		}
-	} else {
-		if RealGOARCH == "arm64" {
-			parchpath = "arm64"
-		}
+	if arch == "arm64" {
+		parchpath = "arm64"
 	}
 
 	archiveFolder := path.Join(PackagesRoot, fullpkgq)
@@ -117,77 +148,65 @@ func DownloadPackage(identifier string, config *types.Config) (string, error) {
 
 	packagepath := path.Join(PackagesRoot, fullpkgq+"/"+parchpath+".tar.gz")
 
-	_, err = os.Stat(packagepath)
-	if err != nil && !os.IsNotExist(err) {
-		return "", err
-	}
-
-	if err == nil {
-		return packagepath, nil
-	}
-
-	archivePath := pkg.Namespace + "/" + pkg.Name + "/" + pkg.Version + ".tar.gz"
+	srcURL := source.archiveURL(pkg, arch)
+	isNetworkRepo := source.dir == "" && source.legacyDir == ""
 
-	pkgBaseURL := PackageBaseURL
-
-	// Check config override
-	if config != nil {
-		cPkgBaseURL := strings.Trim(config.PackageBaseURL, " ")
-		if len(cPkgBaseURL) > 0 {
-			pkgBaseURL = cPkgBaseURL
+	if locked := lock.Find(pkg.Namespace, pkg.Name, arch); locked != nil {
+		err = locked.check(pkg, srcURL)
+		if err != nil {
+			return "", nil, err
 		}
 	}
 
-	// Check environment variable override
-	ePkgBaseURL := os.Getenv("OPS_PACKAGE_BASE_URL")
-	if ePkgBaseURL != "" {
-		pkgBaseURL = ePkgBaseURL
+	_, err = os.Stat(packagepath)
+	if err != nil && !os.IsNotExist(err) {
+		return "", nil, err
 	}
 
-	isNetworkRepo := !strings.HasPrefix(pkgBaseURL, "file://")
-	if isNetworkRepo {
-		var fileURL string
-		if strings.HasSuffix(pkgBaseURL, "/") {
-			fileURL = pkgBaseURL + archivePath
+	if os.IsNotExist(err) {
+		if isNetworkRepo {
+			err = downloadFile(packagepath, srcURL, 600, true, source.apiKey(srcURL))
 		} else {
-			fileURL = fmt.Sprintf("%s/%s", pkgBaseURL, archivePath)
+			err = copyWithProgress(srcURL, packagepath)
 		}
-
-		if AltGOARCH != "" {
-			if AltGOARCH == "arm64" {
-				fileURL = strings.ReplaceAll(fileURL, ".tar.gz", "/arm64.tar.gz")
-			}
-
-		} else {
-			if runtime.GOARCH == "arm64" {
-				fileURL = strings.ReplaceAll(fileURL, ".tar.gz", "/arm64.tar.gz")
-			}
+		if err != nil {
+			return "", nil, err
 		}
+	}
+	touchCacheEntry(path.Dir(packagepath))
 
-		if err = DownloadFileWithProgress(packagepath, fileURL, 600); err != nil {
-			return "", err
-		}
+	// cached archives are verified as well in case they were tampered
+	// with
+	sig, err := fetchPackageSignature(srcURL+PackageSignatureSuffix, source.apiKey(srcURL))
+	if err != nil {
+		return "", nil, err
+	}
 
-		return packagepath, nil
+	requireSigned := config != nil && config.RequireSignedPackages
+	err = VerifyPackageArchive(packagepath, pkg, sig, requireSigned)
+	if err != nil {
+		os.Remove(packagepath)
+		return "", nil, err
 	}
 
-	pkgBaseURL = strings.TrimPrefix(pkgBaseURL, "file://")
-	srcPath := filepath.Join(pkgBaseURL, archivename)
+	return packagepath, pkg, nil
+}
 
-	srcFile, err := os.Open(srcPath)
+func copyWithProgress(src string, dst string) error {
+	srcFile, err := os.Open(src)
 	if err != nil {
-		return "", err
+		return err
 	}
 	defer srcFile.Close()
 
 	srcStat, err := srcFile.Stat()
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	destFile, err := os.Create(packagepath)
+	destFile, err := os.Create(dst)
 	if err != nil {
-		return "", err
+		return err
 	}
 	defer destFile.Close()
 
@@ -195,34 +214,25 @@ func DownloadPackage(identifier string, config *types.Config) (string, error) {
 	progressCounter.Start()
 	_, err = io.Copy(destFile, io.TeeReader(srcFile, progressCounter))
 	if err != nil {
-		return "", err
+		return err
 	}
 	progressCounter.Finish()
 
-	return packagepath, nil
+	return nil
 }
 
-// GetPackageList provides list of packages
+// GetPackageList provides list of packages of all package sources
 func GetPackageList(config *types.Config) (*PackageList, error) {
-	var err error
-
-	pkgManifestURL := PackageManifestURL
-
-	// Check config override
-	if config != nil {
-		cPkgManifestURL := strings.Trim(config.PackageManifestURL, " ")
-		if len(cPkgManifestURL) > 0 {
-			pkgManifestURL = cPkgManifestURL
-		}
-	}
+	return mergePackageLists(config, func(s packageSource) ([]Package, error) {
+		return s.list()
+	})
+}
 
-	// Check environment var override
-	ePkgManifestURL := os.Getenv("OPS_PACKAGE_MANIFEST_URL")
-	if ePkgManifestURL != "" {
-		pkgManifestURL = ePkgManifestURL
-	}
+// readPackageManifest fetches the manifest at pkgManifestURL to
+// packageManifest and reads it.
+func readPackageManifest(pkgManifestURL string, packageManifest string) (*PackageList, error) {
+	var err error
 
-	packageManifest := GetPackageManifestFile()
 	if strings.HasPrefix(pkgManifestURL, "file://") {
 		destFile, err := os.OpenFile(packageManifest, os.O_RDWR|os.O_TRUNC|os.O_CREATE, 0666)
 		if err != nil {
@@ -292,31 +302,14 @@ func PackageManifestChanged(fino os.FileInfo, remoteURL string) bool {
 	return fino.Size() != res.ContentLength
 }
 
-func sha256Of(filename string) string {
-	f, err := os.Open(filename)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer f.Close()
-
-	h := sha256.New()
-	if _, err := io.Copy(h, f); err != nil {
-		log.Fatal(err)
-	}
-
-	return fmt.Sprintf("%x", h.Sum(nil))
-}
-
 // ExtractPackage extracts package in ops home.
 // This function is currently over-loaded.
 func ExtractPackage(archive, dest string, config *types.Config) {
-	sha := sha256Of(archive)
 	homeDirName := filepath.Base(GetOpsHome())
 
 	// hack
-	// this only verifies for packages - unfortunately this function is
-	// used for extracting releases (which currently don't have
-	// checksums)
+	// downloaded packages are extracted to packages/<arch>/<namespace>;
+	// they were already verified by DownloadPackage.
 	if strings.Contains(archive, filepath.Join(homeDirName, "packages")) {
 		d := filepath.Base(archive)
 		dest = strings.ReplaceAll(d, ".tar.gz", "")
@@ -325,19 +318,8 @@ func ExtractPackage(archive, dest string, config *types.Config) {
 		st := strings.Split(archive, homeDirName+"/packages/")
 		st = strings.Split(st[1], "/")
 		namespace := st[0]
-		fname := st[1]
 
 		dest = dest + "/" + namespace
-
-		fnameTokens := strings.Split(fname, "_")
-		pkgName := fnameTokens[0]
-		version := fnameTokens[len(fnameTokens)-1]
-
-		pkg, _ := GetPackageMetadata(namespace, pkgName, version)
-		if pkg == nil || pkg.SHA256 != sha {
-			log.Fatalf("This package doesn't match what is in the manifest.")
-		}
-
 	}
 
 	in, err := os.Open(archive)
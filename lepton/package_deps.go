@@ -0,0 +1,251 @@
+package lepton
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/nanovms/ops/fs"
+	"github.com/nanovms/ops/types"
+)
+
+func packageIdentifier(pkg *Package) string {
+	return pkg.Namespace + "/" + pkg.Name + ":" + pkg.Version
+}
+
+// PackageDirectory is where the package identified by identifier is
+// extracted to for arch.
+func PackageDirectory(identifier, arch string) string {
+	parch := "amd64"
+	if archOrHost(arch) == "arm64" {
+		parch = "arm64"
+	}
+
+	return path.Join(PackagesRoot, parch, strings.ReplaceAll(identifier, ":", "_"))
+}
+
+// ManifestDependencies returns the dependencies declared in the
+// package.manifest of the package extracted at dir.
+func ManifestDependencies(dir string) ([]string, error) {
+	body, err := os.ReadFile(filepath.Join(dir, "package.manifest"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var manifest struct {
+		Dependencies []string
+	}
+	err = json.Unmarshal(body, &manifest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid package manifest in %s: %v", dir, err)
+	}
+
+	return manifest.Dependencies, nil
+}
+
+// fetchDependencies makes sure deps and their own dependencies are
+// extracted and returns their directories, each package before its own
+// dependencies. stack holds the packages depending on deps to catch
+// cycles.
+func fetchDependencies(deps []string, config *types.Config, stack []string) ([]string, error) {
+	arch := TargetArch(config)
+	dirs := []string{}
+
+	for _, dep := range deps {
+		pinned, err := PinPackage(dep, arch)
+		if err != nil {
+			return nil, err
+		}
+
+		if ParseIdentifier(pinned).Version == "latest" {
+			return nil, fmt.Errorf("dependency %s needs a version", dep)
+		}
+
+		for _, s := range stack {
+			if s == pinned {
+				return nil, fmt.Errorf("dependency cycle: %s -> %s", strings.Join(stack, " -> "), pinned)
+			}
+		}
+
+		var subdeps []string
+
+		dir := PackageDirectory(pinned, arch)
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			archive, pkg, err := downloadPackageArchive(pinned, config)
+			if err != nil {
+				return nil, fmt.Errorf("dependency %s: %v", pinned, err)
+			}
+
+			ExtractPackage(archive, "", config)
+			os.Remove(archive)
+
+			subdeps = append(subdeps, pkg.Dependencies...)
+		}
+
+		manifestDeps, err := ManifestDependencies(dir)
+		if err != nil {
+			return nil, err
+		}
+		subdeps = appendMissing(subdeps, manifestDeps...)
+
+		dirs = appendMissing(dirs, dir)
+
+		subdirs, err := fetchDependencies(subdeps, config, append(stack, pinned))
+		if err != nil {
+			return nil, err
+		}
+		dirs = appendMissing(dirs, subdirs...)
+	}
+
+	return dirs, nil
+}
+
+func appendMissing(list []string, items ...string) []string {
+	for _, item := range items {
+		found := false
+		for _, l := range list {
+			if l == item {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// sysrootFiles returns the files and symlinks of the sysroot of the
+// package at dir by image path.
+func sysrootFiles(dir string) (map[string]string, error) {
+	root := filepath.Join(dir, "sysroot")
+	files := map[string]string{}
+
+	err := filepath.Walk(root, func(hostpath string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && hostpath == root {
+				return nil
+			}
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, hostpath)
+		if err != nil {
+			return err
+		}
+		files["/"+filepath.ToSlash(rel)] = hostpath
+		return nil
+	})
+
+	return files, err
+}
+
+// sameFile tells if two sysroot entries have the same content.
+func sameFile(a, b string) bool {
+	ia, err := os.Lstat(a)
+	if err != nil {
+		return false
+	}
+	ib, err := os.Lstat(b)
+	if err != nil {
+		return false
+	}
+
+	if ia.Mode()&os.ModeSymlink != 0 || ib.Mode()&os.ModeSymlink != 0 {
+		la, erra := os.Readlink(a)
+		lb, errb := os.Readlink(b)
+		return erra == nil && errb == nil && la == lb
+	}
+
+	if ia.Size() != ib.Size() {
+		return false
+	}
+
+	sa, err := FileSHA256(a)
+	if err != nil {
+		return false
+	}
+	sb, err := FileSHA256(b)
+	if err != nil {
+		return false
+	}
+	return sa == sb
+}
+
+// addDependencyFiles merges the sysroots of the dependencies in dirs
+// beneath the files of the package at packagepath: files of the package
+// win over those of its dependencies, and dependencies providing
+// different files at the same path are a conflict.
+func addDependencyFiles(m *fs.Manifest, packagepath string, dirs []string) error {
+	own, err := sysrootFiles(packagepath)
+	if err != nil {
+		return err
+	}
+
+	added := map[string]string{}
+	owner := map[string]string{}
+	conflicts := []string{}
+
+	for _, dir := range dirs {
+		files, err := sysrootFiles(dir)
+		if err != nil {
+			return err
+		}
+
+		paths := make([]string, 0, len(files))
+		for p := range files {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+
+		for _, p := range paths {
+			hostpath := files[p]
+
+			if _, ok := own[p]; ok {
+				continue
+			}
+
+			if prev, ok := added[p]; ok {
+				if !sameFile(prev, hostpath) {
+					conflicts = append(conflicts, fmt.Sprintf("%s (%s, %s)", p, filepath.Base(owner[p]), filepath.Base(dir)))
+				}
+				continue
+			}
+
+			info, err := os.Lstat(hostpath)
+			if err != nil {
+				return err
+			}
+
+			if info.Mode()&os.ModeSymlink != 0 {
+				err = m.AddLink(p, hostpath)
+			} else {
+				err = m.AddFile(p, hostpath)
+			}
+			if err != nil {
+				return err
+			}
+
+			added[p] = hostpath
+			owner[p] = dir
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return fmt.Errorf("conflicting files in package dependencies:\n\t%s", strings.Join(conflicts, "\n\t"))
+	}
+
+	return nil
+}
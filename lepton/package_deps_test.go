@@ -0,0 +1,100 @@
+package lepton
+
+import (
+	"encoding/json"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nanovms/ops/fs"
+	"github.com/nanovms/ops/testutils"
+	"github.com/nanovms/ops/types"
+)
+
+func writeTestPackage(t *testing.T, dir string, deps []string, files map[string]string) {
+	body, err := json.Marshal(types.Config{Program: "/bin/app", Dependencies: deps})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkgFiles := map[string]string{"package.manifest": string(body)}
+	for p, content := range files {
+		pkgFiles[path.Join("sysroot", p)] = content
+	}
+	testutils.WriteFiles(t, dir, pkgFiles)
+}
+
+func withTestPackagesRoot(t *testing.T) {
+	old := PackagesRoot
+	PackagesRoot = t.TempDir()
+	t.Cleanup(func() { PackagesRoot = old })
+}
+
+func TestFetchDependencies(t *testing.T) {
+	withTestPackagesRoot(t)
+
+	writeTestPackage(t, PackageDirectory("base/python:3.11", "amd64"), []string{"base/openssl:3.0"}, nil)
+	writeTestPackage(t, PackageDirectory("base/openssl:3.0", "amd64"), nil, nil)
+	writeTestPackage(t, PackageDirectory("base/zlib:1.3", "amd64"), []string{"base/openssl:3.0"}, nil)
+
+	c := &types.Config{Arch: "amd64"}
+
+	dirs, err := fetchDependencies([]string{"base/python:3.11", "base/zlib:1.3"}, c, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		PackageDirectory("base/python:3.11", "amd64"),
+		PackageDirectory("base/openssl:3.0", "amd64"),
+		PackageDirectory("base/zlib:1.3", "amd64"),
+	}
+	if strings.Join(dirs, ",") != strings.Join(want, ",") {
+		t.Fatalf("got %v, want %v", dirs, want)
+	}
+
+	if _, err := fetchDependencies([]string{"base/python"}, c, nil); err == nil {
+		t.Fatal("expected dependency without version to be refused")
+	}
+
+	writeTestPackage(t, PackageDirectory("base/openssl:3.0", "amd64"), []string{"base/python:3.11"}, nil)
+	_, err = fetchDependencies([]string{"base/python:3.11"}, c, nil)
+	if err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Fatalf("expected dependency cycle, got %v", err)
+	}
+}
+
+func TestAddDependencyFiles(t *testing.T) {
+	root := t.TempDir()
+
+	app := filepath.Join(root, "app")
+	python := filepath.Join(root, "python")
+	openssl := filepath.Join(root, "openssl")
+
+	writeTestPackage(t, app, nil, map[string]string{"/app/main.py": "print(1)", "/etc/ssl/openssl.cnf": "app"})
+	writeTestPackage(t, python, nil, map[string]string{"/usr/bin/python3": "python", "/lib/libssl.so.3": "ssl"})
+	writeTestPackage(t, openssl, nil, map[string]string{"/lib/libssl.so.3": "ssl", "/etc/ssl/openssl.cnf": "default"})
+
+	m := fs.NewManifest("")
+	if err := addDependencyFiles(m, app, []string{python, openssl}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{"/usr/bin/python3", "/lib/libssl.so.3"} {
+		if !m.FileExists(p) {
+			t.Fatalf("expected %s from a dependency", p)
+		}
+	}
+
+	// files of the package itself are added by addFilesFromPackage
+	if m.FileExists("/etc/ssl/openssl.cnf") {
+		t.Fatal("expected the file of the package to win over its dependency")
+	}
+
+	writeTestPackage(t, openssl, nil, map[string]string{"/lib/libssl.so.3": "other ssl"})
+	err := addDependencyFiles(fs.NewManifest(""), app, []string{python, openssl})
+	if err == nil || !strings.Contains(err.Error(), "/lib/libssl.so.3") {
+		t.Fatalf("expected conflict on libssl, got %v", err)
+	}
+}
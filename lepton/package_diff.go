@@ -0,0 +1,180 @@
+package lepton
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+)
+
+// Kinds of changes of a file between two packages.
+const (
+	FileAdded    = "added"
+	FileRemoved  = "removed"
+	FileModified = "modified"
+)
+
+// ManifestFieldChange is a field of package.manifest that differs.
+type ManifestFieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// FileChange is a file of the sysroot that differs.
+type FileChange struct {
+	Path      string `json:"path"`
+	Change    string `json:"change"`
+	OldSize   int64  `json:"old_size,omitempty"`
+	NewSize   int64  `json:"new_size,omitempty"`
+	OldSHA256 string `json:"old_sha256,omitempty"`
+	NewSHA256 string `json:"new_sha256,omitempty"`
+}
+
+// PackageDiff is what changes between two packages.
+type PackageDiff struct {
+	Manifest []ManifestFieldChange `json:"manifest"`
+	Files    []FileChange          `json:"files"`
+}
+
+// Empty tells if both packages are the same.
+func (d *PackageDiff) Empty() bool {
+	return len(d.Manifest) == 0 && len(d.Files) == 0
+}
+
+// diffedManifestFields are the fields of package.manifest compared.
+var diffedManifestFields = []string{"Program", "Args", "Env", "Klibs", "Dirs"}
+
+func readManifestFields(dir string) (map[string]any, error) {
+	fields := map[string]any{}
+
+	body, err := os.ReadFile(filepath.Join(dir, "package.manifest"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fields, nil
+		}
+		return nil, err
+	}
+
+	err = json.Unmarshal(body, &fields)
+	if err != nil {
+		return nil, fmt.Errorf("invalid package manifest in %s: %v", dir, err)
+	}
+	return fields, nil
+}
+
+func manifestFieldString(v any) string {
+	if v == nil {
+		return ""
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	body, _ := json.Marshal(v)
+	return string(body)
+}
+
+type sysrootFile struct {
+	size int64
+	sha  string
+}
+
+// sysrootTree returns the files of the sysroot of the package at dir;
+// symlinks are hashed by their target.
+func sysrootTree(dir string) (map[string]sysrootFile, error) {
+	root := filepath.Join(dir, PackageSysRootFolderName)
+	files := map[string]sysrootFile{}
+
+	err := filepath.Walk(root, func(hostpath string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && hostpath == root {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, hostpath)
+		if err != nil {
+			return err
+		}
+
+		f := sysrootFile{size: info.Size()}
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(hostpath)
+			if err != nil {
+				return err
+			}
+			f.sha = "-> " + target
+		} else {
+			f.sha, err = FileSHA256(hostpath)
+			if err != nil {
+				return err
+			}
+		}
+
+		files["/"+filepath.ToSlash(rel)] = f
+		return nil
+	})
+
+	return files, err
+}
+
+// DiffPackages compares the manifests and sysroots of the packages
+// extracted at oldDir and newDir.
+func DiffPackages(oldDir, newDir string) (*PackageDiff, error) {
+	diff := &PackageDiff{Manifest: []ManifestFieldChange{}, Files: []FileChange{}}
+
+	oldFields, err := readManifestFields(oldDir)
+	if err != nil {
+		return nil, err
+	}
+	newFields, err := readManifestFields(newDir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range diffedManifestFields {
+		if !reflect.DeepEqual(oldFields[f], newFields[f]) {
+			diff.Manifest = append(diff.Manifest, ManifestFieldChange{
+				Field: f,
+				Old:   manifestFieldString(oldFields[f]),
+				New:   manifestFieldString(newFields[f]),
+			})
+		}
+	}
+
+	oldFiles, err := sysrootTree(oldDir)
+	if err != nil {
+		return nil, err
+	}
+	newFiles, err := sysrootTree(newDir)
+	if err != nil {
+		return nil, err
+	}
+
+	for p, o := range oldFiles {
+		n, ok := newFiles[p]
+		switch {
+		case !ok:
+			diff.Files = append(diff.Files, FileChange{Path: p, Change: FileRemoved, OldSize: o.size, OldSHA256: o.sha})
+		case o.sha != n.sha:
+			diff.Files = append(diff.Files, FileChange{Path: p, Change: FileModified, OldSize: o.size, NewSize: n.size, OldSHA256: o.sha, NewSHA256: n.sha})
+		}
+	}
+	for p, n := range newFiles {
+		if _, ok := oldFiles[p]; !ok {
+			diff.Files = append(diff.Files, FileChange{Path: p, Change: FileAdded, NewSize: n.size, NewSHA256: n.sha})
+		}
+	}
+
+	sort.Slice(diff.Files, func(i, j int) bool {
+		return diff.Files[i].Path < diff.Files[j].Path
+	})
+
+	return diff, nil
+}
@@ -0,0 +1,109 @@
+package lepton
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nanovms/ops/testutils"
+)
+
+func TestDiffPackages(t *testing.T) {
+	oldDir, newDir := t.TempDir(), t.TempDir()
+
+	testutils.WriteFiles(t, oldDir, map[string]string{
+		"package.manifest":        `{"Program": "node_18/node", "Args": ["node"], "Env": {"A": "1"}, "Version": "18"}`,
+		"sysroot/lib/libc.so":     "libc",
+		"sysroot/lib/libssl.so.1": "ssl1",
+		"sysroot/etc/hosts":       "hosts",
+	})
+	testutils.WriteFiles(t, newDir, map[string]string{
+		"package.manifest":        `{"Program": "node_20/node", "Args": ["node"], "Env": {"A": "2"}, "Version": "20"}`,
+		"sysroot/lib/libc.so":     "libc-new",
+		"sysroot/lib/libssl.so.3": "ssl3",
+		"sysroot/etc/hosts":       "hosts",
+	})
+
+	diff, err := DiffPackages(oldDir, newDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(diff.Manifest) != 2 || diff.Manifest[0].Field != "Program" || diff.Manifest[1].Field != "Env" {
+		t.Fatalf("unexpected manifest changes %+v", diff.Manifest)
+	}
+	if diff.Manifest[1].Old != `{"A":"1"}` || diff.Manifest[1].New != `{"A":"2"}` {
+		t.Fatalf("unexpected env change %+v", diff.Manifest[1])
+	}
+
+	want := []struct{ path, change string }{
+		{"/lib/libc.so", FileModified},
+		{"/lib/libssl.so.1", FileRemoved},
+		{"/lib/libssl.so.3", FileAdded},
+	}
+	if len(diff.Files) != len(want) {
+		t.Fatalf("unexpected file changes %+v", diff.Files)
+	}
+	for i, w := range want {
+		if diff.Files[i].Path != w.path || diff.Files[i].Change != w.change {
+			t.Fatalf("got %+v, want %+v", diff.Files[i], w)
+		}
+	}
+	if diff.Files[0].OldSize != 4 || diff.Files[0].NewSize != 8 {
+		t.Fatalf("unexpected sizes %+v", diff.Files[0])
+	}
+
+	same, err := DiffPackages(oldDir, oldDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !same.Empty() {
+		t.Fatalf("expected no changes, got %+v", same)
+	}
+}
+
+func TestOutdatedPackages(t *testing.T) {
+	local := []CacheEntry{
+		{Kind: CacheKindPackage, Name: "eyberg/node", Version: "18.9.0", Arch: "amd64"},
+		{Kind: CacheKindPackage, Name: "eyberg/node", Version: "18.10.0", Arch: "amd64"},
+		{Kind: CacheKindPackage, Name: "eyberg/redis", Version: "7.0.0", Arch: "arm64"},
+		{Kind: CacheKindPackage, Name: "eyberg/nginx", Version: "latest", Arch: "amd64"},
+		{Kind: CacheKindArchive, Name: "eyberg/python", Version: "3.8"},
+	}
+	remote := []Package{
+		{Namespace: "eyberg", Name: "node", Version: "18.10.0", Arch: "x86_64"},
+		{Namespace: "eyberg", Name: "node", Version: "v20.5.0", Arch: "x86_64"},
+		{Namespace: "eyberg", Name: "node", Version: "21.0.0", Arch: "arm64"},
+		{Namespace: "eyberg", Name: "redis", Version: "7.0.0", Arch: "arm64"},
+		{Namespace: "eyberg", Name: "nginx", Version: "1.25.0", Arch: "x86_64"},
+		{Namespace: "eyberg", Name: "python", Version: "3.12", Arch: "x86_64"},
+	}
+
+	outdated := outdatedPackages(local, remote)
+	if len(outdated) != 1 {
+		t.Fatalf("unexpected outdated packages %+v", outdated)
+	}
+	if o := outdated[0]; o.Name != "eyberg/node" || o.Arch != "amd64" || o.Version != "18.10.0" || o.Latest != "v20.5.0" {
+		t.Fatalf("unexpected outdated package %+v", o)
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	long := strings.Repeat("9", 300)
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.10.0", "1.9.2", 1},
+		{"1.9.2", "1.10.0", -1},
+		{"1.02", "1.2", 0},
+		{"1.0", "1.0-rc1", -1},
+		{"1.0a", "1.0b", -1},
+		{"1." + long, "1.1", 1},
+		{"1." + long + "8", "1." + long + "9", -1},
+	}
+	for _, tt := range tests {
+		if got := CompareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package lepton
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/nanovms/ops/log"
+	"github.com/nanovms/ops/types"
+)
+
+// PackageLockFile is the lockfile honoured by package downloads; it is
+// read from the working directory.
+var PackageLockFile = "ops.lock"
+
+// LockedPackage pins a package to an exact archive.
+type LockedPackage struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	Arch      string `json:"arch"`
+	SHA256    string `json:"sha256"`
+	Source    string `json:"source"`
+}
+
+// Identifier returns <namespace>/<name>:<version>.
+func (lp LockedPackage) Identifier() string {
+	return lp.Namespace + "/" + lp.Name + ":" + lp.Version
+}
+
+// PackageLock is the content of ops.lock.
+type PackageLock struct {
+	Version  int             `json:"version"`
+	Packages []LockedPackage `json:"packages"`
+}
+
+// ReadPackageLock reads the lockfile at file. It returns nil if there is
+// none.
+func ReadPackageLock(file string) (*PackageLock, error) {
+	body, err := os.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	lock := &PackageLock{}
+	err = json.Unmarshal(body, lock)
+	if err != nil {
+		return nil, fmt.Errorf("invalid lockfile %s: %v", file, err)
+	}
+
+	return lock, nil
+}
+
+// Write writes the lock to file.
+func (l *PackageLock) Write(file string) error {
+	l.Version = 1
+	sort.Slice(l.Packages, func(i, j int) bool {
+		a, b := l.Packages[i], l.Packages[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Arch < b.Arch
+	})
+
+	body, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(file, append(body, '\n'), 0644)
+}
+
+// Find returns the locked package for arch or nil if it isn't locked.
+func (l *PackageLock) Find(ns, name, arch string) *LockedPackage {
+	if l == nil {
+		return nil
+	}
+
+	for i := range l.Packages {
+		p := &l.Packages[i]
+		if p.Namespace == ns && p.Name == name && p.Arch == archOrHost(arch) {
+			return p
+		}
+	}
+	return nil
+}
+
+// Add adds p replacing the package it supersedes.
+func (l *PackageLock) Add(p LockedPackage) {
+	if old := l.Find(p.Namespace, p.Name, p.Arch); old != nil {
+		*old = p
+		return
+	}
+	l.Packages = append(l.Packages, p)
+}
+
+// Pin resolves identifier against the lock: a package without version
+// or with latest gets the locked version and a version other than the
+// locked one is an error. Packages that aren't locked are left as is.
+func (l *PackageLock) Pin(identifier, arch string) (string, error) {
+	pkgIdf := ParseIdentifier(identifier)
+
+	locked := l.Find(pkgIdf.Namespace, pkgIdf.Name, arch)
+	if locked == nil {
+		return identifier, nil
+	}
+
+	if pkgIdf.Version != "latest" && pkgIdf.Version != locked.Version {
+		return "", fmt.Errorf("%s: %s is locked to version %s in %s, run 'ops pkg lock' to update it", identifier, pkgIdf.Namespace+"/"+pkgIdf.Name, locked.Version, PackageLockFile)
+	}
+
+	return locked.Identifier(), nil
+}
+
+// check compares the package about to be downloaded from srcURL with
+// the locked one.
+func (lp *LockedPackage) check(pkg *Package, srcURL string) error {
+	if pkg.Version != lp.Version {
+		return fmt.Errorf("%s: resolved version %s but %s is locked", PackageLockFile, pkg.Version, lp.Identifier())
+	}
+
+	if pkg.SHA256 != lp.SHA256 {
+		return fmt.Errorf("%s: sha256 of %s is %s but %s is locked", PackageLockFile, lp.Identifier(), pkg.SHA256, lp.SHA256)
+	}
+
+	if srcURL != lp.Source {
+		log.Warnf("%s is downloaded from %s instead of %s", lp.Identifier(), srcURL, lp.Source)
+	}
+
+	return nil
+}
+
+// PinPackage pins identifier to the version locked in ops.lock, if any.
+func PinPackage(identifier, arch string) (string, error) {
+	lock, err := ReadPackageLock(PackageLockFile)
+	if err != nil {
+		return "", err
+	}
+
+	return lock.Pin(identifier, arch)
+}
+
+// LockPackage resolves identifier against the package sources and
+// returns the entry to record in ops.lock.
+func LockPackage(config *types.Config, identifier, arch string) (*LockedPackage, error) {
+	locked, _, err := lockPackage(config, identifier, arch)
+	return locked, err
+}
+
+// LockPackageWithDependencies returns the entries to record in ops.lock
+// for identifier and, recursively, the dependencies it declares.
+func LockPackageWithDependencies(config *types.Config, identifier, arch string) ([]LockedPackage, error) {
+	return lockDependencies(config, []string{identifier}, arch, nil, map[string]bool{})
+}
+
+// lockDependencies locks deps and their own dependencies. stack holds
+// the packages depending on deps to catch cycles and seen the packages
+// already locked.
+func lockDependencies(config *types.Config, deps []string, arch string, stack []string, seen map[string]bool) ([]LockedPackage, error) {
+	locks := []LockedPackage{}
+	for _, dep := range deps {
+		locked, pkg, err := lockPackage(config, dep, arch)
+		if err != nil {
+			if len(stack) > 0 {
+				return nil, fmt.Errorf("dependency %s of %s: %v", dep, stack[len(stack)-1], err)
+			}
+			return nil, err
+		}
+
+		id := locked.Identifier()
+		for _, s := range stack {
+			if s == id {
+				return nil, fmt.Errorf("dependency cycle: %s -> %s", strings.Join(stack, " -> "), id)
+			}
+		}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		locks = append(locks, *locked)
+
+		for _, d := range pkg.Dependencies {
+			if ParseIdentifier(d).Version == "latest" {
+				return nil, fmt.Errorf("dependency %s of %s needs a version", d, id)
+			}
+		}
+
+		sub, err := lockDependencies(config, pkg.Dependencies, arch, append(stack, id), seen)
+		if err != nil {
+			return nil, err
+		}
+		locks = append(locks, sub...)
+	}
+	return locks, nil
+}
+
+func lockPackage(config *types.Config, identifier, arch string) (*LockedPackage, *Package, error) {
+	pkgIdf := ParseIdentifier(identifier)
+	arch = archOrHost(arch)
+
+	pkg, source, err := resolvePackage(config, pkgIdf.Namespace, pkgIdf.Name, pkgIdf.Version, arch)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if pkg == nil {
+		return nil, nil, fmt.Errorf("package %q does not exist", identifier)
+	}
+
+	if pkg.SHA256 == "" {
+		return nil, nil, fmt.Errorf("%s has no sha256 and can't be locked", identifier)
+	}
+
+	return &LockedPackage{
+		Namespace: pkg.Namespace,
+		Name:      pkg.Name,
+		Version:   pkg.Version,
+		Arch:      arch,
+		SHA256:    pkg.SHA256,
+		Source:    source.archiveURL(pkg, arch),
+	}, pkg, nil
+}
@@ -0,0 +1,118 @@
+package lepton
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nanovms/ops/types"
+)
+
+func TestPackageLockPin(t *testing.T) {
+	lock := &PackageLock{}
+	lock.Add(LockedPackage{Namespace: "eyberg", Name: "redis", Version: "7.0.0", Arch: "amd64"})
+	lock.Add(LockedPackage{Namespace: "eyberg", Name: "redis", Version: "6.0.0", Arch: "arm64"})
+
+	tests := []struct {
+		identifier string
+		arch       string
+		want       string
+		fail       bool
+	}{
+		{"eyberg/redis", "amd64", "eyberg/redis:7.0.0", false},
+		{"eyberg/redis:latest", "amd64", "eyberg/redis:7.0.0", false},
+		{"eyberg/redis:latest", "arm64", "eyberg/redis:6.0.0", false},
+		{"eyberg/redis:7.0.0", "amd64", "eyberg/redis:7.0.0", false},
+		{"eyberg/redis:7.2.0", "amd64", "", true},
+		{"eyberg/node:20.0.0", "amd64", "eyberg/node:20.0.0", false},
+	}
+
+	for _, tt := range tests {
+		got, err := lock.Pin(tt.identifier, tt.arch)
+		if tt.fail {
+			if err == nil {
+				t.Fatalf("%s: expected version mismatch", tt.identifier)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Fatalf("%s: got %s, want %s", tt.identifier, got, tt.want)
+		}
+	}
+
+	var none *PackageLock
+	if got, err := none.Pin("eyberg/redis:latest", "amd64"); err != nil || got != "eyberg/redis:latest" {
+		t.Fatalf("expected no lock to leave identifier as is, got %s %v", got, err)
+	}
+}
+
+func TestLockAndDownloadPackage(t *testing.T) {
+	withTestPackagesRoot(t)
+
+	dir := t.TempDir()
+	writeLocalPackage(t, dir, localPackage{Package: Package{Namespace: "acme", Name: "api", Version: "1.0.0", Arch: "x86_64", SHA256: "abc"}})
+
+	c := &types.Config{Arch: "amd64", PackageSources: []types.PackageSource{{Name: "local", URL: "file://" + dir}}}
+
+	locked, err := LockPackage(c, "acme/api:latest", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if locked.Version != "1.0.0" || locked.SHA256 != "abc" || locked.Source != filepath.Join(dir, "acme", "api", "1.0.0.tar.gz") {
+		t.Fatalf("unexpected lock %+v", locked)
+	}
+
+	lockFile := filepath.Join(t.TempDir(), "ops.lock")
+	lock := &PackageLock{}
+	locked.SHA256 = "def"
+	lock.Add(*locked)
+	if err := lock.Write(lockFile); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := ReadPackageLock(lockFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Find("acme", "api", "amd64") == nil {
+		t.Fatal("expected locked package to be read back")
+	}
+
+	old := PackageLockFile
+	PackageLockFile = lockFile
+	t.Cleanup(func() { PackageLockFile = old })
+
+	_, err = DownloadPackage("acme/api", c)
+	if err == nil || !strings.Contains(err.Error(), "sha256") {
+		t.Fatalf("expected sha256 mismatch, got %v", err)
+	}
+}
+
+func TestLockPackageWithDependencies(t *testing.T) {
+	dir := t.TempDir()
+	writeLocalPackage(t, dir, localPackage{Package: Package{Namespace: "acme", Name: "api", Version: "1.0.0", Arch: "x86_64", SHA256: "a", Dependencies: []string{"acme/runtime:2.0.0", "acme/libc:1.0.0"}}})
+	writeLocalPackage(t, dir, localPackage{Package: Package{Namespace: "acme", Name: "runtime", Version: "2.0.0", Arch: "x86_64", SHA256: "b", Dependencies: []string{"acme/libc:1.0.0"}}})
+	writeLocalPackage(t, dir, localPackage{Package: Package{Namespace: "acme", Name: "libc", Version: "1.0.0", Arch: "x86_64", SHA256: "c"}})
+
+	c := &types.Config{Arch: "amd64", PackageSources: []types.PackageSource{{Name: "local", URL: "file://" + dir}}}
+
+	locks, err := LockPackageWithDependencies(c, "acme/api:latest", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, l := range locks {
+		got = append(got, l.Identifier())
+	}
+	if strings.Join(got, " ") != "acme/api:1.0.0 acme/runtime:2.0.0 acme/libc:1.0.0" {
+		t.Fatalf("unexpected locks %v", got)
+	}
+
+	writeLocalPackage(t, dir, localPackage{Package: Package{Namespace: "acme", Name: "libc", Version: "1.0.0", Arch: "x86_64", SHA256: "c", Dependencies: []string{"acme/runtime:2.0.0"}}})
+	if _, err := LockPackageWithDependencies(c, "acme/api:1.0.0", "amd64"); err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Fatalf("expected a dependency cycle, got %v", err)
+	}
+}
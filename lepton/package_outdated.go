@@ -0,0 +1,121 @@
+package lepton
+
+import (
+	"cmp"
+	"sort"
+	"strings"
+
+	"github.com/nanovms/ops/types"
+)
+
+// CompareVersions compares versions a and b, returning -1, 0 or +1.
+// Runs of digits are compared as numbers of any length, e.g. 1.10.0 is
+// after 1.9.2, everything else byte by byte.
+func CompareVersions(a, b string) int {
+	for a != "" && b != "" {
+		da, db := digitPrefix(a), digitPrefix(b)
+		switch {
+		case da != "" && db != "":
+			na, nb := strings.TrimLeft(da, "0"), strings.TrimLeft(db, "0")
+			if c := cmp.Compare(len(na), len(nb)); c != 0 {
+				return c
+			}
+			if c := strings.Compare(na, nb); c != 0 {
+				return c
+			}
+			a, b = a[len(da):], b[len(db):]
+		case da != "":
+			return -1
+		case db != "":
+			return 1
+		default:
+			if a[0] != b[0] {
+				return cmp.Compare(a[0], b[0])
+			}
+			a, b = a[1:], b[1:]
+		}
+	}
+	return cmp.Compare(len(a), len(b))
+}
+
+// digitPrefix returns the leading run of digits of s.
+func digitPrefix(s string) string {
+	i := 0
+	for i < len(s) && '0' <= s[i] && s[i] <= '9' {
+		i++
+	}
+	return s[:i]
+}
+
+// newerVersion tells if version a is newer than b, ignoring a leading v.
+func newerVersion(a, b string) bool {
+	return CompareVersions(strings.TrimPrefix(a, "v"), strings.TrimPrefix(b, "v")) > 0
+}
+
+// OutdatedPackage is a downloaded package with a newer version available.
+type OutdatedPackage struct {
+	Name    string `json:"name"`
+	Arch    string `json:"arch"`
+	Version string `json:"version"`
+	Latest  string `json:"latest"`
+}
+
+// outdatedPackages compares the newest local version of each package
+// with the remote ones.
+func outdatedPackages(local []CacheEntry, remote []Package) []OutdatedPackage {
+	type key struct{ name, arch string }
+
+	newest := map[key]string{}
+	for _, e := range local {
+		if e.Kind != CacheKindPackage || e.Version == "" || e.Version == "latest" {
+			continue
+		}
+		k := key{e.Name, e.Arch}
+		if v, ok := newest[k]; !ok || newerVersion(e.Version, v) {
+			newest[k] = e.Version
+		}
+	}
+
+	latest := map[key]string{}
+	for _, p := range remote {
+		k := key{p.Namespace + "/" + p.Name, normalizePackageArch(p.Arch)}
+		if _, ok := newest[k]; !ok {
+			continue
+		}
+		if v, ok := latest[k]; !ok || newerVersion(p.Version, v) {
+			latest[k] = p.Version
+		}
+	}
+
+	outdated := []OutdatedPackage{}
+	for k, v := range newest {
+		if l, ok := latest[k]; ok && newerVersion(l, v) {
+			outdated = append(outdated, OutdatedPackage{Name: k.name, Arch: k.arch, Version: v, Latest: l})
+		}
+	}
+
+	sort.Slice(outdated, func(i, j int) bool {
+		if outdated[i].Name != outdated[j].Name {
+			return outdated[i].Name < outdated[j].Name
+		}
+		return outdated[i].Arch < outdated[j].Arch
+	})
+
+	return outdated
+}
+
+// OutdatedPackages lists the downloaded packages that have a newer
+// version in the package sources.
+func OutdatedPackages(config *types.Config) ([]OutdatedPackage, error) {
+	local, err := CacheEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	remote, err := GetPackageList(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return outdatedPackages(local, remote.Packages), nil
+}
@@ -0,0 +1,458 @@
+package lepton
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nanovms/ops/types"
+)
+
+// packageSource is a registry packages are looked up in along with the
+// urls of its endpoints.
+type packageSource struct {
+	types.PackageSource
+
+	hubURL        string // base of the search and metadata apis
+	packagesURL   string // base of the archives
+	manifestURL   string
+	manifestCache string // where the manifest is downloaded to
+
+	// legacy file:// PackageBaseURL; archives are <ns>/<name>_<version>.tar.gz
+	// and metadata still comes from hub.
+	legacyDir string
+
+	// directory in the 'ops pkg serve' layout
+	dir string
+}
+
+// PackageArchivePath is the path of the archive of a package relative to
+// the packages url of a registry.
+func PackageArchivePath(ns, name, version, arch string) string {
+	if archOrHost(arch) == "arm64" {
+		return ns + "/" + name + "/" + version + "/arm64.tar.gz"
+	}
+	return ns + "/" + name + "/" + version + ".tar.gz"
+}
+
+// PackageMetadataPath is where the metadata of a package is kept in a
+// registry directory.
+func PackageMetadataPath(ns, name, version, arch string) string {
+	a := "amd64"
+	if archOrHost(arch) == "arm64" {
+		a = "arm64"
+	}
+	return ns + "/" + name + "/" + version + "/" + a + ".json"
+}
+
+// defaultPackageSource is the source used when none are configured:
+// pkghub, with the archives and manifest possibly coming from elsewhere
+// (config PackageBaseURL, then OPS_PACKAGE_BASE_URL).
+func defaultPackageSource(config *types.Config) packageSource {
+	s := packageSource{
+		PackageSource: types.PackageSource{Name: "pkghub", URL: PkghubBaseURL},
+		hubURL:        PkghubBaseURL,
+		packagesURL:   PackageBaseURL,
+		manifestURL:   PackageManifestURL,
+		manifestCache: GetPackageManifestFile(),
+	}
+
+	if config != nil {
+		if u := strings.Trim(config.PackageBaseURL, " "); u != "" {
+			s.packagesURL = u
+		}
+		if u := strings.Trim(config.PackageManifestURL, " "); u != "" {
+			s.manifestURL = u
+		}
+	}
+
+	if u := os.Getenv("OPS_PACKAGE_BASE_URL"); u != "" {
+		s.packagesURL = u
+	}
+	if u := os.Getenv("OPS_PACKAGE_MANIFEST_URL"); u != "" {
+		s.manifestURL = u
+	}
+
+	if strings.HasPrefix(s.packagesURL, "file://") {
+		s.legacyDir = strings.TrimPrefix(s.packagesURL, "file://")
+	}
+
+	return s
+}
+
+func newPackageSource(ps types.PackageSource) packageSource {
+	s := packageSource{PackageSource: ps}
+	if s.Name == "" {
+		s.Name = ps.URL
+	}
+
+	if strings.HasPrefix(ps.URL, "file://") {
+		s.dir = strings.TrimPrefix(ps.URL, "file://")
+		return s
+	}
+
+	base := strings.TrimRight(ps.URL, "/")
+	s.hubURL = base
+	s.packagesURL = base + "/v2/packages"
+	s.manifestURL = base + "/v2/manifest.json"
+	return s
+}
+
+// allPackageSources returns every source in priority order.
+func allPackageSources(config *types.Config) []packageSource {
+	if config == nil || len(config.PackageSources) == 0 {
+		return []packageSource{defaultPackageSource(config)}
+	}
+
+	sources := []packageSource{}
+	for _, ps := range config.PackageSources {
+		sources = append(sources, newPackageSource(ps))
+	}
+	return sources
+}
+
+// packageSourcesFor returns the sources packages of namespace are looked
+// up in, in priority order.
+func packageSourcesFor(config *types.Config, namespace string) []packageSource {
+	all := allPackageSources(config)
+
+	routed := []packageSource{}
+	fallback := []packageSource{}
+	for _, s := range all {
+		if len(s.Namespaces) == 0 {
+			fallback = append(fallback, s)
+			continue
+		}
+
+		for _, pattern := range s.Namespaces {
+			if ok, _ := path.Match(pattern, namespace); ok {
+				routed = append(routed, s)
+				break
+			}
+		}
+	}
+
+	// a namespace routed somewhere never falls back to other sources so
+	// a public registry can't shadow private packages
+	if len(routed) > 0 {
+		return routed
+	}
+	return fallback
+}
+
+// apiKey returns the api key requests of the source to u are sent with:
+// its APIKey or, only for the pkghub host the login key is issued for,
+// the key of 'ops login'.
+func (s packageSource) apiKey(u string) string {
+	if s.APIKey != "" {
+		return s.APIKey
+	}
+
+	if !sameURLHost(u, PkghubBaseURL) {
+		return ""
+	}
+
+	creds, _ := ReadCredsFromLocal()
+	if creds != nil {
+		return creds.APIKey
+	}
+	return ""
+}
+
+// sameURLHost tells if the urls a and b have the same scheme and host.
+func sameURLHost(a, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil || ua.Host == "" {
+		return false
+	}
+	ub, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+	return ua.Scheme == ub.Scheme && strings.EqualFold(ua.Host, ub.Host)
+}
+
+// metadata returns the metadata of a package or nil if the source
+// doesn't have it.
+func (s packageSource) metadata(ns, name, version, arch string) (*Package, error) {
+	if s.dir == "" {
+		pkg, err := fetchPackageMetadata(s.hubURL, s.apiKey(s.hubURL), ns, name, version, arch)
+		if errors.Is(err, ErrPackageNotFound) {
+			return nil, nil
+		}
+		return pkg, err
+	}
+
+	if version == "" || version == "latest" {
+		pkgs, err := s.localPackages()
+		if err != nil {
+			return nil, err
+		}
+
+		var latest *localPackage
+		for i := range pkgs {
+			p := pkgs[i]
+			if p.Namespace != ns || p.Name != name || archOrHost(normalizePackageArch(p.Arch)) != archOrHost(normalizePackageArch(arch)) {
+				continue
+			}
+			if latest == nil || p.Created.After(latest.Created) {
+				latest = &p
+			}
+		}
+
+		if latest == nil {
+			return nil, nil
+		}
+		return &latest.Package, nil
+	}
+
+	body, err := os.ReadFile(filepath.Join(s.dir, filepath.FromSlash(PackageMetadataPath(ns, name, version, arch))))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var pkg Package
+	err = json.Unmarshal(body, &pkg)
+	if err != nil {
+		return nil, err
+	}
+	return &pkg, nil
+}
+
+// archiveURL is where the archive of pkg is; a path for local sources.
+func (s packageSource) archiveURL(pkg *Package, arch string) string {
+	if s.dir != "" {
+		return filepath.Join(s.dir, filepath.FromSlash(PackageArchivePath(pkg.Namespace, pkg.Name, pkg.Version, arch)))
+	}
+
+	if s.legacyDir != "" {
+		return filepath.Join(s.legacyDir, pkg.Namespace+"/"+pkg.Name+"_"+pkg.Version+".tar.gz")
+	}
+
+	return strings.TrimRight(s.packagesURL, "/") + "/" + PackageArchivePath(pkg.Namespace, pkg.Name, pkg.Version, arch)
+}
+
+// localPackage is the metadata kept in a registry directory.
+type localPackage struct {
+	Package
+	Created time.Time `json:"created"`
+}
+
+func (s packageSource) localPackages() ([]localPackage, error) {
+	matches, err := filepath.Glob(filepath.Join(s.dir, "*", "*", "*", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	pkgs := []localPackage{}
+	for _, m := range matches {
+		body, err := os.ReadFile(m)
+		if err != nil {
+			return nil, err
+		}
+
+		var p localPackage
+		if err := json.Unmarshal(body, &p); err != nil {
+			return nil, fmt.Errorf("invalid metadata %s: %v", m, err)
+		}
+		pkgs = append(pkgs, p)
+	}
+
+	return pkgs, nil
+}
+
+// normalizePackageArch maps the arch names used by pkghub to amd64 or
+// arm64.
+func normalizePackageArch(arch string) string {
+	if arch == "x86_64" {
+		return "amd64"
+	}
+	return arch
+}
+
+// hasArch tells if the metadata is the one of arch. Registries may
+// answer with the metadata of another arch when arch is missing.
+func (p *Package) hasArch(arch string) bool {
+	arch = normalizePackageArch(archOrHost(arch))
+
+	if len(p.Arches) > 0 {
+		_, ok := p.Arches[arch]
+		return ok
+	}
+
+	return p.Arch == "" || normalizePackageArch(p.Arch) == arch
+}
+
+// PackageArches returns the arches a package is available for.
+func PackageArches(config *types.Config, ns, name, version string) ([]string, error) {
+	arches := []string{}
+	for _, arch := range []string{"amd64", "arm64"} {
+		pkg, _, err := resolvePackage(config, ns, name, version, arch)
+		if err != nil {
+			return nil, err
+		}
+		if pkg != nil && pkg.hasArch(arch) {
+			arches = append(arches, arch)
+		}
+	}
+	return arches, nil
+}
+
+// missingPackageError tells whether the package doesn't exist at all or
+// only not for arch.
+func missingPackageError(config *types.Config, identifier, arch string) error {
+	idf := ParseIdentifier(identifier)
+	arches, err := PackageArches(config, idf.Namespace, idf.Name, idf.Version)
+	if err != nil || len(arches) == 0 {
+		return fmt.Errorf("package %q does not exist", identifier)
+	}
+
+	return fmt.Errorf("package %q is not available for %s, only for %s", identifier, normalizePackageArch(archOrHost(arch)), strings.Join(arches, ", "))
+}
+
+// search searches the packages of the source; arch is amd64 or arm64.
+func (s packageSource) search(q string, arch string) ([]Package, error) {
+	if s.dir == "" {
+		list, err := searchPackages(s.hubURL, q, arch)
+		if err != nil {
+			return nil, err
+		}
+		return list.Packages, nil
+	}
+
+	pkgs, err := s.localPackages()
+	if err != nil {
+		return nil, err
+	}
+
+	q = strings.ToLower(q)
+	found := []Package{}
+	for _, p := range pkgs {
+		if arch != "" && archOrHost(normalizePackageArch(p.Arch)) != archOrHost(normalizePackageArch(arch)) {
+			continue
+		}
+		if strings.Contains(strings.ToLower(p.Name), q) ||
+			strings.Contains(strings.ToLower(p.Namespace), q) ||
+			strings.Contains(strings.ToLower(p.Description), q) {
+			found = append(found, p.Package)
+		}
+	}
+	return found, nil
+}
+
+// list returns every package of the source.
+func (s packageSource) list() ([]Package, error) {
+	if s.dir != "" {
+		pkgs, err := s.localPackages()
+		if err != nil {
+			return nil, err
+		}
+
+		list := []Package{}
+		for _, p := range pkgs {
+			list = append(list, p.Package)
+		}
+		return list, nil
+	}
+
+	// cache each manifest on its own
+	manifest := s.manifestCache
+	if manifest == "" {
+		manifest = path.Join(PackagesRoot, fmt.Sprintf("manifest-%x.json", sha256.Sum256([]byte(s.manifestURL))))
+	}
+
+	list, err := readPackageManifest(s.manifestURL, manifest)
+	if err != nil {
+		return nil, err
+	}
+	return list.Packages, nil
+}
+
+// resolvePackage looks a package up in the sources its namespace is
+// routed to and returns its metadata along with the source it was found
+// in.
+func resolvePackage(config *types.Config, ns, name, version, arch string) (*Package, *packageSource, error) {
+	var errs []error
+
+	for _, s := range packageSourcesFor(config, ns) {
+		pkg, err := s.metadata(ns, name, version, arch)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", s.Name, err))
+			continue
+		}
+
+		if pkg != nil {
+			return pkg, &s, nil
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, nil, errors.Join(errs...)
+	}
+
+	return nil, nil, nil
+}
+
+// SearchPackageSources searches every source; packages found in more
+// than one are reported from the one with the highest priority.
+func SearchPackageSources(config *types.Config, q string, arch string) (*PackageList, error) {
+	return mergePackageLists(config, func(s packageSource) ([]Package, error) {
+		return s.search(q, arch)
+	})
+}
+
+func mergePackageLists(config *types.Config, f func(s packageSource) ([]Package, error)) (*PackageList, error) {
+	list := &PackageList{}
+	seen := map[string]bool{}
+
+	var errs []error
+	for _, s := range allPackageSources(config) {
+		pkgs, err := f(s)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", s.Name, err))
+			continue
+		}
+
+		for _, p := range pkgs {
+			// only keep what would actually be resolved for the
+			// namespace
+			routed := packageSourcesFor(config, p.Namespace)
+			if len(routed) > 0 && !containsSource(routed, s) {
+				continue
+			}
+
+			key := p.Namespace + "/" + p.Name + ":" + p.Version + "/" + normalizePackageArch(p.Arch)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			list.Packages = append(list.Packages, p)
+		}
+	}
+
+	// only fail if no source could be reached
+	if len(errs) > 0 && len(errs) == len(allPackageSources(config)) {
+		return nil, errors.Join(errs...)
+	}
+
+	return list, nil
+}
+
+func containsSource(sources []packageSource, s packageSource) bool {
+	for _, o := range sources {
+		if o.Name == s.Name && o.URL == s.URL {
+			return true
+		}
+	}
+	return false
+}
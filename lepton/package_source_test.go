@@ -0,0 +1,165 @@
+package lepton
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nanovms/ops/testutils"
+	"github.com/nanovms/ops/types"
+)
+
+func writeLocalPackage(t *testing.T, dir string, p localPackage) {
+	body, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutils.WriteFiles(t, dir, map[string]string{
+		PackageMetadataPath(p.Namespace, p.Name, p.Version, normalizePackageArch(p.Arch)): string(body),
+	})
+}
+
+func TestPackageSourcesFor(t *testing.T) {
+	c := &types.Config{PackageSources: []types.PackageSource{
+		{Name: "internal", URL: "https://pkgs.example.com", Namespaces: []string{"acme", "acme-*"}},
+		{Name: "mirror", URL: "https://mirror.example.com"},
+		{Name: "pkghub", URL: "https://repo.ops.city"},
+	}}
+
+	names := func(sources []packageSource) []string {
+		n := []string{}
+		for _, s := range sources {
+			n = append(n, s.Name)
+		}
+		return n
+	}
+
+	tests := []struct {
+		namespace string
+		want      []string
+	}{
+		{"acme", []string{"internal"}},
+		{"acme-tools", []string{"internal"}},
+		{"eyberg", []string{"mirror", "pkghub"}},
+	}
+
+	for _, tt := range tests {
+		got := names(packageSourcesFor(c, tt.namespace))
+		if len(got) != len(tt.want) {
+			t.Fatalf("%s: got %v, want %v", tt.namespace, got, tt.want)
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Fatalf("%s: got %v, want %v", tt.namespace, got, tt.want)
+			}
+		}
+	}
+
+	if got := packageSourcesFor(&types.Config{}, "eyberg"); len(got) != 1 || got[0].Name != "pkghub" {
+		t.Fatalf("expected pkghub to be the default source, got %v", names(got))
+	}
+}
+
+func TestPackageSourceAPIKey(t *testing.T) {
+	t.Setenv("OPS_HOME", t.TempDir())
+	if err := StoreCredentials(Credentials{Username: "me", APIKey: "login-key"}); err != nil {
+		t.Fatal(err)
+	}
+
+	hub := defaultPackageSource(nil)
+	if key := hub.apiKey(hub.archiveURL(&Package{Namespace: "eyberg", Name: "node", Version: "v1"}, "amd64")); key != "login-key" {
+		t.Fatalf("expected the login key for pkghub, got %q", key)
+	}
+
+	// the login key is never sent to other registries
+	mirror := newPackageSource(types.PackageSource{URL: "https://mirror.example.com"})
+	if key := mirror.apiKey(mirror.hubURL); key != "" {
+		t.Fatalf("expected no key for a mirror, got %q", key)
+	}
+
+	private := newPackageSource(types.PackageSource{URL: "https://pkgs.example.com", APIKey: "private-key"})
+	if key := private.apiKey(private.hubURL); key != "private-key" {
+		t.Fatalf("expected the key of the source, got %q", key)
+	}
+}
+
+func TestResolvePackageFromDirectory(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+
+	writeLocalPackage(t, dir, localPackage{Package: Package{Namespace: "acme", Name: "api", Version: "1.0.0", Arch: "x86_64"}, Created: now.Add(-time.Hour)})
+	writeLocalPackage(t, dir, localPackage{Package: Package{Namespace: "acme", Name: "api", Version: "1.1.0", Arch: "x86_64"}, Created: now})
+	writeLocalPackage(t, dir, localPackage{Package: Package{Namespace: "acme", Name: "api", Version: "0.9.0", Arch: "arm64"}, Created: now})
+
+	c := &types.Config{PackageSources: []types.PackageSource{
+		{Name: "local", URL: "file://" + dir, Namespaces: []string{"acme"}},
+	}}
+
+	pkg, source, err := resolvePackage(c, "acme", "api", "latest", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkg == nil || pkg.Version != "1.1.0" || source.Name != "local" {
+		t.Fatalf("unexpected package %+v", pkg)
+	}
+
+	want := filepath.Join(dir, "acme", "api", "1.1.0.tar.gz")
+	if got := source.archiveURL(pkg, "amd64"); got != want {
+		t.Fatalf("got archive %s, want %s", got, want)
+	}
+
+	pkg, _, err = resolvePackage(c, "acme", "api", "latest", "arm64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkg == nil || pkg.Version != "0.9.0" {
+		t.Fatalf("unexpected arm64 package %+v", pkg)
+	}
+
+	pkg, _, err = resolvePackage(c, "acme", "missing", "1.0.0", "amd64")
+	if err != nil || pkg != nil {
+		t.Fatalf("expected no package, got %+v %v", pkg, err)
+	}
+}
+
+func TestSearchPackageSourcesMerges(t *testing.T) {
+	newHub := func(pkgs ...Package) *httptest.Server {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(PackageList{Version: 1, Packages: pkgs})
+		}))
+		t.Cleanup(ts.Close)
+		return ts
+	}
+
+	private := newHub(Package{Namespace: "acme", Name: "redis", Version: "7.0.0", Arch: "x86_64"})
+	public := newHub(
+		Package{Namespace: "eyberg", Name: "redis", Version: "7.0.0", Arch: "x86_64"},
+		// shadows the private package and must be ignored
+		Package{Namespace: "acme", Name: "redis", Version: "6.0.0", Arch: "x86_64"},
+	)
+	mirror := newHub(Package{Namespace: "eyberg", Name: "redis", Version: "7.0.0", Arch: "x86_64"})
+
+	c := &types.Config{PackageSources: []types.PackageSource{
+		{Name: "private", URL: private.URL, Namespaces: []string{"acme"}},
+		{Name: "mirror", URL: mirror.URL},
+		{Name: "public", URL: public.URL},
+		{Name: "down", URL: "http://127.0.0.1:1"},
+	}}
+
+	list, err := SearchPackageSources(c, "redis", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list.Packages) != 2 {
+		t.Fatalf("expected 2 packages, got %+v", list.Packages)
+	}
+	for _, p := range list.Packages {
+		if p.Namespace == "acme" && p.Version != "7.0.0" {
+			t.Fatalf("private package was shadowed by %+v", p)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package lepton
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// PackageSignatureMediaType is the media type of detached package
+// signatures.
+const PackageSignatureMediaType = "application/vnd.ops.pkg.signature.v1+json"
+
+// PackageSignatureSuffix is appended to the url of a package archive to
+// get its detached signature.
+const PackageSignatureSuffix = ".sig"
+
+// PackageSignature is a detached signature of a package archive. It is
+// modeled after sigstore message signature bundles: the signature is an
+// ed25519 signature of the sha256 digest of the archive.
+type PackageSignature struct {
+	MediaType        string                  `json:"mediaType"`
+	KeyID            string                  `json:"keyId"`
+	MessageSignature PackageMessageSignature `json:"messageSignature"`
+}
+
+// PackageMessageSignature holds the signed digest.
+type PackageMessageSignature struct {
+	MessageDigest PackageMessageDigest `json:"messageDigest"`
+	Signature     string               `json:"signature"` // base64
+}
+
+// PackageMessageDigest is the digest that was signed.
+type PackageMessageDigest struct {
+	Algorithm string `json:"algorithm"` // always SHA2_256
+	Digest    string `json:"digest"`    // base64
+}
+
+// PackageVerificationError is returned when a package archive doesn't
+// match its metadata or signature.
+type PackageVerificationError struct {
+	Package string
+	Reason  string
+}
+
+func (e *PackageVerificationError) Error() string {
+	return fmt.Sprintf("verification of package %s failed: %s", e.Package, e.Reason)
+}
+
+// IsPackageVerificationError tells if err is a failed package
+// verification.
+func IsPackageVerificationError(err error) bool {
+	var verr *PackageVerificationError
+	return errors.As(err, &verr)
+}
+
+// TrustStoreDir is where public keys trusted to sign packages are kept.
+// Each key is a <key id>.pub file with a PEM encoded ed25519 public key.
+func TrustStoreDir() string {
+	return path.Join(GetOpsHome(), "trust")
+}
+
+// LoadTrustedKeys reads the keys of the trust store by key id.
+func LoadTrustedKeys() (map[string]ed25519.PublicKey, error) {
+	keys := map[string]ed25519.PublicKey{}
+
+	files, err := os.ReadDir(TrustStoreDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return keys, nil
+		}
+		return nil, err
+	}
+
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != ".pub" {
+			continue
+		}
+
+		body, err := os.ReadFile(path.Join(TrustStoreDir(), f.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := ParseEd25519PublicKey(body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %s: %v", f.Name(), err)
+		}
+
+		keys[strings.TrimSuffix(f.Name(), ".pub")] = key
+	}
+
+	return keys, nil
+}
+
+// ParseEd25519PublicKey parses a PEM encoded (PKIX) or base64 raw
+// ed25519 public key.
+func ParseEd25519PublicKey(body []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(body)
+	if block == nil {
+		raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(body)))
+		if err != nil || len(raw) != ed25519.PublicKeySize {
+			return nil, errors.New("not an ed25519 public key")
+		}
+		return ed25519.PublicKey(raw), nil
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := pub.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("not an ed25519 public key")
+	}
+
+	return key, nil
+}
+
+// SignPackageArchive creates a detached signature of archive.
+func SignPackageArchive(archive string, keyID string, key ed25519.PrivateKey) ([]byte, error) {
+	digest, err := fileDigest(archive)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.MarshalIndent(newSignature(PackageSignatureMediaType, digest, keyID, key), "", "  ")
+}
+
+// newSignature signs the sha256 digest with key.
+func newSignature(mediaType string, digest []byte, keyID string, key ed25519.PrivateKey) PackageSignature {
+	return PackageSignature{
+		MediaType: mediaType,
+		KeyID:     keyID,
+		MessageSignature: PackageMessageSignature{
+			MessageDigest: PackageMessageDigest{
+				Algorithm: "SHA2_256",
+				Digest:    base64.StdEncoding.EncodeToString(digest),
+			},
+			Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(key, digest)),
+		},
+	}
+}
+
+// VerifyPackageArchive checks archive against the sha256 of its metadata,
+// which is mandatory, and against its detached signature if there is
+// one. Without a signature (sig is nil) it only fails if requireSigned
+// is set. A signature by a key not in the trust store is only an error
+// if requireSigned is set, a signature that doesn't match always is.
+func VerifyPackageArchive(archive string, pkg *Package, sig []byte, requireSigned bool) error {
+	name := pkg.Namespace + "/" + pkg.Name + ":" + pkg.Version
+
+	fail := func(format string, a ...interface{}) error {
+		return &PackageVerificationError{Package: name, Reason: fmt.Sprintf(format, a...)}
+	}
+
+	if pkg.SHA256 == "" {
+		return fail("no sha256 in package metadata")
+	}
+
+	digest, err := fileDigest(archive)
+	if err != nil {
+		return err
+	}
+
+	if hex.EncodeToString(digest) != strings.ToLower(pkg.SHA256) {
+		return fail("sha256 mismatch (expected %s, got %x)", pkg.SHA256, digest)
+	}
+
+	if sig == nil {
+		if requireSigned {
+			return fail("package is not signed")
+		}
+		return nil
+	}
+
+	var ps PackageSignature
+	err = json.Unmarshal(sig, &ps)
+	if err != nil {
+		return fail("invalid signature: %v", err)
+	}
+
+	if ps.MessageSignature.MessageDigest.Algorithm != "SHA2_256" {
+		return fail("unsupported digest algorithm %q", ps.MessageSignature.MessageDigest.Algorithm)
+	}
+
+	signedDigest, err := base64.StdEncoding.DecodeString(ps.MessageSignature.MessageDigest.Digest)
+	if err != nil || hex.EncodeToString(signedDigest) != hex.EncodeToString(digest) {
+		return fail("signature is for a different archive")
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(ps.MessageSignature.Signature)
+	if err != nil {
+		return fail("invalid signature: %v", err)
+	}
+
+	keys, err := LoadTrustedKeys()
+	if err != nil {
+		return err
+	}
+
+	candidates := keys
+	if ps.KeyID != "" {
+		candidates = map[string]ed25519.PublicKey{}
+		if key, ok := keys[ps.KeyID]; ok {
+			candidates[ps.KeyID] = key
+		}
+	}
+
+	if len(candidates) == 0 {
+		if requireSigned {
+			return fail("signed by untrusted key %q (add it to %s)", ps.KeyID, TrustStoreDir())
+		}
+		fmt.Printf("warning: package %s is signed by untrusted key %q\n", name, ps.KeyID)
+		return nil
+	}
+
+	for _, key := range candidates {
+		if ed25519.Verify(key, digest, signature) {
+			return nil
+		}
+	}
+
+	return fail("invalid signature")
+}
+
+// fetchPackageSignature fetches the detached signature at url (a file
+// path for local repos). It returns nil if there is none.
+func fetchPackageSignature(url string, apiKey string) ([]byte, error) {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		body, err := os.ReadFile(url)
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return body, err
+	}
+
+	req, err := BaseHTTPRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// signatures of private packages are private as well
+	if apiKey != "" {
+		req.Header.Set(APIKeyHeader, apiKey)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not fetch signature %s: %s", url, resp.Status)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+// FileSHA256 returns the hex encoded sha256 of a file.
+func FileSHA256(filename string) (string, error) {
+	digest, err := fileDigest(filename)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(digest), nil
+}
+
+func fileDigest(filename string) ([]byte, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,119 @@
+package lepton
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+)
+
+func writeTestArchive(t *testing.T) (string, *Package) {
+	archive := path.Join(t.TempDir(), "amd64.tar.gz")
+	body := []byte("not really a tarball")
+	if err := os.WriteFile(archive, body, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	pkg := &Package{
+		Namespace: "eyberg",
+		Name:      "node",
+		Version:   "20.5.0",
+		SHA256:    fmt.Sprintf("%x", sha256.Sum256(body)),
+	}
+
+	return archive, pkg
+}
+
+func trustTestKey(t *testing.T, keyID string) ed25519.PrivateKey {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.MkdirAll(TrustStoreDir(), 0755)
+	err = os.WriteFile(path.Join(TrustStoreDir(), keyID+".pub"), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return priv
+}
+
+func TestVerifyPackageArchiveSHA256(t *testing.T) {
+	t.Setenv("OPS_HOME", t.TempDir())
+
+	archive, pkg := writeTestArchive(t)
+
+	if err := VerifyPackageArchive(archive, pkg, nil, false); err != nil {
+		t.Fatal(err)
+	}
+
+	bad := *pkg
+	bad.SHA256 = "0000"
+	err := VerifyPackageArchive(archive, &bad, nil, false)
+	if !IsPackageVerificationError(err) {
+		t.Fatalf("expected a verification error, got %v", err)
+	}
+
+	bad.SHA256 = ""
+	err = VerifyPackageArchive(archive, &bad, nil, false)
+	if !IsPackageVerificationError(err) {
+		t.Fatalf("expected a verification error for a missing checksum, got %v", err)
+	}
+
+	err = VerifyPackageArchive(archive, pkg, nil, true)
+	if !IsPackageVerificationError(err) {
+		t.Fatalf("expected unsigned package to be refused, got %v", err)
+	}
+}
+
+func TestVerifyPackageArchiveSignature(t *testing.T) {
+	t.Setenv("OPS_HOME", t.TempDir())
+
+	archive, pkg := writeTestArchive(t)
+	priv := trustTestKey(t, "nanovms")
+
+	sig, err := SignPackageArchive(archive, "nanovms", priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := VerifyPackageArchive(archive, pkg, sig, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// signed by a key that isn't trusted
+	_, other, _ := ed25519.GenerateKey(rand.Reader)
+	untrusted, err := SignPackageArchive(archive, "someone", other)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := VerifyPackageArchive(archive, pkg, untrusted, false); err != nil {
+		t.Fatalf("untrusted keys should only warn unless signatures are required: %v", err)
+	}
+
+	if err := VerifyPackageArchive(archive, pkg, untrusted, true); !IsPackageVerificationError(err) {
+		t.Fatalf("expected untrusted key to be refused, got %v", err)
+	}
+
+	// claims to be from a trusted key but isn't
+	forged, err := SignPackageArchive(archive, "nanovms", other)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := VerifyPackageArchive(archive, pkg, forged, false); !IsPackageVerificationError(err) {
+		t.Fatalf("expected forged signature to be refused, got %v", err)
+	}
+}
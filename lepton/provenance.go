@@ -0,0 +1,276 @@
+package lepton
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	opsfs "github.com/nanovms/ops/fs"
+	"github.com/nanovms/ops/types"
+)
+
+// ProvenanceImagePath is where the provenance of images is written in
+// their root filesystem.
+const ProvenanceImagePath = "/etc/ops/provenance.json"
+
+// Provenance records how an image was built.
+type Provenance struct {
+	OpsVersion   string              `json:"ops_version"`
+	NanosVersion string              `json:"nanos_version,omitempty"`
+	Arch         string              `json:"arch"`
+	Program      string              `json:"program"`
+	Kernel       *ProvenanceFile     `json:"kernel,omitempty"`
+	Klibs        []ProvenanceFile    `json:"klibs,omitempty"`
+	ConfigSHA256 string              `json:"config_sha256"`
+	Packages     []ProvenancePackage `json:"packages,omitempty"`
+	GitCommit    string              `json:"git_commit,omitempty"`
+	GitDirty     bool                `json:"git_dirty,omitempty"`
+	BuildTime    time.Time           `json:"build_time"`
+}
+
+// ProvenanceFile is a file of the boot filesystem of an image.
+type ProvenanceFile struct {
+	Name   string `json:"name"`
+	SHA256 string `json:"sha256"`
+}
+
+// ProvenancePackage is a package an image was built from. SHA256 is the
+// digest of the paths and content of the files of the package.
+type ProvenancePackage struct {
+	Name   string `json:"name"`
+	SHA256 string `json:"sha256"`
+}
+
+// newProvenance returns the provenance of the image of c built with m
+// from the packages of the directories packages.
+func newProvenance(c *types.Config, m *opsfs.Manifest, packages []string) (*Provenance, error) {
+	hasher := newFileHasher()
+
+	p := &Provenance{
+		OpsVersion:   Version,
+		NanosVersion: c.NanosVersion,
+		Arch:         TargetArch(c),
+		Program:      c.Program,
+		BuildTime:    time.Now().UTC().Truncate(time.Second),
+	}
+
+	if p.NanosVersion == "" && c.Kernel != "" {
+		if dir := filepath.Base(filepath.Dir(c.Kernel)); releaseDirRegex.MatchString(dir) {
+			p.NanosVersion = strings.TrimSuffix(dir, "-arm")
+		}
+	}
+
+	for name, hostpath := range m.BootFiles() {
+		sum, err := hasher.hash(hostpath)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case name == "/kernel":
+			p.Kernel = &ProvenanceFile{Name: filepath.Base(hostpath), SHA256: sum}
+		case strings.HasPrefix(name, "/klib/"):
+			p.Klibs = append(p.Klibs, ProvenanceFile{Name: path.Base(name), SHA256: sum})
+		}
+	}
+	sort.Slice(p.Klibs, func(i, j int) bool { return p.Klibs[i].Name < p.Klibs[j].Name })
+
+	var err error
+	p.ConfigSHA256, err = configDigest(c)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, dir := range packages {
+		sum, err := packageDigest(dir, hasher)
+		if err != nil {
+			return nil, err
+		}
+		p.Packages = append(p.Packages, ProvenancePackage{Name: provenancePackageName(dir), SHA256: sum})
+	}
+
+	p.GitCommit, p.GitDirty = gitCommit(c.LocalFilesParentDirectory)
+
+	if err := hasher.save(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// configDigest returns the sha256 of c as JSON.
+func configDigest(c *types.Config) (string, error) {
+	// the build directory is temporary and where the image is written
+	// isn't how it's built
+	hc := *c
+	hc.BuildDir = ""
+	hc.RunConfig.ImageName = ""
+	config, err := json.Marshal(hc)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(config)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// provenancePackageName returns the identifier of the package of dir,
+// or its name for packages outside of the package store.
+func provenancePackageName(dir string) string {
+	dir = filepath.Clean(dir)
+	rel, err := filepath.Rel(PackagesRoot, dir)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return filepath.Base(dir)
+	}
+
+	// <arch>/<namespace>/<name>_<version>
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	if len(parts) != 3 {
+		return filepath.Base(dir)
+	}
+	name, version := splitPackageDir(parts[2])
+	return parts[1] + "/" + name + ":" + version
+}
+
+// packageDigest returns the digest of the paths and content of the files
+// of dir.
+func packageDigest(dir string, hasher *fileHasher) (string, error) {
+	h := sha256.New()
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		switch {
+		case d.Type()&os.ModeSymlink != 0:
+			target, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "link %q %q\n", rel, target)
+		case d.Type().IsRegular():
+			sum, err := hasher.hash(p)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "file %q %s\n", rel, sum)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// gitCommit returns the commit checked out in dir, if it's in a git
+// repository, and whether it has changes.
+func gitCommit(dir string) (string, bool) {
+	cmd := exec.Command("git", "rev-parse", "HEAD")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", false
+	}
+
+	cmd = exec.Command("git", "status", "--porcelain")
+	cmd.Dir = dir
+	status, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err == nil && len(status) > 0
+}
+
+// addProvenanceToManifest writes the provenance of the image of c in the
+// image.
+func addProvenanceToManifest(c *types.Config, m *opsfs.Manifest, packages []string) error {
+	p, err := newProvenance(c, m, packages)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// the build directory is removed after each build
+	dir := getImageTempDir(c)
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	hostpath := path.Join(dir, "provenance.json")
+	err = os.WriteFile(hostpath, body, 0644)
+	if err != nil {
+		return err
+	}
+	return m.AddFile(ProvenanceImagePath, hostpath)
+}
+
+// ImageProvenance returns the provenance of the image of reader.
+func ImageProvenance(reader *opsfs.Reader) (*Provenance, error) {
+	r, err := reader.ReadFile(ProvenanceImagePath)
+	if err != nil {
+		return nil, errors.New("the image has no provenance, it was built by an older ops")
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Provenance{}
+	err = json.Unmarshal(body, p)
+	if err != nil {
+		return nil, fmt.Errorf("invalid provenance: %v", err)
+	}
+	return p, nil
+}
+
+var labelValueInvalidChars = regexp.MustCompile(`[^a-z0-9_-]`)
+
+// labelValue makes v a valid label value for all providers: lowercase
+// letters, digits, '-' and '_', at most 63 characters.
+func labelValue(v string) string {
+	v = labelValueInvalidChars.ReplaceAllString(strings.ToLower(v), "-")
+	if len(v) > 63 {
+		v = v[:63]
+	}
+	return v
+}
+
+// Labels returns the provenance as image labels for providers.
+func (p *Provenance) Labels() []types.Tag {
+	imageLabel := true
+	attr := &types.TagAttribute{ImageLabel: &imageLabel}
+
+	values := [][2]string{
+		{"ops-version", p.OpsVersion},
+		{"nanos-version", p.NanosVersion},
+		{"config-sha256", p.ConfigSHA256[:min(16, len(p.ConfigSHA256))]},
+		{"git-commit", p.GitCommit[:min(12, len(p.GitCommit))]},
+		{"build-time", strconv.FormatInt(p.BuildTime.Unix(), 10)},
+	}
+
+	var tags []types.Tag
+	for _, kv := range values {
+		if kv[1] != "" {
+			tags = append(tags, types.Tag{Key: kv[0], Value: labelValue(kv[1]), Attribute: attr})
+		}
+	}
+	return tags
+}
@@ -0,0 +1,96 @@
+package lepton
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/nanovms/ops/fs"
+	"github.com/nanovms/ops/testutils"
+	"github.com/nanovms/ops/types"
+)
+
+func TestImageProvenance(t *testing.T) {
+	t.Setenv("OPS_HOME", t.TempDir())
+
+	dir := t.TempDir()
+	testutils.WriteFiles(t, dir, map[string]string{"app": "app", "kernel.img": "kernel", "pkg/sysroot/lib/a.so": "a"})
+
+	c := &types.Config{Program: "app", NanosVersion: "0.1.50", NoBuildCache: true}
+	c.BaseVolumeSz = "4m"
+	c.RunConfig.ImageName = filepath.Join(dir, "app.img")
+
+	m := fs.NewManifest("")
+	m.AddKernel(filepath.Join(dir, "kernel.img"))
+	m.AddArgument("/app")
+	if err := m.AddFile("/app", filepath.Join(dir, "app")); err != nil {
+		t.Fatal(err)
+	}
+	if err := createImageFile(c, m, []string{filepath.Join(dir, "pkg")}); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := fs.NewReader(c.RunConfig.ImageName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	p, err := ImageProvenance(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Program != "app" || p.NanosVersion != "0.1.50" || len(p.ConfigSHA256) != 64 || p.BuildTime.IsZero() {
+		t.Fatalf("unexpected provenance %+v", p)
+	}
+	// sha256 of "kernel"
+	if p.Kernel == nil || p.Kernel.Name != "kernel.img" || p.Kernel.SHA256 != "6923dd1bc0460082c5d55a831908c24a282860b7f1cd6c2b79cf1bc8857c639c" {
+		t.Fatalf("unexpected kernel %+v", p.Kernel)
+	}
+	if len(p.Packages) != 1 || p.Packages[0].Name != "pkg" || len(p.Packages[0].SHA256) != 64 {
+		t.Fatalf("unexpected packages %+v", p.Packages)
+	}
+
+	// same content, same digest
+	sum, err := packageDigest(filepath.Join(dir, "pkg"), newFileHasher())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != p.Packages[0].SHA256 {
+		t.Fatalf("expected package digest %s, got %s", sum, p.Packages[0].SHA256)
+	}
+}
+
+func TestProvenancePackageName(t *testing.T) {
+	old := PackagesRoot
+	PackagesRoot = "/ops/packages"
+	t.Cleanup(func() { PackagesRoot = old })
+
+	if name := provenancePackageName("/ops/packages/amd64/eyberg/node_v16.5.0"); name != "eyberg/node:v16.5.0" {
+		t.Fatalf("unexpected name %s", name)
+	}
+	if name := provenancePackageName("/home/me/mypkg"); name != "mypkg" {
+		t.Fatalf("unexpected name %s", name)
+	}
+}
+
+func TestProvenanceLabels(t *testing.T) {
+	p := &Provenance{OpsVersion: "0.1.43", ConfigSHA256: "0123456789abcdef0123"}
+
+	got := map[string]string{}
+	for _, tag := range p.Labels() {
+		if !tag.IsImageLabel() || tag.IsInstanceLabel() {
+			t.Fatalf("expected %s to only label images", tag.Key)
+		}
+		got[tag.Key] = tag.Value
+	}
+
+	expected := map[string]string{"ops-version": "0-1-43", "config-sha256": "0123456789abcdef", "build-time": "-62135596800"}
+	if len(got) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+	for k, v := range expected {
+		if got[k] != v {
+			t.Fatalf("expected %v, got %v", expected, got)
+		}
+	}
+}
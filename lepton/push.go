@@ -10,57 +10,93 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 )
 
-// BuildRequestForArchiveUpload builds the request to upload a package with the provided metadata
-func BuildRequestForArchiveUpload(namespace, name string, pkg Package, archiveLocation string, private bool, arch string) (*http.Request, error) {
+// pkghubArch is the arch name pkghub expects for amd64 or arm64.
+func pkghubArch(arch string) string {
+	if arch == "amd64" || arch == "" {
+		return "x86_64"
+	}
+	return arch
+}
+
+// BuildRequestForArchiveUpload builds the request to upload a package with the provided metadata.
+// archives maps each arch (amd64, arm64) to its archive; archives of
+// several arches are published as one release. signatures maps arches
+// to the detached signatures of their archives, if they are signed.
+func BuildRequestForArchiveUpload(namespace, name string, pkg Package, archives map[string]string, signatures map[string]string, private bool) (*http.Request, error) {
 	privateStr := "off"
 	if private {
 		privateStr = "on"
 	}
-	if arch == "amd64" || arch == "" {
-		arch = "x86_64"
-	}
 	params := map[string]string{
 		"name":        name,
 		"description": pkg.Description,
 		"language":    pkg.Language,
 		"version":     pkg.Version,
-		"arch":        arch,
 		"namespace":   namespace,
 		"private":     privateStr,
 	}
-	return newfileUploadRequest(PkghubBaseURL+"/packages/create", params, "package", archiveLocation)
-
-}
-
-func newfileUploadRequest(uri string, params map[string]string, fileParamName, path string) (*http.Request, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, err
+	if len(pkg.Dependencies) > 0 {
+		params["dependencies"] = strings.Join(pkg.Dependencies, ",")
 	}
-	fileContents, err := io.ReadAll(file)
-	if err != nil {
-		return nil, err
+
+	arches := []string{}
+	for arch := range archives {
+		arches = append(arches, arch)
 	}
-	fi, err := file.Stat()
-	if err != nil {
-		return nil, err
+	sort.Strings(arches)
+
+	files := map[string]string{}
+	switch len(arches) {
+	case 0:
+		return nil, fmt.Errorf("no archive to upload")
+	case 1:
+		params["arch"] = pkghubArch(arches[0])
+		files["package"] = archives[arches[0]]
+		if sig, ok := signatures[arches[0]]; ok {
+			files["signature"] = sig
+		}
+	default:
+		hubArches := []string{}
+		for _, arch := range arches {
+			hubArches = append(hubArches, pkghubArch(arch))
+			files["package_"+pkghubArch(arch)] = archives[arch]
+			if sig, ok := signatures[arch]; ok {
+				files["signature_"+pkghubArch(arch)] = sig
+			}
+		}
+		params["arches"] = strings.Join(hubArches, ",")
 	}
-	file.Close()
 
+	return newfileUploadRequest(PkghubBaseURL+"/packages/create", params, files)
+}
+
+// newfileUploadRequest builds a multipart request with params and the
+// files (form field to path).
+func newfileUploadRequest(uri string, params map[string]string, files map[string]string) (*http.Request, error) {
 	body := new(bytes.Buffer)
 	writer := multipart.NewWriter(body)
-	part, err := writer.CreateFormFile(fileParamName, fi.Name())
-	if err != nil {
-		return nil, err
+
+	for fileParamName, path := range files {
+		fileContents, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		part, err := writer.CreateFormFile(fileParamName, filepath.Base(path))
+		if err != nil {
+			return nil, err
+		}
+		part.Write(fileContents)
 	}
-	part.Write(fileContents)
 
 	for key, val := range params {
 		_ = writer.WriteField(key, val)
 	}
-	err = writer.Close()
+	err := writer.Close()
 	if err != nil {
 		return nil, err
 	}
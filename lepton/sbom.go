@@ -0,0 +1,690 @@
+package lepton
+
+import (
+	"bufio"
+	"bytes"
+	"debug/buildinfo"
+	"debug/elf"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nanovms/ops/fs"
+)
+
+// SBOMComponent is something found in a package or image.
+type SBOMComponent struct {
+	Name    string
+	Version string
+	Type    string // application, library or operating-system package
+	PURL    string
+	Path    string // where it was found, empty for os packages
+	SHA256  string
+
+	// Package is the os package (dpkg/apk) a file belongs to.
+	Package string
+
+	// Needed are the shared libraries an ELF file loads.
+	Needed []string
+}
+
+// SBOM is the software bill of materials of a package or image.
+type SBOM struct {
+	Name       string
+	Version    string
+	Components []SBOMComponent
+}
+
+// sbomSource is the filesystem an SBOM is generated from.
+type sbomSource interface {
+	// files returns the regular files
+	files() ([]string, error)
+	readFile(p string) ([]byte, error)
+	open(p string) (io.ReadCloser, error)
+
+	// hostRoot returns a directory of the host with the files elfs at
+	// their path, and the libraries they load, and removes it once done
+	// is called.
+	hostRoot(elfs []string) (root string, done func(), err error)
+}
+
+type dirSBOMSource struct {
+	root string
+}
+
+func (s dirSBOMSource) files() ([]string, error) {
+	files := []string{}
+	err := filepath.Walk(s.root, func(hostpath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.root, hostpath)
+		if err != nil {
+			return err
+		}
+		files = append(files, "/"+filepath.ToSlash(rel))
+		return nil
+	})
+	return files, err
+}
+
+func (s dirSBOMSource) readFile(p string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(s.root, filepath.FromSlash(p)))
+}
+
+func (s dirSBOMSource) open(p string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(s.root, filepath.FromSlash(p)))
+}
+
+func (s dirSBOMSource) hostRoot(elfs []string) (string, func(), error) {
+	return s.root, func() {}, nil
+}
+
+type imageSBOMSource struct {
+	reader *fs.Reader
+	links  []string
+}
+
+func (s *imageSBOMSource) files() ([]string, error) {
+	files := []string{}
+	s.links = nil
+
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		entries, err := s.reader.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+
+		for _, e := range entries {
+			p := path.Join(dir, e.Name())
+			switch e.Mode() {
+			case os.ModeDir:
+				if err := walk(p); err != nil {
+					return err
+				}
+			case 0:
+				files = append(files, p)
+			case os.ModeSymlink:
+				s.links = append(s.links, p)
+			}
+		}
+		return nil
+	}
+
+	err := walk("/")
+	return files, err
+}
+
+func (s *imageSBOMSource) readFile(p string) ([]byte, error) {
+	r, err := s.reader.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func (s *imageSBOMSource) open(p string) (io.ReadCloser, error) {
+	r, err := s.reader.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(r), nil
+}
+
+// hostRoot copies the ELF files and the symlinks of the image, through
+// which libraries are found, to a temporary directory.
+func (s *imageSBOMSource) hostRoot(elfs []string) (string, func(), error) {
+	root, err := os.MkdirTemp("", "ops-sbom")
+	if err != nil {
+		return "", nil, err
+	}
+	done := func() { os.RemoveAll(root) }
+
+	for _, p := range append(append([]string{}, elfs...), s.links...) {
+		dest := filepath.Join(root, filepath.FromSlash(p))
+		err := os.MkdirAll(filepath.Dir(dest), 0755)
+		if err == nil {
+			err = s.reader.CopyFile(p, dest, false)
+		}
+		if err != nil {
+			done()
+			return "", nil, err
+		}
+	}
+
+	return root, done, nil
+}
+
+// osPackage is a package of a dpkg or apk database.
+type osPackage struct {
+	name    string
+	version string
+	arch    string
+	purl    string
+	files   []string
+}
+
+// parseDpkgStatus parses a dpkg status file (or one of the files of
+// status.d used by distroless images).
+func parseDpkgStatus(body []byte) []osPackage {
+	pkgs := []osPackage{}
+
+	for _, para := range strings.Split(string(body), "\n\n") {
+		fields := map[string]string{}
+		for _, line := range strings.Split(para, "\n") {
+			k, v, ok := strings.Cut(line, ":")
+			if !ok || strings.HasPrefix(line, " ") {
+				continue
+			}
+			fields[k] = strings.TrimSpace(v)
+		}
+
+		if fields["Package"] == "" {
+			continue
+		}
+		if st, ok := fields["Status"]; ok && !strings.HasSuffix(st, "installed") {
+			continue
+		}
+
+		p := osPackage{name: fields["Package"], version: fields["Version"], arch: fields["Architecture"]}
+		p.purl = fmt.Sprintf("pkg:deb/debian/%s@%s", p.name, p.version)
+		if p.arch != "" {
+			p.purl += "?arch=" + p.arch
+		}
+		pkgs = append(pkgs, p)
+	}
+
+	return pkgs
+}
+
+// parseApkInstalled parses the apk database of alpine.
+func parseApkInstalled(body []byte) []osPackage {
+	pkgs := []osPackage{}
+
+	var p *osPackage
+	dir := ""
+	flush := func() {
+		if p != nil && p.name != "" {
+			p.purl = fmt.Sprintf("pkg:apk/alpine/%s@%s", p.name, p.version)
+			if p.arch != "" {
+				p.purl += "?arch=" + p.arch
+			}
+			pkgs = append(pkgs, *p)
+		}
+		p = nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			flush()
+			continue
+		}
+		if p == nil {
+			p = &osPackage{}
+		}
+
+		k, v, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch k {
+		case "P":
+			p.name = v
+		case "V":
+			p.version = v
+		case "A":
+			p.arch = v
+		case "F":
+			dir = v
+		case "R":
+			p.files = append(p.files, "/"+path.Join(dir, v))
+		}
+	}
+	flush()
+
+	return pkgs
+}
+
+var soVersionRegex = regexp.MustCompile(`\.so\.([0-9][0-9.]*)$`)
+
+// isELFFile tells if the file p of src is an ELF file, reading only its
+// magic number.
+func isELFFile(src sbomSource, p string) (bool, error) {
+	r, err := src.open(p)
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+
+	magic := make([]byte, len(elf.ELFMAG))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return false, nil
+	}
+	return string(magic) == elf.ELFMAG, nil
+}
+
+// elfComponent describes the ELF file at the path p of root or returns
+// nil if it isn't a valid one.
+func elfComponent(root string, p string) (*SBOMComponent, error) {
+	hostpath := filepath.Join(root, filepath.FromSlash(p))
+
+	efd, err := GetElfFileInfo(hostpath)
+	if err != nil {
+		return nil, nil
+	}
+	defer efd.Close()
+
+	digest, err := fileDigest(hostpath)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &SBOMComponent{
+		Name:   path.Base(p),
+		Type:   "application",
+		Path:   p,
+		SHA256: fmt.Sprintf("%x", digest),
+	}
+
+	if efd.Type == elf.ET_DYN {
+		if sonames, err := efd.DynString(elf.DT_SONAME); err == nil && len(sonames) > 0 {
+			c.Type = "library"
+			c.Name = sonames[0]
+		}
+	}
+
+	if m := soVersionRegex.FindStringSubmatch(path.Base(p)); m != nil {
+		c.Version = m[1]
+		c.Type = "library"
+	}
+
+	// the libraries it loads from root, or the names of the ones it's
+	// linked against if some aren't there
+	if libs, err := getSharedLibs(root, p, nil); err == nil {
+		for lib := range libs {
+			c.Needed = append(c.Needed, lib)
+		}
+		sort.Strings(c.Needed)
+	} else if libs, err := efd.ImportedLibraries(); err == nil {
+		c.Needed = libs
+	}
+
+	// go binaries carry the version of their main module
+	if bi, err := buildinfo.ReadFile(hostpath); err == nil {
+		if bi.Main.Path != "" {
+			c.Name = bi.Main.Path
+			c.PURL = fmt.Sprintf("pkg:golang/%s@%s", bi.Main.Path, bi.Main.Version)
+		}
+		c.Version = bi.Main.Version
+	}
+
+	if c.PURL == "" {
+		c.PURL = "pkg:generic/" + c.Name
+		if c.Version != "" {
+			c.PURL += "@" + c.Version
+		}
+	}
+
+	return c, nil
+}
+
+func generateSBOM(src sbomSource, name, version string) (*SBOM, error) {
+	files, err := src.files()
+	if err != nil {
+		return nil, err
+	}
+
+	sbom := &SBOM{Name: name, Version: version}
+	owners := map[string]string{}
+
+	for _, f := range files {
+		var pkgs []osPackage
+
+		switch {
+		case f == "/var/lib/dpkg/status" || strings.HasPrefix(f, "/var/lib/dpkg/status.d/"):
+			body, err := src.readFile(f)
+			if err != nil {
+				return nil, err
+			}
+			pkgs = parseDpkgStatus(body)
+		case f == "/lib/apk/db/installed":
+			body, err := src.readFile(f)
+			if err != nil {
+				return nil, err
+			}
+			pkgs = parseApkInstalled(body)
+		case strings.HasPrefix(f, "/var/lib/dpkg/info/") && strings.HasSuffix(f, ".list"):
+			body, err := src.readFile(f)
+			if err != nil {
+				return nil, err
+			}
+			pkg := strings.TrimSuffix(path.Base(f), ".list")
+			pkg, _, _ = strings.Cut(pkg, ":")
+			for _, line := range strings.Split(string(body), "\n") {
+				if line != "" {
+					owners[line] = pkg
+				}
+			}
+		}
+
+		for _, p := range pkgs {
+			for _, pf := range p.files {
+				owners[pf] = p.name
+			}
+			sbom.Components = append(sbom.Components, SBOMComponent{
+				Name:    p.name,
+				Version: p.version,
+				Type:    "operating-system package",
+				PURL:    p.purl,
+			})
+		}
+	}
+
+	elfs := []string{}
+	for _, f := range files {
+		ok, err := isELFFile(src, f)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			elfs = append(elfs, f)
+		}
+	}
+
+	root, done, err := src.hostRoot(elfs)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	for _, f := range elfs {
+		c, err := elfComponent(root, f)
+		if err != nil {
+			return nil, err
+		}
+		if c == nil {
+			continue
+		}
+		c.Package = owners[f]
+		sbom.Components = append(sbom.Components, *c)
+	}
+
+	sort.SliceStable(sbom.Components, func(i, j int) bool {
+		a, b := sbom.Components[i], sbom.Components[j]
+		if a.Type != b.Type {
+			return a.Type > b.Type
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Path < b.Path
+	})
+
+	return sbom, nil
+}
+
+// PackageSBOM generates the SBOM of the package identified by
+// identifier extracted at dir.
+func PackageSBOM(dir, identifier string) (*SBOM, error) {
+	name, version, _ := strings.Cut(identifier, ":")
+
+	deps, err := ManifestDependencies(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	sbom, err := generateSBOM(dirSBOMSource{root: filepath.Join(dir, "sysroot")}, name, version)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, dep := range deps {
+		idf := ParseIdentifier(dep)
+		sbom.Components = append(sbom.Components, SBOMComponent{
+			Name:    idf.Namespace + "/" + idf.Name,
+			Version: idf.Version,
+			Type:    "ops package",
+			PURL:    fmt.Sprintf("pkg:generic/%s/%s@%s", idf.Namespace, idf.Name, idf.Version),
+		})
+	}
+
+	return sbom, nil
+}
+
+// ImageSBOM generates the SBOM of the image read by reader.
+func ImageSBOM(reader *fs.Reader, name string) (*SBOM, error) {
+	return generateSBOM(&imageSBOMSource{reader: reader}, name, "")
+}
+
+// SBOM formats.
+const (
+	SBOMFormatCycloneDX = "cyclonedx"
+	SBOMFormatSPDX      = "spdx"
+)
+
+type cdxHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+type cdxProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type cdxComponent struct {
+	BOMRef     string        `json:"bom-ref"`
+	Type       string        `json:"type"`
+	Name       string        `json:"name"`
+	Version    string        `json:"version,omitempty"`
+	PURL       string        `json:"purl,omitempty"`
+	Hashes     []cdxHash     `json:"hashes,omitempty"`
+	Properties []cdxProperty `json:"properties,omitempty"`
+}
+
+type cdxDependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn"`
+}
+
+type cdxBOM struct {
+	BOMFormat    string `json:"bomFormat"`
+	SpecVersion  string `json:"specVersion"`
+	SerialNumber string `json:"serialNumber"`
+	Version      int    `json:"version"`
+	Metadata     struct {
+		Timestamp string       `json:"timestamp"`
+		Component cdxComponent `json:"component"`
+	} `json:"metadata"`
+	Components   []cdxComponent  `json:"components"`
+	Dependencies []cdxDependency `json:"dependencies,omitempty"`
+}
+
+// componentRef returns a unique reference to the i-th component.
+func (s *SBOM) componentRef(i int) string {
+	c := s.Components[i]
+	if c.Path != "" {
+		return "file:" + c.Path
+	}
+	return c.PURL
+}
+
+// neededRefs returns the references of the libraries the i-th
+// component is linked against that are part of the SBOM.
+func (s *SBOM) neededRefs(i int) []string {
+	refs := []string{}
+	for _, lib := range s.Components[i].Needed {
+		for j, c := range s.Components {
+			if c.Path != "" && (c.Path == lib || c.Name == lib || path.Base(c.Path) == lib) {
+				refs = append(refs, s.componentRef(j))
+				break
+			}
+		}
+	}
+	return refs
+}
+
+// CycloneDX returns the SBOM as a CycloneDX 1.5 document.
+func (s *SBOM) CycloneDX() ([]byte, error) {
+	bom := cdxBOM{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.5",
+		SerialNumber: "urn:uuid:" + uuid.New().String(),
+		Version:      1,
+		Components:   []cdxComponent{},
+	}
+	bom.Metadata.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	bom.Metadata.Component = cdxComponent{BOMRef: "root", Type: "application", Name: s.Name, Version: s.Version}
+
+	for i, c := range s.Components {
+		cc := cdxComponent{
+			BOMRef:  s.componentRef(i),
+			Type:    "library",
+			Name:    c.Name,
+			Version: c.Version,
+			PURL:    c.PURL,
+		}
+		if c.Type == "application" {
+			cc.Type = "application"
+		}
+		if c.SHA256 != "" {
+			cc.Hashes = []cdxHash{{Alg: "SHA-256", Content: c.SHA256}}
+		}
+		if c.Path != "" {
+			cc.Properties = append(cc.Properties, cdxProperty{Name: "ops:path", Value: c.Path})
+		}
+		if c.Package != "" {
+			cc.Properties = append(cc.Properties, cdxProperty{Name: "ops:package", Value: c.Package})
+		}
+		bom.Components = append(bom.Components, cc)
+
+		if refs := s.neededRefs(i); len(refs) > 0 {
+			bom.Dependencies = append(bom.Dependencies, cdxDependency{Ref: cc.BOMRef, DependsOn: refs})
+		}
+	}
+
+	return json.MarshalIndent(bom, "", "  ")
+}
+
+type spdxChecksum struct {
+	Algorithm     string `json:"algorithm"`
+	ChecksumValue string `json:"checksumValue"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxPackage struct {
+	SPDXID           string            `json:"SPDXID"`
+	Name             string            `json:"name"`
+	VersionInfo      string            `json:"versionInfo,omitempty"`
+	DownloadLocation string            `json:"downloadLocation"`
+	FilesAnalyzed    bool              `json:"filesAnalyzed"`
+	PackageFileName  string            `json:"packageFileName,omitempty"`
+	Checksums        []spdxChecksum    `json:"checksums,omitempty"`
+	ExternalRefs     []spdxExternalRef `json:"externalRefs,omitempty"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+type spdxDocument struct {
+	SPDXVersion       string `json:"spdxVersion"`
+	DataLicense       string `json:"dataLicense"`
+	SPDXID            string `json:"SPDXID"`
+	Name              string `json:"name"`
+	DocumentNamespace string `json:"documentNamespace"`
+	CreationInfo      struct {
+		Created  string   `json:"created"`
+		Creators []string `json:"creators"`
+	} `json:"creationInfo"`
+	Packages      []spdxPackage      `json:"packages"`
+	Relationships []spdxRelationship `json:"relationships"`
+}
+
+var spdxIDRegex = regexp.MustCompile(`[^a-zA-Z0-9.-]+`)
+
+// SPDX returns the SBOM as a SPDX 2.3 document.
+func (s *SBOM) SPDX() ([]byte, error) {
+	doc := spdxDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              s.Name,
+		DocumentNamespace: "https://nanovms.com/spdxdocs/" + spdxIDRegex.ReplaceAllString(s.Name, "-") + "-" + uuid.New().String(),
+		Packages:          []spdxPackage{},
+	}
+	doc.CreationInfo.Created = time.Now().UTC().Format(time.RFC3339)
+	doc.CreationInfo.Creators = []string{"Tool: ops"}
+
+	doc.Packages = append(doc.Packages, spdxPackage{
+		SPDXID:           "SPDXRef-root",
+		Name:             s.Name,
+		VersionInfo:      s.Version,
+		DownloadLocation: "NOASSERTION",
+	})
+	doc.Relationships = append(doc.Relationships, spdxRelationship{"SPDXRef-DOCUMENT", "DESCRIBES", "SPDXRef-root"})
+
+	ids := map[string]string{}
+	for i, c := range s.Components {
+		id := fmt.Sprintf("SPDXRef-%d-%s", i, spdxIDRegex.ReplaceAllString(c.Name, "-"))
+		ids[s.componentRef(i)] = id
+
+		p := spdxPackage{
+			SPDXID:           id,
+			Name:             c.Name,
+			VersionInfo:      c.Version,
+			DownloadLocation: "NOASSERTION",
+			PackageFileName:  c.Path,
+		}
+		if c.SHA256 != "" {
+			p.Checksums = []spdxChecksum{{Algorithm: "SHA256", ChecksumValue: c.SHA256}}
+		}
+		if c.PURL != "" {
+			p.ExternalRefs = []spdxExternalRef{{ReferenceCategory: "PACKAGE-MANAGER", ReferenceType: "purl", ReferenceLocator: c.PURL}}
+		}
+		doc.Packages = append(doc.Packages, p)
+		doc.Relationships = append(doc.Relationships, spdxRelationship{"SPDXRef-root", "CONTAINS", id})
+	}
+
+	for i := range s.Components {
+		for _, ref := range s.neededRefs(i) {
+			doc.Relationships = append(doc.Relationships, spdxRelationship{ids[s.componentRef(i)], "DEPENDS_ON", ids[ref]})
+		}
+	}
+
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// Encode returns the SBOM in the given format.
+func (s *SBOM) Encode(format string) ([]byte, error) {
+	switch format {
+	case SBOMFormatCycloneDX, "":
+		return s.CycloneDX()
+	case SBOMFormatSPDX:
+		return s.SPDX()
+	default:
+		return nil, fmt.Errorf("unknown sbom format %q, use %s or %s", format, SBOMFormatCycloneDX, SBOMFormatSPDX)
+	}
+}
@@ -0,0 +1,142 @@
+package lepton
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/nanovms/ops/testutils"
+)
+
+func TestParseOSPackageDatabases(t *testing.T) {
+	dpkg := `Package: libc6
+Status: install ok installed
+Architecture: amd64
+Version: 2.36-9
+Description: GNU C Library
+ shared libraries
+
+Package: removed
+Status: deinstall ok config-files
+Version: 1.0
+`
+	pkgs := parseDpkgStatus([]byte(dpkg))
+	if len(pkgs) != 1 || pkgs[0].name != "libc6" || pkgs[0].purl != "pkg:deb/debian/libc6@2.36-9?arch=amd64" {
+		t.Fatalf("unexpected dpkg packages %+v", pkgs)
+	}
+
+	apk := `P:musl
+V:1.2.4-r2
+A:x86_64
+F:lib
+R:ld-musl-x86_64.so.1
+
+P:zlib
+V:1.3-r0
+`
+	pkgs = parseApkInstalled([]byte(apk))
+	if len(pkgs) != 2 || pkgs[0].name != "musl" || pkgs[1].version != "1.3-r0" {
+		t.Fatalf("unexpected apk packages %+v", pkgs)
+	}
+	if len(pkgs[0].files) != 1 || pkgs[0].files[0] != "/lib/ld-musl-x86_64.so.1" {
+		t.Fatalf("unexpected musl files %v", pkgs[0].files)
+	}
+}
+
+func TestPackageSBOM(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin, err := os.ReadFile(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(t.TempDir(), "app_1.0.0")
+	writeTestPackage(t, dir, []string{"base/openssl:3.0"}, map[string]string{
+		"/usr/bin/app":                string(bin),
+		"/etc/app.conf":               "not an elf",
+		"/var/lib/dpkg/status":        "Package: app\nStatus: install ok installed\nVersion: 1.0.0\n",
+		"/var/lib/dpkg/info/app.list": "/usr/bin/app\n/etc/app.conf\n",
+	})
+
+	sbom, err := PackageSBOM(dir, "acme/app:1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sbom.Name != "acme/app" || sbom.Version != "1.0.0" {
+		t.Fatalf("unexpected sbom %s %s", sbom.Name, sbom.Version)
+	}
+
+	found := map[string]SBOMComponent{}
+	for _, c := range sbom.Components {
+		found[c.Type] = c
+	}
+	if found["application"].Path != "/usr/bin/app" || found["application"].Package != "app" {
+		t.Fatalf("expected the binary owned by the app deb, got %+v", found["application"])
+	}
+	if found["operating-system package"].PURL != "pkg:deb/debian/app@1.0.0" {
+		t.Fatalf("unexpected deb %+v", found["operating-system package"])
+	}
+	if found["ops package"].Name != "base/openssl" {
+		t.Fatalf("expected the dependency, got %+v", found["ops package"])
+	}
+
+	for _, format := range []string{SBOMFormatCycloneDX, SBOMFormatSPDX} {
+		body, err := sbom.Encode(format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var doc map[string]any
+		if err := json.Unmarshal(body, &doc); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(body), "/usr/bin/app") {
+			t.Fatalf("%s: expected the binary in %s", format, body)
+		}
+	}
+
+	if _, err := sbom.Encode("swid"); err == nil {
+		t.Fatal("expected unknown format to be refused")
+	}
+}
+
+func TestPackageSBOMSharedLibs(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("libraries are resolved on linux")
+	}
+	hostLibs, err := getSharedLibs("", "/bin/ls", nil)
+	if err != nil || len(hostLibs) == 0 {
+		t.Skip("no dynamically linked /bin/ls:", err)
+	}
+
+	files := map[string]string{"/usr/bin/ls": testutils.ReadFile(t, "/bin/ls")}
+	for libpath, hostpath := range hostLibs {
+		files[libpath] = testutils.ReadFile(t, hostpath)
+	}
+	dir := filepath.Join(t.TempDir(), "ls_1.0.0")
+	writeTestPackage(t, dir, nil, files)
+
+	sbom, err := PackageSBOM(dir, "acme/ls:1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, c := range sbom.Components {
+		if c.Path != "/usr/bin/ls" {
+			continue
+		}
+		if len(c.Needed) != len(hostLibs) {
+			t.Fatalf("expected the libraries of ls %v, got %v", hostLibs, c.Needed)
+		}
+		if refs := sbom.neededRefs(i); len(refs) != len(hostLibs) {
+			t.Fatalf("expected a dependency on each library, got %v", refs)
+		}
+		return
+	}
+	t.Fatal("expected ls in the sbom")
+}
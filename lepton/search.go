@@ -35,9 +35,15 @@ func SearchPackages(q string) (*PackageList, error) {
 // SearchPackagesWithArch searches packages against pkghub with the
 // specified arch.
 func SearchPackagesWithArch(q string, arch string) (*PackageList, error) {
-	// we can ignore this error as the url being parsed here is const so
-	// wouldn't error
-	pkghub, _ := url.Parse(PkghubBaseURL + "/api/v1/search")
+	return searchPackages(PkghubBaseURL, q, arch)
+}
+
+// searchPackages searches the pkghub compatible registry at baseURL.
+func searchPackages(baseURL string, q string, arch string) (*PackageList, error) {
+	pkghub, err := url.Parse(baseURL + "/api/v1/search")
+	if err != nil {
+		return nil, err
+	}
 	query := pkghub.Query()
 	query.Add("q", q)
 
@@ -45,7 +51,9 @@ func SearchPackagesWithArch(q string, arch string) (*PackageList, error) {
 		arch = "x86_64"
 	}
 
-	query.Add("arch", arch)
+	if arch != "" {
+		query.Add("arch", arch)
+	}
 	pkghub.RawQuery = query.Encode()
 
 	req, err := BaseHTTPRequest("GET", pkghub.String(), nil)
@@ -57,6 +65,8 @@ func SearchPackagesWithArch(q string, arch string) (*PackageList, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer response.Body.Close()
+
 	pkgList := PackageList{}
 
 	err = json.NewDecoder(response.Body).Decode(&pkgList)
@@ -0,0 +1,361 @@
+package lepton
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/nanovms/ops/fs"
+	"github.com/nanovms/ops/types"
+)
+
+// secrets modes
+const (
+	// SecretsModeMetadata passes the secrets in the user data of the
+	// instances when they are created.
+	SecretsModeMetadata = "metadata"
+	// SecretsModeEncrypted writes the secrets encrypted in the image and
+	// passes the key in the user data of the instances.
+	SecretsModeEncrypted = "encrypted"
+)
+
+// SecretsImagePath is where the secrets are written in images in
+// encrypted mode: a 12 bytes nonce followed by the AES-256-GCM sealed
+// JSON object of the secrets by name.
+const SecretsImagePath = "/etc/ops/secrets"
+
+// keys of the JSON object passed as user data
+const (
+	// SecretsUserDataKey has the object of the secrets by name in
+	// metadata mode.
+	SecretsUserDataKey = "ops_secrets"
+	// SecretsKeyUserDataKey has the base64 key of the secrets of the
+	// image in encrypted mode.
+	SecretsKeyUserDataKey = "ops_secrets_key"
+)
+
+// userDataPlatforms pass CloudConfig.UserData to the instances they
+// create.
+var userDataPlatforms = []string{"aws", "azure", "digitalocean", "gcp", "hetzner", "ibm", "oci", "openstack", "upcloud", "vultr"}
+
+var secretNameRegex = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// SecretStoreDir is the local secret store, a stand-in for a vault:
+// values are files of the values directory and keys of the secrets of
+// images files of the keys directory, only readable by the user.
+func SecretStoreDir() string {
+	return path.Join(GetOpsHome(), "secrets")
+}
+
+func secretValuesDir() string {
+	return path.Join(SecretStoreDir(), "values")
+}
+
+func secretKeysDir() string {
+	return path.Join(SecretStoreDir(), "keys")
+}
+
+func checkSecretName(name string) error {
+	if !secretNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid secret name %q, expected letters, digits, '_', '-' and '.'", name)
+	}
+	return nil
+}
+
+func writeSecretFile(dir string, name string, body []byte) error {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+	// temporary files are only readable by the user
+	return writeFileAtomic(path.Join(dir, name), body)
+}
+
+// SetSecret sets the value of the secret name of the local store.
+func SetSecret(name string, value string) error {
+	if err := checkSecretName(name); err != nil {
+		return err
+	}
+	return writeSecretFile(secretValuesDir(), name, []byte(value))
+}
+
+// GetSecret returns the value of the secret name of the local store.
+func GetSecret(name string) (string, error) {
+	if err := checkSecretName(name); err != nil {
+		return "", err
+	}
+
+	body, err := os.ReadFile(path.Join(secretValuesDir(), name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("secret %s is not in the secret store, set it with 'ops secret set %s'", name, name)
+		}
+		return "", err
+	}
+	return string(body), nil
+}
+
+// ListSecrets returns the names of the secrets of the local store.
+func ListSecrets() ([]string, error) {
+	entries, err := os.ReadDir(secretValuesDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && secretNameRegex.MatchString(e.Name()) {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// DeleteSecret removes the secret name from the local store.
+func DeleteSecret(name string) error {
+	if err := checkSecretName(name); err != nil {
+		return err
+	}
+
+	err := os.Remove(path.Join(secretValuesDir(), name))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("secret %s is not in the secret store", name)
+	}
+	return err
+}
+
+func secretsMode(c *types.Config) (string, error) {
+	switch c.Secrets.Mode {
+	case "", SecretsModeMetadata:
+		return SecretsModeMetadata, nil
+	case SecretsModeEncrypted:
+		return SecretsModeEncrypted, nil
+	}
+	return "", fmt.Errorf("unknown secrets mode %q, expected %s or %s", c.Secrets.Mode, SecretsModeMetadata, SecretsModeEncrypted)
+}
+
+// ResolveSecrets reads the values of the secrets of c by name.
+func ResolveSecrets(c *types.Config) (map[string]string, error) {
+	values := map[string]string{}
+
+	for name, s := range c.Secrets.Values {
+		sources := 0
+		for _, source := range []string{s.Env, s.File, s.Store} {
+			if source != "" {
+				sources++
+			}
+		}
+		if sources != 1 {
+			return nil, fmt.Errorf("secret %s needs one of Env, File or Store", name)
+		}
+
+		switch {
+		case s.Env != "":
+			v, ok := os.LookupEnv(s.Env)
+			if !ok {
+				return nil, fmt.Errorf("secret %s: environment variable %s is not set", name, s.Env)
+			}
+			values[name] = v
+		case s.File != "":
+			p := s.File
+			if !filepath.IsAbs(p) {
+				p = filepath.Join(c.LocalFilesParentDirectory, p)
+			}
+			body, err := os.ReadFile(p)
+			if err != nil {
+				return nil, fmt.Errorf("secret %s: %v", name, err)
+			}
+			values[name] = strings.TrimRight(string(body), "\r\n")
+		default:
+			v, err := GetSecret(s.Store)
+			if err != nil {
+				return nil, fmt.Errorf("secret %s: %v", name, err)
+			}
+			values[name] = v
+		}
+	}
+
+	return values, nil
+}
+
+// imageSecretsKey returns the key of the secrets of image, made if
+// create is set and the image has none yet.
+func imageSecretsKey(image string, create bool) ([]byte, error) {
+	name := filepath.Base(image) + ".key"
+
+	body, err := os.ReadFile(path.Join(secretKeysDir(), name))
+	if err == nil {
+		return base64.StdEncoding.DecodeString(strings.TrimSpace(string(body)))
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if !create {
+		return nil, fmt.Errorf("no secrets key for image %s, it was built without encrypted secrets or on another machine", image)
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	err = writeSecretFile(secretKeysDir(), name, []byte(base64.StdEncoding.EncodeToString(key)))
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// EncryptSecrets seals the JSON object of secrets with AES-256-GCM and
+// key, the nonce first.
+func EncryptSecrets(key []byte, secrets map[string]string) ([]byte, error) {
+	plain, err := json.Marshal(secrets)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plain, nil), nil
+}
+
+// DecryptSecrets opens secrets encrypted with key by EncryptSecrets, as
+// programs do at boot with the key of the user data.
+func DecryptSecrets(key []byte, body []byte) (map[string]string, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(body) < gcm.NonceSize() {
+		return nil, errors.New("truncated secrets")
+	}
+	plain, err := gcm.Open(nil, body[:gcm.NonceSize()], body[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, err
+	}
+
+	secrets := map[string]string{}
+	err = json.Unmarshal(plain, &secrets)
+	return secrets, err
+}
+
+// addSecretsToManifest writes the secrets of c encrypted in the image in
+// encrypted mode; in metadata mode nothing of them is in the image.
+func addSecretsToManifest(m *fs.Manifest, c *types.Config) error {
+	if len(c.Secrets.Values) == 0 {
+		return nil
+	}
+
+	for name := range c.Secrets.Values {
+		if _, ok := c.Env[name]; ok {
+			return fmt.Errorf("%s is both an environment variable and a secret", name)
+		}
+	}
+
+	mode, err := secretsMode(c)
+	if err != nil || mode != SecretsModeEncrypted {
+		return err
+	}
+
+	secrets, err := ResolveSecrets(c)
+	if err != nil {
+		return err
+	}
+
+	key, err := imageSecretsKey(c.CloudConfig.ImageName, true)
+	if err != nil {
+		return err
+	}
+
+	body, err := EncryptSecrets(key, secrets)
+	if err != nil {
+		return err
+	}
+
+	p := path.Join(getImageTempDir(c), "secrets")
+	err = os.WriteFile(p, body, 0600)
+	if err != nil {
+		return err
+	}
+	return m.AddFile(SecretsImagePath, p)
+}
+
+// InjectSecrets adds the secrets of c, or the key of the secrets of its
+// image in encrypted mode, to the user data of the instance created
+// with c, which is made a JSON object.
+func InjectSecrets(c *types.Config) error {
+	if len(c.Secrets.Values) == 0 {
+		return nil
+	}
+
+	mode, err := secretsMode(c)
+	if err != nil {
+		return err
+	}
+
+	if !slices.Contains(userDataPlatforms, c.CloudConfig.Platform) {
+		platform := c.CloudConfig.Platform
+		if platform == "" {
+			platform = "onprem"
+		}
+		return fmt.Errorf("secrets are passed in the user data of instances, which %s instances don't have", platform)
+	}
+
+	userData := map[string]any{}
+	if c.CloudConfig.UserData != "" {
+		if err := json.Unmarshal([]byte(c.CloudConfig.UserData), &userData); err != nil {
+			return errors.New("secrets are passed in the user data, which must then be a JSON object")
+		}
+	}
+
+	if mode == SecretsModeEncrypted {
+		key, err := imageSecretsKey(c.CloudConfig.ImageName, false)
+		if err != nil {
+			return err
+		}
+		userData[SecretsKeyUserDataKey] = base64.StdEncoding.EncodeToString(key)
+	} else {
+		secrets, err := ResolveSecrets(c)
+		if err != nil {
+			return err
+		}
+		userData[SecretsUserDataKey] = secrets
+	}
+
+	body, err := json.Marshal(userData)
+	if err != nil {
+		return err
+	}
+	c.CloudConfig.UserData = string(body)
+	return nil
+}
@@ -0,0 +1,192 @@
+package lepton
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/nanovms/ops/fs"
+	"github.com/nanovms/ops/testutils"
+	"github.com/nanovms/ops/types"
+)
+
+func TestResolveSecrets(t *testing.T) {
+	t.Setenv("OPS_HOME", t.TempDir())
+	t.Setenv("DB_PASSWORD", "from env")
+
+	dir := t.TempDir()
+	testutils.WriteFiles(t, dir, map[string]string{"token": "from file\n"})
+
+	if err := SetSecret("api-key", "from store"); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &types.Config{LocalFilesParentDirectory: dir}
+	c.Secrets.Values = map[string]types.Secret{
+		"DB_PASSWORD": {Env: "DB_PASSWORD"},
+		"TOKEN":       {File: "token"},
+		"API_KEY":     {Store: "api-key"},
+	}
+
+	secrets, err := ResolveSecrets(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"DB_PASSWORD": "from env", "TOKEN": "from file", "API_KEY": "from store"}
+	if !reflect.DeepEqual(secrets, expected) {
+		t.Fatalf("expected %v, got %v", expected, secrets)
+	}
+
+	c.Secrets.Values = map[string]types.Secret{"A": {Env: "A", Store: "a"}}
+	if _, err := ResolveSecrets(c); err == nil {
+		t.Fatal("expected an error for a secret with two sources")
+	}
+
+	c.Secrets.Values = map[string]types.Secret{"A": {Store: "missing"}}
+	if _, err := ResolveSecrets(c); err == nil {
+		t.Fatal("expected an error for a secret missing from the store")
+	}
+}
+
+func TestSecretStore(t *testing.T) {
+	t.Setenv("OPS_HOME", t.TempDir())
+
+	for _, name := range []string{"b", "a"} {
+		if err := SetSecret(name, name+" value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := SetSecret("../a", "x"); err == nil {
+		t.Fatal("expected an error for an invalid name")
+	}
+
+	info, err := os.Stat(filepath.Join(secretValuesDir(), "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("expected secrets only readable by the user, got %v", info.Mode().Perm())
+	}
+
+	names, err := ListSecrets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Fatalf("unexpected secrets %v", names)
+	}
+
+	if err := DeleteSecret("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetSecret("a"); err == nil {
+		t.Fatal("expected deleted secret to be gone")
+	}
+}
+
+func TestEncryptSecrets(t *testing.T) {
+	key := make([]byte, 32)
+	secrets := map[string]string{"A": "1"}
+
+	body, err := EncryptSecrets(key, secrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decrypted, err := DecryptSecrets(key, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decrypted, secrets) {
+		t.Fatalf("expected %v, got %v", secrets, decrypted)
+	}
+
+	key[0] = 1
+	if _, err := DecryptSecrets(key, body); err == nil {
+		t.Fatal("expected an error with another key")
+	}
+}
+
+func TestInjectSecrets(t *testing.T) {
+	t.Setenv("OPS_HOME", t.TempDir())
+	t.Setenv("TOKEN", "secret")
+
+	c := &types.Config{}
+	c.CloudConfig.Platform = "gcp"
+	c.CloudConfig.UserData = `{"other": 1}`
+	c.Secrets.Values = map[string]types.Secret{"TOKEN": {Env: "TOKEN"}}
+
+	if err := InjectSecrets(c); err != nil {
+		t.Fatal(err)
+	}
+	if c.CloudConfig.UserData != `{"ops_secrets":{"TOKEN":"secret"},"other":1}` {
+		t.Fatalf("unexpected user data %s", c.CloudConfig.UserData)
+	}
+
+	c.CloudConfig.UserData = "#!/bin/sh"
+	if err := InjectSecrets(c); err == nil {
+		t.Fatal("expected an error for user data that isn't a JSON object")
+	}
+
+	c.CloudConfig.UserData = ""
+	c.CloudConfig.Platform = "onprem"
+	if err := InjectSecrets(c); err == nil {
+		t.Fatal("expected an error for a platform without user data")
+	}
+}
+
+func TestAddSecretsToManifest(t *testing.T) {
+	t.Setenv("OPS_HOME", t.TempDir())
+	t.Setenv("TOKEN", "secret")
+
+	c := &types.Config{BuildDir: t.TempDir()}
+	c.CloudConfig.ImageName = "app"
+	c.CloudConfig.Platform = "aws"
+	c.Secrets.Values = map[string]types.Secret{"TOKEN": {Env: "TOKEN"}}
+
+	// metadata mode leaves the image alone
+	m := fs.NewManifest("")
+	if err := addSecretsToManifest(m, c); err != nil {
+		t.Fatal(err)
+	}
+	if m.FileExists(SecretsImagePath) {
+		t.Fatal("expected no secrets in the image in metadata mode")
+	}
+
+	c.Secrets.Mode = SecretsModeEncrypted
+	m = fs.NewManifest("")
+	if err := addSecretsToManifest(m, c); err != nil {
+		t.Fatal(err)
+	}
+	if !m.FileExists(SecretsImagePath) {
+		t.Fatal("expected encrypted secrets in the image")
+	}
+
+	if err := InjectSecrets(c); err != nil {
+		t.Fatal(err)
+	}
+	userData := map[string]string{}
+	if err := json.Unmarshal([]byte(c.CloudConfig.UserData), &userData); err != nil {
+		t.Fatal(err)
+	}
+	key, err := base64.StdEncoding.DecodeString(userData[SecretsKeyUserDataKey])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secrets, err := DecryptSecrets(key, []byte(testutils.ReadFile(t, filepath.Join(c.BuildDir, "secrets"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secrets["TOKEN"] != "secret" {
+		t.Fatalf("unexpected secrets %v", secrets)
+	}
+
+	c.Env = map[string]string{"TOKEN": "x"}
+	if err := addSecretsToManifest(fs.NewManifest(""), c); err == nil {
+		t.Fatal("expected an error for a secret also in Env")
+	}
+}
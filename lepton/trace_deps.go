@@ -0,0 +1,275 @@
+package lepton
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nanovms/ops/types"
+)
+
+// TracedDeps are the files and directories a program opened while it
+// was traced.
+type TracedDeps struct {
+	// Files are absolute, or relative to the directory of the local
+	// files of the config.
+	Files []string
+	// Proposed are the other host files, like files of the home
+	// directory or /etc, they may be secrets so they are only added
+	// when asked to.
+	Proposed []string
+	// Dirs were listed or opened and may need to be in the image even
+	// when empty.
+	Dirs []string
+}
+
+// tracedDepsIgnored are the paths never worth adding to an image.
+var tracedDepsIgnored = []string{"/proc/", "/sys/", "/dev/", "/run/", "/etc/ld.so.cache", "/etc/ld.so.preload"}
+
+// tracedDepsAdded are the host directories of libraries and data the
+// traced files of are added to images.
+var tracedDepsAdded = []string{"/usr/", "/lib/", "/lib32/", "/lib64/", "/opt/"}
+
+// straceCallRegex matches the path and the result of open, openat,
+// openat2 and execve calls in strace output; calls interrupted by
+// another process are matched when resumed.
+var (
+	straceCallRegex    = regexp.MustCompile(`^(\d+\s+)?(open|openat|openat2|execve)\((?:[^"]*, )?"((?:[^"\\]|\\.)*)"(.*)$`)
+	straceResumedRegex = regexp.MustCompile(`^(\d+\s+)?<\.\.\. (open|openat|openat2|execve) resumed>(.*)$`)
+	straceResultRegex  = regexp.MustCompile(`\)\s+=\s+(-?\d+)`)
+)
+
+// parseStraceOutput returns the paths successfully opened or executed
+// in the output of strace -f, in the order they were first opened.
+func parseStraceOutput(r io.Reader) ([]string, error) {
+	var paths []string
+	seen := map[string]bool{}
+	pending := map[string]string{}
+
+	add := func(p, rest string) {
+		// the last one, arguments of execve may look like a result
+		m := straceResultRegex.FindAllStringSubmatch(rest, -1)
+		if m == nil {
+			return
+		}
+		if ret, _ := strconv.Atoi(m[len(m)-1][1]); ret < 0 {
+			return
+		}
+		if !seen[p] {
+			seen[p] = true
+			paths = append(paths, p)
+		}
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if m := straceResumedRegex.FindStringSubmatch(line); m != nil {
+			pid := strings.TrimSpace(m[1])
+			if p, ok := pending[pid]; ok {
+				delete(pending, pid)
+				add(p, m[3])
+			}
+			continue
+		}
+
+		m := straceCallRegex.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+
+		p, err := strconv.Unquote(`"` + m[3] + `"`)
+		if err != nil {
+			p = m[3]
+		}
+
+		if strings.HasSuffix(m[4], "<unfinished ...>") {
+			pending[strings.TrimSpace(m[1])] = p
+			continue
+		}
+		add(p, m[4])
+	}
+
+	return paths, scanner.Err()
+}
+
+// TraceDeps runs the program of the config on the host under strace
+// until it exits or timeout passes and returns the files it opened
+// that aren't part of the image yet, like dlopen'd plugins or data
+// files.
+func TraceDeps(c *types.Config, timeout time.Duration) (*TracedDeps, error) {
+	if c.TargetRoot != "" {
+		return nil, errors.New("tracing runs the program on the host and can't be used with a target root")
+	}
+
+	strace, err := exec.LookPath("strace")
+	if err != nil {
+		return nil, errors.New("tracing dependencies requires strace to be installed")
+	}
+
+	program, err := filepath.Abs(c.Program)
+	if err != nil {
+		return nil, err
+	}
+
+	// the program runs in the directory of the local files like it
+	// runs in / in the image
+	dir, err := filepath.Abs(c.LocalFilesParentDirectory)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := os.CreateTemp("", "ops-trace")
+	if err != nil {
+		return nil, err
+	}
+	out.Close()
+	defer os.Remove(out.Name())
+
+	var args []string
+	if len(c.Args) > 1 {
+		args = c.Args[1:]
+	}
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, strace, append([]string{"-f", "-qq", "-e", "trace=open,openat,openat2,execve", "-o", out.Name(), "--", program}, args...)...)
+	cmd.Dir = dir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = os.Environ()
+	for k, v := range c.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+
+	err = cmd.Run()
+	if err != nil && ctx.Err() == nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return nil, fmt.Errorf("failed tracing %s: %v", c.Program, err)
+		}
+		// the program failing is fine, what it opened until then counts
+	}
+
+	f, err := os.Open(out.Name())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	paths, err := parseStraceOutput(f)
+	if err != nil {
+		return nil, err
+	}
+
+	deps := tracedDeps(paths, dir, program, start)
+
+	// the shared libraries are added to the image anyway
+	libs, err := getSharedLibs("", c.Program, c)
+	if err == nil {
+		deps.Files = filterOut(deps.Files, libs)
+	}
+
+	return deps, nil
+}
+
+func filterOut(files []string, libs map[string]string) []string {
+	resolved := map[string]bool{}
+	for libpath, hostpath := range libs {
+		resolved[libpath] = true
+		if p, err := filepath.EvalSymlinks(hostpath); err == nil {
+			resolved[p] = true
+		}
+	}
+
+	var kept []string
+	for _, f := range files {
+		if p, err := filepath.EvalSymlinks(f); resolved[f] || (err == nil && resolved[p]) {
+			continue
+		}
+		kept = append(kept, f)
+	}
+	return kept
+}
+
+// tracedDeps sorts the paths opened by program, run in dir since start,
+// into the files and dirs to add to the image.
+func tracedDeps(paths []string, dir string, program string, start time.Time) *TracedDeps {
+	deps := &TracedDeps{}
+	seen := map[string]bool{}
+
+	for _, p := range paths {
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(dir, p)
+		}
+		p = filepath.Clean(p)
+
+		ignored := p == program || p == "/" || seen[p]
+		for _, prefix := range tracedDepsIgnored {
+			if strings.HasPrefix(p, prefix) || p+"/" == prefix {
+				ignored = true
+			}
+		}
+		if ignored {
+			continue
+		}
+		seen[p] = true
+
+		info, err := os.Stat(p)
+		if err != nil {
+			// removed since
+			continue
+		}
+		if !info.IsDir() && info.ModTime().After(start) {
+			// written by the program, like temporary files
+			continue
+		}
+
+		// files of the local files directory are added relative to it
+		local := false
+		if rel, err := filepath.Rel(dir, p); err == nil && !strings.HasPrefix(rel, "..") {
+			if rel == "." {
+				continue
+			}
+			p = rel
+			local = true
+		}
+
+		switch {
+		case info.IsDir():
+			deps.Dirs = append(deps.Dirs, p)
+		case local || hasAnyPrefix(p, tracedDepsAdded):
+			deps.Files = append(deps.Files, p)
+		default:
+			deps.Proposed = append(deps.Proposed, p)
+		}
+	}
+
+	sort.Strings(deps.Files)
+	sort.Strings(deps.Proposed)
+	sort.Strings(deps.Dirs)
+	return deps
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package lepton
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nanovms/ops/testutils"
+)
+
+func TestParseStraceOutput(t *testing.T) {
+	out := `100   execve("/usr/bin/python3", ["python3", "app.py"], 0x7ffd /* 20 vars */) = 0
+100   openat(AT_FDCWD, "/etc/ld.so.cache", O_RDONLY|O_CLOEXEC) = 3
+100   openat(AT_FDCWD, "/usr/lib/python3/lib-dynload/_ssl.so", O_RDONLY|O_CLOEXEC <unfinished ...>
+101   openat(AT_FDCWD, "/nonexistent", O_RDONLY) = -1 ENOENT (No such file or directory)
+100   <... openat resumed>) = 4
+101   open("config \"prod\".yml", O_RDONLY) = 5
+101   execve("/bin/sh", ["sh", "-c", "exit) = 0"], 0x7ffd /* 20 vars */) = -1 EACCES (Permission denied)
+100   openat(AT_FDCWD, "/etc/ld.so.cache", O_RDONLY|O_CLOEXEC) = 3
+100   +++ exited with 0 +++
+`
+	paths, err := parseStraceOutput(strings.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"/usr/bin/python3", "/etc/ld.so.cache", "/usr/lib/python3/lib-dynload/_ssl.so", `config "prod".yml`}
+	if !reflect.DeepEqual(paths, want) {
+		t.Fatalf("got %q, want %q", paths, want)
+	}
+}
+
+func TestTracedDeps(t *testing.T) {
+	start := time.Now().Add(time.Minute)
+	dir := t.TempDir()
+	host := t.TempDir()
+	testutils.WriteFiles(t, dir, map[string]string{"app": "", "conf/app.yml": ""})
+	testutils.WriteFiles(t, host, map[string]string{"lib/plugins/a.so": ""})
+
+	paths := []string{
+		filepath.Join(dir, "app"),
+		"conf/app.yml",
+		".",
+		filepath.Join(host, "lib/plugins"),
+		filepath.Join(host, "lib/plugins/a.so"),
+		filepath.Join(host, "lib/plugins/removed.so"),
+		filepath.Join(host, "written.log"),
+		"/proc/self/maps",
+		"/dev/null",
+		"/etc/ld.so.cache",
+	}
+
+	// written while traced
+	testutils.WriteFiles(t, host, map[string]string{"written.log": ""})
+	os.Chtimes(filepath.Join(host, "written.log"), start.Add(time.Second), start.Add(time.Second))
+
+	deps := tracedDeps(paths, dir, filepath.Join(dir, "app"), start)
+
+	// host files outside of library directories are only proposed
+	if want := []string{"conf/app.yml"}; !reflect.DeepEqual(deps.Files, want) {
+		t.Fatalf("got files %v, want %v", deps.Files, want)
+	}
+	if want := []string{filepath.Join(host, "lib/plugins/a.so")}; !reflect.DeepEqual(deps.Proposed, want) {
+		t.Fatalf("got proposed files %v, want %v", deps.Proposed, want)
+	}
+	if !hasAnyPrefix("/usr/lib/python3/_ssl.so", tracedDepsAdded) || hasAnyPrefix("/etc/passwd", tracedDepsAdded) {
+		t.Fatal("expected only library directories to be added")
+	}
+	if want := []string{filepath.Join(host, "lib/plugins")}; !reflect.DeepEqual(deps.Dirs, want) {
+		t.Fatalf("got dirs %v, want %v", deps.Dirs, want)
+	}
+
+	libs := map[string]string{"/lib/plugins/a.so": filepath.Join(host, "lib/plugins/a.so")}
+	os.Symlink(filepath.Join(host, "lib/plugins/a.so"), filepath.Join(host, "a.so"))
+	if kept := filterOut([]string{filepath.Join(host, "a.so"), "conf/app.yml"}, libs); !reflect.DeepEqual(kept, []string{"conf/app.yml"}) {
+		t.Fatalf("expected shared libraries to be filtered out, got %v", kept)
+	}
+}
@@ -18,6 +18,7 @@ type instance struct {
 	Pid       string   `json:"pid"`
 	Mgmt      string   `json:"mgmt"`
 	Arch      string   `json:"arch"`
+	Tap       string   `json:"tap,omitempty"` // only set if bridged on linux
 
 	FreeMemory  int64
 	TotalMemory int64
@@ -0,0 +1,404 @@
+package onprem
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nanovms/ops/lepton"
+)
+
+// clockTicks is USER_HZ which is what /proc/<pid>/stat times are in.
+const clockTicks = 100
+
+// InstanceMetrics are the resource usage of an onprem instance.
+type InstanceMetrics struct {
+	Name  string
+	Image string
+	PID   string
+
+	CPUSeconds float64 // user + system time of the qemu process
+	RSSBytes   int64   // resident memory of the qemu process
+
+	// as reported by the balloon device of the guest
+	BalloonTotalBytes int64
+	BalloonFreeBytes  int64
+
+	Disks []DiskMetrics
+	Nics  []NicMetrics
+}
+
+// DiskMetrics are the io stats of a block device of an instance.
+type DiskMetrics struct {
+	Device     string
+	ReadBytes  int64
+	WriteBytes int64
+	ReadOps    int64
+	WriteOps   int64
+}
+
+// NicMetrics are the io stats of the tap device of an instance.
+type NicMetrics struct {
+	Interface string
+	RxBytes   int64
+	TxBytes   int64
+	RxPackets int64
+	TxPackets int64
+}
+
+// InstancesMetrics collects the resource usage of every running
+// instance. Stats that can't be read for an instance (eg: /proc off of
+// linux or a qemu without a balloon device) are left at zero.
+func (p *OnPrem) InstancesMetrics(ctx *lepton.Context) ([]InstanceMetrics, error) {
+	metas, err := p.GetMetaInstances(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := []InstanceMetrics{}
+	for _, i := range metas {
+		m := InstanceMetrics{
+			Name:  i.Instance,
+			Image: path.Base(i.Image),
+			PID:   i.Pid,
+		}
+
+		if runtime.GOOS == "linux" {
+			stat, err := os.ReadFile(path.Join("/proc", i.Pid, "stat"))
+			if err == nil {
+				m.CPUSeconds, m.RSSBytes, _ = parseProcStat(stat)
+			}
+
+			if i.Tap != "" {
+				m.Nics = append(m.Nics, tapMetrics(i.Tap))
+			}
+		}
+
+		if i.Mgmt != "" {
+			qmpMetrics(&m, i)
+		}
+
+		metrics = append(metrics, m)
+	}
+
+	return metrics, nil
+}
+
+// parseProcStat returns the cpu time in seconds and the rss in bytes
+// from the contents of /proc/<pid>/stat.
+func parseProcStat(stat []byte) (float64, int64, error) {
+	// comm can have spaces so start after it
+	s := string(stat)
+	end := strings.LastIndex(s, ")")
+	if end == -1 {
+		return 0, 0, errors.New("invalid stat")
+	}
+
+	// fields[0] is the 3rd field of stat (state)
+	fields := strings.Fields(s[end+1:])
+	if len(fields) < 22 {
+		return 0, 0, errors.New("invalid stat")
+	}
+
+	utime, err := strconv.ParseInt(fields[11], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	stime, err := strconv.ParseInt(fields[12], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	rss, err := strconv.ParseInt(fields[21], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return float64(utime+stime) / clockTicks, rss * int64(os.Getpagesize()), nil
+}
+
+func tapMetrics(tap string) NicMetrics {
+	read := func(name string) int64 {
+		body, err := os.ReadFile(path.Join("/sys/class/net", tap, "statistics", name))
+		if err != nil {
+			return 0
+		}
+		v, _ := strconv.ParseInt(strings.TrimSpace(string(body)), 10, 64)
+		return v
+	}
+
+	return NicMetrics{
+		Interface: tap,
+		RxBytes:   read("rx_bytes"),
+		TxBytes:   read("tx_bytes"),
+		RxPackets: read("rx_packets"),
+		TxPackets: read("tx_packets"),
+	}
+}
+
+type qmpBlockStatsResponse struct {
+	Return []struct {
+		Device   string `json:"device"`
+		NodeName string `json:"node-name"`
+		Qdev     string `json:"qdev"`
+		Stats    struct {
+			ReadBytes  int64 `json:"rd_bytes"`
+			WriteBytes int64 `json:"wr_bytes"`
+			ReadOps    int64 `json:"rd_operations"`
+			WriteOps   int64 `json:"wr_operations"`
+		} `json:"stats"`
+	} `json:"return"`
+}
+
+// qmpMetrics fills in the balloon and block stats of an instance from
+// its qmp port.
+func qmpMetrics(m *InstanceMetrics, i instance) {
+	devid := "2"
+	if i.Arch == "amd64" {
+		devid = "3"
+	}
+	balloon := "/machine/peripheral-anon/device[" + devid + "]"
+
+	replies, err := queryQMP(i.Mgmt, []string{
+		`{ "execute": "qom-set", "arguments": { "path": "` + balloon + `", "property": "guest-stats-polling-interval", "value": 2}}`,
+		`{ "execute": "qom-get", "arguments": { "path": "` + balloon + `", "property": "guest-stats" } }`,
+		`{ "execute": "query-blockstats" }`,
+	})
+	if err != nil || len(replies) != 3 {
+		return
+	}
+
+	var lr qmpResponse
+	if json.Unmarshal(replies[1], &lr) == nil {
+		m.BalloonTotalBytes = lr.qmpReturn.Stats.TotalMemory
+		m.BalloonFreeBytes = lr.qmpReturn.Stats.FreeMemory
+	}
+
+	var bs qmpBlockStatsResponse
+	if json.Unmarshal(replies[2], &bs) == nil {
+		for _, b := range bs.Return {
+			device := b.Device
+			if device == "" {
+				device = b.NodeName
+			}
+			if device == "" {
+				device = b.Qdev
+			}
+
+			m.Disks = append(m.Disks, DiskMetrics{
+				Device:     device,
+				ReadBytes:  b.Stats.ReadBytes,
+				WriteBytes: b.Stats.WriteBytes,
+				ReadOps:    b.Stats.ReadOps,
+				WriteOps:   b.Stats.WriteOps,
+			})
+		}
+	}
+}
+
+// queryQMP negotiates capabilities on the qmp port and returns the reply
+// to each command. Unlike executeQMPLastRead it never exits so it can be
+// used from long running processes.
+func queryQMP(port string, commands []string) ([][]byte, error) {
+	c, err := net.DialTimeout("tcp", "localhost:"+port, 2*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+
+	r := bufio.NewReader(c)
+
+	// greeting
+	_, err = r.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	commands = append([]string{`{ "execute": "qmp_capabilities" }`}, commands...)
+
+	replies := [][]byte{}
+	for _, cmd := range commands {
+		_, err = c.Write([]byte(cmd + "\n"))
+		if err != nil {
+			return nil, err
+		}
+
+		reply, err := readQMPReply(r)
+		if err != nil {
+			return nil, err
+		}
+		replies = append(replies, reply)
+	}
+
+	return replies[1:], nil
+}
+
+// readQMPReply reads the next reply skipping async events.
+func readQMPReply(r *bufio.Reader) ([]byte, error) {
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		var ev struct {
+			Event string `json:"event"`
+		}
+		if json.Unmarshal(line, &ev) == nil && ev.Event != "" {
+			continue
+		}
+
+		return line, nil
+	}
+}
+
+// WriteMetrics writes the metrics of onprem instances along with the
+// recorded build and upload durations in the prometheus text format.
+func (p *OnPrem) WriteMetrics(ctx *lepton.Context, w io.Writer) error {
+	instances, err := p.GetInstances(ctx)
+	if err != nil {
+		return err
+	}
+
+	states := map[string]int{}
+	for _, i := range instances {
+		states[i.Status]++
+	}
+
+	metrics, err := p.InstancesMetrics(ctx)
+	if err != nil {
+		return err
+	}
+
+	durations, err := lepton.ReadDurations()
+	if err != nil {
+		return err
+	}
+
+	writePrometheus(w, states, metrics, durations)
+	return nil
+}
+
+type promSample struct {
+	labels []string // name, value pairs
+	value  float64
+}
+
+type promFamily struct {
+	name    string
+	help    string
+	typ     string
+	samples []promSample
+}
+
+func (f *promFamily) add(value float64, labels ...string) {
+	f.samples = append(f.samples, promSample{labels: labels, value: value})
+}
+
+func writePrometheus(w io.Writer, states map[string]int, metrics []InstanceMetrics, durations []lepton.DurationStats) {
+	count := &promFamily{name: "ops_instances", help: "Number of onprem instances by state.", typ: "gauge"}
+	stateNames := []string{}
+	for s := range states {
+		stateNames = append(stateNames, s)
+	}
+	sort.Strings(stateNames)
+	for _, s := range stateNames {
+		count.add(float64(states[s]), "state", strings.ToLower(s))
+	}
+
+	cpu := &promFamily{name: "ops_instance_cpu_seconds_total", help: "CPU time used by the hypervisor of an instance.", typ: "counter"}
+	rss := &promFamily{name: "ops_instance_resident_memory_bytes", help: "Resident memory of the hypervisor of an instance.", typ: "gauge"}
+	btotal := &promFamily{name: "ops_instance_balloon_total_memory_bytes", help: "Total memory reported by the balloon device of an instance.", typ: "gauge"}
+	bfree := &promFamily{name: "ops_instance_balloon_free_memory_bytes", help: "Free memory reported by the balloon device of an instance.", typ: "gauge"}
+	rdBytes := &promFamily{name: "ops_instance_disk_read_bytes_total", help: "Bytes read from a disk of an instance.", typ: "counter"}
+	wrBytes := &promFamily{name: "ops_instance_disk_written_bytes_total", help: "Bytes written to a disk of an instance.", typ: "counter"}
+	rdOps := &promFamily{name: "ops_instance_disk_reads_total", help: "Read operations on a disk of an instance.", typ: "counter"}
+	wrOps := &promFamily{name: "ops_instance_disk_writes_total", help: "Write operations on a disk of an instance.", typ: "counter"}
+	rxBytes := &promFamily{name: "ops_instance_network_receive_bytes_total", help: "Bytes received by an instance.", typ: "counter"}
+	txBytes := &promFamily{name: "ops_instance_network_transmit_bytes_total", help: "Bytes transmitted by an instance.", typ: "counter"}
+	rxPackets := &promFamily{name: "ops_instance_network_receive_packets_total", help: "Packets received by an instance.", typ: "counter"}
+	txPackets := &promFamily{name: "ops_instance_network_transmit_packets_total", help: "Packets transmitted by an instance.", typ: "counter"}
+
+	for _, m := range metrics {
+		cpu.add(m.CPUSeconds, "instance", m.Name, "image", m.Image, "pid", m.PID)
+		rss.add(float64(m.RSSBytes), "instance", m.Name, "image", m.Image, "pid", m.PID)
+		btotal.add(float64(m.BalloonTotalBytes), "instance", m.Name)
+		bfree.add(float64(m.BalloonFreeBytes), "instance", m.Name)
+
+		for _, d := range m.Disks {
+			rdBytes.add(float64(d.ReadBytes), "instance", m.Name, "device", d.Device)
+			wrBytes.add(float64(d.WriteBytes), "instance", m.Name, "device", d.Device)
+			rdOps.add(float64(d.ReadOps), "instance", m.Name, "device", d.Device)
+			wrOps.add(float64(d.WriteOps), "instance", m.Name, "device", d.Device)
+		}
+
+		for _, n := range m.Nics {
+			rxBytes.add(float64(n.RxBytes), "instance", m.Name, "interface", n.Interface)
+			txBytes.add(float64(n.TxBytes), "instance", m.Name, "interface", n.Interface)
+			rxPackets.add(float64(n.RxPackets), "instance", m.Name, "interface", n.Interface)
+			txPackets.add(float64(n.TxPackets), "instance", m.Name, "interface", n.Interface)
+		}
+	}
+
+	// summaries without quantiles are just a _sum and a _count
+	durSum := &promFamily{name: "ops_operation_duration_seconds_sum", typ: ""}
+	durCount := &promFamily{name: "ops_operation_duration_seconds_count", typ: ""}
+	durLast := &promFamily{name: "ops_operation_last_duration_seconds", help: "Duration of the last build or upload.", typ: "gauge"}
+	for _, d := range durations {
+		durSum.add(d.Sum, "operation", d.Operation, "target", d.Target)
+		durCount.add(float64(d.Count), "operation", d.Operation, "target", d.Target)
+		durLast.add(d.Last, "operation", d.Operation, "target", d.Target)
+	}
+
+	families := []*promFamily{count, cpu, rss, btotal, bfree, rdBytes, wrBytes, rdOps, wrOps, rxBytes, txBytes, rxPackets, txPackets}
+	for _, f := range families {
+		f.write(w)
+	}
+
+	fmt.Fprintln(w, "# HELP ops_operation_duration_seconds Time spent building or uploading images.")
+	fmt.Fprintln(w, "# TYPE ops_operation_duration_seconds summary")
+	durSum.write(w)
+	durCount.write(w)
+	durLast.write(w)
+}
+
+func (f *promFamily) write(w io.Writer) {
+	if f.help != "" {
+		fmt.Fprintf(w, "# HELP %s %s\n", f.name, f.help)
+	}
+	if f.typ != "" {
+		fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.typ)
+	}
+
+	for _, s := range f.samples {
+		labels := []string{}
+		for i := 0; i+1 < len(s.labels); i += 2 {
+			labels = append(labels, s.labels[i]+`="`+promEscape(s.labels[i+1])+`"`)
+		}
+
+		if len(labels) == 0 {
+			fmt.Fprintf(w, "%s %s\n", f.name, strconv.FormatFloat(s.value, 'g', -1, 64))
+			continue
+		}
+		fmt.Fprintf(w, "%s{%s} %s\n", f.name, strings.Join(labels, ","), strconv.FormatFloat(s.value, 'g', -1, 64))
+	}
+}
+
+func promEscape(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return strings.ReplaceAll(s, "\n", `\n`)
+}
@@ -0,0 +1,90 @@
+package onprem
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nanovms/ops/lepton"
+)
+
+func TestParseProcStat(t *testing.T) {
+	stat := "4242 (qemu system x86) S 1 4242 4242 0 -1 4194560 1000 0 0 0 250 150 0 0 20 0 4 0 100 2147483648 2048 18446744073709551615"
+
+	cpu, rss, err := parseProcStat([]byte(stat))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cpu != 4 {
+		t.Errorf("expected 4 seconds of cpu, got %v", cpu)
+	}
+
+	if rss != 2048*int64(os.Getpagesize()) {
+		t.Errorf("unexpected rss %d", rss)
+	}
+
+	_, _, err = parseProcStat([]byte("4242 (qemu) S 1"))
+	if err == nil {
+		t.Error("expected an error for a truncated stat")
+	}
+}
+
+func TestWritePrometheus(t *testing.T) {
+	t.Setenv("OPS_HOME", t.TempDir())
+
+	lepton.RecordDuration(lepton.OpBuild, "", 2*time.Second)
+	lepton.RecordDuration(lepton.OpBuild, "", 4*time.Second)
+
+	durations, err := lepton.ReadDurations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metrics := []InstanceMetrics{
+		{
+			Name:       "web",
+			Image:      "web.img",
+			PID:        "4242",
+			CPUSeconds: 1.5,
+			RSSBytes:   1024,
+			Disks:      []DiskMetrics{{Device: "hd0", ReadBytes: 10, WriteBytes: 20}},
+			Nics:       []NicMetrics{{Interface: "web", RxBytes: 30, TxBytes: 40}},
+		},
+	}
+
+	var buf bytes.Buffer
+	writePrometheus(&buf, map[string]int{"Running": 1}, metrics, durations)
+	out := buf.String()
+
+	expected := []string{
+		"# TYPE ops_instances gauge",
+		`ops_instances{state="running"} 1`,
+		"# TYPE ops_instance_cpu_seconds_total counter",
+		`ops_instance_cpu_seconds_total{instance="web",image="web.img",pid="4242"} 1.5`,
+		`ops_instance_resident_memory_bytes{instance="web",image="web.img",pid="4242"} 1024`,
+		`ops_instance_disk_read_bytes_total{instance="web",device="hd0"} 10`,
+		`ops_instance_disk_written_bytes_total{instance="web",device="hd0"} 20`,
+		`ops_instance_network_receive_bytes_total{instance="web",interface="web"} 30`,
+		`ops_instance_network_transmit_bytes_total{instance="web",interface="web"} 40`,
+		"# TYPE ops_operation_duration_seconds summary",
+		`ops_operation_duration_seconds_sum{operation="build",target="onprem"} 6`,
+		`ops_operation_duration_seconds_count{operation="build",target="onprem"} 2`,
+		`ops_operation_last_duration_seconds{operation="build",target="onprem"} 4`,
+	}
+
+	for _, e := range expected {
+		if !strings.Contains(out, e+"\n") {
+			t.Errorf("missing %q in:\n%s", e, out)
+		}
+	}
+}
+
+func TestPromEscape(t *testing.T) {
+	got := promEscape("a\"b\\c\nd")
+	if got != `a\"b\\c\nd` {
+		t.Errorf("unexpected escape %s", got)
+	}
+}
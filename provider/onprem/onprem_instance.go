@@ -100,6 +100,10 @@ func (p *OnPrem) createInstance(ctx *lepton.Context) (string, error) {
 
 	c.RunConfig.Mgmt = qemu.GenMgmtPort()
 
+	if c.RunConfig.Arch == "" {
+		c.RunConfig.Arch = c.Arch
+	}
+
 	err := hypervisor.Start(&c.RunConfig)
 	if err != nil {
 		return "", err
@@ -119,7 +123,7 @@ func (p *OnPrem) createInstance(ctx *lepton.Context) (string, error) {
 	instances := path.Join(opshome, "instances")
 
 	arch := "arm64"
-	if qemu.ArchCheck() {
+	if qemu.ArchCheck(c.RunConfig.Arch) {
 		arch = "amd64"
 	}
 
@@ -134,6 +138,9 @@ func (p *OnPrem) createInstance(ctx *lepton.Context) (string, error) {
 
 	if c.RunConfig.Bridged {
 		i.Bridged = true
+		if runtime.GOOS == "linux" {
+			i.Tap = c.RunConfig.TapName
+		}
 	}
 
 	if qemu.OPSD != "" {
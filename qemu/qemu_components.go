@@ -3,8 +3,6 @@ package qemu
 import (
 	"fmt"
 	"strings"
-
-	"github.com/nanovms/ops/lepton"
 )
 
 type drive struct {
@@ -39,17 +37,18 @@ type device struct {
 	mac     string
 	devid   string
 	addr    string
+	arch    string
 }
 
 // ArchCheck returns true if the user is on or wants to use amd64
-// otherwise it returns false.
-func ArchCheck() bool {
+// otherwise it returns false. An empty arch is the host architecture.
+func ArchCheck(arch string) bool {
 	usex86 := true
-	if (isx86() && lepton.AltGOARCH == "") || lepton.AltGOARCH == "amd64" {
+	if (isx86() && arch == "") || arch == "amd64" {
 		usex86 = true
 	}
 
-	if (!isx86() && lepton.AltGOARCH == "") || lepton.AltGOARCH == "arm64" {
+	if (!isx86() && arch == "") || arch == "arm64" {
 		usex86 = false
 	}
 
@@ -66,7 +65,7 @@ func (dv device) String() string {
 	// simple pci net hack -- FIXME
 	if dv.driver == "virtio-net" {
 
-		usex86 := ArchCheck()
+		usex86 := ArchCheck(dv.arch)
 
 		if usex86 {
 			if isInception() {
@@ -36,7 +36,7 @@ func GenMgmtPort() string {
 	return strconv.Itoa(int(dd))
 }
 
-func qemuBaseCommand() string {
+func qemuBaseCommand(arch string) string {
 	x86 := "qemu-system-x86_64"
 	arm := "qemu-system-aarch64"
 
@@ -45,11 +45,11 @@ func qemuBaseCommand() string {
 		x86 = "/usr/local/bin/qemu-system-x86_64"
 	}
 
-	if lepton.AltGOARCH != "" {
-		if lepton.AltGOARCH == "amd64" {
+	if arch != "" {
+		if arch == "amd64" {
 			return x86
 		}
-		if lepton.AltGOARCH == "arm64" {
+		if arch == "arm64" {
 			return arm
 		}
 	}
@@ -76,6 +76,7 @@ type qemu struct {
 	kernel     string
 	atExitHook string
 	mgmt       string // not sure why we have this as string..
+	arch       string // empty is the host architecture
 }
 
 func newQemu() Hypervisor {
@@ -121,14 +122,14 @@ func (q *qemu) Command(rconfig *types.RunConfig) *exec.Cmd {
 		return nil
 	}
 	if q.atExitHook != "" {
-		qc := qemuBaseCommand() + " " + strings.Join(args, " ")
+		qc := qemuBaseCommand(q.arch) + " " + strings.Join(args, " ")
 		fullCmd := qc + "; " + q.atExitHook
 		logv(rconfig, fullCmd)
 		q.cmd = exec.Command("/bin/sh", "-c", fullCmd)
 	} else {
-		logv(rconfig, qemuBaseCommand()+" "+strings.Join(args, " "))
+		logv(rconfig, qemuBaseCommand(q.arch)+" "+strings.Join(args, " "))
 
-		q.cmd = exec.Command(qemuBaseCommand(), args...)
+		q.cmd = exec.Command(qemuBaseCommand(q.arch), args...)
 	}
 
 	if rconfig.BackgroundDetach {
@@ -298,6 +299,7 @@ func (q *qemu) addNetDevice(devType, ifaceName, mac string, hostPorts []string,
 			devtype: "netdev",
 			devid:   id,
 			addr:    "0x" + si,
+			arch:    q.arch,
 		}
 		ndv := netdev{
 			nettype: devType,
@@ -431,7 +433,7 @@ func (q *qemu) addAccel() (bool, error) {
 	}
 
 	const hvfSupportedVersion = "2.12" // https://wiki.qemu.org/ChangeLog/2.12#Host_support
-	qemuVersion, err := Version()
+	qemuVersion, err := version(q.arch)
 	if err != nil {
 		return false, &errQemuCannotGetQemuVersion{errCustom{"cannot get QEMU version", err}}
 	}
@@ -441,7 +443,7 @@ func (q *qemu) addAccel() (bool, error) {
 		return false, &errQemuHWAccelNotSupported{errCustom{"Hardware acceleration not supported", err}}
 	}
 
-	if lepton.AltGOARCH != "" {
+	if q.arch != "" {
 		return false, nil
 	}
 
@@ -471,6 +473,8 @@ func (q *qemu) addAccel() (bool, error) {
 }
 
 func (q *qemu) setConfig(rconfig *types.RunConfig) error {
+	q.arch = rconfig.Arch
+
 	if rconfig.GPUs > 0 {
 		gpuType := rconfig.GPUType
 		if gpuType == "" {
@@ -493,11 +497,11 @@ func (q *qemu) setConfig(rconfig *types.RunConfig) error {
 	q.addDrive("hd0", rconfig.ImageName, "none")
 
 	pciBus := ""
-	if isx86() || lepton.AltGOARCH == "amd64" {
+	if isx86() || q.arch == "amd64" {
 		pciBus = "pcie.0"
 	}
 
-	usex86 := ArchCheck()
+	usex86 := ArchCheck(q.arch)
 
 	if usex86 {
 		q.addOption("-machine", "q35")
@@ -604,9 +608,9 @@ func (q *qemu) setConfig(rconfig *types.RunConfig) error {
 		q.addOption("-L", "/Applications/qemu.app/Contents/MacOS/")
 	}
 
-	if runtime.GOOS == "darwin" && runtime.GOARCH != "arm64" && lepton.AltGOARCH == "" {
+	if runtime.GOOS == "darwin" && runtime.GOARCH != "arm64" && q.arch == "" {
 		q.addOption("-cpu", "max,-rdtscp")
-	} else if runtime.GOOS == "darwin" && runtime.GOARCH == "arm64" && lepton.AltGOARCH == "" {
+	} else if runtime.GOOS == "darwin" && runtime.GOARCH == "arm64" && q.arch == "" {
 		q.addOption("-cpu", "host")
 	} else {
 		if !isInception() {
@@ -638,7 +642,7 @@ func (q *qemu) setConfig(rconfig *types.RunConfig) error {
 }
 
 func (q *qemu) isInstalled() bool {
-	qemuCommand := qemuBaseCommand()
+	qemuCommand := qemuBaseCommand(q.arch)
 	if filepath.Base(qemuCommand) == qemuCommand {
 		lp, err := exec.LookPath(qemuCommand)
 		if err != nil {
@@ -720,7 +724,12 @@ func generateMac() string {
 
 // Version gives the version of qemu running locally.
 func Version() (string, error) {
-	versionData, err := exec.Command(qemuBaseCommand(), "--version").Output()
+	return version("")
+}
+
+// version gives the version of the local qemu for arch.
+func version(arch string) (string, error) {
+	versionData, err := exec.Command(qemuBaseCommand(arch), "--version").Output()
 	if err != nil {
 		return "", &errQemuCannotExecute{errCustom{"cannot execute QEMU", err}}
 	}
@@ -0,0 +1,86 @@
+package registry
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"os"
+)
+
+// APIKey is a key allowed to push to (and read private packages of)
+// some namespaces. A namespace of "*" allows all of them.
+type APIKey struct {
+	Key        string   `json:"key"`
+	Username   string   `json:"username"`
+	Namespaces []string `json:"namespaces"`
+}
+
+// Auth holds the api keys of a registry. A nil Auth allows nothing so
+// the registry is read only.
+//
+// auth.json:
+//
+//	{
+//	  "keys": [
+//	    { "key": "secret", "username": "alice", "namespaces": ["alice", "team"] }
+//	  ]
+//	}
+type Auth struct {
+	Keys []APIKey `json:"keys"`
+}
+
+// LoadAuth reads the api keys in path. A missing file means there are
+// no keys.
+func LoadAuth(path string) (*Auth, error) {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	a := &Auth{}
+	err = json.Unmarshal(body, a)
+	if err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+func (a *Auth) lookup(key string) *APIKey {
+	if a == nil || key == "" {
+		return nil
+	}
+
+	for i := range a.Keys {
+		if subtle.ConstantTimeCompare([]byte(a.Keys[i].Key), []byte(key)) == 1 {
+			return &a.Keys[i]
+		}
+	}
+	return nil
+}
+
+// User returns the user of key.
+func (a *Auth) User(key string) (string, bool) {
+	k := a.lookup(key)
+	if k == nil {
+		return "", false
+	}
+	return k.Username, true
+}
+
+// Allowed tells if key gives access to namespace.
+func (a *Auth) Allowed(key string, namespace string) bool {
+	k := a.lookup(key)
+	if k == nil {
+		return false
+	}
+
+	for _, ns := range k.Namespaces {
+		if ns == "*" || ns == namespace {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,274 @@
+// Package registry implements a self-hostable package registry that
+// speaks the same apis ops uses to talk to pkghub.
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nanovms/ops/lepton"
+)
+
+// maxUploadSize is the largest package archive accepted.
+const maxUploadSize = 4 << 30
+
+// Server serves the packages of a store.
+//
+//	GET  /api/v1/search?q=&arch=  search packages
+//	POST /api/v1/pkg/metadata     metadata of a package
+//	GET  /v2/manifest.json        all public packages
+//	GET  /v2/packages/...         package archives and signatures
+//	POST /packages/create         upload a package
+//	POST /apikeys/validate        validate an api key for 'ops pkg login'
+type Server struct {
+	Store *Store
+	Auth  *Auth
+}
+
+// Handler returns the http handler of the registry.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/search", s.search)
+	mux.HandleFunc("POST /api/v1/pkg/metadata", s.metadata)
+	mux.HandleFunc("GET /v2/manifest.json", s.manifest)
+	mux.HandleFunc("GET /v2/packages/", s.download)
+	mux.HandleFunc("POST /packages/create", s.create)
+	mux.HandleFunc("POST /apikeys/validate", s.validate)
+	return mux
+}
+
+// canRead tells if the request may see pkg.
+func (s *Server) canRead(r *http.Request, pkg StoredPackage) bool {
+	if !pkg.Private {
+		return true
+	}
+
+	return s.Auth.Allowed(r.Header.Get(lepton.APIKeyHeader), pkg.Namespace)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) visible(r *http.Request) ([]StoredPackage, error) {
+	all, err := s.Store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	pkgs := []StoredPackage{}
+	for _, p := range all {
+		if s.canRead(r, p) {
+			pkgs = append(pkgs, p)
+		}
+	}
+	return pkgs, nil
+}
+
+func packageList(pkgs []StoredPackage) lepton.PackageList {
+	list := lepton.PackageList{Version: 1, Packages: []lepton.Package{}}
+	for _, p := range pkgs {
+		list.Packages = append(list.Packages, p.Package)
+	}
+	return list
+}
+
+func (s *Server) search(w http.ResponseWriter, r *http.Request) {
+	q := strings.ToLower(r.URL.Query().Get("q"))
+	arch := r.URL.Query().Get("arch")
+
+	pkgs, err := s.visible(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	found := []StoredPackage{}
+	for _, p := range pkgs {
+		if arch != "" && normalizeArch(p.Arch) != normalizeArch(arch) {
+			continue
+		}
+
+		if strings.Contains(strings.ToLower(p.Name), q) ||
+			strings.Contains(strings.ToLower(p.Namespace), q) ||
+			strings.Contains(strings.ToLower(p.Description), q) {
+			found = append(found, p)
+		}
+	}
+
+	writeJSON(w, packageList(found))
+}
+
+func (s *Server) metadata(w http.ResponseWriter, r *http.Request) {
+	var req lepton.APIMetadataRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	pkg, err := s.Store.Get(req.Namespace, req.PkgName, req.Version, req.Arch)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// private packages don't exist for those who can't see them
+	if !s.canRead(r, *pkg) {
+		http.NotFound(w, r)
+		return
+	}
+
+	writeJSON(w, pkg.Package)
+}
+
+func (s *Server) manifest(w http.ResponseWriter, r *http.Request) {
+	pkgs, err := s.visible(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, packageList(pkgs))
+}
+
+// download serves /v2/packages/<ns>/<name>/<version>.tar.gz and
+// /v2/packages/<ns>/<name>/<version>/arm64.tar.gz along with their
+// .sig files.
+func (s *Server) download(w http.ResponseWriter, r *http.Request) {
+	rel := strings.TrimPrefix(r.URL.Path, "/v2/packages/")
+	parts := strings.Split(rel, "/")
+
+	file := parts[len(parts)-1]
+	sig := strings.HasSuffix(file, lepton.PackageSignatureSuffix)
+	file = strings.TrimSuffix(file, lepton.PackageSignatureSuffix)
+
+	var ns, name, version, arch string
+	switch {
+	case len(parts) == 3 && strings.HasSuffix(file, ".tar.gz"):
+		ns, name, version, arch = parts[0], parts[1], strings.TrimSuffix(file, ".tar.gz"), "amd64"
+	case len(parts) == 4 && strings.HasSuffix(file, ".tar.gz"):
+		ns, name, version, arch = parts[0], parts[1], parts[2], strings.TrimSuffix(file, ".tar.gz")
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	pkg, err := s.Store.Get(ns, name, version, arch)
+	if err != nil || !s.canRead(r, *pkg) {
+		http.NotFound(w, r)
+		return
+	}
+
+	path := filepath.Join(s.Store.Dir, ArchivePath(ns, name, version, arch))
+	if sig {
+		path += lepton.PackageSignatureSuffix
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	http.ServeFile(w, r, path)
+}
+
+func (s *Server) create(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+
+	err := r.ParseMultipartForm(32 << 20)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ns := r.FormValue("namespace")
+	if !s.Auth.Allowed(r.Header.Get(lepton.APIKeyHeader), ns) {
+		http.Error(w, "not allowed to push to namespace "+ns, http.StatusForbidden)
+		return
+	}
+
+	// archives of several arches are uploaded as package_<arch> along
+	// with the list of arches, and their signatures as signature_<arch>
+	archives := map[string]io.Reader{}
+	sigFields := map[string]string{}
+	if arches := r.FormValue("arches"); arches != "" {
+		for _, arch := range strings.Split(arches, ",") {
+			f, _, err := r.FormFile("package_" + arch)
+			if err != nil {
+				http.Error(w, "missing archive for "+arch, http.StatusBadRequest)
+				return
+			}
+			defer f.Close()
+			archives[arch] = f
+			sigFields[arch] = "signature_" + arch
+		}
+	} else {
+		f, _, err := r.FormFile("package")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer f.Close()
+		archives[r.FormValue("arch")] = f
+		sigFields[r.FormValue("arch")] = "signature"
+	}
+
+	sigs := map[string][]byte{}
+	for arch, field := range sigFields {
+		f, _, err := r.FormFile(field)
+		if err != nil {
+			continue
+		}
+		sigs[arch], err = io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	pkg := StoredPackage{
+		Package: lepton.Package{
+			Namespace:   ns,
+			Name:        r.FormValue("name"),
+			Version:     r.FormValue("version"),
+			Description: r.FormValue("description"),
+			Language:    r.FormValue("language"),
+		},
+		Private: r.FormValue("private") == "on",
+	}
+
+	if deps := r.FormValue("dependencies"); deps != "" {
+		pkg.Dependencies = strings.Split(deps, ",")
+	}
+
+	// the arches of a release are published together
+	err = s.Store.PutArches(pkg, archives, sigs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) validate(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.Auth.User(r.Header.Get(lepton.APIKeyHeader))
+	if !ok {
+		http.Error(w, "incorrect api-key", http.StatusForbidden)
+		return
+	}
+
+	writeJSON(w, lepton.ValidateSuccessResponse{Username: user})
+}
@@ -0,0 +1,278 @@
+package registry
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/nanovms/ops/lepton"
+	"github.com/nanovms/ops/types"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth := &Auth{Keys: []APIKey{
+		{Key: "alice-key", Username: "alice", Namespaces: []string{"alice"}},
+	}}
+
+	ts := httptest.NewServer((&Server{Store: store, Auth: auth}).Handler())
+	t.Cleanup(ts.Close)
+
+	old := lepton.PkghubBaseURL
+	lepton.PkghubBaseURL = ts.URL
+	t.Cleanup(func() { lepton.PkghubBaseURL = old })
+
+	return ts
+}
+
+func push(t *testing.T, ns, name, version, key string, private bool) int {
+	archive := filepath.Join(t.TempDir(), name+".tar.gz")
+	if err := os.WriteFile(archive, []byte(name+version), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	pkg := lepton.Package{Version: version, Language: "c", Description: "a test package"}
+	req, err := lepton.BuildRequestForArchiveUpload(ns, name, pkg, map[string]string{"amd64": archive}, nil, private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(lepton.APIKeyHeader, key)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode
+}
+
+func TestServerPushAndFetch(t *testing.T) {
+	ts := newTestServer(t)
+
+	if code := push(t, "alice", "redis", "7.0.0", "alice-key", false); code != http.StatusOK {
+		t.Fatalf("push failed with %d", code)
+	}
+
+	if code := push(t, "bob", "redis", "7.0.0", "alice-key", false); code != http.StatusForbidden {
+		t.Fatalf("expected push to another namespace to be forbidden, got %d", code)
+	}
+
+	if code := push(t, "alice", "redis", "7.0.1", "", false); code != http.StatusForbidden {
+		t.Fatalf("expected anonymous push to be forbidden, got %d", code)
+	}
+
+	pkg, err := lepton.GetPackageMetadata("alice", "redis", "latest", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkg.Version != "7.0.0" || pkg.SHA256 == "" {
+		t.Fatalf("unexpected metadata %+v", pkg)
+	}
+
+	resp, err := http.Get(ts.URL + "/v2/packages/alice/redis/7.0.0.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "redis7.0.0" {
+		t.Fatalf("unexpected archive %q", body)
+	}
+
+	list, err := lepton.SearchPackages("red")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Packages) != 1 {
+		t.Fatalf("expected one package, got %d", len(list.Packages))
+	}
+
+	v, err := lepton.ValidateAPIKey("alice-key")
+	if err != nil || v.Username != "alice" {
+		t.Fatalf("unexpected validation %v %v", v, err)
+	}
+
+	if _, err := lepton.ValidateAPIKey("nope"); err == nil {
+		t.Fatal("expected invalid key to be refused")
+	}
+}
+
+func TestServerPrivatePackages(t *testing.T) {
+	ts := newTestServer(t)
+
+	if code := push(t, "alice", "secret", "1.0.0", "alice-key", true); code != http.StatusOK {
+		t.Fatalf("push failed with %d", code)
+	}
+
+	// GetPackageMetadata sends the key of ~/.ops/credentials if any
+	t.Setenv("OPS_HOME", t.TempDir())
+
+	_, err := lepton.GetPackageMetadata("alice", "secret", "1.0.0", "")
+	if err == nil {
+		t.Fatal("expected private package to be hidden")
+	}
+
+	resp, err := http.Get(ts.URL + "/v2/packages/alice/secret/1.0.0.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected private archive to be hidden, got %d", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest("GET", ts.URL+"/v2/packages/alice/secret/1.0.0.tar.gz", nil)
+	req.Header.Set(lepton.APIKeyHeader, "alice-key")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected owner to see private archive, got %d", resp.StatusCode)
+	}
+}
+
+func TestStoreRejectsPathTraversal(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkg := StoredPackage{Package: lepton.Package{Namespace: "..", Name: "x", Version: "1"}}
+	if err := store.Put(pkg, nil, nil); err == nil {
+		t.Fatal("expected invalid namespace to be refused")
+	}
+}
+
+func TestServerMultiArchRelease(t *testing.T) {
+	ts := newTestServer(t)
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// like pkg push --sign-key
+	archives := map[string]string{}
+	signatures := map[string]string{}
+	for _, arch := range []string{"amd64", "arm64"} {
+		archives[arch] = filepath.Join(t.TempDir(), arch+".tar.gz")
+		if err := os.WriteFile(archives[arch], []byte("node-"+arch), 0644); err != nil {
+			t.Fatal(err)
+		}
+		sig, err := lepton.SignPackageArchive(archives[arch], "release", key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signatures[arch] = archives[arch] + lepton.PackageSignatureSuffix
+		if err := os.WriteFile(signatures[arch], sig, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pkg := lepton.Package{Version: "20.0.0", Language: "js"}
+	req, err := lepton.BuildRequestForArchiveUpload("alice", "node", pkg, archives, signatures, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(lepton.APIKeyHeader, "alice-key")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("push failed with %d", resp.StatusCode)
+	}
+
+	amd, err := lepton.GetPackageMetadata("alice", "node", "20.0.0", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	arm, err := lepton.GetPackageMetadata("alice", "node", "20.0.0", "arm64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(arm.Arches) != 2 || arm.Arches["amd64"] != amd.SHA256 || arm.Arches["arm64"] != arm.SHA256 || amd.SHA256 == arm.SHA256 {
+		t.Fatalf("expected a sha256 per arch, got %+v and %+v", amd, arm)
+	}
+
+	for _, arch := range []string{"amd64", "arm64"} {
+		resp, err := http.Get(ts.URL + "/v2/packages/alice/node/20.0.0/" + arch + ".tar.gz" + lepton.PackageSignatureSuffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if want, _ := os.ReadFile(signatures[arch]); string(body) != string(want) {
+			t.Fatalf("expected the signature of %s to be stored, got %q", arch, body)
+		}
+	}
+
+	if code := push(t, "alice", "redis", "7.0.0", "alice-key", false); code != http.StatusOK {
+		t.Fatalf("push failed with %d", code)
+	}
+
+	c := &types.Config{Arch: "arm64", PackageSources: []types.PackageSource{{Name: "test", URL: ts.URL}}}
+	_, err = lepton.DownloadPackage("alice/redis:7.0.0", c)
+	if err == nil || !strings.Contains(err.Error(), "not available for arm64, only for amd64") {
+		t.Fatalf("expected missing arch to be reported, got %v", err)
+	}
+
+	arches, err := lepton.PackageArches(c, "alice", "node", "20.0.0")
+	if err != nil || strings.Join(arches, ",") != "amd64,arm64" {
+		t.Fatalf("unexpected arches %v %v", arches, err)
+	}
+}
+
+func TestStorePutArchesIsAtomic(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkg := StoredPackage{Package: lepton.Package{Namespace: "alice", Name: "node", Version: "20.0.0"}}
+	err = store.PutArches(pkg, map[string]io.Reader{"amd64": strings.NewReader("v1")}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := store.Get("alice", "node", "20.0.0", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the arm64 upload fails, the amd64 archive of the push isn't stored
+	err = store.PutArches(pkg, map[string]io.Reader{
+		"amd64": strings.NewReader("v2"),
+		"arm64": iotest.ErrReader(errors.New("connection reset")),
+	}, nil)
+	if err == nil {
+		t.Fatal("expected the push to fail")
+	}
+
+	if _, err := store.Get("alice", "node", "20.0.0", "arm64"); err != ErrNotFound {
+		t.Fatalf("expected no arm64 release, got %v", err)
+	}
+	amd, err := store.Get("alice", "node", "20.0.0", "amd64")
+	if err != nil || amd.SHA256 != stored.SHA256 {
+		t.Fatalf("expected the previous amd64 release to be kept, got %+v %v", amd, err)
+	}
+	body, err := os.ReadFile(filepath.Join(store.Dir, ArchivePath("alice", "node", "20.0.0", "amd64")))
+	if err != nil || string(body) != "v1" {
+		t.Fatalf("expected the previous amd64 archive to be kept, got %q %v", body, err)
+	}
+}
@@ -0,0 +1,381 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nanovms/ops/lepton"
+)
+
+// ErrNotFound is returned when a package doesn't exist in the store.
+var ErrNotFound = errors.New("package not found")
+
+// StoredPackage is the metadata the registry keeps for each package.
+type StoredPackage struct {
+	lepton.Package
+	Private bool      `json:"private"`
+	Created time.Time `json:"created"`
+}
+
+// Store keeps packages in a directory laid out like the package urls
+// of pkghub:
+//
+//	<ns>/<name>/<version>.tar.gz         (amd64)
+//	<ns>/<name>/<version>/arm64.tar.gz
+//
+// with the metadata of each in <ns>/<name>/<version>/<arch>.json.
+type Store struct {
+	Dir string
+
+	mu sync.RWMutex
+}
+
+// NewStore creates a store backed by dir.
+func NewStore(dir string) (*Store, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{Dir: dir}, nil
+}
+
+// normalizeArch maps the arch names used by the different pkghub apis
+// to amd64 or arm64.
+func normalizeArch(arch string) string {
+	switch arch {
+	case "", "amd64", "x86_64":
+		return "amd64"
+	}
+	return arch
+}
+
+// hubArch is the arch name pkghub reports packages with.
+func hubArch(arch string) string {
+	if normalizeArch(arch) == "amd64" {
+		return "x86_64"
+	}
+	return arch
+}
+
+// validName refuses anything that could escape the store directory.
+func validName(s string) bool {
+	return s != "" && s != "." && s != ".." && !strings.ContainsAny(s, `/\`)
+}
+
+// ArchivePath returns the relative path of the archive of a package.
+func ArchivePath(ns, name, version, arch string) string {
+	return filepath.FromSlash(lepton.PackageArchivePath(ns, name, version, normalizeArch(arch)))
+}
+
+func (s *Store) metadataPath(ns, name, version, arch string) string {
+	return filepath.Join(s.Dir, filepath.FromSlash(lepton.PackageMetadataPath(ns, name, version, normalizeArch(arch))))
+}
+
+// Put stores the archive read from r along with its metadata and
+// signature (if not nil). The sha256 of the metadata is computed from
+// the archive.
+func (s *Store) Put(pkg StoredPackage, r io.Reader, sig []byte) error {
+	return s.PutArches(pkg, map[string]io.Reader{pkg.Arch: r}, map[string][]byte{pkg.Arch: sig})
+}
+
+// stagedArchive is an archive uploaded to a temporary file before it's
+// stored.
+type stagedArchive struct {
+	pkg     StoredPackage
+	tmp     string
+	archive string
+	backups map[string]string // stored files replaced, by path
+	created []string          // stored files that didn't exist
+}
+
+// PutArches stores the archives of several arches of a package as one
+// release: either all of them are stored or none is. sigs has the
+// signatures of the archives by arch.
+func (s *Store) PutArches(pkg StoredPackage, archives map[string]io.Reader, sigs map[string][]byte) error {
+	if !validName(pkg.Namespace) || !validName(pkg.Name) || !validName(pkg.Version) {
+		return fmt.Errorf("invalid package %s/%s:%s", pkg.Namespace, pkg.Name, pkg.Version)
+	}
+
+	for arch := range archives {
+		if normalizeArch(arch) != "amd64" && normalizeArch(arch) != "arm64" {
+			return fmt.Errorf("unsupported arch %s", arch)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := os.MkdirAll(filepath.Join(s.Dir, pkg.Namespace, pkg.Name, pkg.Version), 0755)
+	if err != nil {
+		return err
+	}
+
+	// upload every archive first
+	staged := []*stagedArchive{}
+	defer func() {
+		for _, st := range staged {
+			os.Remove(st.tmp)
+		}
+	}()
+
+	arches := make([]string, 0, len(archives))
+	for arch := range archives {
+		arches = append(arches, arch)
+	}
+	sort.Strings(arches)
+
+	for _, arch := range arches {
+		st := &stagedArchive{pkg: pkg, backups: map[string]string{}}
+		st.pkg.Arch = hubArch(arch)
+		st.archive = filepath.Join(s.Dir, ArchivePath(pkg.Namespace, pkg.Name, pkg.Version, arch))
+		st.tmp = st.archive + ".tmp"
+		staged = append(staged, st)
+
+		err = stageArchive(st, archives[arch])
+		if err != nil {
+			return err
+		}
+	}
+
+	// then store them together, restoring what was replaced if one
+	// can't be
+	for i, st := range staged {
+		err = s.commitArchive(st, sigs[arches[i]])
+		if err != nil {
+			for _, done := range staged[:i+1] {
+				done.rollback()
+			}
+			return err
+		}
+	}
+
+	for _, st := range staged {
+		for _, backup := range st.backups {
+			os.Remove(backup)
+		}
+	}
+
+	return s.syncArches(pkg.Namespace, pkg.Name, pkg.Version)
+}
+
+func stageArchive(st *stagedArchive, r io.Reader) error {
+	f, err := os.Create(st.tmp)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, r)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	st.pkg.SHA256, err = lepton.FileSHA256(st.tmp)
+	return err
+}
+
+// replace moves the stored file p, if any, aside to be restored on
+// rollback.
+func (st *stagedArchive) replace(p string) error {
+	err := os.Rename(p, p+".bak")
+	if os.IsNotExist(err) {
+		st.created = append(st.created, p)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	st.backups[p] = p + ".bak"
+	return nil
+}
+
+func (st *stagedArchive) rollback() {
+	for _, p := range st.created {
+		os.Remove(p)
+	}
+	for p, backup := range st.backups {
+		os.Rename(backup, p)
+	}
+}
+
+func (s *Store) commitArchive(st *stagedArchive, sig []byte) error {
+	pkg := st.pkg
+	sigPath := st.archive + lepton.PackageSignatureSuffix
+	metadata := s.metadataPath(pkg.Namespace, pkg.Name, pkg.Version, pkg.Arch)
+
+	for _, p := range []string{st.archive, sigPath, metadata} {
+		if err := st.replace(p); err != nil {
+			return err
+		}
+	}
+
+	err := os.Rename(st.tmp, st.archive)
+	if err != nil {
+		return err
+	}
+
+	// a new archive invalidates the old signature
+	if sig != nil {
+		err = os.WriteFile(sigPath, sig, 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	if pkg.Created.IsZero() {
+		pkg.Created = time.Now().UTC()
+	}
+
+	body, err := json.MarshalIndent(pkg, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(metadata, body, 0644)
+}
+
+// syncArches records the sha256 of the archive of every arch of a
+// version in the metadata of each of them so they form one release.
+func (s *Store) syncArches(ns, name, version string) error {
+	matches, err := filepath.Glob(filepath.Join(s.Dir, ns, name, version, "*.json"))
+	if err != nil {
+		return err
+	}
+
+	pkgs := []StoredPackage{}
+	arches := map[string]string{}
+	for _, m := range matches {
+		body, err := os.ReadFile(m)
+		if err != nil {
+			return err
+		}
+
+		var pkg StoredPackage
+		if err := json.Unmarshal(body, &pkg); err != nil {
+			return fmt.Errorf("invalid metadata %s: %v", m, err)
+		}
+		pkgs = append(pkgs, pkg)
+		arches[normalizeArch(pkg.Arch)] = pkg.SHA256
+	}
+
+	for _, pkg := range pkgs {
+		pkg.Arches = arches
+
+		body, err := json.MarshalIndent(pkg, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		err = os.WriteFile(s.metadataPath(pkg.Namespace, pkg.Name, pkg.Version, pkg.Arch), body, 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Get returns the metadata of a package. A version of "latest" or ""
+// resolves to the most recently uploaded version.
+func (s *Store) Get(ns, name, version, arch string) (*StoredPackage, error) {
+	if !validName(ns) || !validName(name) {
+		return nil, ErrNotFound
+	}
+
+	if version == "" || version == "latest" {
+		all, err := s.List()
+		if err != nil {
+			return nil, err
+		}
+
+		var latest *StoredPackage
+		for i := range all {
+			p := all[i]
+			if p.Namespace != ns || p.Name != name || normalizeArch(p.Arch) != normalizeArch(arch) {
+				continue
+			}
+			if latest == nil || p.Created.After(latest.Created) {
+				latest = &p
+			}
+		}
+
+		if latest == nil {
+			return nil, ErrNotFound
+		}
+		return latest, nil
+	}
+
+	if !validName(version) {
+		return nil, ErrNotFound
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	body, err := os.ReadFile(s.metadataPath(ns, name, version, arch))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	var pkg StoredPackage
+	err = json.Unmarshal(body, &pkg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pkg, nil
+}
+
+// List returns all packages of the store sorted by namespace, name,
+// version and arch.
+func (s *Store) List() ([]StoredPackage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	matches, err := filepath.Glob(filepath.Join(s.Dir, "*", "*", "*", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	pkgs := []StoredPackage{}
+	for _, m := range matches {
+		body, err := os.ReadFile(m)
+		if err != nil {
+			return nil, err
+		}
+
+		var pkg StoredPackage
+		if err := json.Unmarshal(body, &pkg); err != nil {
+			return nil, fmt.Errorf("invalid metadata %s: %v", m, err)
+		}
+		pkgs = append(pkgs, pkg)
+	}
+
+	sort.Slice(pkgs, func(i, j int) bool {
+		a, b := pkgs[i], pkgs[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.Version != b.Version {
+			return a.Version < b.Version
+		}
+		return a.Arch < b.Arch
+	})
+
+	return pkgs, nil
+}
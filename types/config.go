@@ -10,6 +10,10 @@ type Config struct {
 	// Args defines an array of commands to execute when the image is launched.
 	Args []string `json:",omitempty"`
 
+	// Arch is the cpu architecture (amd64 or arm64) to build and run
+	// for. It defaults to the architecture of the host.
+	Arch string `json:",omitempty"`
+
 	// Disable auto copy of files from host to container when present in args
 	DisableArgsCopy bool `json:",omitempty"`
 
@@ -52,6 +56,10 @@ type Config struct {
 	// Force
 	Force bool `json:",omitempty"`
 
+	// Hooks are commands run before and after building images and
+	// deploying them
+	Hooks HooksConfig `json:",omitempty"`
+
 	// Home specifies the root folder for an ops home. By default it is
 	// an empty string and not used. Any non-empty string value will
 	// overrride anything that might be present in OPS_HOME env var.
@@ -86,6 +94,10 @@ type Config struct {
 	// NightlyBuild
 	NightlyBuild bool `json:",omitempty"`
 
+	// NoBuildCache builds the image even if an identical one is in the
+	// build cache.
+	NoBuildCache bool `json:",omitempty"`
+
 	// NoTrace
 	NoTrace []string `json:",omitempty"`
 
@@ -106,6 +118,10 @@ type Config struct {
 	// RunConfig
 	RunConfig RunConfig `json:",omitempty"`
 
+	// Secrets are passed to instances without being written in clear
+	// text in the image
+	Secrets SecretsConfig `json:",omitempty"`
+
 	// LocalFilesParentDirectory is the parent directory of the files/directories specified in Files and Dirs
 	// The default value is the directory from where the ops command is running
 	LocalFilesParentDirectory string `json:",omitempty"`
@@ -113,6 +129,10 @@ type Config struct {
 	// TargetRoot
 	TargetRoot string `json:",omitempty"`
 
+	// TrustPolicy restricts the images instances are created from to
+	// images signed with 'ops image sign'
+	TrustPolicy TrustPolicy `json:",omitempty"`
+
 	// TFSv4 forces use of the deprecated TFS version 4 encoding
 	TFSv4 bool `json:",omitempty"`
 
@@ -125,6 +145,11 @@ type Config struct {
 	// Description
 	Description string `json:",omitempty"`
 
+	// Dependencies are the packages (<namespace>/<name>:<version>) a
+	// package is layered on; their sysroots are merged beneath the
+	// files of the package.
+	Dependencies []string `json:",omitempty"`
+
 	// VolumesDir is the directory used to store and fetch volumes
 	VolumesDir string `json:",omitempty"`
 
@@ -133,6 +158,92 @@ type Config struct {
 
 	// PackageManifestURL stores info about all packages
 	PackageManifestURL string `json:",omitempty"`
+
+	// RequireSignedPackages refuses packages without a valid signature
+	// from a key of the trust store.
+	RequireSignedPackages bool `json:",omitempty"`
+
+	// PackageSources are the registries packages are looked up in, in
+	// order. If empty pkghub (or PackageBaseURL) is used.
+	PackageSources []PackageSource `json:",omitempty"`
+}
+
+// PackageSource is a registry packages can be fetched from.
+type PackageSource struct {
+	// Name identifies the source in messages.
+	Name string `json:",omitempty"`
+
+	// URL is either the base url of a pkghub compatible registry (eg:
+	// https://repo.ops.city or one started with 'ops pkg serve') or a
+	// file:// url of a directory laid out like the one 'ops pkg serve'
+	// uses.
+	URL string
+
+	// Namespaces routes these namespaces (glob patterns) to the source.
+	// Packages of a namespace routed to some sources are only looked up
+	// in those; all other packages are looked up in the sources without
+	// namespaces.
+	Namespaces []string `json:",omitempty"`
+
+	// APIKey is sent to the source. The key of 'ops pkg login' is only sent
+	// to pkghub.
+	APIKey string `json:",omitempty"`
+}
+
+// HooksConfig are shell commands run around builds and deploys, with
+// the image and config in OPS_ environment variables. A failing command
+// fails the build or deploy.
+type HooksConfig struct {
+	// PreBuild runs before the image is built.
+	PreBuild string `json:",omitempty"`
+
+	// PostBuild runs once the image is built.
+	PostBuild string `json:",omitempty"`
+
+	// PreDeploy runs before 'ops deploy' builds the image.
+	PreDeploy string `json:",omitempty"`
+
+	// PostDeploy runs once 'ops deploy' created the instance.
+	PostDeploy string `json:",omitempty"`
+}
+
+// TrustPolicy tells which signatures images need for instances to be
+// created from them.
+type TrustPolicy struct {
+	// RequireSigned refuses images not signed by a key of the trust
+	// store.
+	RequireSigned bool `json:",omitempty"`
+
+	// Keys are the ids of the keys of the trust store images must be
+	// signed with, any of them if empty. Setting them requires signed
+	// images.
+	Keys []string `json:",omitempty"`
+}
+
+// SecretsConfig are values the program gets at boot without them being
+// written in clear text in the image.
+type SecretsConfig struct {
+	// Mode is how secrets get to instances: "metadata", the default,
+	// passes them in the user data of instances when they are created,
+	// "encrypted" writes them encrypted in the image and passes the key
+	// in the user data.
+	Mode string `json:",omitempty"`
+
+	// Values are where the secrets are read from, by name.
+	Values map[string]Secret `json:",omitempty"`
+}
+
+// Secret tells where the value of a secret is read from, one of an
+// environment variable of the host, a file or the local secret store.
+type Secret struct {
+	// Env is an environment variable of the host.
+	Env string `json:",omitempty"`
+
+	// File is a file of the host, relative to the config file.
+	File string `json:",omitempty"`
+
+	// Store is the name of a secret of the local secret store.
+	Store string `json:",omitempty"`
 }
 
 // ProviderConfig give provider details
@@ -287,6 +398,10 @@ type RunConfig struct {
 	// Accel defines whether hardware acceleration should be enabled.
 	Accel bool `json:",omitempty"`
 
+	// Arch is the cpu architecture of the image being run. It defaults
+	// to the architecture of the host.
+	Arch string `json:",omitempty"`
+
 	// AtExit allows hooks to be ran after instance stops.
 	AtExit string `json:",omitempty"`
 
@@ -440,6 +555,15 @@ func (c Config) MarshalJSON() ([]byte, error) {
 	if reflect.ValueOf(c.RunConfig).IsZero() {
 		skipBaseFields = append(skipBaseFields, "RunConfig")
 	}
+	if reflect.ValueOf(c.Secrets).IsZero() {
+		skipBaseFields = append(skipBaseFields, "Secrets")
+	}
+	if reflect.ValueOf(c.Hooks).IsZero() {
+		skipBaseFields = append(skipBaseFields, "Hooks")
+	}
+	if reflect.ValueOf(c.TrustPolicy).IsZero() {
+		skipBaseFields = append(skipBaseFields, "TrustPolicy")
+	}
 
 	type _mj Config
 	cJSON, err := json.Marshal((*_mj)(&c))
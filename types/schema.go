@@ -0,0 +1,97 @@
+package types
+
+import (
+	"reflect"
+	"strings"
+)
+
+// SchemaDraft is the JSON Schema version of the generated schemas.
+const SchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// SizePattern matches sizes like 512M, 2G or 1024: a number of bytes,
+// or of k, m, g units.
+const SizePattern = `^[0-9]+[kKmMgG]?$`
+
+// MemoryPattern matches memory sizes like 2G or 512M, megabytes when
+// there's no unit.
+const MemoryPattern = `^[0-9]+(\.[0-9]+)?[bBkKmMgGtT]?$`
+
+// Schema is the part of JSON Schema needed to describe configs.
+type Schema struct {
+	Draft       string             `json:"$schema,omitempty"`
+	Type        string             `json:"type,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Pattern     string             `json:"pattern,omitempty"`
+	Enum        []string           `json:"enum,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Items       *Schema            `json:"items,omitempty"`
+	OneOf       []*Schema          `json:"oneOf,omitempty"`
+	// AdditionalProperties is false for structs and the schema of the
+	// values for maps.
+	AdditionalProperties any `json:"additionalProperties,omitempty"`
+}
+
+// schemaPatterns are the patterns of the fields that are sizes.
+var schemaPatterns = map[string]string{
+	"Config.BaseVolumeSz": SizePattern,
+	"RunConfig.Memory":    MemoryPattern,
+}
+
+// schemaEnums are the values allowed for fields that are one of a few.
+var schemaEnums = map[string][]string{
+	"SecretsConfig.Mode": {"metadata", "encrypted"},
+}
+
+// ConfigSchema returns the JSON Schema of Config, generated from its
+// fields and those of ProviderConfig, RunConfig, Nic and Tag.
+func ConfigSchema() *Schema {
+	s := schemaOf(reflect.TypeOf(Config{}))
+	s.Draft = SchemaDraft
+	return s
+}
+
+func schemaOf(t reflect.Type) *Schema {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaOf(t.Elem())
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: schemaOf(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: schemaOf(t.Elem())}
+	case reflect.Struct:
+		s := &Schema{Type: "object", Properties: map[string]*Schema{}, AdditionalProperties: false}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			fs := schemaOf(f.Type)
+			if p, ok := schemaPatterns[t.Name()+"."+f.Name]; ok {
+				fs.Pattern = p
+			}
+			if e, ok := schemaEnums[t.Name()+"."+f.Name]; ok {
+				fs.Enum = e
+			}
+			s.Properties[name] = fs
+		}
+		return s
+	}
+	// any
+	return &Schema{}
+}